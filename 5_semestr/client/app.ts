@@ -10,16 +10,20 @@ interface Window {
 
 	showAddClientForm: () => void
 	submitNewClient: () => Promise<void>
+	searchClients: (query: string) => void
 
 	openNewLoanModal: (clientId: number) => Promise<void>
 	issueLoan: () => Promise<void>
 	previewSchedule: () => void
 	showSchedule: (contractId: number) => Promise<void>
+	loanAction: (contractId: number, action: string) => Promise<void>
 
 	showAddEmployeeForm: () => void
 	submitNewEmployee: () => Promise<void>
 
 	doEarlyRepayment: (contractId: number, balance: number) => void
+	showPartialRepayment: (contractId: number, balance: number) => Promise<void>
+	doPartialRepayment: (contractId: number) => Promise<void>
 	applyLogFilters: () => void
 	doBackup: () => void
 	payInstallment: (
@@ -56,14 +60,27 @@ interface Employee {
 	login: string
 	role: string
 }
+// Денежные суммы сервер отдает десятичной строкой: "1234.56"
+type Money = string
+
+type ScheduleType = 'annuity' | 'differentiated' | 'bullet'
+type RepayMode = 'term' | 'payment'
+interface RepaymentSchedule {
+	scheduleType: ScheduleType
+	payments: any[]
+	total: { payment: Money; principal: Money; interest: Money; penalty: Money }
+}
 interface CreditProduct {
 	id: number
 	name: string
-	minAmount: number
-	maxAmount: number
+	minAmount: Money
+	maxAmount: Money
 	minTerm: number
 	maxTerm: number
 	rate: number
+	scheduleType: ScheduleType
+	penaltyRate: number
+	penaltyCap: number
 	isActive: boolean
 }
 interface LoanContract {
@@ -72,17 +89,31 @@ interface LoanContract {
 	clientId: number
 	clientName: string
 	productName: string
-	amount: number
+	amount: Money
 	interestRate: number
 	termMonths: number
 	startDate: string
 	status: string
 }
 
+// Ответ постраничных списков сервера (/clients, /loans, /employees, /logs)
+interface Page<T> {
+	items: T[]
+	total: number
+	nextCursor?: string
+}
+
 
 class ApiService {
 	private isRefreshing = false
 
+	// Первая страница списка; params - фильтры, sort, order и limit
+	private async list<T = any>(endpoint: string, params: Record<string, string> = {}): Promise<T[]> {
+		const qs = new URLSearchParams(params).toString()
+		const page: Page<T> | null = await this.request(qs ? `${endpoint}?${qs}` : endpoint)
+		return page ? page.items : []
+	}
+
 	private async request(endpoint: string, method: string = 'GET', body?: any) {
 		try {
 			const opts: RequestInit = {
@@ -120,8 +151,9 @@ class ApiService {
 			}
 
 			if (!res.ok) {
-				const err = await res.json().catch(() => ({ error: res.statusText }))
-				throw new Error(err.error || res.statusText)
+				const err = await res.json().catch(() => ({ message: res.statusText }))
+				const violations = err.details?.violations?.map((v: any) => v.message) ?? []
+				throw new Error([err.message || res.statusText, ...violations].join('\n'))
 			}
 			return await res.json()
 		} catch (e: any) {
@@ -140,11 +172,20 @@ class ApiService {
 	}
 
 	async getLoanById(id: number) {
-		const loans = await this.getLoans()
-		return loans.find((l: any) => l.id === id)
+		let cursor = ''
+		do {
+			const params: Record<string, string> = { limit: '200' }
+			if (cursor) params.cursor = cursor
+			const page: Page<any> | null = await this.request(`/loans?${new URLSearchParams(params)}`)
+			if (!page) return undefined
+			const loan = page.items.find((l: any) => l.id === id)
+			if (loan) return loan
+			cursor = page.nextCursor || ''
+		} while (cursor)
+		return undefined
 	}
-	async makePayment(scheduleId: number) {
-		return this.request('/pay', 'POST', { scheduleId })
+	async makePayment(scheduleId: number, amount?: Money) {
+		return this.request('/pay', 'POST', { scheduleId, amount })
 	}
 	async getFinanceReport() {
 		return this.request('/finance-report') || []
@@ -164,40 +205,105 @@ class ApiService {
 	async createEmployee(d: any) {
 		return this.request('/register', 'POST', d)
 	}
-	async getEmployees() {
-		return this.request('/employees') || []
+	async getEmployees(params: Record<string, string> = {}) {
+		return this.list<Employee>('/employees', params)
+	}
+	async getClients(params: Record<string, string> = {}) {
+		return this.list<Client>('/clients', params)
 	}
-	async getClients() {
-		return this.request('/clients') || []
+	async searchClients(q: string) {
+		const res = await this.request(`/clients/search?${new URLSearchParams({ q })}`)
+		return res ? res.items : []
 	}
 	async createClient(c: any) {
 		return this.request('/clients', 'POST', c)
 	}
+	async getClient(id: number) {
+		return this.request(`/clients/${id}`)
+	}
+	async updateClient(id: number, c: any) {
+		return this.request(`/clients/${id}`, 'PUT', c)
+	}
+	async setClientActive(id: number, active: boolean) {
+		return this.request(`/clients/${id}/active`, 'PUT', { active })
+	}
+	async getClientHistory(id: number) {
+		return (await this.request(`/clients/${id}/history`)) || []
+	}
 	async getProducts() {
 		return this.request('/products') || []
 	}
-	async getLoans() {
-		return this.request('/loans') || []
+	async getAllProducts() {
+		return (await this.request('/products?all=true')) || []
+	}
+	async createProduct(p: any) {
+		return this.request('/products', 'POST', p)
+	}
+	async updateProduct(id: number, p: any) {
+		return this.request(`/products/${id}`, 'PUT', p)
+	}
+	async setProductActive(id: number, active: boolean) {
+		return this.request(`/products/${id}/active`, 'PUT', { active })
+	}
+	async getProductVersions(id: number) {
+		return (await this.request(`/products/${id}/versions`)) || []
+	}
+	async getLoans(params: Record<string, string> = {}) {
+		return this.list('/loans', params)
 	}
 	async getStats() {
 		return this.request('/stats') || {}
 	}
-	async repayEarly(contractId: number) {
-		return this.request('/repay-early', 'POST', { contractId })
+	async repayEarly(contractId: number, amount?: Money, mode?: RepayMode) {
+		return this.request('/repay-early', 'POST', { contractId, amount, mode })
 	}
 	async issueLoan(d: any) {
 		return this.request('/loans', 'POST', d)
 	}
-	async getSchedule(id: number) {
-		return this.request(`/loans/${id}/schedule`) || []
+	async changeLoanStatus(id: number, action: string, reason: string = '') {
+		return this.request(`/loans/${id}/${action}`, 'POST', { reason })
 	}
-	async getLogs(filters: any = {}) {
-		const params = new URLSearchParams(filters).toString()
-		return this.request(`/logs?${params}`) || []
+	async getLoanTransitions(id: number) {
+		return (await this.request(`/loans/${id}/transitions`)) || []
+	}
+	async getSchedule(id: number): Promise<RepaymentSchedule> {
+		return (
+			(await this.request(`/loans/${id}/schedule`)) || {
+				scheduleType: 'annuity',
+				payments: [],
+				total: {
+					payment: '0.00',
+					principal: '0.00',
+					interest: '0.00',
+					penalty: '0.00',
+				},
+			}
+		)
+	}
+	async getLogs(filters: Record<string, string> = {}) {
+		return this.list('/logs', filters)
 	}
 
-	calculatePreview(amountRub: number, rate: number, months: number) {
+	calculatePreview(
+		amountRub: number,
+		rate: number,
+		months: number,
+		scheduleType: ScheduleType = 'annuity'
+	) {
 		const monthlyRate = rate / 12 / 100
+		// Для дифференцированного графика показываем первый (самый большой) платеж.
+		if (scheduleType === 'differentiated') {
+			return {
+				monthlyPayment: amountRub / months + amountRub * monthlyRate,
+				totalInterest: (amountRub * monthlyRate * (months + 1)) / 2,
+			}
+		}
+		if (scheduleType === 'bullet') {
+			return {
+				monthlyPayment: amountRub * monthlyRate,
+				totalInterest: amountRub * monthlyRate * months,
+			}
+		}
 		const annuity =
 			(amountRub * (monthlyRate * Math.pow(1 + monthlyRate, months))) /
 			(Math.pow(1 + monthlyRate, months) - 1)
@@ -420,24 +526,14 @@ window.router = async (route: string) => {
 	} else if (route === 'clients') {
 		const clients = await api.getClients()
 		if (clients && clients.length > 0) {
-			const rows = clients
-				.map(
-					(c: Client) => `
-                <tr>
-                    <td>${c.lastName} ${c.firstName} ${c.middleName}</td>
-                    <td>${c.passportSeries} ${c.passportNumber}</td>
-                    <td>${c.phone}</td>
-                    <td><button class="btn btn-secondary" onclick="window.openNewLoanModal(${c.id})">Выдать кредит</button></td>
-                </tr>`
-				)
-				.join('')
 			html = `
                 <div class="card">
                     <div style="display:flex; justify-content:space-between; margin-bottom:20px">
                         <h3>Список клиентов</h3>
+                        <input type="search" placeholder="ФИО, паспорт, телефон или email" oninput="window.searchClients(this.value)" style="flex:1; margin:0 20px">
                         <button class="btn btn-primary" onclick="window.showAddClientForm()">+ Новый клиент</button>
                     </div>
-                    <table><thead><tr><th>ФИО</th><th>Паспорт</th><th>Телефон</th><th>Действия</th></tr></thead><tbody>${rows}</tbody></table>
+                    <table><thead><tr><th>ФИО</th><th>Паспорт</th><th>Телефон</th><th>Действия</th></tr></thead><tbody id="clientsTableBody">${renderClientRows(clients)}</tbody></table>
                 </div>`
 		} else {
 			html = `
@@ -459,6 +555,9 @@ window.router = async (route: string) => {
 					if (l.status === 'closed') {
 						badgeStyle = 'background:#ffebee; color:#c62828'
 					}
+					if (l.status === 'defaulted') {
+						badgeStyle = 'background:#212121; color:#fff'
+					}
 
 					return `
                 <tr>
@@ -472,7 +571,7 @@ window.router = async (route: string) => {
 					}</span></td>
                     <td><button class="btn btn-secondary" onclick="window.showSchedule(${
 											l.id
-										})">График</button></td>
+										})">График</button>${loanActionButtons(l)}</td>
                 </tr>`
 				})
 				.join('')
@@ -537,6 +636,9 @@ window.router = async (route: string) => {
 					if (l.status === 'closed') {
 						badgeStyle = 'background:#ffebee; color:#c62828' 
 					}
+					if (l.status === 'defaulted') {
+						badgeStyle = 'background:#212121; color:#fff'
+					}
 					return `
                 <tr>
                     <td>${l.contractNumber}</td>
@@ -687,6 +789,33 @@ window.submitNewEmployee = async () => {
 }
 
 
+function renderClientRows(clients: Client[]) {
+	return clients
+		.map(
+			(c: Client) => `
+                <tr>
+                    <td>${c.lastName} ${c.firstName} ${c.middleName || ''}</td>
+                    <td>${c.passportSeries} ${c.passportNumber}</td>
+                    <td>${c.phone}</td>
+                    <td><button class="btn btn-secondary" onclick="window.openNewLoanModal(${c.id})">Выдать кредит</button></td>
+                </tr>`
+		)
+		.join('')
+}
+
+// Поиск срабатывает с двух символов; пустая строка возвращает полный список
+let clientSearchTimer: ReturnType<typeof setTimeout> | undefined
+window.searchClients = (query: string) => {
+	clearTimeout(clientSearchTimer)
+	clientSearchTimer = setTimeout(async () => {
+		const q = query.trim()
+		if (q.length === 1) return
+		const clients = q ? await api.searchClients(q) : await api.getClients()
+		const tbody = document.querySelector('#clientsTableBody')
+		if (tbody) tbody.innerHTML = renderClientRows(clients)
+	}, 300)
+}
+
 window.showAddClientForm = () => {
 	document.getElementById('page-content')!.innerHTML = `
         <div class="card" style="max-width: 800px; padding: 30px;">
@@ -968,9 +1097,9 @@ window.previewSchedule = () => {
 
 		let isValid = true
 
-		if (amount < prod.minAmount || amount > prod.maxAmount) {
+		if (amount < Number(prod.minAmount) || amount > Number(prod.maxAmount)) {
 			amountInput.style.borderColor = '#e74c3c'
-			amountErr!.innerText = `Выход за лимиты (от ${prod.minAmount.toLocaleString()} до ${prod.maxAmount.toLocaleString()})`
+			amountErr!.innerText = `Выход за лимиты (от ${formatMoney(prod.minAmount)} до ${formatMoney(prod.maxAmount)})`
 			isValid = false
 		} else {
 			amountInput.style.borderColor = '#eee'
@@ -992,11 +1121,20 @@ window.previewSchedule = () => {
 			return
 		}
 
-		const res = api.calculatePreview(amount, prod.rate, term)
+		const res = api.calculatePreview(
+			amount,
+			prod.rate,
+			term,
+			prod.scheduleType
+		)
+		const paymentLabel =
+			prod.scheduleType === 'differentiated'
+				? 'Первый платеж'
+				: 'Ежемесячный платеж'
 
 		previewBox.innerHTML = `
             <div style="display:flex; justify-content:space-between; margin-bottom:5px;">
-                <span>Ежемесячный платеж:</span>
+                <span>${paymentLabel}:</span>
                 <b style="color: var(--accent-rose); font-size: 1.1em;">
                     ${formatMoney(res.monthlyPayment)} ₽
                 </b>
@@ -1008,7 +1146,11 @@ window.previewSchedule = () => {
                 </b>
             </div>
             <div style="margin-top:5px; font-size:0.85em; color:#666; text-align:right;">
-                Ставка: ${prod.rate}%
+                Ставка: ${prod.rate}%, ${scheduleTypeName(prod.scheduleType)}${
+									prod.penaltyRate > 0
+										? `<br>Пени за просрочку: ${prod.penaltyRate}% в день`
+										: ''
+								}
             </div>
         `
 	}
@@ -1025,20 +1167,17 @@ window.issueLoan = async () => {
 		productId: Number(
 			(document.getElementById('loanProduct') as HTMLInputElement).value
 		),
-		amount: Number(
-			(document.getElementById('loanAmount') as HTMLInputElement).value
-		),
+		amount: (document.getElementById('loanAmount') as HTMLInputElement).value,
 		termMonths: Number(
 			(document.getElementById('loanTerm') as HTMLInputElement).value
 		),
-		employeeId: currentUser.id,
 	}
 
 	const response = await api.issueLoan(data)
 
 	if (response && response.contractId) {
 		const printNow = confirm(
-			'Кредит успешно оформлен! Распечатать договор и график платежей?'
+			'Заявка на кредит создана! Распечатать договор и график платежей?'
 		)
 
 		if (printNow) {
@@ -1049,6 +1188,39 @@ window.issueLoan = async () => {
 	}
 }
 
+// Следующие шаги заявки по ее статусу. Одобрение и отказ доступны только
+// администратору.
+const loanActions: Record<string, { action: string; label: string; admin?: boolean }[]> = {
+	draft: [{ action: 'submit', label: 'На рассмотрение' }],
+	review: [
+		{ action: 'approve', label: 'Одобрить', admin: true },
+		{ action: 'reject', label: 'Отклонить', admin: true },
+	],
+	approved: [{ action: 'sign', label: 'Подписан' }],
+	signed: [{ action: 'activate', label: 'Выдать' }],
+}
+
+function loanActionButtons(l: any): string {
+	return (loanActions[l.status] || [])
+		.filter(a => !a.admin || currentUser!.role === 'admin')
+		.map(
+			a =>
+				` <button class="btn btn-primary" onclick="window.loanAction(${l.id}, '${a.action}')">${a.label}</button>`
+		)
+		.join('')
+}
+
+window.loanAction = async (contractId: number, action: string) => {
+	let reason = ''
+	if (action === 'reject') {
+		reason = prompt('Причина отказа') || ''
+		if (!reason.trim()) return
+	}
+
+	const res = await api.changeLoanStatus(contractId, action, reason)
+	if (res) window.router('loans')
+}
+
 window.showSchedule = async (id: number) => {
 	const [schedule, operations] = await Promise.all([
 		api.getSchedule(id),
@@ -1059,16 +1231,17 @@ window.showSchedule = async (id: number) => {
 	let isLoanActive = false
 	let currentBalance = 0
 
-	const list = isClient ? await api.getMyLoans() : await api.getLoans()
-	const loan = list.find((l: any) => Number(l.id) === Number(id))
+	const loan = isClient
+		? (await api.getMyLoans()).find((l: any) => Number(l.id) === Number(id))
+		: await api.getLoanById(Number(id))
 
 	if (loan) {
-		currentBalance = loan.balance
-		isLoanActive = loan.status === 'active' && loan.balance > 0
+		currentBalance = Number(loan.balance)
+		isLoanActive = loan.status === 'active' && currentBalance > 0
 	}
 
 	let nextPaymentFound = false
-	const scheduleRows = schedule
+	const scheduleRows = schedule.payments
 		.map((r: any) => {
 			let actionCell = ''
 			if (r.isPaid) {
@@ -1076,21 +1249,27 @@ window.showSchedule = async (id: number) => {
 			} else {
 				if (isClient && isLoanActive) {
 					if (!nextPaymentFound) {
-						actionCell = `<button class="btn btn-primary" style="padding: 4px 10px; font-size: 0.8rem;" onclick="window.payInstallment(${r.id}, ${r.paymentAmount}, ${id})">Оплатить</button>`
+						actionCell = `<button class="btn btn-primary" style="padding: 4px 10px; font-size: 0.8rem;" onclick="window.payInstallment(${r.id}, ${r.due}, ${id})">Оплатить</button>`
 						nextPaymentFound = true
 					} else {
 						actionCell = `<span style="color:#999; font-size:0.85rem;"><i class="fas fa-lock"></i> Оплатите предыдущий</span>`
 					}
 				} else {
-					actionCell = `<span class="status-badge" style="background:#ffebee; color:#c62828">Не оплачено</span>`
+					actionCell = `<span class="status-badge" style="background:#ffebee; color:#c62828">${
+						Number(r.paidAmount) > 0 ? 'Оплачено частично' : 'Не оплачено'
+					}</span>`
 				}
 			}
+			const penalty = Number(r.penalty) - Number(r.penaltyPaid)
 			return `
             <tr>
                 <td>${new Date(r.paymentDate).toLocaleDateString()}</td>
                 <td><b>${formatMoney(r.paymentAmount)} ₽</b></td>
                 <td style="color:#666">${formatMoney(r.principal)}</td>
                 <td style="color:#666">${formatMoney(r.interest)}</td>
+                <td style="color:${penalty > 0 ? '#c62828' : '#666'}">${
+									Number(r.penalty) > 0 ? formatMoney(r.penalty) : '—'
+								}</td>
                 <td>${formatMoney(r.remainingBalance)}</td>
                 <td>${actionCell}</td>
             </tr>`
@@ -1115,6 +1294,15 @@ window.showSchedule = async (id: number) => {
 				typeName = 'Досрочное погашение'
 				icon = '<i class="fas fa-star" style="color:gold"></i>'
 			}
+			if (op.type === 'penalty') {
+				typeName = 'Начислены пени'
+				icon =
+					'<i class="fas fa-exclamation-circle" style="color:#c62828"></i>'
+			}
+			if (op.type === 'penalty_payment') {
+				typeName = 'Оплата пени'
+				icon = '<i class="fas fa-check-circle" style="color:#c62828"></i>'
+			}
 
 			return `
             <tr>
@@ -1139,13 +1327,16 @@ window.showSchedule = async (id: number) => {
 	const backRoute = isClient ? 'my-loans' : 'loans'
 	const earlyRepayBtn =
 		isClient && isLoanActive
-			? `<button class="btn" style="background:var(--accent-rose); color:white; margin-right:10px; border:none;" onclick="window.doEarlyRepayment(${id}, ${currentBalance})"><i class="fas fa-money-check-alt"></i> Полное погашение</button>`
+			? `<button class="btn btn-secondary" style="margin-right:10px;" onclick="window.showPartialRepayment(${id}, ${currentBalance})"><i class="fas fa-coins"></i> Частичное погашение</button>
+               <button class="btn" style="background:var(--accent-rose); color:white; margin-right:10px; border:none;" onclick="window.doEarlyRepayment(${id}, ${currentBalance})"><i class="fas fa-money-check-alt"></i> Полное погашение</button>`
 			: ''
 
 	document.getElementById('page-content')!.innerHTML = `
         <div class="card">
             <div style="display:flex; justify-content:space-between; align-items:center;">
-                <h3>График платежей</h3>
+                <h3>График платежей <span style="font-size:0.8em; color:#888; font-weight:normal">(${scheduleTypeName(
+									schedule.scheduleType
+								)})</span></h3>
                 <div>
                     ${earlyRepayBtn}
                     ${
@@ -1165,8 +1356,16 @@ window.showSchedule = async (id: number) => {
 						}
             <br>
             <table>
-                <thead><tr><th>Дата</th><th>Сумма</th><th>Осн. долг</th><th>Проценты</th><th>Остаток</th><th>Статус</th></tr></thead>
+                <thead><tr><th>Дата</th><th>Сумма</th><th>Осн. долг</th><th>Проценты</th><th>Пени</th><th>Остаток</th><th>Статус</th></tr></thead>
                 <tbody>${scheduleRows}</tbody>
+                <tfoot><tr style="font-weight:bold">
+                    <td>Итого</td>
+                    <td>${formatMoney(schedule.total.payment)} ₽</td>
+                    <td>${formatMoney(schedule.total.principal)}</td>
+                    <td>${formatMoney(schedule.total.interest)}</td>
+                    <td>${formatMoney(schedule.total.penalty)}</td>
+                    <td colspan="2"></td>
+                </tr></tfoot>
             </table>
         </div>
 
@@ -1314,14 +1513,14 @@ async function generateContractPDF(contractId: number) {
 		],
 	]
 
-	schedule.forEach((row: any, index: number) => {
+	schedule.payments.forEach((row: any, index: number) => {
 		tableBody.push([
 			(index + 1).toString(),
 			new Date(row.paymentDate).toLocaleDateString(),
-			row.paymentAmount.toFixed(2),
-			row.principal.toFixed(2),
-			row.interest.toFixed(2),
-			row.remainingBalance.toFixed(2),
+			row.paymentAmount,
+			row.principal,
+			row.interest,
+			row.remainingBalance,
 		])
 	})
 
@@ -1352,7 +1551,7 @@ async function generateContractPDF(contractId: number) {
 					'Банк обязуется предоставить Заемщику (',
 					{ text: contract.clientName, bold: true },
 					') денежные средства (Кредит) в размере ',
-					{ text: `${contract.amount.toLocaleString()} руб.`, bold: true },
+					{ text: `${formatMoney(contract.amount)} руб.`, bold: true },
 					' на срок ',
 					{ text: `${contract.termMonths} мес.`, bold: true },
 					' под ',
@@ -1433,25 +1632,45 @@ window.payInstallment = async (
 	amount: number,
 	contractId: number
 ) => {
-	if (!confirm(`Выполнить списание средств в размере ${amount.toFixed(2)} ₽?`))
+	if (
+		!confirm(
+			`Выполнить списание средств в размере ${formatMoney(
+				amount
+			)} ₽?\n\nСначала гасятся пени, затем проценты и основной долг.`
+		)
+	)
 		return
 
 	const res = await api.makePayment(scheduleId)
 
 	if (res && res.message) {
-		alert('Платеж успешно выполнен!')
+		const penalty = Number(res.paid?.penalty || 0)
+		alert(
+			penalty > 0
+				? `Платеж успешно выполнен! Из него оплачено пени: ${formatMoney(penalty)} ₽`
+				: 'Платеж успешно выполнен!'
+		)
 		window.showSchedule(contractId)
 	}
 }
 
-function formatMoney(amount: number): string {
-	return amount.toLocaleString('ru-RU', {
+function formatMoney(amount: number | Money): string {
+	return Number(amount).toLocaleString('ru-RU', {
 		minimumFractionDigits: 2,
 		maximumFractionDigits: 2,
 		useGrouping: true,
 	})
 }
 
+function scheduleTypeName(t: ScheduleType): string {
+	const m: Record<ScheduleType, string> = {
+		annuity: 'аннуитетный',
+		differentiated: 'дифференцированный',
+		bullet: 'проценты ежемесячно, долг в конце срока',
+	}
+	return m[t] || m.annuity
+}
+
 window.doEarlyRepayment = async (contractId: number, balance: number) => {
 	if (
 		!confirm(
@@ -1471,11 +1690,107 @@ window.doEarlyRepayment = async (contractId: number, balance: number) => {
 	}
 }
 
+window.showPartialRepayment = async (contractId: number, balance: number) => {
+	const schedule = await api.getSchedule(contractId)
+	// При погашении долга в конце срока платеж состоит из одних процентов,
+	// сокращать срок нечем.
+	const canReduceTerm = schedule.scheduleType !== 'bullet'
+
+	document.getElementById('page-content')!.innerHTML = `
+        <div class="card" style="max-width: 520px;">
+            <h3>Частичное досрочное погашение</h3>
+            <div style="margin: 10px 0; font-size: 0.9rem; color: #666;">Текущий долг: <b>${formatMoney(
+							balance
+						)} ₽</b></div>
+            <div class="form-group">
+                <label>Сумма, ₽ *</label>
+                <input id="repay-amount" type="number" min="0.01" max="${balance}" step="0.01">
+            </div>
+            <div class="form-group">
+                <label>Что уменьшить</label>
+                <label style="display:block; font-weight:normal;">
+                    <input type="radio" name="repay-mode" value="term" ${
+											canReduceTerm ? 'checked' : 'disabled'
+										}> Срок кредита (платеж не изменится)
+                </label>
+                <label style="display:block; font-weight:normal;">
+                    <input type="radio" name="repay-mode" value="payment" ${
+											canReduceTerm ? '' : 'checked'
+										}> Ежемесячный платеж (срок не изменится)
+                </label>
+            </div>
+            <button class="btn btn-primary" onclick="window.doPartialRepayment(${contractId})">Погасить</button>
+            <button class="btn btn-secondary" onclick="window.showSchedule(${contractId})">Назад</button>
+        </div>
+    `
+}
+
+window.doPartialRepayment = async (contractId: number) => {
+	const amount = (document.getElementById('repay-amount') as HTMLInputElement)
+		.value
+	const mode = (
+		document.querySelector(
+			'input[name="repay-mode"]:checked'
+		) as HTMLInputElement | null
+	)?.value as RepayMode | undefined
+
+	if (!amount || Number(amount) <= 0) {
+		alert('Укажите сумму погашения')
+		return
+	}
+
+	const res = await api.repayEarly(contractId, Number(amount).toFixed(2), mode)
+	if (!res) return
+
+	const upcoming = (s: RepaymentSchedule) =>
+		s.payments.filter((p: any) => !p.isPaid)
+	const before = upcoming(res.before)
+	const after = upcoming(res.after)
+	const sum = (rows: any[], key: string) =>
+		rows.reduce((acc, r) => acc + Number(r[key]), 0)
+
+	const rows = before
+		.map((old: any, i: number) => {
+			const cur = after[i]
+			return `
+            <tr>
+                <td>${new Date(old.paymentDate).toLocaleDateString()}</td>
+                <td>${formatMoney(old.paymentAmount)} ₽</td>
+                <td style="color:#666">${formatMoney(old.interest)}</td>
+                <td><b>${cur ? formatMoney(cur.paymentAmount) + ' ₽' : '—'}</b></td>
+                <td style="color:#666">${cur ? formatMoney(cur.interest) : '—'}</td>
+            </tr>`
+		})
+		.join('')
+
+	document.getElementById('page-content')!.innerHTML = `
+        <div class="card">
+            <h3>${res.message}</h3>
+            <div style="margin: 10px 0; font-size: 0.9rem; color: #666;">
+                Списано: <b>${formatMoney(res.paidAmount)} ₽</b>.
+                Платежей: ${before.length} → ${after.length},
+                проценты: ${formatMoney(sum(before, 'interest'))} → ${formatMoney(
+		sum(after, 'interest')
+	)} ₽
+            </div>
+            <table>
+                <thead>
+                    <tr><th rowspan="2">Дата</th><th colspan="2">Было</th><th colspan="2">Стало</th></tr>
+                    <tr><th>Платеж</th><th>Проценты</th><th>Платеж</th><th>Проценты</th></tr>
+                </thead>
+                <tbody>${rows}</tbody>
+            </table>
+            <br>
+            <button class="btn btn-primary" onclick="window.showSchedule(${contractId})">К графику</button>
+        </div>
+    `
+}
+
 function renderStatsPage(stats: any, financeReport: any[]) {
     
     const reportRows = financeReport.map(r => {
-        const color = r.net >= 0 ? '#2e7d32' : '#c62828';
-        const sign = r.net > 0 ? '+' : '';
+        const color = Number(r.net) >= 0 ? '#2e7d32' : '#c62828';
+        const sign = Number(r.net) > 0 ? '+' : '';
 
         return `
             <tr>
@@ -1489,20 +1804,22 @@ function renderStatsPage(stats: any, financeReport: any[]) {
         `;
     }).join('');
 
+    const delinquency: any[] = stats.delinquency || [];
+
     return `
         <!-- Блок 1: Карточки -->
         <div style="display: grid; grid-template-columns: 1fr 1fr; gap: 20px; margin-bottom: 20px;">
             <div class="card" style="background: linear-gradient(135deg, #fff0f3 0%, #fff 100%); border-left: 5px solid var(--accent-rose);">
                 <h4 style="color: #666; margin-bottom: 10px;">Всего выдано (тело кредитов)</h4>
                 <div style="font-size: 1.8em; font-weight: bold; color: var(--accent-rose);">
-                    ${formatMoney(stats.totalIssued / 100)} ₽
+                    ${formatMoney(stats.totalIssued)} ₽
                 </div>
             </div>
             
             <div class="card" style="background: linear-gradient(135deg, #e8f5e9 0%, #fff 100%); border-left: 5px solid #2e7d32;">
                 <h4 style="color: #666; margin-bottom: 10px;">Всего возвращено (платежи)</h4>
                 <div style="font-size: 1.8em; font-weight: bold; color: #2e7d32;">
-                    ${formatMoney(stats.totalRepaid / 100)} ₽
+                    ${formatMoney(stats.totalRepaid)} ₽
                 </div>
             </div>
         </div>
@@ -1537,6 +1854,19 @@ function renderStatsPage(stats: any, financeReport: any[]) {
                 </div>
             </div>
         </div>
+
+        <!-- Блок 4: Просрочка по корзинам -->
+        <div class="card" style="margin-top: 20px;">
+            <h3>Просроченные договоры</h3>
+            <table>
+                <thead>
+                    <tr>${delinquency.map((b: any) => `<th>${b.label} дн.</th>`).join('')}</tr>
+                </thead>
+                <tbody>
+                    <tr>${delinquency.map((b: any) => `<td style="color: ${b.value > 0 ? '#c62828' : '#999'}; font-weight: bold;">${b.value}</td>`).join('')}</tr>
+                </tbody>
+            </table>
+        </div>
     `;
 }
 
@@ -2,31 +2,36 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/config"
 	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/backup"
 	"github.com/stepan41k/Kursach/5_semestr/pkg/handler"
 	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/auth"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/credentials"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/jobs"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/lifecycle"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/lockout"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/mail"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository/postgres"
 )
 
-func initDB() (*pgxpool.Pool) {
-	dbUrl := os.Getenv("DATABASE_URL")
-
-	if dbUrl == "" {
-		log.Fatal("DATABASE_URL environment variable is not set")
-	}
-
-	config, err := pgxpool.ParseConfig(dbUrl)
+func initDB(cfg config.Database) (*pgxpool.Pool) {
+	poolConfig, err := pgxpool.ParseConfig(cfg.URL)
 	if err != nil {
 		log.Fatal("Unable to parse DB URL:", err)
 	}
 
-	db, err := pgxpool.NewWithConfig(context.Background(), config)
+	db, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
 	if err != nil {
 		log.Fatal("Unable to connect to database:", err)
 	}
@@ -40,18 +45,71 @@ func initDB() (*pgxpool.Pool) {
 }
 
 
+// migrateCredentials - подкоманда migrate-credentials. Режим rehash, при
+// котором старые открытые пароли остаются рабочими до первой смены,
+// включается только явно.
+func migrateCredentials(db *pgxpool.Pool, args []string) {
+	fs := flag.NewFlagSet("migrate-credentials", flag.ExitOnError)
+	mode := fs.String("mode", string(credentials.ModeExpire), "expire | rehash")
+	fs.Parse(args)
+
+	res, err := credentials.Migrate(context.Background(), postgres.NewStore(db), credentials.Mode(*mode))
+	if err != nil {
+		log.Fatal("Credentials migration failed:", err)
+	}
+	log.Printf("Credentials migration done, %d account(s) flagged for password change", len(res.Migrated))
+}
+
+
 func main() {
-	db := initDB()
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to config file (.yaml or .toml)")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db := initDB(cfg.Database)
 
 	defer db.Close()
 
-	driver := handler.NewHandlerDriver(db)
+	if args := flag.Args(); len(args) > 0 && args[0] == "migrate-credentials" {
+		migrateCredentials(db, args[1:])
+		return
+	}
 
-	backup.StartDailyBackupScheduler()
+	store := postgres.NewStore(db)
+
+	// При старте открытые пароли только обесцениваются: войти ими нельзя,
+	// доступ восстанавливается через сброс пароля.
+	if _, err := credentials.Migrate(context.Background(), store, credentials.ModeExpire); err != nil {
+		log.Fatal("Credentials migration failed:", err)
+	}
+
+	sessions := auth.NewSessions(cfg.Auth, auth.NewPgRefreshStore(db))
+	limiter := lockout.NewPgLimiter(db, lockout.DefaultPolicy)
+	backups := backup.New(cfg.Backup)
+	mailer := mail.NewSender(cfg.Mail)
+	driver := handler.NewHandlerDriver(cfg.Auth, store, sessions, limiter, mailer, backups)
+	authz := auth.NewAuthorizer(auth.RoutePolicy, driver.AuditDenied)
+
+	workers := lifecycle.New()
+	workers.Go("backup-scheduler", backups.RunScheduler)
+	if cfg.Mail.Enabled() {
+		transport, err := mail.NewMailer(cfg.Mail)
+		if err != nil {
+			log.Fatal("Mail transport failed:", err)
+		}
+		workers.Go("mail", mail.NewWorker(store.Outbox(), transport, cfg.Mail).Run)
+		workers.Go("reminders", jobs.Every("reminders", cfg.Jobs.Interval.Std(), jobs.SendPaymentReminders(store, mailer, cfg.Jobs.ReminderDays)))
+	}
+	workers.Go("penalties", jobs.Every("penalties", cfg.Jobs.Interval.Std(), jobs.AccruePenalties(store)))
+	workers.Go("delinquency", jobs.Every("delinquency", cfg.Jobs.Interval.Std(), jobs.ProcessDelinquency(store, cfg.Jobs.DefaultAfterDays)))
 
 	r := gin.Default()
 	r.Use(cors.New(cors.Config{
-			AllowOrigins:     []string{"http://localhost:3010"},
+			AllowOrigins:     cfg.HTTP.AllowOrigins,
 			AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 			AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
 			ExposeHeaders:    []string{"Content-Length"},
@@ -59,40 +117,43 @@ func main() {
 			MaxAge:           12 * time.Hour,
 		}))
 
-	api := r.Group("/api")
-	{
-		api.POST("/login", driver.LoginHandler)
-		api.POST("/refresh", handler.RefreshHandler)
-        api.POST("/logout", handler.LogoutHandler)
-		
-		protected := api.Group("/")
-		protected.Use(auth.AuthMiddleware())
-		{
-			protected.POST("/register", driver.RegisterHandler)
-
-			protected.GET("/clients", driver.GetClients)
-			protected.POST("/clients", driver.CreateClient)
-
-			protected.GET("/products", driver.GetProducts)
-			protected.POST("/loans", driver.IssueLoan)
-			protected.GET("/loans", driver.GetLoans)
-			protected.GET("/loans/:id/schedule", driver.GetSchedule)
-			protected.GET("/loans/:id/operations", driver.GetLoanOperationsHandler)
-			protected.GET("/my-loans", driver.GetMyLoansHandler)
-
-			protected.POST("/pay", driver.MakePaymentHandler)
-			protected.POST("/repay-early", driver.EarlyRepaymentHandler)
-
-			protected.GET("/employees", driver.GetEmployeesHandler)
-			
-			protected.GET("/logs", driver.GetLogsHandler)
-			protected.GET("/stats", driver.GetStatsHandler)
-			protected.GET("/finance-report", driver.GetFinanceReportHandler)
-
-			protected.POST("/backup", driver.CreateBackupHandler)
-		}
-		
+	driver.RegisterRoutes(r, authz)
+
+	serve(cfg.HTTP, r, workers)
+}
+
+// serve обслуживает HTTP до SIGINT/SIGTERM, затем дожидается текущих
+// запросов и останавливает воркеры. Пул БД закрывается уже после возврата.
+func serve(cfg config.HTTP, h http.Handler, workers *lifecycle.Manager) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{
+		Addr:    cfg.Addr,
+		Handler: h,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %s", cfg.Addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case <-ctx.Done():
+		log.Println("Shutdown signal received, draining...")
+	case err := <-serveErr:
+		log.Printf("HTTP server failed: %v", err)
 	}
 
-	r.Run(":8080")
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Std())
+	defer cancel()
+
+	if err := srv.Shutdown(drainCtx); err != nil {
+		log.Printf("HTTP shutdown: %v", err)
+	}
+	if err := workers.Shutdown(drainCtx); err != nil {
+		log.Printf("Workers shutdown: %v", err)
+	}
+	log.Println("Server stopped")
 }
\ No newline at end of file
@@ -0,0 +1,7 @@
+DROP PROCEDURE IF EXISTS sp_revoke_user_sessions (BIGINT);
+
+DROP PROCEDURE IF EXISTS sp_revoke_refresh_family (VARCHAR);
+
+DROP PROCEDURE IF EXISTS sp_save_refresh_token (VARCHAR, VARCHAR, BIGINT, TIMESTAMPTZ);
+
+DROP TABLE IF EXISTS refresh_tokens;
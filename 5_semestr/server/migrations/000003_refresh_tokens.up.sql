@@ -0,0 +1,51 @@
+CREATE TABLE
+    refresh_tokens (
+        id VARCHAR(64) PRIMARY KEY,
+        family_id VARCHAR(64) NOT NULL,
+        user_id BIGINT NOT NULL REFERENCES users (id),
+        expires_at TIMESTAMPTZ NOT NULL,
+        used_at TIMESTAMPTZ,
+        revoked_at TIMESTAMPTZ,
+        created_at TIMESTAMPTZ DEFAULT NOW()
+    );
+
+CREATE INDEX idx_refresh_tokens_family ON refresh_tokens (family_id);
+
+CREATE INDEX idx_refresh_tokens_user ON refresh_tokens (user_id);
+
+-- SaveRefreshToken
+CREATE
+OR REPLACE PROCEDURE sp_save_refresh_token (
+    p_id VARCHAR,
+    p_family_id VARCHAR,
+    p_user_id BIGINT,
+    p_expires_at TIMESTAMPTZ
+) LANGUAGE plpgsql AS $$
+BEGIN
+    INSERT INTO refresh_tokens (id, family_id, user_id, expires_at)
+    VALUES (p_id, p_family_id, p_user_id, p_expires_at);
+
+    DELETE FROM refresh_tokens
+    WHERE user_id = p_user_id AND expires_at < NOW() - INTERVAL '1 day';
+END;
+$$;
+
+-- RevokeRefreshFamily
+CREATE
+OR REPLACE PROCEDURE sp_revoke_refresh_family (p_family_id VARCHAR) LANGUAGE plpgsql AS $$
+BEGIN
+    UPDATE refresh_tokens
+    SET revoked_at = NOW()
+    WHERE family_id = p_family_id AND revoked_at IS NULL;
+END;
+$$;
+
+-- RevokeUserSessions
+CREATE
+OR REPLACE PROCEDURE sp_revoke_user_sessions (p_user_id BIGINT) LANGUAGE plpgsql AS $$
+BEGIN
+    UPDATE refresh_tokens
+    SET revoked_at = NOW()
+    WHERE user_id = p_user_id AND revoked_at IS NULL;
+END;
+$$;
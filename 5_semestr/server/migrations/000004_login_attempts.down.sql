@@ -0,0 +1,3 @@
+DROP FUNCTION IF EXISTS fn_register_login_failure (VARCHAR, INT);
+
+DROP TABLE IF EXISTS login_attempts;
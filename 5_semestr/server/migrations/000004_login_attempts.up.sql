@@ -0,0 +1,31 @@
+CREATE TABLE
+    login_attempts (
+        key VARCHAR(150) PRIMARY KEY,
+        failures INT NOT NULL DEFAULT 0,
+        last_failure_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        blocked_until TIMESTAMPTZ,
+        locked BOOLEAN NOT NULL DEFAULT FALSE
+    );
+
+-- RegisterLoginFailure
+CREATE
+OR REPLACE FUNCTION fn_register_login_failure (
+    p_key VARCHAR,
+    p_window_seconds INT
+) RETURNS TABLE (
+    failures INT,
+    failed_at TIMESTAMPTZ
+) AS $$
+BEGIN
+    RETURN QUERY
+    INSERT INTO login_attempts AS la (key, failures, last_failure_at)
+    VALUES (p_key, 1, NOW())
+    ON CONFLICT (key) DO UPDATE
+    SET failures = CASE
+            WHEN la.last_failure_at < NOW() - make_interval(secs => p_window_seconds) THEN 1
+            ELSE la.failures + 1
+        END,
+        last_failure_at = NOW()
+    RETURNING la.failures, la.last_failure_at;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,22 @@
+DROP PROCEDURE IF EXISTS sp_set_role_2fa (VARCHAR, BOOLEAN);
+
+DROP PROCEDURE IF EXISTS sp_disable_totp (BIGINT);
+
+DROP FUNCTION IF EXISTS fn_use_recovery_code (BIGINT, VARCHAR);
+
+DROP FUNCTION IF EXISTS fn_use_totp_step (BIGINT, BIGINT);
+
+DROP PROCEDURE IF EXISTS sp_confirm_totp (BIGINT, BIGINT, VARCHAR[]);
+
+DROP PROCEDURE IF EXISTS sp_begin_totp_enrollment (BIGINT, VARCHAR);
+
+DROP FUNCTION IF EXISTS fn_get_user_2fa (BIGINT);
+
+DROP FUNCTION IF EXISTS fn_get_user_profile (BIGINT);
+
+DROP TABLE IF EXISTS user_recovery_codes;
+
+DROP TABLE IF EXISTS user_totp;
+
+ALTER TABLE roles
+DROP COLUMN IF EXISTS require_2fa;
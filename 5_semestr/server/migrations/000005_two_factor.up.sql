@@ -0,0 +1,147 @@
+ALTER TABLE roles
+ADD COLUMN require_2fa BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE
+    user_totp (
+        user_id BIGINT PRIMARY KEY REFERENCES users (id),
+        secret VARCHAR(64) NOT NULL,
+        confirmed_at TIMESTAMPTZ,
+        last_used_step BIGINT,
+        created_at TIMESTAMPTZ DEFAULT NOW()
+    );
+
+CREATE TABLE
+    user_recovery_codes (
+        id BIGSERIAL PRIMARY KEY,
+        user_id BIGINT NOT NULL REFERENCES users (id),
+        code_hash VARCHAR(64) NOT NULL,
+        used_at TIMESTAMPTZ
+    );
+
+CREATE INDEX idx_recovery_codes_user ON user_recovery_codes (user_id);
+
+-- GetUserProfile
+CREATE
+OR REPLACE FUNCTION fn_get_user_profile (p_user_id BIGINT) RETURNS TABLE (
+    login VARCHAR,
+    role_name VARCHAR,
+    first_name VARCHAR,
+    last_name VARCHAR,
+    email VARCHAR
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT v.login, v.role_name, v.first_name, v.last_name, v.email
+    FROM v_user_complete_profile v
+    WHERE v.user_id = p_user_id AND v.is_active = TRUE;
+END;
+$$ LANGUAGE plpgsql;
+
+-- GetUserTwoFactor
+CREATE
+OR REPLACE FUNCTION fn_get_user_2fa (p_user_id BIGINT) RETURNS TABLE (
+    secret VARCHAR,
+    confirmed BOOLEAN,
+    required BOOLEAN
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT
+        t.secret,
+        t.confirmed_at IS NOT NULL,
+        r.require_2fa
+    FROM users u
+    JOIN roles r ON u.role_id = r.id
+    LEFT JOIN user_totp t ON t.user_id = u.id
+    WHERE u.id = p_user_id;
+END;
+$$ LANGUAGE plpgsql;
+
+-- BeginTotpEnrollment
+CREATE
+OR REPLACE PROCEDURE sp_begin_totp_enrollment (p_user_id BIGINT, p_secret VARCHAR) LANGUAGE plpgsql AS $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM user_totp WHERE user_id = p_user_id AND confirmed_at IS NOT NULL) THEN
+        RAISE EXCEPTION 'Двухфакторная аутентификация уже подключена';
+    END IF;
+
+    INSERT INTO user_totp (user_id, secret)
+    VALUES (p_user_id, p_secret)
+    ON CONFLICT (user_id) DO UPDATE
+    SET secret = EXCLUDED.secret, last_used_step = NULL, created_at = NOW();
+END;
+$$;
+
+-- ConfirmTotp
+CREATE
+OR REPLACE PROCEDURE sp_confirm_totp (
+    p_user_id BIGINT,
+    p_step BIGINT,
+    p_code_hashes VARCHAR[]
+) LANGUAGE plpgsql AS $$
+BEGIN
+    UPDATE user_totp
+    SET confirmed_at = NOW(), last_used_step = p_step
+    WHERE user_id = p_user_id AND confirmed_at IS NULL;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Нет ожидающей подтверждения настройки 2FA';
+    END IF;
+
+    DELETE FROM user_recovery_codes WHERE user_id = p_user_id;
+
+    INSERT INTO user_recovery_codes (user_id, code_hash)
+    SELECT p_user_id, h FROM UNNEST(p_code_hashes) AS h;
+END;
+$$;
+
+-- UseTotpStep (защита от повторного использования кода)
+CREATE
+OR REPLACE FUNCTION fn_use_totp_step (p_user_id BIGINT, p_step BIGINT) RETURNS BOOLEAN AS $$
+BEGIN
+    UPDATE user_totp
+    SET last_used_step = p_step
+    WHERE user_id = p_user_id
+      AND confirmed_at IS NOT NULL
+      AND (last_used_step IS NULL OR last_used_step < p_step);
+
+    RETURN FOUND;
+END;
+$$ LANGUAGE plpgsql;
+
+-- UseRecoveryCode
+CREATE
+OR REPLACE FUNCTION fn_use_recovery_code (p_user_id BIGINT, p_code_hash VARCHAR) RETURNS BOOLEAN AS $$
+BEGIN
+    UPDATE user_recovery_codes
+    SET used_at = NOW()
+    WHERE id = (
+        SELECT id FROM user_recovery_codes
+        WHERE user_id = p_user_id AND code_hash = p_code_hash AND used_at IS NULL
+        LIMIT 1
+    );
+
+    RETURN FOUND;
+END;
+$$ LANGUAGE plpgsql;
+
+-- DisableTotp
+CREATE
+OR REPLACE PROCEDURE sp_disable_totp (p_user_id BIGINT) LANGUAGE plpgsql AS $$
+BEGIN
+    DELETE FROM user_recovery_codes WHERE user_id = p_user_id;
+    DELETE FROM user_totp WHERE user_id = p_user_id;
+END;
+$$;
+
+-- SetRoleTwoFactor
+CREATE
+OR REPLACE PROCEDURE sp_set_role_2fa (p_role VARCHAR, p_required BOOLEAN) LANGUAGE plpgsql AS $$
+BEGIN
+    UPDATE roles SET require_2fa = p_required WHERE name = p_role;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Роль % не найдена', p_role;
+    END IF;
+END;
+$$;
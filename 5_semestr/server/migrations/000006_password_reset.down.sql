@@ -0,0 +1,16 @@
+DROP FUNCTION IF EXISTS fn_session_valid (BIGINT, TIMESTAMPTZ);
+
+DROP FUNCTION IF EXISTS fn_consume_password_reset (VARCHAR);
+
+DROP PROCEDURE IF EXISTS sp_create_password_reset (BIGINT, VARCHAR, INT);
+
+DROP FUNCTION IF EXISTS fn_find_user_for_reset (VARCHAR);
+
+DROP PROCEDURE IF EXISTS sp_set_password (BIGINT, VARCHAR);
+
+DROP FUNCTION IF EXISTS fn_get_user_password (BIGINT);
+
+DROP TABLE IF EXISTS password_reset_tokens;
+
+ALTER TABLE users
+DROP COLUMN IF EXISTS password_changed_at;
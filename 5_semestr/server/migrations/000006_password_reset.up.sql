@@ -0,0 +1,113 @@
+ALTER TABLE users
+ADD COLUMN password_changed_at TIMESTAMPTZ;
+
+CREATE TABLE
+    password_reset_tokens (
+        id BIGSERIAL PRIMARY KEY,
+        user_id BIGINT NOT NULL REFERENCES users (id),
+        token_hash VARCHAR(64) NOT NULL UNIQUE,
+        expires_at TIMESTAMPTZ NOT NULL,
+        used_at TIMESTAMPTZ,
+        created_at TIMESTAMPTZ DEFAULT NOW()
+    );
+
+CREATE INDEX idx_password_reset_user ON password_reset_tokens (user_id);
+
+-- GetUserPassword
+CREATE
+OR REPLACE FUNCTION fn_get_user_password (p_user_id BIGINT) RETURNS TABLE (
+    login VARCHAR,
+    password_hash VARCHAR
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT u.login, u.password_hash
+    FROM users u
+    WHERE u.id = p_user_id AND u.is_active = TRUE;
+END;
+$$ LANGUAGE plpgsql;
+
+-- SetPassword (смена пароля завершает все сессии пользователя)
+CREATE
+OR REPLACE PROCEDURE sp_set_password (p_user_id BIGINT, p_password_hash VARCHAR) LANGUAGE plpgsql AS $$
+BEGIN
+    UPDATE users
+    SET password_hash = p_password_hash, password_changed_at = NOW()
+    WHERE id = p_user_id;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Пользователь не найден';
+    END IF;
+
+    UPDATE password_reset_tokens
+    SET used_at = NOW()
+    WHERE user_id = p_user_id AND used_at IS NULL;
+
+    CALL sp_revoke_user_sessions(p_user_id);
+END;
+$$;
+
+-- FindUserForReset
+CREATE
+OR REPLACE FUNCTION fn_find_user_for_reset (p_login VARCHAR) RETURNS TABLE (
+    user_id BIGINT,
+    first_name VARCHAR,
+    last_name VARCHAR,
+    email VARCHAR
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT v.user_id, v.first_name, v.last_name, v.email
+    FROM v_user_complete_profile v
+    WHERE (v.login = p_login OR LOWER(v.email) = LOWER(p_login))
+      AND v.is_active = TRUE
+      AND v.email IS NOT NULL AND v.email != ''
+    ORDER BY (v.login = p_login) DESC
+    LIMIT 1;
+END;
+$$ LANGUAGE plpgsql;
+
+-- CreatePasswordReset
+CREATE
+OR REPLACE PROCEDURE sp_create_password_reset (
+    p_user_id BIGINT,
+    p_token_hash VARCHAR,
+    p_ttl_seconds INT
+) LANGUAGE plpgsql AS $$
+BEGIN
+    UPDATE password_reset_tokens
+    SET used_at = NOW()
+    WHERE user_id = p_user_id AND used_at IS NULL;
+
+    INSERT INTO password_reset_tokens (user_id, token_hash, expires_at)
+    VALUES (p_user_id, p_token_hash, NOW() + make_interval(secs => p_ttl_seconds));
+END;
+$$;
+
+-- ConsumePasswordReset
+CREATE
+OR REPLACE FUNCTION fn_consume_password_reset (p_token_hash VARCHAR) RETURNS BIGINT AS $$
+DECLARE
+    v_user_id BIGINT;
+BEGIN
+    UPDATE password_reset_tokens
+    SET used_at = NOW()
+    WHERE token_hash = p_token_hash AND used_at IS NULL AND expires_at > NOW()
+    RETURNING user_id INTO v_user_id;
+
+    RETURN v_user_id;
+END;
+$$ LANGUAGE plpgsql;
+
+-- SessionValid (токены, выданные до смены пароля, недействительны)
+CREATE
+OR REPLACE FUNCTION fn_session_valid (p_user_id BIGINT, p_issued_at TIMESTAMPTZ) RETURNS BOOLEAN AS $$
+BEGIN
+    RETURN EXISTS (
+        SELECT 1 FROM users u
+        WHERE u.id = p_user_id
+          AND u.is_active = TRUE
+          AND (u.password_changed_at IS NULL OR p_issued_at >= date_trunc('second', u.password_changed_at))
+    );
+END;
+$$ LANGUAGE plpgsql;
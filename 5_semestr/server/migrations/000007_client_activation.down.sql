@@ -0,0 +1,17 @@
+DROP FUNCTION IF EXISTS fn_consume_activation_token (VARCHAR);
+
+DROP FUNCTION IF EXISTS fn_get_client_activation (BIGINT);
+
+DROP PROCEDURE IF EXISTS sp_register_client (
+    VARCHAR, VARCHAR, VARCHAR, VARCHAR, VARCHAR, VARCHAR, VARCHAR, VARCHAR,
+    VARCHAR, VARCHAR, VARCHAR, VARCHAR, VARCHAR, INT, BIGINT, VARCHAR
+);
+
+DROP PROCEDURE IF EXISTS sp_create_activation_token (BIGINT, VARCHAR, INT);
+
+DROP FUNCTION IF EXISTS fn_next_free_login (VARCHAR);
+
+DROP TABLE IF EXISTS activation_tokens;
+
+ALTER TABLE users
+DROP COLUMN IF EXISTS activated_at;
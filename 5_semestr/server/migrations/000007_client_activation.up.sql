@@ -0,0 +1,136 @@
+ALTER TABLE users
+ADD COLUMN activated_at TIMESTAMPTZ DEFAULT NOW();
+
+UPDATE users
+SET activated_at = created_at;
+
+CREATE TABLE
+    activation_tokens (
+        id BIGSERIAL PRIMARY KEY,
+        user_id BIGINT NOT NULL REFERENCES users (id),
+        token_hash VARCHAR(64) NOT NULL UNIQUE,
+        expires_at TIMESTAMPTZ NOT NULL,
+        used_at TIMESTAMPTZ,
+        created_at TIMESTAMPTZ DEFAULT NOW()
+    );
+
+CREATE INDEX idx_activation_tokens_user ON activation_tokens (user_id);
+
+-- NextFreeLogin
+CREATE
+OR REPLACE FUNCTION fn_next_free_login (p_base VARCHAR) RETURNS VARCHAR AS $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM users WHERE login = p_base) THEN
+        RETURN p_base;
+    END IF;
+
+    RETURN (
+        SELECT p_base || s
+        FROM generate_series(2, 1000000) AS s
+        WHERE NOT EXISTS (SELECT 1 FROM users WHERE login = p_base || s)
+        LIMIT 1
+    );
+END;
+$$ LANGUAGE plpgsql;
+
+-- CreateActivationToken
+CREATE
+OR REPLACE PROCEDURE sp_create_activation_token (
+    p_user_id BIGINT,
+    p_token_hash VARCHAR,
+    p_ttl_seconds INT
+) LANGUAGE plpgsql AS $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM users WHERE id = p_user_id AND activated_at IS NOT NULL) THEN
+        RAISE EXCEPTION 'Учетная запись уже активирована';
+    END IF;
+
+    UPDATE activation_tokens
+    SET used_at = NOW()
+    WHERE user_id = p_user_id AND used_at IS NULL;
+
+    INSERT INTO activation_tokens (user_id, token_hash, expires_at)
+    VALUES (p_user_id, p_token_hash, NOW() + make_interval(secs => p_ttl_seconds));
+END;
+$$;
+
+-- RegisterClient (логин подбирается под блокировкой, чтобы быть уникальным)
+CREATE
+OR REPLACE PROCEDURE sp_register_client (
+    p_login_base VARCHAR,
+    p_password VARCHAR,
+    p_first_name VARCHAR,
+    p_last_name VARCHAR,
+    p_middle_name VARCHAR,
+    p_passport_series VARCHAR,
+    p_passport_number VARCHAR,
+    p_passport_issued VARCHAR,
+    p_dob VARCHAR,
+    p_address VARCHAR,
+    p_phone VARCHAR,
+    p_email VARCHAR,
+    p_token_hash VARCHAR,
+    p_ttl_seconds INT,
+    INOUT p_client_id BIGINT DEFAULT NULL,
+    INOUT p_login VARCHAR DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_user_id BIGINT;
+BEGIN
+    PERFORM pg_advisory_xact_lock(hashtext('login:' || p_login_base));
+
+    p_login := fn_next_free_login(p_login_base);
+
+    CALL sp_create_client(
+        p_login, p_password,
+        p_first_name, p_last_name, p_middle_name,
+        p_passport_series, p_passport_number, p_passport_issued,
+        p_dob, p_address, p_phone, p_email,
+        p_client_id
+    );
+
+    SELECT user_id INTO v_user_id FROM clients WHERE id = p_client_id;
+
+    UPDATE users SET activated_at = NULL WHERE id = v_user_id;
+
+    CALL sp_create_activation_token(v_user_id, p_token_hash, p_ttl_seconds);
+END;
+$$;
+
+-- GetClientActivation
+CREATE
+OR REPLACE FUNCTION fn_get_client_activation (p_client_id BIGINT) RETURNS TABLE (
+    user_id BIGINT,
+    login VARCHAR,
+    first_name VARCHAR,
+    last_name VARCHAR,
+    email VARCHAR,
+    activated BOOLEAN
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT u.id, u.login, c.first_name, c.last_name, c.email, u.activated_at IS NOT NULL
+    FROM clients c
+    JOIN users u ON c.user_id = u.id
+    WHERE c.id = p_client_id;
+END;
+$$ LANGUAGE plpgsql;
+
+-- ConsumeActivationToken
+CREATE
+OR REPLACE FUNCTION fn_consume_activation_token (p_token_hash VARCHAR) RETURNS BIGINT AS $$
+DECLARE
+    v_user_id BIGINT;
+BEGIN
+    UPDATE activation_tokens
+    SET used_at = NOW()
+    WHERE token_hash = p_token_hash AND used_at IS NULL AND expires_at > NOW()
+    RETURNING user_id INTO v_user_id;
+
+    IF v_user_id IS NOT NULL THEN
+        UPDATE users SET activated_at = NOW() WHERE id = v_user_id;
+    END IF;
+
+    RETURN v_user_id;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,68 @@
+CREATE
+OR REPLACE PROCEDURE sp_set_password (p_user_id BIGINT, p_password_hash VARCHAR) LANGUAGE plpgsql AS $$
+BEGIN
+    UPDATE users
+    SET password_hash = p_password_hash, password_changed_at = NOW()
+    WHERE id = p_user_id;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Пользователь не найден';
+    END IF;
+
+    UPDATE password_reset_tokens
+    SET used_at = NOW()
+    WHERE user_id = p_user_id AND used_at IS NULL;
+
+    CALL sp_revoke_user_sessions(p_user_id);
+END;
+$$;
+
+DROP PROCEDURE IF EXISTS sp_replace_credential (BIGINT, VARCHAR);
+
+DROP FUNCTION IF EXISTS fn_get_plaintext_credentials ();
+
+DROP FUNCTION IF EXISTS fn_get_user_profile (BIGINT);
+
+CREATE
+OR REPLACE FUNCTION fn_get_user_profile (p_user_id BIGINT) RETURNS TABLE (
+    login VARCHAR,
+    role_name VARCHAR,
+    first_name VARCHAR,
+    last_name VARCHAR,
+    email VARCHAR
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT v.login, v.role_name, v.first_name, v.last_name, v.email
+    FROM v_user_complete_profile v
+    WHERE v.user_id = p_user_id AND v.is_active = TRUE;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP FUNCTION IF EXISTS fn_get_user_by_login (VARCHAR);
+
+CREATE
+OR REPLACE FUNCTION fn_get_user_by_login (p_login VARCHAR) RETURNS TABLE (
+    user_id BIGINT,
+    password_hash VARCHAR,
+    role_name VARCHAR,
+    first_name VARCHAR,
+    last_name VARCHAR,
+    email VARCHAR
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT 
+        v.user_id,
+        v.password_hash,
+        v.role_name,
+        v.first_name,
+        v.last_name,
+        v.email
+    FROM v_user_complete_profile v
+    WHERE v.login = p_login AND v.is_active = TRUE;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE users
+DROP COLUMN IF EXISTS must_change_password;
@@ -0,0 +1,105 @@
+ALTER TABLE users
+ADD COLUMN must_change_password BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Любое значение, не являющееся bcrypt-хешем, считается паролем в открытом виде.
+UPDATE users
+SET must_change_password = TRUE
+WHERE password_hash !~ '^\$2[aby]\$';
+
+DROP FUNCTION IF EXISTS fn_get_user_by_login (VARCHAR);
+
+-- GetUser
+CREATE
+OR REPLACE FUNCTION fn_get_user_by_login (p_login VARCHAR) RETURNS TABLE (
+    user_id BIGINT,
+    password_hash VARCHAR,
+    role_name VARCHAR,
+    first_name VARCHAR,
+    last_name VARCHAR,
+    email VARCHAR,
+    must_change_password BOOLEAN
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT 
+        v.user_id,
+        v.password_hash,
+        v.role_name,
+        v.first_name,
+        v.last_name,
+        v.email,
+        u.must_change_password
+    FROM v_user_complete_profile v
+    JOIN users u ON u.id = v.user_id
+    WHERE v.login = p_login AND v.is_active = TRUE;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP FUNCTION IF EXISTS fn_get_user_profile (BIGINT);
+
+-- GetUserProfile
+CREATE
+OR REPLACE FUNCTION fn_get_user_profile (p_user_id BIGINT) RETURNS TABLE (
+    login VARCHAR,
+    role_name VARCHAR,
+    first_name VARCHAR,
+    last_name VARCHAR,
+    email VARCHAR,
+    must_change_password BOOLEAN
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT v.login, v.role_name, v.first_name, v.last_name, v.email, u.must_change_password
+    FROM v_user_complete_profile v
+    JOIN users u ON u.id = v.user_id
+    WHERE v.user_id = p_user_id AND v.is_active = TRUE;
+END;
+$$ LANGUAGE plpgsql;
+
+-- GetPlaintextCredentials
+CREATE
+OR REPLACE FUNCTION fn_get_plaintext_credentials () RETURNS TABLE (
+    user_id BIGINT,
+    login VARCHAR,
+    password_hash VARCHAR
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT u.id, u.login, u.password_hash
+    FROM users u
+    WHERE u.password_hash !~ '^\$2[aby]\$'
+    ORDER BY u.id;
+END;
+$$ LANGUAGE plpgsql;
+
+-- ReplaceCredential (новый хеш + обязательная смена пароля при входе)
+CREATE
+OR REPLACE PROCEDURE sp_replace_credential (p_user_id BIGINT, p_password_hash VARCHAR) LANGUAGE plpgsql AS $$
+BEGIN
+    UPDATE users
+    SET password_hash = p_password_hash, must_change_password = TRUE
+    WHERE id = p_user_id;
+
+    CALL sp_revoke_user_sessions(p_user_id);
+END;
+$$;
+
+-- SetPassword (смена пароля завершает все сессии пользователя)
+CREATE
+OR REPLACE PROCEDURE sp_set_password (p_user_id BIGINT, p_password_hash VARCHAR) LANGUAGE plpgsql AS $$
+BEGIN
+    UPDATE users
+    SET password_hash = p_password_hash, password_changed_at = NOW(), must_change_password = FALSE
+    WHERE id = p_user_id;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Пользователь не найден';
+    END IF;
+
+    UPDATE password_reset_tokens
+    SET used_at = NOW()
+    WHERE user_id = p_user_id AND used_at IS NULL;
+
+    CALL sp_revoke_user_sessions(p_user_id);
+END;
+$$;
@@ -0,0 +1,341 @@
+ALTER TABLE clients
+RENAME CONSTRAINT uq_clients_passport TO clients_passport_series_passport_number_key;
+
+-- ChangeHistory
+CREATE
+OR REPLACE FUNCTION prevent_change_history () RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'Безопасность: Изменение или удаление исторических записей операций ЗАПРЕЩЕНО!';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE
+OR REPLACE FUNCTION check_client_creditworthiness () RETURNS TRIGGER AS $$
+DECLARE
+    bad_debt_count INT;
+BEGIN
+    SELECT COUNT(*) INTO bad_debt_count
+    FROM repayment_schedule rs
+    JOIN loan_contracts lc ON rs.contract_id = lc.id
+    WHERE lc.client_id = NEW.client_id
+      AND rs.is_paid = FALSE
+      AND rs.payment_date < (CURRENT_DATE - INTERVAL '5 days');
+
+    IF bad_debt_count > 0 THEN
+        RAISE EXCEPTION 'ОТКАЗАНО: У клиента имеется непогашенная просроченная задолженность.';
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE
+OR REPLACE PROCEDURE sp_issue_loan (
+    p_client_id BIGINT,
+    p_product_id INT,
+    p_amount BIGINT,
+    p_term_months INT,
+    p_employee_id INT,
+    INOUT p_new_id BIGINT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_rate NUMERIC;
+    v_annuity BIGINT;
+    v_balance BIGINT;
+    v_monthly_rate NUMERIC;
+    v_interest_part BIGINT;
+    v_principal_part BIGINT;
+    v_current_date DATE := CURRENT_DATE;
+    i INT;
+BEGIN
+    SELECT interest_rate INTO v_rate FROM credit_products WHERE id = p_product_id;
+    
+    v_monthly_rate := v_rate / 12 / 100;
+    v_annuity := fn_calculate_annuity(p_amount, v_rate, p_term_months);
+    v_balance := p_amount;
+
+    INSERT INTO loan_contracts (
+        contract_number, client_id, product_id, approved_by_employee_id, 
+        amount, interest_rate, term_months, start_date, end_date, status, balance
+    ) VALUES (
+        'LN-' || CAST(EXTRACT(EPOCH FROM NOW()) AS BIGINT) || '-' || p_client_id,
+        p_client_id, p_product_id, p_employee_id,
+        p_amount, v_rate, p_term_months, v_current_date, 
+        v_current_date + (p_term_months || ' months')::INTERVAL, 
+        'active', p_amount
+    ) RETURNING id INTO p_new_id;
+
+    FOR i IN 1..p_term_months LOOP
+        v_current_date := v_current_date + INTERVAL '1 month';
+        
+        v_interest_part := ROUND(v_balance * v_monthly_rate)::BIGINT;
+        v_principal_part := v_annuity - v_interest_part;
+
+        IF i = p_term_months OR v_principal_part > v_balance THEN
+            v_principal_part := v_balance;
+            v_annuity := v_principal_part + v_interest_part;
+        END IF;
+
+        v_balance := v_balance - v_principal_part;
+
+        INSERT INTO repayment_schedule (
+            contract_id, payment_date, payment_amount, 
+            principal_amount, interest_amount, remaining_balance, is_paid
+        ) VALUES (
+            p_new_id, v_current_date, v_annuity, 
+            v_principal_part, v_interest_part, v_balance, FALSE
+        );
+    END LOOP;
+
+    INSERT INTO operations (contract_id, employee_id, operation_type, amount, description)
+    VALUES (p_new_id, p_employee_id, 'issue', p_amount, 'Выдача');
+END;
+$$;
+
+CREATE
+OR REPLACE PROCEDURE sp_make_payment (p_schedule_id BIGINT) LANGUAGE plpgsql AS $$
+DECLARE
+    v_contract_id BIGINT;
+    v_principal_amount NUMERIC;
+    v_payment_amount NUMERIC;
+    v_new_balance NUMERIC;
+    v_is_paid BOOLEAN;
+BEGIN
+    SELECT contract_id, principal_amount, payment_amount, is_paid 
+    INTO v_contract_id, v_principal_amount, v_payment_amount, v_is_paid
+    FROM repayment_schedule 
+    WHERE id = p_schedule_id;
+
+    IF v_contract_id IS NULL THEN
+        RAISE EXCEPTION 'Платеж не найден';
+    END IF;
+
+    IF v_is_paid THEN
+        RAISE EXCEPTION 'Этот платеж уже оплачен';
+    END IF;
+
+    UPDATE repayment_schedule 
+    SET is_paid = TRUE, paid_at = NOW() 
+    WHERE id = p_schedule_id;
+
+    UPDATE loan_contracts 
+    SET balance = balance - v_principal_amount 
+    WHERE id = v_contract_id
+    RETURNING balance INTO v_new_balance;
+
+    INSERT INTO operations (contract_id, operation_type, amount, description)
+    VALUES (v_contract_id, 'scheduled_payment', v_payment_amount, 'Здесь можно добавит способ оплаты');
+
+    IF v_new_balance <= 0 THEN
+        UPDATE loan_contracts 
+        SET status = 'closed', closed_at = NOW(), balance = 0 
+        WHERE id = v_contract_id;
+    END IF;
+END;
+$$;
+
+-- EarlyRepayement
+CREATE
+OR REPLACE PROCEDURE sp_early_repayment (
+    p_contract_id BIGINT,
+    p_user_id BIGINT,
+    INOUT p_paid_amount BIGINT DEFAULT 0
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_balance BIGINT;
+    v_status VARCHAR;
+    v_owner_id BIGINT;
+BEGIN
+    SELECT lc.balance, lc.status, cl.user_id 
+    INTO v_balance, v_status, v_owner_id
+    FROM loan_contracts lc JOIN clients cl ON lc.client_id = cl.id
+    WHERE lc.id = p_contract_id;
+
+    IF v_balance <= 0 THEN RAISE EXCEPTION 'Нет долга'; END IF;
+
+    p_paid_amount := v_balance;
+
+    UPDATE loan_contracts SET balance = 0, status = 'closed', closed_at = NOW() WHERE id = p_contract_id;
+    DELETE FROM repayment_schedule WHERE contract_id = p_contract_id AND is_paid = FALSE;
+    
+    INSERT INTO operations (contract_id, operation_type, amount, description, operation_date)
+    VALUES (p_contract_id, 'early_repayment', v_balance, 'Полное погашение', NOW());
+END;
+$$;
+
+-- CreateClient
+CREATE
+OR REPLACE PROCEDURE sp_create_client (
+    p_login VARCHAR,
+    p_password VARCHAR,
+    p_first_name VARCHAR,
+    p_last_name VARCHAR,
+    p_middle_name VARCHAR,
+    p_passport_series VARCHAR,
+    p_passport_number VARCHAR,
+    p_passport_issued VARCHAR,
+    p_dob VARCHAR,
+    p_address VARCHAR,
+    p_phone VARCHAR,
+    p_email VARCHAR,
+    INOUT p_client_id BIGINT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_role_id INT;
+    v_user_id BIGINT;
+    v_dob_date DATE;
+BEGIN
+    BEGIN
+        v_dob_date := TO_DATE(p_dob, 'YYYY-MM-DD');
+    EXCEPTION WHEN OTHERS THEN
+        RAISE EXCEPTION 'Неверный формат даты рождения (YYYY-MM-DD)';
+    END;
+
+    SELECT id INTO v_role_id FROM roles WHERE name = 'client';
+    IF v_role_id IS NULL THEN
+        RAISE EXCEPTION 'Роль client не найдена';
+    END IF;
+
+    INSERT INTO users (role_id, login, password_hash, is_active)
+    VALUES (v_role_id, p_login, p_password, true)
+    RETURNING id INTO v_user_id;
+
+    INSERT INTO clients (
+        user_id, first_name, last_name, middle_name, 
+        passport_series, passport_number, passport_issued_by, 
+        date_of_birth, address, phone, email
+    )
+    VALUES (
+        v_user_id, p_first_name, p_last_name, p_middle_name,
+        p_passport_series, p_passport_number, p_passport_issued,
+        v_dob_date, p_address, p_phone, p_email
+    )
+    RETURNING id INTO p_client_id;
+
+EXCEPTION WHEN unique_violation THEN
+    RAISE EXCEPTION 'Пользователь с таким логином или паспортом уже существует';
+END;
+$$;
+
+-- RegisterHandler
+CREATE
+OR REPLACE PROCEDURE sp_register_employee (
+    p_login VARCHAR,
+    p_password VARCHAR,
+    p_first_name VARCHAR,
+    p_last_name VARCHAR,
+    p_position VARCHAR,
+    p_email VARCHAR,
+    INOUT p_user_id BIGINT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_role_id INT;
+BEGIN
+    SELECT id INTO v_role_id FROM roles WHERE name = 'manager';
+    
+    IF v_role_id IS NULL THEN
+        RAISE EXCEPTION 'Роль manager не найдена в БД';
+    END IF;
+
+    INSERT INTO users (role_id, login, password_hash, is_active)
+    VALUES (v_role_id, p_login, p_password, TRUE)
+    RETURNING id INTO p_user_id;
+
+    INSERT INTO employees (user_id, first_name, last_name, position, email)
+    VALUES (p_user_id, p_first_name, p_last_name, p_position, p_email);
+
+EXCEPTION WHEN unique_violation THEN
+    RAISE EXCEPTION 'Пользователь с таким логином уже существует';
+END;
+$$;
+
+-- BeginTotpEnrollment
+CREATE
+OR REPLACE PROCEDURE sp_begin_totp_enrollment (p_user_id BIGINT, p_secret VARCHAR) LANGUAGE plpgsql AS $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM user_totp WHERE user_id = p_user_id AND confirmed_at IS NOT NULL) THEN
+        RAISE EXCEPTION 'Двухфакторная аутентификация уже подключена';
+    END IF;
+
+    INSERT INTO user_totp (user_id, secret)
+    VALUES (p_user_id, p_secret)
+    ON CONFLICT (user_id) DO UPDATE
+    SET secret = EXCLUDED.secret, last_used_step = NULL, created_at = NOW();
+END;
+$$;
+
+-- ConfirmTotp
+CREATE
+OR REPLACE PROCEDURE sp_confirm_totp (
+    p_user_id BIGINT,
+    p_step BIGINT,
+    p_code_hashes VARCHAR[]
+) LANGUAGE plpgsql AS $$
+BEGIN
+    UPDATE user_totp
+    SET confirmed_at = NOW(), last_used_step = p_step
+    WHERE user_id = p_user_id AND confirmed_at IS NULL;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Нет ожидающей подтверждения настройки 2FA';
+    END IF;
+
+    DELETE FROM user_recovery_codes WHERE user_id = p_user_id;
+
+    INSERT INTO user_recovery_codes (user_id, code_hash)
+    SELECT p_user_id, h FROM UNNEST(p_code_hashes) AS h;
+END;
+$$;
+
+-- SetRoleTwoFactor
+CREATE
+OR REPLACE PROCEDURE sp_set_role_2fa (p_role VARCHAR, p_required BOOLEAN) LANGUAGE plpgsql AS $$
+BEGIN
+    UPDATE roles SET require_2fa = p_required WHERE name = p_role;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Роль % не найдена', p_role;
+    END IF;
+END;
+$$;
+
+-- CreateActivationToken
+CREATE
+OR REPLACE PROCEDURE sp_create_activation_token (
+    p_user_id BIGINT,
+    p_token_hash VARCHAR,
+    p_ttl_seconds INT
+) LANGUAGE plpgsql AS $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM users WHERE id = p_user_id AND activated_at IS NOT NULL) THEN
+        RAISE EXCEPTION 'Учетная запись уже активирована';
+    END IF;
+
+    UPDATE activation_tokens
+    SET used_at = NOW()
+    WHERE user_id = p_user_id AND used_at IS NULL;
+
+    INSERT INTO activation_tokens (user_id, token_hash, expires_at)
+    VALUES (p_user_id, p_token_hash, NOW() + make_interval(secs => p_ttl_seconds));
+END;
+$$;
+
+-- SetPassword (смена пароля завершает все сессии пользователя)
+CREATE
+OR REPLACE PROCEDURE sp_set_password (p_user_id BIGINT, p_password_hash VARCHAR) LANGUAGE plpgsql AS $$
+BEGIN
+    UPDATE users
+    SET password_hash = p_password_hash, password_changed_at = NOW(), must_change_password = FALSE
+    WHERE id = p_user_id;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Пользователь не найден';
+    END IF;
+
+    UPDATE password_reset_tokens
+    SET used_at = NOW()
+    WHERE user_id = p_user_id AND used_at IS NULL;
+
+    CALL sp_revoke_user_sessions(p_user_id);
+END;
+$$;
@@ -0,0 +1,169 @@
+DROP FUNCTION IF EXISTS fn_get_clients (DATE, DATE, VARCHAR, BOOLEAN, TEXT, BIGINT, INT);
+
+DROP FUNCTION IF EXISTS fn_count_clients (DATE, DATE);
+
+DROP FUNCTION IF EXISTS fn_get_employees (VARCHAR, VARCHAR, BOOLEAN, TEXT, BIGINT, INT);
+
+DROP FUNCTION IF EXISTS fn_count_employees (VARCHAR);
+
+DROP FUNCTION IF EXISTS fn_get_loans (VARCHAR, INT, BIGINT, INT, DATE, DATE, VARCHAR, BOOLEAN, TEXT, BIGINT, INT);
+
+DROP FUNCTION IF EXISTS fn_count_loans (VARCHAR, INT, BIGINT, INT, DATE, DATE);
+
+DROP FUNCTION IF EXISTS fn_get_audit_logs (VARCHAR, VARCHAR, BIGINT, TIMESTAMPTZ, TIMESTAMPTZ, VARCHAR, BOOLEAN, TEXT, BIGINT, INT);
+
+DROP FUNCTION IF EXISTS fn_count_audit_logs (VARCHAR, VARCHAR, BIGINT, TIMESTAMPTZ, TIMESTAMPTZ);
+
+DROP INDEX IF EXISTS idx_contracts_created;
+
+DROP INDEX IF EXISTS idx_contracts_status;
+
+DROP INDEX IF EXISTS idx_contracts_product;
+
+DROP INDEX IF EXISTS idx_contracts_employee;
+
+DROP INDEX IF EXISTS idx_clients_created;
+
+DROP INDEX IF EXISTS idx_audit_created;
+
+DROP INDEX IF EXISTS idx_audit_action;
+
+-- GetClients
+CREATE
+OR REPLACE FUNCTION fn_get_all_clients () RETURNS TABLE (
+    id BIGINT,
+    first_name VARCHAR,
+    last_name VARCHAR,
+    middle_name VARCHAR,
+    passport_series VARCHAR,
+    passport_number VARCHAR,
+    passport_issued_by TEXT,
+    date_of_birth DATE,
+    address TEXT,
+    phone VARCHAR,
+    email VARCHAR,
+    created_at TIMESTAMPTZ
+) AS $$
+BEGIN
+    RETURN QUERY 
+    SELECT 
+        c.id, 
+        c.first_name, 
+        c.last_name, 
+        c.middle_name,
+        c.passport_series, 
+        c.passport_number, 
+        c.passport_issued_by,
+        c.date_of_birth, 
+        c.address, 
+        c.phone, 
+        c.email, 
+        c.created_at
+    FROM clients c
+    ORDER BY c.id DESC;
+END;
+$$ LANGUAGE plpgsql;
+
+-- GetEmployees
+CREATE OR REPLACE FUNCTION fn_get_all_employees()
+RETURNS TABLE (
+    id INT, 
+    first_name VARCHAR,
+    last_name VARCHAR,
+    "position" VARCHAR,
+    login VARCHAR,
+    role_name VARCHAR
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT 
+        e.id::INT,
+        e.first_name, 
+        e.last_name, 
+        e.position, 
+        u.login, 
+        r.name::VARCHAR
+    FROM employees e
+    JOIN users u ON e.user_id = u.id
+    JOIN roles r ON u.role_id = r.id
+    ORDER BY e.id DESC;
+END;
+$$ LANGUAGE plpgsql;
+
+-- GetLoans
+CREATE
+OR REPLACE FUNCTION fn_get_all_loans () RETURNS TABLE (
+    id BIGINT,
+    contract_number VARCHAR,
+    amount BIGINT,
+    status VARCHAR,
+    start_date DATE,
+    interest_rate NUMERIC,
+    term_months INT,
+    balance BIGINT,
+    client_name TEXT,
+    product_name VARCHAR
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT 
+        vd.contract_id, 
+        vd.contract_number, 
+        vd.issued_amount, 
+        vd.status::VARCHAR, 
+        vd.start_date,
+        vd.interest_rate,
+        vd.term_months,
+        vd.remaining_debt,
+        vd.client_name::TEXT,
+        vd.product_name
+    FROM v_loan_dossier vd
+    ORDER BY vd.created_at DESC;
+END;
+$$ LANGUAGE plpgsql;
+
+-- GetLogs
+CREATE
+OR REPLACE FUNCTION fn_get_audit_logs (
+    p_action VARCHAR DEFAULT NULL,
+    p_from_date VARCHAR DEFAULT NULL
+) RETURNS TABLE (
+    id BIGINT,
+    action_type VARCHAR,
+    entity_name VARCHAR,
+    entity_id BIGINT,
+    created_at TIMESTAMPTZ,
+    new_values JSONB,
+    login VARCHAR,
+    first_name VARCHAR,
+    last_name VARCHAR
+) AS $$
+DECLARE
+    v_date_filter TIMESTAMPTZ;
+BEGIN
+    IF p_from_date IS NOT NULL AND p_from_date != '' THEN
+        v_date_filter := p_from_date::TIMESTAMPTZ;
+    END IF;
+
+    RETURN QUERY
+    SELECT 
+        a.id, 
+        a.action_type, 
+        COALESCE(a.entity_name, 'system'),
+        COALESCE(a.entity_id, 0),          
+        a.created_at, 
+        COALESCE(a.new_values, '{}'::jsonb), 
+        u.login, 
+        e.first_name, 
+        e.last_name
+    FROM audit_logs a
+    LEFT JOIN users u ON a.user_id = u.id
+    LEFT JOIN employees e ON u.id = e.user_id
+    WHERE 
+        (p_action IS NULL OR p_action = '' OR a.action_type = p_action)
+        AND
+        (v_date_filter IS NULL OR a.created_at >= v_date_filter)
+    ORDER BY a.created_at DESC 
+    LIMIT 50;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,325 @@
+-- Постраничные списки вместо fn_get_all_*: keyset-пагинация по паре
+-- (поле сортировки, id), фильтры и отдельный подсчет общего количества.
+--
+-- Курсор передается как p_after_value (значение поля сортировки последней
+-- записи в текстовом виде, его возвращает колонка sort_value) и p_after_id.
+-- Поле сортировки выбирается из фиксированного списка, иначе RB016.
+
+CREATE INDEX idx_contracts_created ON loan_contracts (created_at, id);
+
+CREATE INDEX idx_contracts_status ON loan_contracts (status);
+
+CREATE INDEX idx_contracts_product ON loan_contracts (product_id);
+
+CREATE INDEX idx_contracts_employee ON loan_contracts (approved_by_employee_id);
+
+CREATE INDEX idx_clients_created ON clients (created_at, id);
+
+CREATE INDEX idx_audit_created ON audit_logs (created_at, id);
+
+CREATE INDEX idx_audit_action ON audit_logs (action_type);
+
+DROP FUNCTION IF EXISTS fn_get_all_clients ();
+
+DROP FUNCTION IF EXISTS fn_get_all_employees ();
+
+DROP FUNCTION IF EXISTS fn_get_all_loans ();
+
+DROP FUNCTION IF EXISTS fn_get_audit_logs (VARCHAR, VARCHAR);
+
+-- GetClients
+CREATE
+OR REPLACE FUNCTION fn_count_clients (p_created_from DATE, p_created_to DATE) RETURNS BIGINT AS $$
+    SELECT COUNT(*)
+    FROM clients c
+    WHERE (p_created_from IS NULL OR c.created_at >= p_created_from)
+      AND (p_created_to IS NULL OR c.created_at < p_created_to + 1);
+$$ LANGUAGE sql STABLE;
+
+CREATE
+OR REPLACE FUNCTION fn_get_clients (
+    p_created_from DATE,
+    p_created_to DATE,
+    p_sort VARCHAR,
+    p_desc BOOLEAN,
+    p_after_value TEXT,
+    p_after_id BIGINT,
+    p_limit INT
+) RETURNS TABLE (
+    id BIGINT,
+    first_name VARCHAR,
+    last_name VARCHAR,
+    middle_name VARCHAR,
+    passport_series VARCHAR,
+    passport_number VARCHAR,
+    passport_issued_by TEXT,
+    date_of_birth DATE,
+    address TEXT,
+    phone VARCHAR,
+    email VARCHAR,
+    created_at TIMESTAMPTZ,
+    sort_value TEXT
+) AS $$
+DECLARE
+    v_column TEXT;
+    v_type TEXT;
+BEGIN
+    CASE p_sort
+        WHEN 'id' THEN v_column := 'c.id'; v_type := 'BIGINT';
+        WHEN 'lastName' THEN v_column := 'c.last_name'; v_type := 'VARCHAR';
+        WHEN 'createdAt' THEN v_column := 'c.created_at'; v_type := 'TIMESTAMPTZ';
+        ELSE RAISE EXCEPTION 'Недопустимое поле сортировки: %', p_sort USING ERRCODE = 'RB016';
+    END CASE;
+
+    RETURN QUERY EXECUTE format($q$
+        SELECT
+            c.id,
+            c.first_name,
+            c.last_name,
+            c.middle_name,
+            c.passport_series,
+            c.passport_number,
+            c.passport_issued_by,
+            c.date_of_birth,
+            c.address,
+            c.phone,
+            c.email,
+            c.created_at,
+            %1$s::TEXT
+        FROM clients c
+        WHERE ($1 IS NULL OR c.created_at >= $1)
+          AND ($2 IS NULL OR c.created_at < $2 + 1)
+          AND ($3 IS NULL OR (%1$s, c.id) %2$s ($3::%3$s, $4))
+        ORDER BY %1$s %4$s, c.id %4$s
+        LIMIT $5
+    $q$, v_column, CASE WHEN p_desc THEN '<' ELSE '>' END, v_type, CASE WHEN p_desc THEN 'DESC' ELSE 'ASC' END)
+    USING p_created_from, p_created_to, p_after_value, p_after_id, p_limit;
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+-- GetEmployees
+CREATE
+OR REPLACE FUNCTION fn_count_employees (p_role VARCHAR) RETURNS BIGINT AS $$
+    SELECT COUNT(*)
+    FROM employees e
+    JOIN users u ON e.user_id = u.id
+    JOIN roles r ON u.role_id = r.id
+    WHERE p_role IS NULL OR r.name = p_role;
+$$ LANGUAGE sql STABLE;
+
+CREATE
+OR REPLACE FUNCTION fn_get_employees (
+    p_role VARCHAR,
+    p_sort VARCHAR,
+    p_desc BOOLEAN,
+    p_after_value TEXT,
+    p_after_id BIGINT,
+    p_limit INT
+) RETURNS TABLE (
+    id INT,
+    first_name VARCHAR,
+    last_name VARCHAR,
+    "position" VARCHAR,
+    login VARCHAR,
+    role_name VARCHAR,
+    sort_value TEXT
+) AS $$
+DECLARE
+    v_column TEXT;
+    v_type TEXT;
+BEGIN
+    CASE p_sort
+        WHEN 'id' THEN v_column := 'e.id'; v_type := 'INT';
+        WHEN 'lastName' THEN v_column := 'e.last_name'; v_type := 'VARCHAR';
+        ELSE RAISE EXCEPTION 'Недопустимое поле сортировки: %', p_sort USING ERRCODE = 'RB016';
+    END CASE;
+
+    RETURN QUERY EXECUTE format($q$
+        SELECT
+            e.id::INT,
+            e.first_name,
+            e.last_name,
+            e.position,
+            u.login,
+            r.name::VARCHAR,
+            %1$s::TEXT
+        FROM employees e
+        JOIN users u ON e.user_id = u.id
+        JOIN roles r ON u.role_id = r.id
+        WHERE ($1 IS NULL OR r.name = $1)
+          AND ($2 IS NULL OR (%1$s, e.id) %2$s ($2::%3$s, $3))
+        ORDER BY %1$s %4$s, e.id %4$s
+        LIMIT $4
+    $q$, v_column, CASE WHEN p_desc THEN '<' ELSE '>' END, v_type, CASE WHEN p_desc THEN 'DESC' ELSE 'ASC' END)
+    USING p_role, p_after_value, p_after_id, p_limit;
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+-- GetLoans
+CREATE
+OR REPLACE FUNCTION fn_count_loans (
+    p_status VARCHAR,
+    p_product_id INT,
+    p_client_id BIGINT,
+    p_employee_id INT,
+    p_from DATE,
+    p_to DATE
+) RETURNS BIGINT AS $$
+    SELECT COUNT(*)
+    FROM loan_contracts lc
+    WHERE (p_status IS NULL OR lc.status = p_status::contract_status)
+      AND (p_product_id IS NULL OR lc.product_id = p_product_id)
+      AND (p_client_id IS NULL OR lc.client_id = p_client_id)
+      AND (p_employee_id IS NULL OR lc.approved_by_employee_id = p_employee_id)
+      AND (p_from IS NULL OR lc.start_date >= p_from)
+      AND (p_to IS NULL OR lc.start_date <= p_to);
+$$ LANGUAGE sql STABLE;
+
+CREATE
+OR REPLACE FUNCTION fn_get_loans (
+    p_status VARCHAR,
+    p_product_id INT,
+    p_client_id BIGINT,
+    p_employee_id INT,
+    p_from DATE,
+    p_to DATE,
+    p_sort VARCHAR,
+    p_desc BOOLEAN,
+    p_after_value TEXT,
+    p_after_id BIGINT,
+    p_limit INT
+) RETURNS TABLE (
+    id BIGINT,
+    contract_number VARCHAR,
+    amount BIGINT,
+    status VARCHAR,
+    start_date DATE,
+    interest_rate NUMERIC,
+    term_months INT,
+    balance BIGINT,
+    client_name TEXT,
+    product_name VARCHAR,
+    sort_value TEXT
+) AS $$
+DECLARE
+    v_column TEXT;
+    v_type TEXT;
+BEGIN
+    CASE p_sort
+        WHEN 'id' THEN v_column := 'lc.id'; v_type := 'BIGINT';
+        WHEN 'createdAt' THEN v_column := 'lc.created_at'; v_type := 'TIMESTAMPTZ';
+        WHEN 'startDate' THEN v_column := 'lc.start_date'; v_type := 'DATE';
+        WHEN 'amount' THEN v_column := 'lc.amount'; v_type := 'BIGINT';
+        WHEN 'balance' THEN v_column := 'lc.balance'; v_type := 'BIGINT';
+        ELSE RAISE EXCEPTION 'Недопустимое поле сортировки: %', p_sort USING ERRCODE = 'RB016';
+    END CASE;
+
+    RETURN QUERY EXECUTE format($q$
+        SELECT
+            lc.id,
+            lc.contract_number,
+            lc.amount,
+            lc.status::VARCHAR,
+            lc.start_date,
+            lc.interest_rate,
+            lc.term_months,
+            lc.balance,
+            (c.last_name || ' ' || c.first_name || ' ' || COALESCE(c.middle_name, ''))::TEXT,
+            cp.name,
+            %1$s::TEXT
+        FROM loan_contracts lc
+        JOIN clients c ON lc.client_id = c.id
+        JOIN credit_products cp ON lc.product_id = cp.id
+        WHERE ($1 IS NULL OR lc.status = $1::contract_status)
+          AND ($2 IS NULL OR lc.product_id = $2)
+          AND ($3 IS NULL OR lc.client_id = $3)
+          AND ($4 IS NULL OR lc.approved_by_employee_id = $4)
+          AND ($5 IS NULL OR lc.start_date >= $5)
+          AND ($6 IS NULL OR lc.start_date <= $6)
+          AND ($7 IS NULL OR (%1$s, lc.id) %2$s ($7::%3$s, $8))
+        ORDER BY %1$s %4$s, lc.id %4$s
+        LIMIT $9
+    $q$, v_column, CASE WHEN p_desc THEN '<' ELSE '>' END, v_type, CASE WHEN p_desc THEN 'DESC' ELSE 'ASC' END)
+    USING p_status, p_product_id, p_client_id, p_employee_id, p_from, p_to, p_after_value, p_after_id, p_limit;
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+-- GetLogs
+CREATE
+OR REPLACE FUNCTION fn_count_audit_logs (
+    p_action VARCHAR,
+    p_entity VARCHAR,
+    p_user_id BIGINT,
+    p_from TIMESTAMPTZ,
+    p_to TIMESTAMPTZ
+) RETURNS BIGINT AS $$
+    SELECT COUNT(*)
+    FROM audit_logs a
+    WHERE (p_action IS NULL OR a.action_type = p_action)
+      AND (p_entity IS NULL OR a.entity_name = p_entity)
+      AND (p_user_id IS NULL OR a.user_id = p_user_id)
+      AND (p_from IS NULL OR a.created_at >= p_from)
+      AND (p_to IS NULL OR a.created_at <= p_to);
+$$ LANGUAGE sql STABLE;
+
+CREATE
+OR REPLACE FUNCTION fn_get_audit_logs (
+    p_action VARCHAR,
+    p_entity VARCHAR,
+    p_user_id BIGINT,
+    p_from TIMESTAMPTZ,
+    p_to TIMESTAMPTZ,
+    p_sort VARCHAR,
+    p_desc BOOLEAN,
+    p_after_value TEXT,
+    p_after_id BIGINT,
+    p_limit INT
+) RETURNS TABLE (
+    id BIGINT,
+    action_type VARCHAR,
+    entity_name VARCHAR,
+    entity_id BIGINT,
+    created_at TIMESTAMPTZ,
+    new_values JSONB,
+    login VARCHAR,
+    first_name VARCHAR,
+    last_name VARCHAR,
+    sort_value TEXT
+) AS $$
+DECLARE
+    v_column TEXT;
+    v_type TEXT;
+BEGIN
+    CASE p_sort
+        WHEN 'id' THEN v_column := 'a.id'; v_type := 'BIGINT';
+        WHEN 'createdAt' THEN v_column := 'a.created_at'; v_type := 'TIMESTAMPTZ';
+        ELSE RAISE EXCEPTION 'Недопустимое поле сортировки: %', p_sort USING ERRCODE = 'RB016';
+    END CASE;
+
+    RETURN QUERY EXECUTE format($q$
+        SELECT
+            a.id,
+            a.action_type,
+            COALESCE(a.entity_name, 'system'),
+            COALESCE(a.entity_id, 0),
+            a.created_at,
+            COALESCE(a.new_values, '{}'::jsonb),
+            u.login,
+            e.first_name,
+            e.last_name,
+            %1$s::TEXT
+        FROM audit_logs a
+        LEFT JOIN users u ON a.user_id = u.id
+        LEFT JOIN employees e ON u.id = e.user_id
+        WHERE ($1 IS NULL OR a.action_type = $1)
+          AND ($2 IS NULL OR a.entity_name = $2)
+          AND ($3 IS NULL OR a.user_id = $3)
+          AND ($4 IS NULL OR a.created_at >= $4)
+          AND ($5 IS NULL OR a.created_at <= $5)
+          AND ($6 IS NULL OR (%1$s, a.id) %2$s ($6::%3$s, $7))
+        ORDER BY %1$s %4$s, a.id %4$s
+        LIMIT $8
+    $q$, v_column, CASE WHEN p_desc THEN '<' ELSE '>' END, v_type, CASE WHEN p_desc THEN 'DESC' ELSE 'ASC' END)
+    USING p_action, p_entity, p_user_id, p_from, p_to, p_after_value, p_after_id, p_limit;
+END;
+$$ LANGUAGE plpgsql STABLE;
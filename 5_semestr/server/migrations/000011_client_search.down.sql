@@ -0,0 +1,19 @@
+DROP FUNCTION IF EXISTS fn_search_clients (TEXT, INT);
+
+DROP INDEX IF EXISTS idx_clients_email;
+
+DROP INDEX IF EXISTS idx_clients_phone_digits;
+
+DROP INDEX IF EXISTS idx_clients_search_vector;
+
+DROP INDEX IF EXISTS idx_clients_last_name;
+
+CREATE INDEX idx_clients_last_name ON clients (last_name);
+
+DROP FUNCTION IF EXISTS fn_client_search_vector (TEXT, TEXT, TEXT);
+
+DROP FUNCTION IF EXISTS fn_normalize_phone (TEXT);
+
+DROP FUNCTION IF EXISTS fn_normalize_name (TEXT);
+
+DROP EXTENSION IF EXISTS pg_trgm;
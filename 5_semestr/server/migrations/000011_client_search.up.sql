@@ -0,0 +1,148 @@
+-- Поиск клиентов по ФИО, паспорту, телефону и email.
+--
+-- ФИО ищется полнотекстово (префиксы слов) и по триграммам фамилии
+-- (часть фамилии, опечатки). Регистр и ё/е нормализуются одной функцией и
+-- в индексах, и в запросе. Паспорт ищется точным совпадением через
+-- idx_clients_passport, телефон - по цифрам номера (8 в начале
+-- российского номера приводится к 7).
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE
+OR REPLACE FUNCTION fn_normalize_name (p_value TEXT) RETURNS TEXT AS $$
+    SELECT translate(lower(COALESCE(p_value, '')), 'ё', 'е');
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+CREATE
+OR REPLACE FUNCTION fn_normalize_phone (p_value TEXT) RETURNS TEXT AS $$
+    SELECT regexp_replace(
+        regexp_replace(COALESCE(p_value, ''), '\D', '', 'g'),
+        '^8(\d{10})$', '7\1'
+    );
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+CREATE
+OR REPLACE FUNCTION fn_client_search_vector (
+    p_last_name TEXT,
+    p_first_name TEXT,
+    p_middle_name TEXT
+) RETURNS TSVECTOR AS $$
+    SELECT to_tsvector('simple', fn_normalize_name(
+        p_last_name || ' ' || p_first_name || ' ' || COALESCE(p_middle_name, '')
+    ));
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+-- Вместо обычного B-tree по фамилии - триграммный индекс по нормализованной
+-- фамилии: он обслуживает и LIKE по части фамилии, и нечеткое сравнение.
+DROP INDEX IF EXISTS idx_clients_last_name;
+
+CREATE INDEX idx_clients_last_name ON clients USING gin (fn_normalize_name (last_name) gin_trgm_ops);
+
+CREATE INDEX idx_clients_search_vector ON clients USING gin (
+    fn_client_search_vector (last_name, first_name, middle_name)
+);
+
+CREATE INDEX idx_clients_phone_digits ON clients USING gin (fn_normalize_phone (phone) gin_trgm_ops);
+
+CREATE INDEX idx_clients_email ON clients (lower(email));
+
+-- SearchClients
+CREATE
+OR REPLACE FUNCTION fn_search_clients (p_query TEXT, p_limit INT) RETURNS TABLE (
+    id BIGINT,
+    first_name VARCHAR,
+    last_name VARCHAR,
+    middle_name VARCHAR,
+    passport_series VARCHAR,
+    passport_number VARCHAR,
+    passport_issued_by TEXT,
+    date_of_birth DATE,
+    address TEXT,
+    phone VARCHAR,
+    email VARCHAR,
+    created_at TIMESTAMPTZ,
+    rank REAL,
+    matched_by VARCHAR
+) AS $$
+DECLARE
+    v_query TEXT := btrim(COALESCE(p_query, ''));
+    v_name TEXT := fn_normalize_name(btrim(COALESCE(p_query, '')));
+    v_digits TEXT := fn_normalize_phone(p_query);
+    v_like TEXT;
+    v_tsquery TSQUERY;
+BEGIN
+    -- Экранирование спецсимволов LIKE во введенной строке.
+    v_like := '%' || replace(replace(replace(v_name, '\', '\\'), '%', '\%'), '_', '\_') || '%';
+
+    SELECT to_tsquery('simple', string_agg(w || ':*', ' & '))
+    INTO v_tsquery
+    FROM regexp_split_to_table(v_name, '[^[:alnum:]]+') AS w
+    WHERE w <> '';
+
+    RETURN QUERY
+    WITH matches AS (
+        -- Серия и номер паспорта, с пробелом или без.
+        SELECT c.id AS client_id, 1.0::REAL AS score, 'passport'::VARCHAR AS kind
+        FROM clients c
+        WHERE v_query ~ '^\d{4}\s?\d{6}$'
+          AND c.passport_series = left(regexp_replace(v_query, '\s', '', 'g'), 4)
+          AND c.passport_number = right(v_query, 6)
+
+        UNION ALL
+
+        SELECT c.id, 1.0::REAL, 'email'::VARCHAR
+        FROM clients c
+        WHERE position('@' IN v_query) > 0
+          AND lower(c.email) = lower(v_query)
+
+        UNION ALL
+
+        -- Полный номер дает больший вес, чем совпадение по части номера.
+        SELECT c.id,
+               CASE WHEN fn_normalize_phone(c.phone) = v_digits THEN 0.9 ELSE 0.6 END::REAL,
+               'phone'::VARCHAR
+        FROM clients c
+        WHERE v_query ~ '^[\d\s()+-]+$'
+          AND length(v_digits) >= 4
+          AND fn_normalize_phone(c.phone) LIKE '%' || v_digits || '%'
+
+        UNION ALL
+
+        SELECT c.id,
+               (0.5 * similarity(fn_normalize_name(c.last_name), v_name)
+                + 0.5 * COALESCE(ts_rank_cd(fn_client_search_vector(c.last_name, c.first_name, c.middle_name), v_tsquery), 0)
+               )::REAL,
+               'name'::VARCHAR
+        FROM clients c
+        WHERE v_name ~ '[[:alpha:]]'
+          AND (
+              fn_client_search_vector(c.last_name, c.first_name, c.middle_name) @@ v_tsquery
+              OR fn_normalize_name(c.last_name) LIKE v_like
+          )
+    ),
+    best AS (
+        SELECT DISTINCT ON (m.client_id) m.client_id, m.score, m.kind
+        FROM matches m
+        ORDER BY m.client_id, m.score DESC
+    )
+    SELECT
+        c.id,
+        c.first_name,
+        c.last_name,
+        c.middle_name,
+        c.passport_series,
+        c.passport_number,
+        c.passport_issued_by,
+        c.date_of_birth,
+        c.address,
+        c.phone,
+        c.email,
+        c.created_at,
+        b.score,
+        b.kind
+    FROM best b
+    JOIN clients c ON c.id = b.client_id
+    ORDER BY b.score DESC, c.id DESC
+    LIMIT p_limit;
+END;
+$$ LANGUAGE plpgsql STABLE;
@@ -0,0 +1,25 @@
+DROP FUNCTION IF EXISTS fn_get_client_history (BIGINT);
+
+DROP PROCEDURE IF EXISTS sp_set_client_active (BIGINT, BIGINT, BOOLEAN, BIGINT);
+
+DROP PROCEDURE IF EXISTS sp_update_client (BIGINT, BIGINT, VARCHAR, VARCHAR, VARCHAR, VARCHAR, VARCHAR, VARCHAR, VARCHAR, VARCHAR, VARCHAR, VARCHAR);
+
+DROP FUNCTION IF EXISTS fn_get_client (BIGINT);
+
+DROP INDEX IF EXISTS idx_audit_entity;
+
+CREATE
+OR REPLACE FUNCTION fn_audit_log () RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO audit_logs (user_id, action_type, entity_name, entity_id, old_values, new_values)
+    VALUES (
+        NULL,
+        TG_OP,
+        TG_TABLE_NAME,
+        COALESCE(NEW.id, OLD.id),
+        CASE WHEN TG_OP = 'UPDATE' OR TG_OP = 'DELETE' THEN row_to_json(OLD)::JSONB ELSE NULL END,
+        CASE WHEN TG_OP = 'UPDATE' OR TG_OP = 'INSERT' THEN row_to_json(NEW)::JSONB ELSE NULL END
+    );
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
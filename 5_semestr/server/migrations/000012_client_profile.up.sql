@@ -0,0 +1,232 @@
+-- Карточка клиента: просмотр, редактирование, деактивация и история
+-- изменений.
+--
+-- RB017 изменение полей, закрепленных в действующих договорах
+-- RB018 у клиента есть действующие договоры
+--
+-- Триггер аудита записывает автора изменения из настройки app.user_id,
+-- которую процедуры выставляют на время транзакции.
+
+CREATE
+OR REPLACE FUNCTION fn_audit_log () RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO audit_logs (user_id, action_type, entity_name, entity_id, old_values, new_values)
+    VALUES (
+        NULLIF(current_setting('app.user_id', TRUE), '')::BIGINT,
+        TG_OP,
+        TG_TABLE_NAME,
+        COALESCE(NEW.id, OLD.id),
+        CASE WHEN TG_OP = 'UPDATE' OR TG_OP = 'DELETE' THEN row_to_json(OLD)::JSONB ELSE NULL END,
+        CASE WHEN TG_OP = 'UPDATE' OR TG_OP = 'INSERT' THEN row_to_json(NEW)::JSONB ELSE NULL END
+    );
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE INDEX idx_audit_entity ON audit_logs (entity_name, entity_id);
+
+-- GetClient
+CREATE
+OR REPLACE FUNCTION fn_get_client (p_client_id BIGINT) RETURNS TABLE (
+    id BIGINT,
+    first_name VARCHAR,
+    last_name VARCHAR,
+    middle_name VARCHAR,
+    passport_series VARCHAR,
+    passport_number VARCHAR,
+    passport_issued_by TEXT,
+    date_of_birth DATE,
+    address TEXT,
+    phone VARCHAR,
+    email VARCHAR,
+    created_at TIMESTAMPTZ,
+    login VARCHAR,
+    is_active BOOLEAN,
+    active_contracts INT
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT
+        c.id,
+        c.first_name,
+        c.last_name,
+        c.middle_name,
+        c.passport_series,
+        c.passport_number,
+        c.passport_issued_by,
+        c.date_of_birth,
+        c.address,
+        c.phone,
+        c.email,
+        c.created_at,
+        u.login,
+        COALESCE(u.is_active, FALSE),
+        (SELECT COUNT(*)::INT FROM loan_contracts lc WHERE lc.client_id = c.id AND lc.status = 'active')
+    FROM clients c
+    LEFT JOIN users u ON c.user_id = u.id
+    WHERE c.id = p_client_id;
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+-- UpdateClient
+-- Пока у клиента есть действующие договоры, ФИО, паспорт и дата рождения
+-- не меняются: они указаны в договорах.
+CREATE
+OR REPLACE PROCEDURE sp_update_client (
+    p_actor_id BIGINT,
+    p_client_id BIGINT,
+    p_first_name VARCHAR,
+    p_last_name VARCHAR,
+    p_middle_name VARCHAR,
+    p_passport_series VARCHAR,
+    p_passport_number VARCHAR,
+    p_passport_issued VARCHAR,
+    p_dob VARCHAR,
+    p_address VARCHAR,
+    p_phone VARCHAR,
+    p_email VARCHAR
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_client clients%ROWTYPE;
+    v_dob DATE;
+BEGIN
+    BEGIN
+        v_dob := p_dob::DATE;
+    EXCEPTION WHEN OTHERS THEN
+        RAISE EXCEPTION 'Неверный формат даты рождения (YYYY-MM-DD)' USING ERRCODE = 'RB005';
+    END;
+
+    SELECT * INTO v_client FROM clients WHERE id = p_client_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Клиент не найден' USING ERRCODE = 'RB012';
+    END IF;
+
+    IF (v_client.first_name, v_client.last_name, COALESCE(v_client.middle_name, ''),
+        v_client.passport_series, v_client.passport_number, v_client.passport_issued_by,
+        v_client.date_of_birth)
+       IS DISTINCT FROM
+       (p_first_name, p_last_name, COALESCE(p_middle_name, ''),
+        p_passport_series, p_passport_number, p_passport_issued::TEXT,
+        v_dob)
+       AND EXISTS (SELECT 1 FROM loan_contracts WHERE client_id = p_client_id AND status = 'active')
+    THEN
+        RAISE EXCEPTION 'ФИО, паспорт и дату рождения нельзя менять при действующих договорах'
+            USING ERRCODE = 'RB017';
+    END IF;
+
+    PERFORM set_config('app.user_id', p_actor_id::TEXT, TRUE);
+
+    UPDATE clients
+    SET first_name = p_first_name,
+        last_name = p_last_name,
+        middle_name = p_middle_name,
+        passport_series = p_passport_series,
+        passport_number = p_passport_number,
+        passport_issued_by = p_passport_issued,
+        date_of_birth = v_dob,
+        address = p_address,
+        phone = p_phone,
+        email = p_email
+    WHERE id = p_client_id;
+
+    PERFORM set_config('app.user_id', '', TRUE);
+END;
+$$;
+
+-- SetClientActive
+-- Деактивация закрывает вход (users.is_active) и отзывает сессии. Запись
+-- истории делается здесь же, потому что флаг хранится не в clients.
+CREATE
+OR REPLACE PROCEDURE sp_set_client_active (
+    p_actor_id BIGINT,
+    p_client_id BIGINT,
+    p_active BOOLEAN,
+    INOUT p_user_id BIGINT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_was_active BOOLEAN;
+BEGIN
+    SELECT u.id, u.is_active INTO p_user_id, v_was_active
+    FROM clients c
+    JOIN users u ON c.user_id = u.id
+    WHERE c.id = p_client_id
+    FOR UPDATE OF u;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Клиент не найден' USING ERRCODE = 'RB012';
+    END IF;
+
+    IF NOT p_active AND EXISTS (
+        SELECT 1 FROM loan_contracts WHERE client_id = p_client_id AND status = 'active'
+    ) THEN
+        RAISE EXCEPTION 'У клиента есть действующие договоры' USING ERRCODE = 'RB018';
+    END IF;
+
+    IF v_was_active IS NOT DISTINCT FROM p_active THEN
+        RETURN;
+    END IF;
+
+    UPDATE users SET is_active = p_active WHERE id = p_user_id;
+
+    IF NOT p_active THEN
+        CALL sp_revoke_user_sessions(p_user_id);
+    END IF;
+
+    INSERT INTO audit_logs (user_id, action_type, entity_name, entity_id, old_values, new_values)
+    VALUES (
+        p_actor_id,
+        'UPDATE',
+        'clients',
+        p_client_id,
+        jsonb_build_object('is_active', v_was_active),
+        jsonb_build_object('is_active', p_active)
+    );
+END;
+$$;
+
+-- GetClientHistory
+-- Каждая запись - изменение карточки с перечнем полей, у которых
+-- отличаются старое и новое значения. Обновления без изменений пропускаются.
+CREATE
+OR REPLACE FUNCTION fn_get_client_history (p_client_id BIGINT) RETURNS TABLE (
+    id BIGINT,
+    action_type VARCHAR,
+    created_at TIMESTAMPTZ,
+    changes JSONB,
+    login VARCHAR,
+    first_name VARCHAR,
+    last_name VARCHAR
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT h.id, h.action_type, h.created_at, h.changes, h.login, h.first_name, h.last_name
+    FROM (
+        SELECT
+            a.id,
+            a.action_type,
+            a.created_at,
+            COALESCE((
+                SELECT jsonb_agg(
+                    jsonb_build_object('field', f.key, 'old', a.old_values -> f.key, 'new', a.new_values -> f.key)
+                    ORDER BY f.key
+                )
+                FROM jsonb_object_keys(COALESCE(a.new_values, a.old_values)) AS f(key)
+                WHERE f.key NOT IN ('id', 'user_id', 'created_at')
+                  AND (a.old_values IS NULL
+                       OR a.new_values IS NULL
+                       OR (a.old_values -> f.key) IS DISTINCT FROM (a.new_values -> f.key))
+            ), '[]'::jsonb) AS changes,
+            u.login,
+            e.first_name,
+            e.last_name
+        FROM audit_logs a
+        LEFT JOIN users u ON a.user_id = u.id
+        LEFT JOIN employees e ON u.id = e.user_id
+        WHERE a.entity_name = 'clients'
+          AND a.entity_id = p_client_id
+          AND a.action_type IN ('INSERT', 'UPDATE', 'DELETE')
+    ) h
+    WHERE h.changes <> '[]'::jsonb
+    ORDER BY h.created_at DESC, h.id DESC;
+END;
+$$ LANGUAGE plpgsql STABLE;
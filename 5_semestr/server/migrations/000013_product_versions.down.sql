@@ -0,0 +1,107 @@
+DROP FUNCTION IF EXISTS fn_get_product_versions (INT);
+
+DROP PROCEDURE IF EXISTS sp_set_product_active (BIGINT, INT, BOOLEAN);
+
+DROP PROCEDURE IF EXISTS sp_update_product (BIGINT, INT, VARCHAR, BIGINT, BIGINT, INT, INT, NUMERIC, INT);
+
+DROP PROCEDURE IF EXISTS sp_create_product (BIGINT, VARCHAR, BIGINT, BIGINT, INT, INT, NUMERIC, INT);
+
+DROP FUNCTION IF EXISTS fn_get_products (INT, BOOLEAN);
+
+DROP TRIGGER IF EXISTS trg_audit_products ON credit_products;
+
+DROP INDEX IF EXISTS idx_contracts_product_version;
+
+ALTER TABLE loan_contracts
+DROP COLUMN IF EXISTS product_version_id;
+
+DROP TABLE IF EXISTS credit_product_versions;
+
+ALTER TABLE credit_products
+DROP COLUMN IF EXISTS current_version;
+
+ALTER TABLE credit_products
+DROP CONSTRAINT IF EXISTS chk_product_rate;
+
+ALTER TABLE credit_products
+DROP CONSTRAINT IF EXISTS chk_product_terms;
+
+ALTER TABLE credit_products
+DROP CONSTRAINT IF EXISTS chk_product_amounts;
+
+ALTER TABLE credit_products
+RENAME CONSTRAINT uq_products_name TO credit_products_name_key;
+
+CREATE
+OR REPLACE PROCEDURE sp_issue_loan (
+    p_client_id BIGINT,
+    p_product_id INT,
+    p_amount BIGINT,
+    p_term_months INT,
+    p_employee_id INT,
+    INOUT p_new_id BIGINT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_rate NUMERIC;
+    v_annuity BIGINT;
+    v_balance BIGINT;
+    v_monthly_rate NUMERIC;
+    v_interest_part BIGINT;
+    v_principal_part BIGINT;
+    v_current_date DATE := CURRENT_DATE;
+    i INT;
+BEGIN
+    SELECT interest_rate INTO v_rate FROM credit_products WHERE id = p_product_id;
+    IF v_rate IS NULL THEN
+        RAISE EXCEPTION 'Кредитный продукт % не найден', p_product_id USING ERRCODE = 'RB011';
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM clients WHERE id = p_client_id) THEN
+        RAISE EXCEPTION 'Клиент % не найден', p_client_id USING ERRCODE = 'RB012';
+    END IF;
+
+    IF p_term_months IS NULL OR p_term_months <= 0 THEN
+        RAISE EXCEPTION 'Неверный срок кредита: %', p_term_months USING ERRCODE = 'RB014';
+    END IF;
+    
+    v_monthly_rate := v_rate / 12 / 100;
+    v_annuity := fn_calculate_annuity(p_amount, v_rate, p_term_months);
+    v_balance := p_amount;
+
+    INSERT INTO loan_contracts (
+        contract_number, client_id, product_id, approved_by_employee_id, 
+        amount, interest_rate, term_months, start_date, end_date, status, balance
+    ) VALUES (
+        'LN-' || CAST(EXTRACT(EPOCH FROM NOW()) AS BIGINT) || '-' || p_client_id,
+        p_client_id, p_product_id, p_employee_id,
+        p_amount, v_rate, p_term_months, v_current_date, 
+        v_current_date + (p_term_months || ' months')::INTERVAL, 
+        'active', p_amount
+    ) RETURNING id INTO p_new_id;
+
+    FOR i IN 1..p_term_months LOOP
+        v_current_date := v_current_date + INTERVAL '1 month';
+        
+        v_interest_part := ROUND(v_balance * v_monthly_rate)::BIGINT;
+        v_principal_part := v_annuity - v_interest_part;
+
+        IF i = p_term_months OR v_principal_part > v_balance THEN
+            v_principal_part := v_balance;
+            v_annuity := v_principal_part + v_interest_part;
+        END IF;
+
+        v_balance := v_balance - v_principal_part;
+
+        INSERT INTO repayment_schedule (
+            contract_id, payment_date, payment_amount, 
+            principal_amount, interest_amount, remaining_balance, is_paid
+        ) VALUES (
+            p_new_id, v_current_date, v_annuity, 
+            v_principal_part, v_interest_part, v_balance, FALSE
+        );
+    END LOOP;
+
+    INSERT INTO operations (contract_id, employee_id, operation_type, amount, description)
+    VALUES (p_new_id, p_employee_id, 'issue', p_amount, 'Выдача');
+END;
+$$;
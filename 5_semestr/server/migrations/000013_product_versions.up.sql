@@ -0,0 +1,343 @@
+-- Управление кредитными продуктами. Условия продукта (суммы, сроки,
+-- ставка) версионируются: каждое их изменение создает новую версию, а
+-- договор ссылается на версию, по которой он выдан. В credit_products
+-- остаются действующие условия.
+--
+-- RB019 кредитный продукт не активен
+
+ALTER TABLE credit_products
+RENAME CONSTRAINT credit_products_name_key TO uq_products_name;
+
+ALTER TABLE credit_products
+ADD CONSTRAINT chk_product_amounts CHECK (
+    min_amount > 0
+    AND max_amount >= min_amount
+);
+
+ALTER TABLE credit_products
+ADD CONSTRAINT chk_product_terms CHECK (
+    min_term_months > 0
+    AND max_term_months >= min_term_months
+);
+
+ALTER TABLE credit_products
+ADD CONSTRAINT chk_product_rate CHECK (
+    interest_rate > 0
+    AND interest_rate < 100
+);
+
+ALTER TABLE credit_products
+ADD COLUMN current_version INT NOT NULL DEFAULT 1;
+
+CREATE TABLE
+    credit_product_versions (
+        id SERIAL PRIMARY KEY,
+        product_id INT NOT NULL REFERENCES credit_products (id),
+        version INT NOT NULL,
+        min_amount BIGINT NOT NULL,
+        max_amount BIGINT NOT NULL,
+        min_term_months INT NOT NULL,
+        max_term_months INT NOT NULL,
+        interest_rate NUMERIC(5, 2) NOT NULL,
+        created_by BIGINT REFERENCES users (id),
+        created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        CONSTRAINT uq_product_versions UNIQUE (product_id, version)
+    );
+
+INSERT INTO
+    credit_product_versions (
+        product_id,
+        version,
+        min_amount,
+        max_amount,
+        min_term_months,
+        max_term_months,
+        interest_rate
+    )
+SELECT id, 1, min_amount, max_amount, min_term_months, max_term_months, interest_rate
+FROM credit_products;
+
+ALTER TABLE loan_contracts
+ADD COLUMN product_version_id INT REFERENCES credit_product_versions (id);
+
+-- Договоры, выданные до версионирования, относятся к первой версии.
+UPDATE loan_contracts lc
+SET product_version_id = v.id
+FROM credit_product_versions v
+WHERE v.product_id = lc.product_id AND v.version = 1;
+
+CREATE INDEX idx_contracts_product_version ON loan_contracts (product_version_id);
+
+CREATE TRIGGER trg_audit_products
+AFTER INSERT
+OR
+UPDATE
+OR DELETE ON credit_products FOR EACH ROW
+EXECUTE FUNCTION fn_audit_log ();
+
+-- GetProducts
+-- p_product_id NULL - все продукты.
+CREATE
+OR REPLACE FUNCTION fn_get_products (p_product_id INT, p_include_inactive BOOLEAN) RETURNS TABLE (
+    id INT,
+    name VARCHAR,
+    min_amount BIGINT,
+    max_amount BIGINT,
+    min_term_months INT,
+    max_term_months INT,
+    interest_rate NUMERIC,
+    is_active BOOLEAN,
+    current_version INT
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT
+        cp.id,
+        cp.name,
+        cp.min_amount,
+        cp.max_amount,
+        cp.min_term_months,
+        cp.max_term_months,
+        cp.interest_rate,
+        COALESCE(cp.is_active, FALSE),
+        cp.current_version
+    FROM credit_products cp
+    WHERE (p_product_id IS NULL OR cp.id = p_product_id)
+      AND (p_include_inactive OR cp.is_active)
+    ORDER BY cp.id;
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+-- CreateProduct
+CREATE
+OR REPLACE PROCEDURE sp_create_product (
+    p_actor_id BIGINT,
+    p_name VARCHAR,
+    p_min_amount BIGINT,
+    p_max_amount BIGINT,
+    p_min_term INT,
+    p_max_term INT,
+    p_rate NUMERIC,
+    INOUT p_product_id INT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+BEGIN
+    PERFORM set_config('app.user_id', p_actor_id::TEXT, TRUE);
+
+    INSERT INTO credit_products (name, min_amount, max_amount, min_term_months, max_term_months, interest_rate, is_active)
+    VALUES (p_name, p_min_amount, p_max_amount, p_min_term, p_max_term, p_rate, TRUE)
+    RETURNING id INTO p_product_id;
+
+    INSERT INTO credit_product_versions (
+        product_id, version, min_amount, max_amount, min_term_months, max_term_months, interest_rate, created_by
+    ) VALUES (
+        p_product_id, 1, p_min_amount, p_max_amount, p_min_term, p_max_term, p_rate, p_actor_id
+    );
+
+    PERFORM set_config('app.user_id', '', TRUE);
+END;
+$$;
+
+-- UpdateProduct
+-- Новая версия создается только при изменении условий; переименование
+-- версию не меняет.
+CREATE
+OR REPLACE PROCEDURE sp_update_product (
+    p_actor_id BIGINT,
+    p_product_id INT,
+    p_name VARCHAR,
+    p_min_amount BIGINT,
+    p_max_amount BIGINT,
+    p_min_term INT,
+    p_max_term INT,
+    p_rate NUMERIC,
+    INOUT p_version INT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_product credit_products%ROWTYPE;
+BEGIN
+    SELECT * INTO v_product FROM credit_products WHERE id = p_product_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Кредитный продукт % не найден', p_product_id USING ERRCODE = 'RB011';
+    END IF;
+
+    PERFORM set_config('app.user_id', p_actor_id::TEXT, TRUE);
+
+    p_version := v_product.current_version;
+
+    IF (v_product.min_amount, v_product.max_amount, v_product.min_term_months,
+        v_product.max_term_months, v_product.interest_rate)
+       IS DISTINCT FROM
+       (p_min_amount, p_max_amount, p_min_term, p_max_term, p_rate::NUMERIC(5, 2))
+    THEN
+        p_version := p_version + 1;
+
+        INSERT INTO credit_product_versions (
+            product_id, version, min_amount, max_amount, min_term_months, max_term_months, interest_rate, created_by
+        ) VALUES (
+            p_product_id, p_version, p_min_amount, p_max_amount, p_min_term, p_max_term, p_rate, p_actor_id
+        );
+    END IF;
+
+    UPDATE credit_products
+    SET name = p_name,
+        min_amount = p_min_amount,
+        max_amount = p_max_amount,
+        min_term_months = p_min_term,
+        max_term_months = p_max_term,
+        interest_rate = p_rate,
+        current_version = p_version
+    WHERE id = p_product_id
+      AND (name, current_version) IS DISTINCT FROM (p_name, p_version);
+
+    PERFORM set_config('app.user_id', '', TRUE);
+END;
+$$;
+
+-- SetProductActive
+-- Неактивный продукт не выдается, действующие договоры по нему не меняются.
+CREATE
+OR REPLACE PROCEDURE sp_set_product_active (
+    p_actor_id BIGINT,
+    p_product_id INT,
+    p_active BOOLEAN
+) LANGUAGE plpgsql AS $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM credit_products WHERE id = p_product_id) THEN
+        RAISE EXCEPTION 'Кредитный продукт % не найден', p_product_id USING ERRCODE = 'RB011';
+    END IF;
+
+    PERFORM set_config('app.user_id', p_actor_id::TEXT, TRUE);
+
+    UPDATE credit_products
+    SET is_active = p_active
+    WHERE id = p_product_id AND is_active IS DISTINCT FROM p_active;
+
+    PERFORM set_config('app.user_id', '', TRUE);
+END;
+$$;
+
+-- GetProductVersions
+CREATE
+OR REPLACE FUNCTION fn_get_product_versions (p_product_id INT) RETURNS TABLE (
+    id INT,
+    version INT,
+    min_amount BIGINT,
+    max_amount BIGINT,
+    min_term_months INT,
+    max_term_months INT,
+    interest_rate NUMERIC,
+    created_at TIMESTAMPTZ,
+    login VARCHAR,
+    first_name VARCHAR,
+    last_name VARCHAR,
+    contracts BIGINT
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT
+        v.id,
+        v.version,
+        v.min_amount,
+        v.max_amount,
+        v.min_term_months,
+        v.max_term_months,
+        v.interest_rate,
+        v.created_at,
+        u.login,
+        e.first_name,
+        e.last_name,
+        (SELECT COUNT(*) FROM loan_contracts lc WHERE lc.product_version_id = v.id)
+    FROM credit_product_versions v
+    LEFT JOIN users u ON v.created_by = u.id
+    LEFT JOIN employees e ON u.id = e.user_id
+    WHERE v.product_id = p_product_id
+    ORDER BY v.version DESC;
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+-- IssueLoan
+-- Договор выдается по текущей версии условий активного продукта.
+CREATE
+OR REPLACE PROCEDURE sp_issue_loan (
+    p_client_id BIGINT,
+    p_product_id INT,
+    p_amount BIGINT,
+    p_term_months INT,
+    p_employee_id INT,
+    INOUT p_new_id BIGINT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_rate NUMERIC;
+    v_version_id INT;
+    v_is_active BOOLEAN;
+    v_annuity BIGINT;
+    v_balance BIGINT;
+    v_monthly_rate NUMERIC;
+    v_interest_part BIGINT;
+    v_principal_part BIGINT;
+    v_current_date DATE := CURRENT_DATE;
+    i INT;
+BEGIN
+    SELECT v.id, v.interest_rate, cp.is_active
+    INTO v_version_id, v_rate, v_is_active
+    FROM credit_products cp
+    JOIN credit_product_versions v ON v.product_id = cp.id AND v.version = cp.current_version
+    WHERE cp.id = p_product_id;
+
+    IF v_version_id IS NULL THEN
+        RAISE EXCEPTION 'Кредитный продукт % не найден', p_product_id USING ERRCODE = 'RB011';
+    END IF;
+
+    IF NOT COALESCE(v_is_active, FALSE) THEN
+        RAISE EXCEPTION 'Кредитный продукт % не активен', p_product_id USING ERRCODE = 'RB019';
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM clients WHERE id = p_client_id) THEN
+        RAISE EXCEPTION 'Клиент % не найден', p_client_id USING ERRCODE = 'RB012';
+    END IF;
+
+    IF p_term_months IS NULL OR p_term_months <= 0 THEN
+        RAISE EXCEPTION 'Неверный срок кредита: %', p_term_months USING ERRCODE = 'RB014';
+    END IF;
+    
+    v_monthly_rate := v_rate / 12 / 100;
+    v_annuity := fn_calculate_annuity(p_amount, v_rate, p_term_months);
+    v_balance := p_amount;
+
+    INSERT INTO loan_contracts (
+        contract_number, client_id, product_id, product_version_id, approved_by_employee_id, 
+        amount, interest_rate, term_months, start_date, end_date, status, balance
+    ) VALUES (
+        'LN-' || CAST(EXTRACT(EPOCH FROM NOW()) AS BIGINT) || '-' || p_client_id,
+        p_client_id, p_product_id, v_version_id, p_employee_id,
+        p_amount, v_rate, p_term_months, v_current_date, 
+        v_current_date + (p_term_months || ' months')::INTERVAL, 
+        'active', p_amount
+    ) RETURNING id INTO p_new_id;
+
+    FOR i IN 1..p_term_months LOOP
+        v_current_date := v_current_date + INTERVAL '1 month';
+        
+        v_interest_part := ROUND(v_balance * v_monthly_rate)::BIGINT;
+        v_principal_part := v_annuity - v_interest_part;
+
+        IF i = p_term_months OR v_principal_part > v_balance THEN
+            v_principal_part := v_balance;
+            v_annuity := v_principal_part + v_interest_part;
+        END IF;
+
+        v_balance := v_balance - v_principal_part;
+
+        INSERT INTO repayment_schedule (
+            contract_id, payment_date, payment_amount, 
+            principal_amount, interest_amount, remaining_balance, is_paid
+        ) VALUES (
+            p_new_id, v_current_date, v_annuity, 
+            v_principal_part, v_interest_part, v_balance, FALSE
+        );
+    END LOOP;
+
+    INSERT INTO operations (contract_id, employee_id, operation_type, amount, description)
+    VALUES (p_new_id, p_employee_id, 'issue', p_amount, 'Выдача');
+END;
+$$;
@@ -0,0 +1,172 @@
+-- Значения review, approved, rejected и signed из contract_status не
+-- удаляются: PostgreSQL не умеет убирать значения enum. Незавершенные
+-- заявки возвращаются в draft.
+
+DROP FUNCTION IF EXISTS fn_get_loan_transitions (BIGINT);
+
+DROP PROCEDURE IF EXISTS sp_change_loan_status (BIGINT, BIGINT, VARCHAR, TEXT);
+
+DROP PROCEDURE IF EXISTS sp_build_schedule (BIGINT);
+
+UPDATE loan_contracts
+SET status = 'draft'
+WHERE status::TEXT IN ('review', 'approved', 'rejected', 'signed');
+
+DROP TABLE IF EXISTS loan_transitions;
+
+ALTER TABLE loan_contracts
+DROP COLUMN IF EXISTS created_by_employee_id;
+
+DROP MATERIALIZED VIEW IF EXISTS mv_dashboard_cache;
+
+CREATE MATERIALIZED VIEW
+    mv_dashboard_cache AS
+WITH
+    issued AS (
+        SELECT
+            COALESCE(SUM(amount), 0) AS total
+        FROM
+            loan_contracts
+        WHERE
+            status != 'draft'
+    ),
+    repaid AS (
+        SELECT
+            COALESCE(SUM(amount), 0) AS total
+        FROM
+            operations
+        WHERE
+            operation_type IN ('scheduled_payment', 'early_repayment')
+    ),
+    dist AS (
+        SELECT
+            json_agg(row_to_json(t)) AS data
+        FROM
+            (
+                SELECT
+                    cp.name AS "label",
+                    COUNT(lc.id) AS "value"
+                FROM
+                    loan_contracts lc
+                    JOIN credit_products cp ON lc.product_id = cp.id
+                GROUP BY
+                    cp.name
+            ) t
+    )
+SELECT
+    1 AS id,
+    json_build_object(
+        'totalIssued',
+        (
+            SELECT
+                total
+            FROM
+                issued
+        ),
+        'totalRepaid',
+        (
+            SELECT
+                total
+            FROM
+                repaid
+        ),
+        'distribution',
+        COALESCE(
+            (
+                SELECT
+                    data
+                FROM
+                    dist
+            ),
+            '[]'::json
+        )
+    ) AS stats_json;
+
+CREATE UNIQUE INDEX idx_mv_dashboard_cache ON mv_dashboard_cache (id);
+
+-- IssueLoan
+-- Договор выдается по текущей версии условий активного продукта.
+CREATE
+OR REPLACE PROCEDURE sp_issue_loan (
+    p_client_id BIGINT,
+    p_product_id INT,
+    p_amount BIGINT,
+    p_term_months INT,
+    p_employee_id INT,
+    INOUT p_new_id BIGINT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_rate NUMERIC;
+    v_version_id INT;
+    v_is_active BOOLEAN;
+    v_annuity BIGINT;
+    v_balance BIGINT;
+    v_monthly_rate NUMERIC;
+    v_interest_part BIGINT;
+    v_principal_part BIGINT;
+    v_current_date DATE := CURRENT_DATE;
+    i INT;
+BEGIN
+    SELECT v.id, v.interest_rate, cp.is_active
+    INTO v_version_id, v_rate, v_is_active
+    FROM credit_products cp
+    JOIN credit_product_versions v ON v.product_id = cp.id AND v.version = cp.current_version
+    WHERE cp.id = p_product_id;
+
+    IF v_version_id IS NULL THEN
+        RAISE EXCEPTION 'Кредитный продукт % не найден', p_product_id USING ERRCODE = 'RB011';
+    END IF;
+
+    IF NOT COALESCE(v_is_active, FALSE) THEN
+        RAISE EXCEPTION 'Кредитный продукт % не активен', p_product_id USING ERRCODE = 'RB019';
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM clients WHERE id = p_client_id) THEN
+        RAISE EXCEPTION 'Клиент % не найден', p_client_id USING ERRCODE = 'RB012';
+    END IF;
+
+    IF p_term_months IS NULL OR p_term_months <= 0 THEN
+        RAISE EXCEPTION 'Неверный срок кредита: %', p_term_months USING ERRCODE = 'RB014';
+    END IF;
+    
+    v_monthly_rate := v_rate / 12 / 100;
+    v_annuity := fn_calculate_annuity(p_amount, v_rate, p_term_months);
+    v_balance := p_amount;
+
+    INSERT INTO loan_contracts (
+        contract_number, client_id, product_id, product_version_id, approved_by_employee_id, 
+        amount, interest_rate, term_months, start_date, end_date, status, balance
+    ) VALUES (
+        'LN-' || CAST(EXTRACT(EPOCH FROM NOW()) AS BIGINT) || '-' || p_client_id,
+        p_client_id, p_product_id, v_version_id, p_employee_id,
+        p_amount, v_rate, p_term_months, v_current_date, 
+        v_current_date + (p_term_months || ' months')::INTERVAL, 
+        'active', p_amount
+    ) RETURNING id INTO p_new_id;
+
+    FOR i IN 1..p_term_months LOOP
+        v_current_date := v_current_date + INTERVAL '1 month';
+        
+        v_interest_part := ROUND(v_balance * v_monthly_rate)::BIGINT;
+        v_principal_part := v_annuity - v_interest_part;
+
+        IF i = p_term_months OR v_principal_part > v_balance THEN
+            v_principal_part := v_balance;
+            v_annuity := v_principal_part + v_interest_part;
+        END IF;
+
+        v_balance := v_balance - v_principal_part;
+
+        INSERT INTO repayment_schedule (
+            contract_id, payment_date, payment_amount, 
+            principal_amount, interest_amount, remaining_balance, is_paid
+        ) VALUES (
+            p_new_id, v_current_date, v_annuity, 
+            v_principal_part, v_interest_part, v_balance, FALSE
+        );
+    END LOOP;
+
+    INSERT INTO operations (contract_id, employee_id, operation_type, amount, description)
+    VALUES (p_new_id, p_employee_id, 'issue', p_amount, 'Выдача');
+END;
+$$;
@@ -0,0 +1,302 @@
+-- Жизненный цикл кредита:
+--
+--   draft (заявка) -> review (на рассмотрении) -> approved (одобрена)
+--                                              -> rejected (отклонена)
+--   approved -> signed (подписана) -> active (действует)
+--
+-- sp_issue_loan теперь только регистрирует заявку. Сумма выдается, а график
+-- платежей строится при переходе в active. Каждый переход записывается в
+-- loan_transitions с автором, причиной и временем.
+--
+-- RB020 переход недопустим из текущего статуса договора
+-- RB021 не указана причина отказа
+--
+-- Новые значения contract_status нельзя использовать в той же транзакции,
+-- в которой они добавлены, поэтому ниже они встречаются только в телах
+-- функций.
+
+ALTER TYPE contract_status ADD VALUE IF NOT EXISTS 'review' AFTER 'draft';
+
+ALTER TYPE contract_status ADD VALUE IF NOT EXISTS 'approved' AFTER 'review';
+
+ALTER TYPE contract_status ADD VALUE IF NOT EXISTS 'rejected' AFTER 'approved';
+
+ALTER TYPE contract_status ADD VALUE IF NOT EXISTS 'signed' AFTER 'rejected';
+
+ALTER TABLE loan_contracts
+ADD COLUMN created_by_employee_id INT REFERENCES employees (id);
+
+CREATE TABLE
+    loan_transitions (
+        id BIGSERIAL PRIMARY KEY,
+        contract_id BIGINT NOT NULL REFERENCES loan_contracts (id),
+        from_status contract_status,
+        to_status contract_status NOT NULL,
+        reason TEXT,
+        user_id BIGINT REFERENCES users (id),
+        created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    );
+
+CREATE INDEX idx_loan_transitions_contract ON loan_transitions (contract_id);
+
+-- Выданными считаются только договоры, по которым прошла выдача.
+DROP MATERIALIZED VIEW IF EXISTS mv_dashboard_cache;
+
+CREATE MATERIALIZED VIEW
+    mv_dashboard_cache AS
+WITH
+    issued AS (
+        SELECT
+            COALESCE(SUM(amount), 0) AS total
+        FROM
+            loan_contracts
+        WHERE
+            status IN ('active', 'closed', 'defaulted')
+    ),
+    repaid AS (
+        SELECT
+            COALESCE(SUM(amount), 0) AS total
+        FROM
+            operations
+        WHERE
+            operation_type IN ('scheduled_payment', 'early_repayment')
+    ),
+    dist AS (
+        SELECT
+            json_agg(row_to_json(t)) AS data
+        FROM
+            (
+                SELECT
+                    cp.name AS "label",
+                    COUNT(lc.id) AS "value"
+                FROM
+                    loan_contracts lc
+                    JOIN credit_products cp ON lc.product_id = cp.id
+                GROUP BY
+                    cp.name
+            ) t
+    )
+SELECT
+    1 AS id,
+    json_build_object(
+        'totalIssued',
+        (
+            SELECT
+                total
+            FROM
+                issued
+        ),
+        'totalRepaid',
+        (
+            SELECT
+                total
+            FROM
+                repaid
+        ),
+        'distribution',
+        COALESCE(
+            (
+                SELECT
+                    data
+                FROM
+                    dist
+            ),
+            '[]'::json
+        )
+    ) AS stats_json;
+
+CREATE UNIQUE INDEX idx_mv_dashboard_cache ON mv_dashboard_cache (id);
+
+-- BuildSchedule
+-- Аннуитетный график от даты начала договора.
+CREATE
+OR REPLACE PROCEDURE sp_build_schedule (p_contract_id BIGINT) LANGUAGE plpgsql AS $$
+DECLARE
+    v_amount BIGINT;
+    v_rate NUMERIC;
+    v_term INT;
+    v_current_date DATE;
+    v_annuity BIGINT;
+    v_balance BIGINT;
+    v_monthly_rate NUMERIC;
+    v_interest_part BIGINT;
+    v_principal_part BIGINT;
+    i INT;
+BEGIN
+    SELECT amount, interest_rate, term_months, start_date
+    INTO v_amount, v_rate, v_term, v_current_date
+    FROM loan_contracts WHERE id = p_contract_id;
+
+    v_monthly_rate := v_rate / 12 / 100;
+    v_annuity := fn_calculate_annuity(v_amount, v_rate, v_term);
+    v_balance := v_amount;
+
+    FOR i IN 1..v_term LOOP
+        v_current_date := v_current_date + INTERVAL '1 month';
+
+        v_interest_part := ROUND(v_balance * v_monthly_rate)::BIGINT;
+        v_principal_part := v_annuity - v_interest_part;
+
+        IF i = v_term OR v_principal_part > v_balance THEN
+            v_principal_part := v_balance;
+            v_annuity := v_principal_part + v_interest_part;
+        END IF;
+
+        v_balance := v_balance - v_principal_part;
+
+        INSERT INTO repayment_schedule (
+            contract_id, payment_date, payment_amount,
+            principal_amount, interest_amount, remaining_balance, is_paid
+        ) VALUES (
+            p_contract_id, v_current_date, v_annuity,
+            v_principal_part, v_interest_part, v_balance, FALSE
+        );
+    END LOOP;
+END;
+$$;
+
+-- IssueLoan
+-- Регистрирует заявку в статусе draft. Остаток долга до выдачи нулевой,
+-- даты договора уточняются при активации.
+CREATE
+OR REPLACE PROCEDURE sp_issue_loan (
+    p_client_id BIGINT,
+    p_product_id INT,
+    p_amount BIGINT,
+    p_term_months INT,
+    p_employee_id INT,
+    INOUT p_new_id BIGINT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_rate NUMERIC;
+    v_version_id INT;
+    v_is_active BOOLEAN;
+BEGIN
+    SELECT v.id, v.interest_rate, cp.is_active
+    INTO v_version_id, v_rate, v_is_active
+    FROM credit_products cp
+    JOIN credit_product_versions v ON v.product_id = cp.id AND v.version = cp.current_version
+    WHERE cp.id = p_product_id;
+
+    IF v_version_id IS NULL THEN
+        RAISE EXCEPTION 'Кредитный продукт % не найден', p_product_id USING ERRCODE = 'RB011';
+    END IF;
+
+    IF NOT COALESCE(v_is_active, FALSE) THEN
+        RAISE EXCEPTION 'Кредитный продукт % не активен', p_product_id USING ERRCODE = 'RB019';
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM clients WHERE id = p_client_id) THEN
+        RAISE EXCEPTION 'Клиент % не найден', p_client_id USING ERRCODE = 'RB012';
+    END IF;
+
+    IF p_term_months IS NULL OR p_term_months <= 0 THEN
+        RAISE EXCEPTION 'Неверный срок кредита: %', p_term_months USING ERRCODE = 'RB014';
+    END IF;
+
+    INSERT INTO loan_contracts (
+        contract_number, client_id, product_id, product_version_id, created_by_employee_id,
+        amount, interest_rate, term_months, start_date, end_date, status, balance
+    ) VALUES (
+        'LN-' || CAST(EXTRACT(EPOCH FROM NOW()) AS BIGINT) || '-' || p_client_id,
+        p_client_id, p_product_id, v_version_id, p_employee_id,
+        p_amount, v_rate, p_term_months, CURRENT_DATE,
+        CURRENT_DATE + (p_term_months || ' months')::INTERVAL,
+        'draft', 0
+    ) RETURNING id INTO p_new_id;
+
+    INSERT INTO loan_transitions (contract_id, from_status, to_status, user_id)
+    VALUES (p_new_id, NULL, 'draft', (SELECT user_id FROM employees WHERE id = p_employee_id));
+END;
+$$;
+
+-- ChangeLoanStatus
+-- p_actor_id - пользователь, выполняющий переход. При одобрении он
+-- записывается как одобривший сотрудник, при активации - как выдавший.
+CREATE
+OR REPLACE PROCEDURE sp_change_loan_status (
+    p_actor_id BIGINT,
+    p_contract_id BIGINT,
+    p_status VARCHAR,
+    p_reason TEXT
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_contract loan_contracts%ROWTYPE;
+    v_employee_id INT;
+BEGIN
+    SELECT * INTO v_contract FROM loan_contracts WHERE id = p_contract_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Договор % не найден', p_contract_id USING ERRCODE = 'RB013';
+    END IF;
+
+    IF (v_contract.status::TEXT, p_status) NOT IN (
+        ('draft', 'review'),
+        ('review', 'approved'),
+        ('review', 'rejected'),
+        ('approved', 'signed'),
+        ('signed', 'active')
+    ) THEN
+        RAISE EXCEPTION 'Договор в статусе % нельзя перевести в статус %', v_contract.status, p_status
+            USING ERRCODE = 'RB020';
+    END IF;
+
+    IF p_status = 'rejected' AND NULLIF(btrim(p_reason), '') IS NULL THEN
+        RAISE EXCEPTION 'Укажите причину отказа' USING ERRCODE = 'RB021';
+    END IF;
+
+    SELECT id INTO v_employee_id FROM employees WHERE user_id = p_actor_id;
+
+    IF p_status = 'approved' THEN
+        UPDATE loan_contracts SET approved_by_employee_id = v_employee_id WHERE id = p_contract_id;
+    END IF;
+
+    IF p_status = 'active' THEN
+        UPDATE loan_contracts
+        SET balance = amount,
+            start_date = CURRENT_DATE,
+            end_date = CURRENT_DATE + (term_months || ' months')::INTERVAL
+        WHERE id = p_contract_id;
+
+        CALL sp_build_schedule(p_contract_id);
+
+        INSERT INTO operations (contract_id, employee_id, operation_type, amount, description)
+        VALUES (p_contract_id, v_employee_id, 'issue', v_contract.amount, 'Выдача');
+    END IF;
+
+    UPDATE loan_contracts SET status = p_status::contract_status WHERE id = p_contract_id;
+
+    INSERT INTO loan_transitions (contract_id, from_status, to_status, reason, user_id)
+    VALUES (p_contract_id, v_contract.status, p_status::contract_status, NULLIF(btrim(p_reason), ''), p_actor_id);
+END;
+$$;
+
+-- GetLoanTransitions
+CREATE
+OR REPLACE FUNCTION fn_get_loan_transitions (p_contract_id BIGINT) RETURNS TABLE (
+    id BIGINT,
+    from_status VARCHAR,
+    to_status VARCHAR,
+    reason TEXT,
+    created_at TIMESTAMPTZ,
+    login VARCHAR,
+    first_name VARCHAR,
+    last_name VARCHAR
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT
+        t.id,
+        t.from_status::VARCHAR,
+        t.to_status::VARCHAR,
+        t.reason,
+        t.created_at,
+        u.login,
+        e.first_name,
+        e.last_name
+    FROM loan_transitions t
+    LEFT JOIN users u ON t.user_id = u.id
+    LEFT JOIN employees e ON u.id = e.user_id
+    WHERE t.contract_id = p_contract_id
+    ORDER BY t.created_at, t.id;
+END;
+$$ LANGUAGE plpgsql STABLE;
@@ -0,0 +1,58 @@
+-- IssueLoan
+-- Регистрирует заявку в статусе draft. Остаток долга до выдачи нулевой,
+-- даты договора уточняются при активации.
+CREATE
+OR REPLACE PROCEDURE sp_issue_loan (
+    p_client_id BIGINT,
+    p_product_id INT,
+    p_amount BIGINT,
+    p_term_months INT,
+    p_employee_id INT,
+    INOUT p_new_id BIGINT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_rate NUMERIC;
+    v_version_id INT;
+    v_is_active BOOLEAN;
+BEGIN
+    SELECT v.id, v.interest_rate, cp.is_active
+    INTO v_version_id, v_rate, v_is_active
+    FROM credit_products cp
+    JOIN credit_product_versions v ON v.product_id = cp.id AND v.version = cp.current_version
+    WHERE cp.id = p_product_id;
+
+    IF v_version_id IS NULL THEN
+        RAISE EXCEPTION 'Кредитный продукт % не найден', p_product_id USING ERRCODE = 'RB011';
+    END IF;
+
+    IF NOT COALESCE(v_is_active, FALSE) THEN
+        RAISE EXCEPTION 'Кредитный продукт % не активен', p_product_id USING ERRCODE = 'RB019';
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM clients WHERE id = p_client_id) THEN
+        RAISE EXCEPTION 'Клиент % не найден', p_client_id USING ERRCODE = 'RB012';
+    END IF;
+
+    IF p_term_months IS NULL OR p_term_months <= 0 THEN
+        RAISE EXCEPTION 'Неверный срок кредита: %', p_term_months USING ERRCODE = 'RB014';
+    END IF;
+
+    INSERT INTO loan_contracts (
+        contract_number, client_id, product_id, product_version_id, created_by_employee_id,
+        amount, interest_rate, term_months, start_date, end_date, status, balance
+    ) VALUES (
+        'LN-' || CAST(EXTRACT(EPOCH FROM NOW()) AS BIGINT) || '-' || p_client_id,
+        p_client_id, p_product_id, v_version_id, p_employee_id,
+        p_amount, v_rate, p_term_months, CURRENT_DATE,
+        CURRENT_DATE + (p_term_months || ' months')::INTERVAL,
+        'draft', 0
+    ) RETURNING id INTO p_new_id;
+
+    INSERT INTO loan_transitions (contract_id, from_status, to_status, user_id)
+    VALUES (p_new_id, NULL, 'draft', (SELECT user_id FROM employees WHERE id = p_employee_id));
+END;
+$$;
+
+DROP FUNCTION IF EXISTS fn_loan_violation (TEXT, TEXT, TEXT, TEXT);
+
+DROP FUNCTION IF EXISTS fn_format_amount (BIGINT);
@@ -0,0 +1,104 @@
+-- Проверка условий продукта при оформлении заявки: активность продукта,
+-- границы суммы и срока по текущей версии условий.
+--
+-- RB022 условия кредита не соответствуют продукту; DETAIL содержит
+-- {"violations": [{field, rule, limit, message}, ...]}
+
+-- Сумма в копейках в виде «10 000.00».
+CREATE
+OR REPLACE FUNCTION fn_format_amount (p_kopecks BIGINT) RETURNS TEXT AS $$
+    SELECT replace(to_char(p_kopecks / 100.0, 'FM999,999,999,999,990.00'), ',', ' ');
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+CREATE
+OR REPLACE FUNCTION fn_loan_violation (
+    p_field TEXT,
+    p_rule TEXT,
+    p_limit TEXT,
+    p_message TEXT
+) RETURNS JSONB AS $$
+    SELECT jsonb_strip_nulls(jsonb_build_object('field', p_field, 'rule', p_rule, 'limit', p_limit, 'message', p_message));
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+-- IssueLoan
+-- Все нарушения условий продукта собираются в один ответ: RB022 с
+-- JSON-списком в DETAIL.
+CREATE
+OR REPLACE PROCEDURE sp_issue_loan (
+    p_client_id BIGINT,
+    p_product_id INT,
+    p_amount BIGINT,
+    p_term_months INT,
+    p_employee_id INT,
+    INOUT p_new_id BIGINT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_name VARCHAR;
+    v_is_active BOOLEAN;
+    v_terms credit_product_versions%ROWTYPE;
+    v_violations JSONB := '[]'::JSONB;
+BEGIN
+    SELECT name, is_active INTO v_name, v_is_active FROM credit_products WHERE id = p_product_id;
+
+    SELECT v.* INTO v_terms
+    FROM credit_products cp
+    JOIN credit_product_versions v ON v.product_id = cp.id AND v.version = cp.current_version
+    WHERE cp.id = p_product_id;
+
+    IF v_terms.id IS NULL THEN
+        RAISE EXCEPTION 'Кредитный продукт % не найден', p_product_id USING ERRCODE = 'RB011';
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM clients WHERE id = p_client_id) THEN
+        RAISE EXCEPTION 'Клиент % не найден', p_client_id USING ERRCODE = 'RB012';
+    END IF;
+
+    IF p_term_months IS NULL OR p_term_months <= 0 THEN
+        RAISE EXCEPTION 'Неверный срок кредита: %', p_term_months USING ERRCODE = 'RB014';
+    END IF;
+
+    IF NOT COALESCE(v_is_active, FALSE) THEN
+        v_violations := v_violations || fn_loan_violation('productId', 'active', NULL,
+            format('Продукт «%s» не принимает новые заявки', v_name));
+    END IF;
+
+    IF p_amount < v_terms.min_amount THEN
+        v_violations := v_violations || fn_loan_violation('amount', 'min', fn_format_amount(v_terms.min_amount),
+            format('Сумма ниже минимальной %s для продукта «%s»', fn_format_amount(v_terms.min_amount), v_name));
+    END IF;
+
+    IF p_amount > v_terms.max_amount THEN
+        v_violations := v_violations || fn_loan_violation('amount', 'max', fn_format_amount(v_terms.max_amount),
+            format('Сумма выше максимальной %s для продукта «%s»', fn_format_amount(v_terms.max_amount), v_name));
+    END IF;
+
+    IF p_term_months < v_terms.min_term_months THEN
+        v_violations := v_violations || fn_loan_violation('termMonths', 'min', v_terms.min_term_months::TEXT,
+            format('Срок меньше минимального %s мес. для продукта «%s»', v_terms.min_term_months, v_name));
+    END IF;
+
+    IF p_term_months > v_terms.max_term_months THEN
+        v_violations := v_violations || fn_loan_violation('termMonths', 'max', v_terms.max_term_months::TEXT,
+            format('Срок больше максимального %s мес. для продукта «%s»', v_terms.max_term_months, v_name));
+    END IF;
+
+    IF jsonb_array_length(v_violations) > 0 THEN
+        RAISE EXCEPTION 'Условия кредита не соответствуют продукту'
+            USING ERRCODE = 'RB022', DETAIL = jsonb_build_object('violations', v_violations)::TEXT;
+    END IF;
+
+    INSERT INTO loan_contracts (
+        contract_number, client_id, product_id, product_version_id, created_by_employee_id,
+        amount, interest_rate, term_months, start_date, end_date, status, balance
+    ) VALUES (
+        'LN-' || CAST(EXTRACT(EPOCH FROM NOW()) AS BIGINT) || '-' || p_client_id,
+        p_client_id, p_product_id, v_terms.id, p_employee_id,
+        p_amount, v_terms.interest_rate, p_term_months, CURRENT_DATE,
+        CURRENT_DATE + (p_term_months || ' months')::INTERVAL,
+        'draft', 0
+    ) RETURNING id INTO p_new_id;
+
+    INSERT INTO loan_transitions (contract_id, from_status, to_status, user_id)
+    VALUES (p_new_id, NULL, 'draft', (SELECT user_id FROM employees WHERE id = p_employee_id));
+END;
+$$;
@@ -0,0 +1 @@
+DROP FUNCTION IF EXISTS fn_get_session_employee (BIGINT);
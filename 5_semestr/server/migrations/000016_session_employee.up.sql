@@ -0,0 +1,11 @@
+-- Сотрудник текущей сессии: обработчики берут его из учетной записи в
+-- токене, а не из тела запроса.
+
+-- ByUser
+CREATE
+OR REPLACE FUNCTION fn_get_session_employee (p_user_id BIGINT) RETURNS INT AS $$
+    SELECT e.id
+    FROM employees e
+    JOIN users u ON u.id = e.user_id
+    WHERE e.user_id = p_user_id AND u.is_active = TRUE;
+$$ LANGUAGE sql STABLE;
@@ -0,0 +1,290 @@
+DROP FUNCTION IF EXISTS fn_get_contract_schedule_type (BIGINT);
+
+DROP FUNCTION IF EXISTS fn_get_products (INT, BOOLEAN);
+
+DROP PROCEDURE IF EXISTS sp_create_product (BIGINT, VARCHAR, BIGINT, BIGINT, INT, INT, NUMERIC, VARCHAR, INT);
+
+DROP PROCEDURE IF EXISTS sp_update_product (BIGINT, INT, VARCHAR, BIGINT, BIGINT, INT, INT, NUMERIC, VARCHAR, INT);
+
+DROP FUNCTION IF EXISTS fn_get_product_versions (INT);
+
+-- Выданными считаются только договоры, по которым прошла выдача.
+DROP MATERIALIZED VIEW IF EXISTS mv_dashboard_cache;
+
+CREATE MATERIALIZED VIEW
+    mv_dashboard_cache AS
+WITH
+    issued AS (
+        SELECT
+            COALESCE(SUM(amount), 0) AS total
+        FROM
+            loan_contracts
+        WHERE
+            status IN ('active', 'closed', 'defaulted')
+    ),
+    repaid AS (
+        SELECT
+            COALESCE(SUM(amount), 0) AS total
+        FROM
+            operations
+        WHERE
+            operation_type IN ('scheduled_payment', 'early_repayment')
+    ),
+    dist AS (
+        SELECT
+            json_agg(row_to_json(t)) AS data
+        FROM
+            (
+                SELECT
+                    cp.name AS "label",
+                    COUNT(lc.id) AS "value"
+                FROM
+                    loan_contracts lc
+                    JOIN credit_products cp ON lc.product_id = cp.id
+                GROUP BY
+                    cp.name
+            ) t
+    )
+SELECT
+    1 AS id,
+    json_build_object(
+        'totalIssued',
+        (
+            SELECT
+                total
+            FROM
+                issued
+        ),
+        'totalRepaid',
+        (
+            SELECT
+                total
+            FROM
+                repaid
+        ),
+        'distribution',
+        COALESCE(
+            (
+                SELECT
+                    data
+                FROM
+                    dist
+            ),
+            '[]'::json
+        )
+    ) AS stats_json;
+
+CREATE UNIQUE INDEX idx_mv_dashboard_cache ON mv_dashboard_cache (id);
+
+-- GetProducts
+-- p_product_id NULL - все продукты.
+CREATE
+OR REPLACE FUNCTION fn_get_products (p_product_id INT, p_include_inactive BOOLEAN) RETURNS TABLE (
+    id INT,
+    name VARCHAR,
+    min_amount BIGINT,
+    max_amount BIGINT,
+    min_term_months INT,
+    max_term_months INT,
+    interest_rate NUMERIC,
+    is_active BOOLEAN,
+    current_version INT
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT
+        cp.id,
+        cp.name,
+        cp.min_amount,
+        cp.max_amount,
+        cp.min_term_months,
+        cp.max_term_months,
+        cp.interest_rate,
+        COALESCE(cp.is_active, FALSE),
+        cp.current_version
+    FROM credit_products cp
+    WHERE (p_product_id IS NULL OR cp.id = p_product_id)
+      AND (p_include_inactive OR cp.is_active)
+    ORDER BY cp.id;
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+-- CreateProduct
+CREATE
+OR REPLACE PROCEDURE sp_create_product (
+    p_actor_id BIGINT,
+    p_name VARCHAR,
+    p_min_amount BIGINT,
+    p_max_amount BIGINT,
+    p_min_term INT,
+    p_max_term INT,
+    p_rate NUMERIC,
+    INOUT p_product_id INT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+BEGIN
+    PERFORM set_config('app.user_id', p_actor_id::TEXT, TRUE);
+
+    INSERT INTO credit_products (name, min_amount, max_amount, min_term_months, max_term_months, interest_rate, is_active)
+    VALUES (p_name, p_min_amount, p_max_amount, p_min_term, p_max_term, p_rate, TRUE)
+    RETURNING id INTO p_product_id;
+
+    INSERT INTO credit_product_versions (
+        product_id, version, min_amount, max_amount, min_term_months, max_term_months, interest_rate, created_by
+    ) VALUES (
+        p_product_id, 1, p_min_amount, p_max_amount, p_min_term, p_max_term, p_rate, p_actor_id
+    );
+
+    PERFORM set_config('app.user_id', '', TRUE);
+END;
+$$;
+
+-- UpdateProduct
+-- Новая версия создается только при изменении условий; переименование
+-- версию не меняет.
+CREATE
+OR REPLACE PROCEDURE sp_update_product (
+    p_actor_id BIGINT,
+    p_product_id INT,
+    p_name VARCHAR,
+    p_min_amount BIGINT,
+    p_max_amount BIGINT,
+    p_min_term INT,
+    p_max_term INT,
+    p_rate NUMERIC,
+    INOUT p_version INT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_product credit_products%ROWTYPE;
+BEGIN
+    SELECT * INTO v_product FROM credit_products WHERE id = p_product_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Кредитный продукт % не найден', p_product_id USING ERRCODE = 'RB011';
+    END IF;
+
+    PERFORM set_config('app.user_id', p_actor_id::TEXT, TRUE);
+
+    p_version := v_product.current_version;
+
+    IF (v_product.min_amount, v_product.max_amount, v_product.min_term_months,
+        v_product.max_term_months, v_product.interest_rate)
+       IS DISTINCT FROM
+       (p_min_amount, p_max_amount, p_min_term, p_max_term, p_rate::NUMERIC(5, 2))
+    THEN
+        p_version := p_version + 1;
+
+        INSERT INTO credit_product_versions (
+            product_id, version, min_amount, max_amount, min_term_months, max_term_months, interest_rate, created_by
+        ) VALUES (
+            p_product_id, p_version, p_min_amount, p_max_amount, p_min_term, p_max_term, p_rate, p_actor_id
+        );
+    END IF;
+
+    UPDATE credit_products
+    SET name = p_name,
+        min_amount = p_min_amount,
+        max_amount = p_max_amount,
+        min_term_months = p_min_term,
+        max_term_months = p_max_term,
+        interest_rate = p_rate,
+        current_version = p_version
+    WHERE id = p_product_id
+      AND (name, current_version) IS DISTINCT FROM (p_name, p_version);
+
+    PERFORM set_config('app.user_id', '', TRUE);
+END;
+$$;
+
+-- GetProductVersions
+CREATE
+OR REPLACE FUNCTION fn_get_product_versions (p_product_id INT) RETURNS TABLE (
+    id INT,
+    version INT,
+    min_amount BIGINT,
+    max_amount BIGINT,
+    min_term_months INT,
+    max_term_months INT,
+    interest_rate NUMERIC,
+    created_at TIMESTAMPTZ,
+    login VARCHAR,
+    first_name VARCHAR,
+    last_name VARCHAR,
+    contracts BIGINT
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT
+        v.id,
+        v.version,
+        v.min_amount,
+        v.max_amount,
+        v.min_term_months,
+        v.max_term_months,
+        v.interest_rate,
+        v.created_at,
+        u.login,
+        e.first_name,
+        e.last_name,
+        (SELECT COUNT(*) FROM loan_contracts lc WHERE lc.product_version_id = v.id)
+    FROM credit_product_versions v
+    LEFT JOIN users u ON v.created_by = u.id
+    LEFT JOIN employees e ON u.id = e.user_id
+    WHERE v.product_id = p_product_id
+    ORDER BY v.version DESC;
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+-- BuildSchedule
+-- Аннуитетный график от даты начала договора.
+CREATE
+OR REPLACE PROCEDURE sp_build_schedule (p_contract_id BIGINT) LANGUAGE plpgsql AS $$
+DECLARE
+    v_amount BIGINT;
+    v_rate NUMERIC;
+    v_term INT;
+    v_current_date DATE;
+    v_annuity BIGINT;
+    v_balance BIGINT;
+    v_monthly_rate NUMERIC;
+    v_interest_part BIGINT;
+    v_principal_part BIGINT;
+    i INT;
+BEGIN
+    SELECT amount, interest_rate, term_months, start_date
+    INTO v_amount, v_rate, v_term, v_current_date
+    FROM loan_contracts WHERE id = p_contract_id;
+
+    v_monthly_rate := v_rate / 12 / 100;
+    v_annuity := fn_calculate_annuity(v_amount, v_rate, v_term);
+    v_balance := v_amount;
+
+    FOR i IN 1..v_term LOOP
+        v_current_date := v_current_date + INTERVAL '1 month';
+
+        v_interest_part := ROUND(v_balance * v_monthly_rate)::BIGINT;
+        v_principal_part := v_annuity - v_interest_part;
+
+        IF i = v_term OR v_principal_part > v_balance THEN
+            v_principal_part := v_balance;
+            v_annuity := v_principal_part + v_interest_part;
+        END IF;
+
+        v_balance := v_balance - v_principal_part;
+
+        INSERT INTO repayment_schedule (
+            contract_id, payment_date, payment_amount,
+            principal_amount, interest_amount, remaining_balance, is_paid
+        ) VALUES (
+            p_contract_id, v_current_date, v_annuity,
+            v_principal_part, v_interest_part, v_balance, FALSE
+        );
+    END LOOP;
+END;
+$$;
+
+ALTER TABLE credit_product_versions
+DROP COLUMN IF EXISTS schedule_type;
+
+ALTER TABLE credit_products
+DROP COLUMN IF EXISTS schedule_type;
+
+DROP TYPE IF EXISTS schedule_type;
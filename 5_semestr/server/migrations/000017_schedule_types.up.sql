@@ -0,0 +1,348 @@
+-- Тип графика платежей как условие продукта:
+--
+--   annuity        - равные платежи (как раньше);
+--   differentiated - равные доли основного долга, проценты на остаток;
+--   bullet         - ежемесячно только проценты, долг одним платежом в
+--                    конце срока.
+--
+-- Тип входит в версию условий: договор строится по типу своей версии.
+
+CREATE TYPE schedule_type AS ENUM('annuity', 'differentiated', 'bullet');
+
+ALTER TABLE credit_products
+ADD COLUMN schedule_type schedule_type NOT NULL DEFAULT 'annuity';
+
+ALTER TABLE credit_product_versions
+ADD COLUMN schedule_type schedule_type NOT NULL DEFAULT 'annuity';
+
+DROP FUNCTION IF EXISTS fn_get_products (INT, BOOLEAN);
+
+DROP PROCEDURE IF EXISTS sp_create_product (BIGINT, VARCHAR, BIGINT, BIGINT, INT, INT, NUMERIC, INT);
+
+DROP PROCEDURE IF EXISTS sp_update_product (BIGINT, INT, VARCHAR, BIGINT, BIGINT, INT, INT, NUMERIC, INT);
+
+DROP FUNCTION IF EXISTS fn_get_product_versions (INT);
+
+-- GetProducts
+-- p_product_id NULL - все продукты.
+CREATE
+OR REPLACE FUNCTION fn_get_products (p_product_id INT, p_include_inactive BOOLEAN) RETURNS TABLE (
+    id INT,
+    name VARCHAR,
+    min_amount BIGINT,
+    max_amount BIGINT,
+    min_term_months INT,
+    max_term_months INT,
+    interest_rate NUMERIC,
+    is_active BOOLEAN,
+    current_version INT,
+    schedule_type VARCHAR
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT
+        cp.id,
+        cp.name,
+        cp.min_amount,
+        cp.max_amount,
+        cp.min_term_months,
+        cp.max_term_months,
+        cp.interest_rate,
+        COALESCE(cp.is_active, FALSE),
+        cp.current_version,
+        cp.schedule_type::VARCHAR
+    FROM credit_products cp
+    WHERE (p_product_id IS NULL OR cp.id = p_product_id)
+      AND (p_include_inactive OR cp.is_active)
+    ORDER BY cp.id;
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+-- CreateProduct
+CREATE
+OR REPLACE PROCEDURE sp_create_product (
+    p_actor_id BIGINT,
+    p_name VARCHAR,
+    p_min_amount BIGINT,
+    p_max_amount BIGINT,
+    p_min_term INT,
+    p_max_term INT,
+    p_rate NUMERIC,
+    p_schedule_type VARCHAR,
+    INOUT p_product_id INT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+BEGIN
+    PERFORM set_config('app.user_id', p_actor_id::TEXT, TRUE);
+
+    INSERT INTO credit_products (
+        name, min_amount, max_amount, min_term_months, max_term_months, interest_rate, schedule_type, is_active
+    ) VALUES (
+        p_name, p_min_amount, p_max_amount, p_min_term, p_max_term, p_rate, p_schedule_type::schedule_type, TRUE
+    ) RETURNING id INTO p_product_id;
+
+    INSERT INTO credit_product_versions (
+        product_id, version, min_amount, max_amount, min_term_months, max_term_months, interest_rate,
+        schedule_type, created_by
+    ) VALUES (
+        p_product_id, 1, p_min_amount, p_max_amount, p_min_term, p_max_term, p_rate,
+        p_schedule_type::schedule_type, p_actor_id
+    );
+
+    PERFORM set_config('app.user_id', '', TRUE);
+END;
+$$;
+
+-- UpdateProduct
+-- Новая версия создается только при изменении условий, включая тип
+-- графика; переименование версию не меняет.
+CREATE
+OR REPLACE PROCEDURE sp_update_product (
+    p_actor_id BIGINT,
+    p_product_id INT,
+    p_name VARCHAR,
+    p_min_amount BIGINT,
+    p_max_amount BIGINT,
+    p_min_term INT,
+    p_max_term INT,
+    p_rate NUMERIC,
+    p_schedule_type VARCHAR,
+    INOUT p_version INT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_product credit_products%ROWTYPE;
+BEGIN
+    SELECT * INTO v_product FROM credit_products WHERE id = p_product_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Кредитный продукт % не найден', p_product_id USING ERRCODE = 'RB011';
+    END IF;
+
+    PERFORM set_config('app.user_id', p_actor_id::TEXT, TRUE);
+
+    p_version := v_product.current_version;
+
+    IF (v_product.min_amount, v_product.max_amount, v_product.min_term_months,
+        v_product.max_term_months, v_product.interest_rate, v_product.schedule_type)
+       IS DISTINCT FROM
+       (p_min_amount, p_max_amount, p_min_term, p_max_term, p_rate::NUMERIC(5, 2), p_schedule_type::schedule_type)
+    THEN
+        p_version := p_version + 1;
+
+        INSERT INTO credit_product_versions (
+            product_id, version, min_amount, max_amount, min_term_months, max_term_months, interest_rate,
+            schedule_type, created_by
+        ) VALUES (
+            p_product_id, p_version, p_min_amount, p_max_amount, p_min_term, p_max_term, p_rate,
+            p_schedule_type::schedule_type, p_actor_id
+        );
+    END IF;
+
+    UPDATE credit_products
+    SET name = p_name,
+        min_amount = p_min_amount,
+        max_amount = p_max_amount,
+        min_term_months = p_min_term,
+        max_term_months = p_max_term,
+        interest_rate = p_rate,
+        schedule_type = p_schedule_type::schedule_type,
+        current_version = p_version
+    WHERE id = p_product_id
+      AND (name, current_version) IS DISTINCT FROM (p_name, p_version);
+
+    PERFORM set_config('app.user_id', '', TRUE);
+END;
+$$;
+
+-- GetProductVersions
+CREATE
+OR REPLACE FUNCTION fn_get_product_versions (p_product_id INT) RETURNS TABLE (
+    id INT,
+    version INT,
+    min_amount BIGINT,
+    max_amount BIGINT,
+    min_term_months INT,
+    max_term_months INT,
+    interest_rate NUMERIC,
+    schedule_type VARCHAR,
+    created_at TIMESTAMPTZ,
+    login VARCHAR,
+    first_name VARCHAR,
+    last_name VARCHAR,
+    contracts BIGINT
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT
+        v.id,
+        v.version,
+        v.min_amount,
+        v.max_amount,
+        v.min_term_months,
+        v.max_term_months,
+        v.interest_rate,
+        v.schedule_type::VARCHAR,
+        v.created_at,
+        u.login,
+        e.first_name,
+        e.last_name,
+        (SELECT COUNT(*) FROM loan_contracts lc WHERE lc.product_version_id = v.id)
+    FROM credit_product_versions v
+    LEFT JOIN users u ON v.created_by = u.id
+    LEFT JOIN employees e ON u.id = e.user_id
+    WHERE v.product_id = p_product_id
+    ORDER BY v.version DESC;
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+-- GetScheduleType
+-- Тип графика договора по версии условий, с которой он выдан.
+CREATE
+OR REPLACE FUNCTION fn_get_contract_schedule_type (p_contract_id BIGINT) RETURNS VARCHAR AS $$
+    SELECT COALESCE(v.schedule_type, 'annuity')::VARCHAR
+    FROM loan_contracts lc
+    LEFT JOIN credit_product_versions v ON v.id = lc.product_version_id
+    WHERE lc.id = p_contract_id;
+$$ LANGUAGE sql STABLE;
+
+-- BuildSchedule
+-- График от даты начала договора по типу его версии условий. Проценты
+-- всегда начисляются на остаток; последний платеж закрывает остаток
+-- целиком, поэтому сумма основного долга по графику равна сумме кредита.
+CREATE
+OR REPLACE PROCEDURE sp_build_schedule (p_contract_id BIGINT) LANGUAGE plpgsql AS $$
+DECLARE
+    v_amount BIGINT;
+    v_rate NUMERIC;
+    v_term INT;
+    v_current_date DATE;
+    v_type schedule_type;
+    v_annuity BIGINT;
+    v_payment BIGINT;
+    v_balance BIGINT;
+    v_monthly_rate NUMERIC;
+    v_interest_part BIGINT;
+    v_principal_part BIGINT;
+    i INT;
+BEGIN
+    SELECT lc.amount, lc.interest_rate, lc.term_months, lc.start_date, COALESCE(v.schedule_type, 'annuity')
+    INTO v_amount, v_rate, v_term, v_current_date, v_type
+    FROM loan_contracts lc
+    LEFT JOIN credit_product_versions v ON v.id = lc.product_version_id
+    WHERE lc.id = p_contract_id;
+
+    v_monthly_rate := v_rate / 12 / 100;
+    v_annuity := fn_calculate_annuity(v_amount, v_rate, v_term);
+    v_balance := v_amount;
+
+    FOR i IN 1..v_term LOOP
+        v_current_date := v_current_date + INTERVAL '1 month';
+
+        v_interest_part := ROUND(v_balance * v_monthly_rate)::BIGINT;
+
+        v_principal_part := CASE v_type
+            WHEN 'differentiated' THEN ROUND(v_amount::NUMERIC / v_term)::BIGINT
+            WHEN 'bullet' THEN 0
+            ELSE v_annuity - v_interest_part
+        END;
+
+        IF i = v_term OR v_principal_part > v_balance THEN
+            v_principal_part := v_balance;
+        END IF;
+
+        v_payment := v_principal_part + v_interest_part;
+        v_balance := v_balance - v_principal_part;
+
+        INSERT INTO repayment_schedule (
+            contract_id, payment_date, payment_amount,
+            principal_amount, interest_amount, remaining_balance, is_paid
+        ) VALUES (
+            p_contract_id, v_current_date, v_payment,
+            v_principal_part, v_interest_part, v_balance, FALSE
+        );
+    END LOOP;
+END;
+$$;
+
+-- Плановые проценты считаются по тем же строкам графика, что отдает
+-- GetSchedule.
+DROP MATERIALIZED VIEW IF EXISTS mv_dashboard_cache;
+
+CREATE MATERIALIZED VIEW
+    mv_dashboard_cache AS
+WITH
+    issued AS (
+        SELECT
+            COALESCE(SUM(amount), 0) AS total
+        FROM
+            loan_contracts
+        WHERE
+            status IN ('active', 'closed', 'defaulted')
+    ),
+    repaid AS (
+        SELECT
+            COALESCE(SUM(amount), 0) AS total
+        FROM
+            operations
+        WHERE
+            operation_type IN ('scheduled_payment', 'early_repayment')
+    ),
+    interest AS (
+        SELECT
+            COALESCE(SUM(rs.interest_amount), 0) AS total
+        FROM
+            repayment_schedule rs
+            JOIN loan_contracts lc ON rs.contract_id = lc.id
+        WHERE
+            lc.status IN ('active', 'closed', 'defaulted')
+    ),
+    dist AS (
+        SELECT
+            json_agg(row_to_json(t)) AS data
+        FROM
+            (
+                SELECT
+                    cp.name AS "label",
+                    COUNT(lc.id) AS "value"
+                FROM
+                    loan_contracts lc
+                    JOIN credit_products cp ON lc.product_id = cp.id
+                GROUP BY
+                    cp.name
+            ) t
+    )
+SELECT
+    1 AS id,
+    json_build_object(
+        'totalIssued',
+        (
+            SELECT
+                total
+            FROM
+                issued
+        ),
+        'totalRepaid',
+        (
+            SELECT
+                total
+            FROM
+                repaid
+        ),
+        'totalInterest',
+        (
+            SELECT
+                total
+            FROM
+                interest
+        ),
+        'distribution',
+        COALESCE(
+            (
+                SELECT
+                    data
+                FROM
+                    dist
+            ),
+            '[]'::json
+        )
+    ) AS stats_json;
+
+CREATE UNIQUE INDEX idx_mv_dashboard_cache ON mv_dashboard_cache (id);
@@ -0,0 +1,37 @@
+DROP PROCEDURE IF EXISTS sp_early_repayment (BIGINT, BIGINT, BIGINT, VARCHAR, BIGINT);
+
+DROP PROCEDURE IF EXISTS sp_rebuild_schedule (BIGINT, BIGINT, INT);
+
+-- EarlyRepayement
+CREATE
+OR REPLACE PROCEDURE sp_early_repayment (
+    p_contract_id BIGINT,
+    p_user_id BIGINT,
+    INOUT p_paid_amount BIGINT DEFAULT 0
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_balance BIGINT;
+    v_status VARCHAR;
+    v_owner_id BIGINT;
+BEGIN
+    SELECT lc.balance, lc.status, cl.user_id 
+    INTO v_balance, v_status, v_owner_id
+    FROM loan_contracts lc JOIN clients cl ON lc.client_id = cl.id
+    WHERE lc.id = p_contract_id;
+
+    IF v_balance IS NULL OR v_owner_id IS DISTINCT FROM p_user_id THEN
+        RAISE EXCEPTION 'Договор не найден' USING ERRCODE = 'RB013';
+    END IF;
+
+    IF v_balance <= 0 THEN RAISE EXCEPTION 'Нет долга' USING ERRCODE = 'RB003'; END IF;
+
+    p_paid_amount := v_balance;
+
+    UPDATE loan_contracts SET balance = 0, status = 'closed', closed_at = NOW() WHERE id = p_contract_id;
+    DELETE FROM repayment_schedule WHERE contract_id = p_contract_id AND is_paid = FALSE;
+    
+    INSERT INTO operations (contract_id, operation_type, amount, description, operation_date)
+    VALUES (p_contract_id, 'early_repayment', v_balance, 'Полное погашение', NOW());
+END;
+$$;
+
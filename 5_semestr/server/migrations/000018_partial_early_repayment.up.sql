@@ -0,0 +1,181 @@
+-- Частичное досрочное погашение. Клиент вносит часть долга и выбирает
+-- способ пересчета неоплаченной части графика:
+--
+--   term    - платеж прежний, срок сокращается;
+--   payment - срок прежний, платеж уменьшается.
+--
+-- Без суммы (или с суммой, равной остатку) договор гасится полностью, как
+-- раньше.
+
+DROP PROCEDURE IF EXISTS sp_early_repayment (BIGINT, BIGINT, BIGINT);
+
+-- Пересчитывает неоплаченную часть графика: p_months платежей от остатка
+-- p_balance в даты прежних неоплаченных платежей.
+CREATE
+OR REPLACE PROCEDURE sp_rebuild_schedule (
+    p_contract_id BIGINT,
+    p_balance BIGINT,
+    p_months INT
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_rate NUMERIC;
+    v_type schedule_type;
+    v_dates DATE[];
+    v_monthly_rate NUMERIC;
+    v_annuity BIGINT;
+    v_step BIGINT;
+    v_balance BIGINT;
+    v_interest_part BIGINT;
+    v_principal_part BIGINT;
+    i INT;
+BEGIN
+    SELECT lc.interest_rate, COALESCE(v.schedule_type, 'annuity')
+    INTO v_rate, v_type
+    FROM loan_contracts lc
+    LEFT JOIN credit_product_versions v ON v.id = lc.product_version_id
+    WHERE lc.id = p_contract_id;
+
+    SELECT ARRAY_AGG(payment_date ORDER BY payment_date)
+    INTO v_dates
+    FROM repayment_schedule
+    WHERE contract_id = p_contract_id AND is_paid = FALSE;
+
+    DELETE FROM repayment_schedule WHERE contract_id = p_contract_id AND is_paid = FALSE;
+
+    v_monthly_rate := v_rate / 12 / 100;
+    v_annuity := fn_calculate_annuity(p_balance, v_rate, p_months);
+    v_step := ROUND(p_balance::NUMERIC / p_months)::BIGINT;
+    v_balance := p_balance;
+
+    FOR i IN 1..p_months LOOP
+        v_interest_part := ROUND(v_balance * v_monthly_rate)::BIGINT;
+
+        v_principal_part := CASE v_type
+            WHEN 'differentiated' THEN v_step
+            WHEN 'bullet' THEN 0
+            ELSE v_annuity - v_interest_part
+        END;
+
+        IF i = p_months OR v_principal_part > v_balance THEN
+            v_principal_part := v_balance;
+        END IF;
+
+        v_balance := v_balance - v_principal_part;
+
+        INSERT INTO repayment_schedule (
+            contract_id, payment_date, payment_amount,
+            principal_amount, interest_amount, remaining_balance, is_paid
+        ) VALUES (
+            p_contract_id, v_dates[i], v_principal_part + v_interest_part,
+            v_principal_part, v_interest_part, v_balance, FALSE
+        );
+    END LOOP;
+END;
+$$;
+
+-- EarlyRepayement
+-- p_amount NULL - погашение всего остатка.
+CREATE
+OR REPLACE PROCEDURE sp_early_repayment (
+    p_contract_id BIGINT,
+    p_user_id BIGINT,
+    p_amount BIGINT,
+    p_mode VARCHAR,
+    INOUT p_paid_amount BIGINT DEFAULT 0
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_balance BIGINT;
+    v_status VARCHAR;
+    v_owner_id BIGINT;
+    v_rate NUMERIC;
+    v_type schedule_type;
+    v_left INT;
+    v_payment BIGINT;
+    v_step BIGINT;
+    v_months INT;
+    v_new_balance BIGINT;
+BEGIN
+    SELECT lc.balance, lc.status, cl.user_id, lc.interest_rate, COALESCE(v.schedule_type, 'annuity')
+    INTO v_balance, v_status, v_owner_id, v_rate, v_type
+    FROM loan_contracts lc
+    JOIN clients cl ON lc.client_id = cl.id
+    LEFT JOIN credit_product_versions v ON v.id = lc.product_version_id
+    WHERE lc.id = p_contract_id;
+
+    IF v_balance IS NULL OR v_owner_id IS DISTINCT FROM p_user_id THEN
+        RAISE EXCEPTION 'Договор не найден' USING ERRCODE = 'RB013';
+    END IF;
+
+    IF v_balance <= 0 THEN RAISE EXCEPTION 'Нет долга' USING ERRCODE = 'RB003'; END IF;
+
+    IF p_amount IS NULL OR p_amount = v_balance THEN
+        p_paid_amount := v_balance;
+
+        UPDATE loan_contracts SET balance = 0, status = 'closed', closed_at = NOW() WHERE id = p_contract_id;
+        DELETE FROM repayment_schedule WHERE contract_id = p_contract_id AND is_paid = FALSE;
+
+        INSERT INTO operations (contract_id, operation_type, amount, description, operation_date)
+        VALUES (p_contract_id, 'early_repayment', v_balance, 'Полное погашение', NOW());
+        RETURN;
+    END IF;
+
+    IF p_amount <= 0 OR p_amount > v_balance THEN
+        RAISE EXCEPTION 'Сумма досрочного погашения должна быть от 0.01 до %', fn_format_amount(v_balance)
+            USING ERRCODE = 'RB023';
+    END IF;
+
+    IF p_mode IS NULL OR p_mode NOT IN ('term', 'payment') OR (p_mode = 'term' AND v_type = 'bullet') THEN
+        RAISE EXCEPTION 'Способ пересчета графика % недоступен', COALESCE(p_mode, 'NULL')
+            USING ERRCODE = 'RB024';
+    END IF;
+
+    SELECT
+        COUNT(*),
+        (ARRAY_AGG(payment_amount ORDER BY payment_date))[1],
+        (ARRAY_AGG(principal_amount ORDER BY payment_date))[1]
+    INTO v_left, v_payment, v_step
+    FROM repayment_schedule
+    WHERE contract_id = p_contract_id AND is_paid = FALSE;
+
+    IF v_left = 0 THEN RAISE EXCEPTION 'Нет неоплаченных платежей' USING ERRCODE = 'RB003'; END IF;
+
+    v_new_balance := v_balance - p_amount;
+    v_months := v_left;
+
+    -- Сокращение срока: наименьшее число платежей, при котором платеж не
+    -- больше прежнего (для дифференцированного - прежняя доля долга).
+    IF p_mode = 'term' THEN
+        IF v_type = 'differentiated' THEN
+            v_months := LEAST(v_left, CEIL(v_new_balance::NUMERIC / GREATEST(v_step, 1))::INT);
+        ELSE
+            v_months := 1;
+            WHILE v_months < v_left AND fn_calculate_annuity(v_new_balance, v_rate, v_months) > v_payment LOOP
+                v_months := v_months + 1;
+            END LOOP;
+        END IF;
+    END IF;
+
+    CALL sp_rebuild_schedule(p_contract_id, v_new_balance, v_months);
+
+    UPDATE loan_contracts
+    SET
+        balance = v_new_balance,
+        term_months = term_months - (v_left - v_months),
+        end_date = (
+            SELECT MAX(payment_date) FROM repayment_schedule WHERE contract_id = p_contract_id
+        )
+    WHERE id = p_contract_id;
+
+    INSERT INTO operations (contract_id, operation_type, amount, description, operation_date)
+    VALUES (
+        p_contract_id, 'early_repayment', p_amount,
+        CASE p_mode
+            WHEN 'term' THEN 'Частичное погашение, сокращение срока'
+            ELSE 'Частичное погашение, уменьшение платежа'
+        END,
+        NOW()
+    );
+
+    p_paid_amount := p_amount;
+END;
+$$;
@@ -0,0 +1,372 @@
+-- Значение penalty_payment в operation_type остается: PostgreSQL не
+-- удаляет значения перечислений.
+
+DROP PROCEDURE IF EXISTS sp_accrue_penalties (DATE, INT, BIGINT);
+
+DROP PROCEDURE IF EXISTS sp_make_payment (BIGINT, BIGINT, BIGINT, BIGINT, BIGINT);
+
+DROP FUNCTION IF EXISTS fn_get_repayment_schedule (BIGINT);
+
+DROP FUNCTION IF EXISTS fn_get_products (INT, BOOLEAN);
+
+DROP PROCEDURE IF EXISTS sp_create_product (BIGINT, VARCHAR, BIGINT, BIGINT, INT, INT, NUMERIC, VARCHAR, NUMERIC, NUMERIC, INT);
+
+DROP PROCEDURE IF EXISTS sp_update_product (BIGINT, INT, VARCHAR, BIGINT, BIGINT, INT, INT, NUMERIC, VARCHAR, NUMERIC, NUMERIC, INT);
+
+DROP FUNCTION IF EXISTS fn_get_product_versions (INT);
+
+ALTER TABLE repayment_schedule
+DROP COLUMN paid_amount,
+DROP COLUMN penalty_amount,
+DROP COLUMN penalty_paid,
+DROP COLUMN penalty_accrued_on;
+
+ALTER TABLE credit_product_versions
+DROP COLUMN penalty_rate,
+DROP COLUMN penalty_cap;
+
+ALTER TABLE credit_products
+DROP CONSTRAINT chk_product_penalty,
+DROP COLUMN penalty_rate,
+DROP COLUMN penalty_cap;
+
+-- GetProducts
+-- p_product_id NULL - все продукты.
+CREATE
+OR REPLACE FUNCTION fn_get_products (p_product_id INT, p_include_inactive BOOLEAN) RETURNS TABLE (
+    id INT,
+    name VARCHAR,
+    min_amount BIGINT,
+    max_amount BIGINT,
+    min_term_months INT,
+    max_term_months INT,
+    interest_rate NUMERIC,
+    is_active BOOLEAN,
+    current_version INT,
+    schedule_type VARCHAR
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT
+        cp.id,
+        cp.name,
+        cp.min_amount,
+        cp.max_amount,
+        cp.min_term_months,
+        cp.max_term_months,
+        cp.interest_rate,
+        COALESCE(cp.is_active, FALSE),
+        cp.current_version,
+        cp.schedule_type::VARCHAR
+    FROM credit_products cp
+    WHERE (p_product_id IS NULL OR cp.id = p_product_id)
+      AND (p_include_inactive OR cp.is_active)
+    ORDER BY cp.id;
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+-- CreateProduct
+CREATE
+OR REPLACE PROCEDURE sp_create_product (
+    p_actor_id BIGINT,
+    p_name VARCHAR,
+    p_min_amount BIGINT,
+    p_max_amount BIGINT,
+    p_min_term INT,
+    p_max_term INT,
+    p_rate NUMERIC,
+    p_schedule_type VARCHAR,
+    INOUT p_product_id INT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+BEGIN
+    PERFORM set_config('app.user_id', p_actor_id::TEXT, TRUE);
+
+    INSERT INTO credit_products (
+        name, min_amount, max_amount, min_term_months, max_term_months, interest_rate, schedule_type, is_active
+    ) VALUES (
+        p_name, p_min_amount, p_max_amount, p_min_term, p_max_term, p_rate, p_schedule_type::schedule_type, TRUE
+    ) RETURNING id INTO p_product_id;
+
+    INSERT INTO credit_product_versions (
+        product_id, version, min_amount, max_amount, min_term_months, max_term_months, interest_rate,
+        schedule_type, created_by
+    ) VALUES (
+        p_product_id, 1, p_min_amount, p_max_amount, p_min_term, p_max_term, p_rate,
+        p_schedule_type::schedule_type, p_actor_id
+    );
+
+    PERFORM set_config('app.user_id', '', TRUE);
+END;
+$$;
+
+-- UpdateProduct
+-- Новая версия создается только при изменении условий, включая тип
+-- графика; переименование версию не меняет.
+CREATE
+OR REPLACE PROCEDURE sp_update_product (
+    p_actor_id BIGINT,
+    p_product_id INT,
+    p_name VARCHAR,
+    p_min_amount BIGINT,
+    p_max_amount BIGINT,
+    p_min_term INT,
+    p_max_term INT,
+    p_rate NUMERIC,
+    p_schedule_type VARCHAR,
+    INOUT p_version INT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_product credit_products%ROWTYPE;
+BEGIN
+    SELECT * INTO v_product FROM credit_products WHERE id = p_product_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Кредитный продукт % не найден', p_product_id USING ERRCODE = 'RB011';
+    END IF;
+
+    PERFORM set_config('app.user_id', p_actor_id::TEXT, TRUE);
+
+    p_version := v_product.current_version;
+
+    IF (v_product.min_amount, v_product.max_amount, v_product.min_term_months,
+        v_product.max_term_months, v_product.interest_rate, v_product.schedule_type)
+       IS DISTINCT FROM
+       (p_min_amount, p_max_amount, p_min_term, p_max_term, p_rate::NUMERIC(5, 2), p_schedule_type::schedule_type)
+    THEN
+        p_version := p_version + 1;
+
+        INSERT INTO credit_product_versions (
+            product_id, version, min_amount, max_amount, min_term_months, max_term_months, interest_rate,
+            schedule_type, created_by
+        ) VALUES (
+            p_product_id, p_version, p_min_amount, p_max_amount, p_min_term, p_max_term, p_rate,
+            p_schedule_type::schedule_type, p_actor_id
+        );
+    END IF;
+
+    UPDATE credit_products
+    SET name = p_name,
+        min_amount = p_min_amount,
+        max_amount = p_max_amount,
+        min_term_months = p_min_term,
+        max_term_months = p_max_term,
+        interest_rate = p_rate,
+        schedule_type = p_schedule_type::schedule_type,
+        current_version = p_version
+    WHERE id = p_product_id
+      AND (name, current_version) IS DISTINCT FROM (p_name, p_version);
+
+    PERFORM set_config('app.user_id', '', TRUE);
+END;
+$$;
+
+-- GetProductVersions
+CREATE
+OR REPLACE FUNCTION fn_get_product_versions (p_product_id INT) RETURNS TABLE (
+    id INT,
+    version INT,
+    min_amount BIGINT,
+    max_amount BIGINT,
+    min_term_months INT,
+    max_term_months INT,
+    interest_rate NUMERIC,
+    schedule_type VARCHAR,
+    created_at TIMESTAMPTZ,
+    login VARCHAR,
+    first_name VARCHAR,
+    last_name VARCHAR,
+    contracts BIGINT
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT
+        v.id,
+        v.version,
+        v.min_amount,
+        v.max_amount,
+        v.min_term_months,
+        v.max_term_months,
+        v.interest_rate,
+        v.schedule_type::VARCHAR,
+        v.created_at,
+        u.login,
+        e.first_name,
+        e.last_name,
+        (SELECT COUNT(*) FROM loan_contracts lc WHERE lc.product_version_id = v.id)
+    FROM credit_product_versions v
+    LEFT JOIN users u ON v.created_by = u.id
+    LEFT JOIN employees e ON u.id = e.user_id
+    WHERE v.product_id = p_product_id
+    ORDER BY v.version DESC;
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+-- GetSchedule
+CREATE
+OR REPLACE FUNCTION fn_get_repayment_schedule (p_contract_id BIGINT) RETURNS TABLE (
+    id BIGINT,
+    payment_date DATE,
+    payment_amount BIGINT,
+    principal_amount BIGINT,
+    interest_amount BIGINT,
+    remaining_balance BIGINT,
+    is_paid BOOLEAN
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT rs.id, rs.payment_date, rs.payment_amount, rs.principal_amount, 
+           rs.interest_amount, rs.remaining_balance, rs.is_paid
+    FROM repayment_schedule rs
+    WHERE rs.contract_id = p_contract_id
+    ORDER BY rs.payment_date ASC;
+END;
+$$ LANGUAGE plpgsql;
+
+-- MakePayment
+CREATE
+OR REPLACE PROCEDURE sp_make_payment (p_schedule_id BIGINT) LANGUAGE plpgsql AS $$
+DECLARE
+    v_contract_id BIGINT;
+    v_principal_amount NUMERIC;
+    v_payment_amount NUMERIC;
+    v_new_balance NUMERIC;
+    v_is_paid BOOLEAN;
+BEGIN
+    SELECT contract_id, principal_amount, payment_amount, is_paid 
+    INTO v_contract_id, v_principal_amount, v_payment_amount, v_is_paid
+    FROM repayment_schedule 
+    WHERE id = p_schedule_id;
+
+    IF v_contract_id IS NULL THEN
+        RAISE EXCEPTION 'Платеж не найден' USING ERRCODE = 'RB001';
+    END IF;
+
+    IF v_is_paid THEN
+        RAISE EXCEPTION 'Этот платеж уже оплачен' USING ERRCODE = 'RB002';
+    END IF;
+
+    UPDATE repayment_schedule 
+    SET is_paid = TRUE, paid_at = NOW() 
+    WHERE id = p_schedule_id;
+
+    UPDATE loan_contracts 
+    SET balance = balance - v_principal_amount 
+    WHERE id = v_contract_id
+    RETURNING balance INTO v_new_balance;
+
+    INSERT INTO operations (contract_id, operation_type, amount, description)
+    VALUES (v_contract_id, 'scheduled_payment', v_payment_amount, 'Здесь можно добавит способ оплаты');
+
+    IF v_new_balance <= 0 THEN
+        UPDATE loan_contracts 
+        SET status = 'closed', closed_at = NOW(), balance = 0 
+        WHERE id = v_contract_id;
+    END IF;
+END;
+$$;
+
+-- EarlyRepayement
+-- p_amount NULL - погашение всего остатка.
+CREATE
+OR REPLACE PROCEDURE sp_early_repayment (
+    p_contract_id BIGINT,
+    p_user_id BIGINT,
+    p_amount BIGINT,
+    p_mode VARCHAR,
+    INOUT p_paid_amount BIGINT DEFAULT 0
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_balance BIGINT;
+    v_status VARCHAR;
+    v_owner_id BIGINT;
+    v_rate NUMERIC;
+    v_type schedule_type;
+    v_left INT;
+    v_payment BIGINT;
+    v_step BIGINT;
+    v_months INT;
+    v_new_balance BIGINT;
+BEGIN
+    SELECT lc.balance, lc.status, cl.user_id, lc.interest_rate, COALESCE(v.schedule_type, 'annuity')
+    INTO v_balance, v_status, v_owner_id, v_rate, v_type
+    FROM loan_contracts lc
+    JOIN clients cl ON lc.client_id = cl.id
+    LEFT JOIN credit_product_versions v ON v.id = lc.product_version_id
+    WHERE lc.id = p_contract_id;
+
+    IF v_balance IS NULL OR v_owner_id IS DISTINCT FROM p_user_id THEN
+        RAISE EXCEPTION 'Договор не найден' USING ERRCODE = 'RB013';
+    END IF;
+
+    IF v_balance <= 0 THEN RAISE EXCEPTION 'Нет долга' USING ERRCODE = 'RB003'; END IF;
+
+    IF p_amount IS NULL OR p_amount = v_balance THEN
+        p_paid_amount := v_balance;
+
+        UPDATE loan_contracts SET balance = 0, status = 'closed', closed_at = NOW() WHERE id = p_contract_id;
+        DELETE FROM repayment_schedule WHERE contract_id = p_contract_id AND is_paid = FALSE;
+
+        INSERT INTO operations (contract_id, operation_type, amount, description, operation_date)
+        VALUES (p_contract_id, 'early_repayment', v_balance, 'Полное погашение', NOW());
+        RETURN;
+    END IF;
+
+    IF p_amount <= 0 OR p_amount > v_balance THEN
+        RAISE EXCEPTION 'Сумма досрочного погашения должна быть от 0.01 до %', fn_format_amount(v_balance)
+            USING ERRCODE = 'RB023';
+    END IF;
+
+    IF p_mode IS NULL OR p_mode NOT IN ('term', 'payment') OR (p_mode = 'term' AND v_type = 'bullet') THEN
+        RAISE EXCEPTION 'Способ пересчета графика % недоступен', COALESCE(p_mode, 'NULL')
+            USING ERRCODE = 'RB024';
+    END IF;
+
+    SELECT
+        COUNT(*),
+        (ARRAY_AGG(payment_amount ORDER BY payment_date))[1],
+        (ARRAY_AGG(principal_amount ORDER BY payment_date))[1]
+    INTO v_left, v_payment, v_step
+    FROM repayment_schedule
+    WHERE contract_id = p_contract_id AND is_paid = FALSE;
+
+    IF v_left = 0 THEN RAISE EXCEPTION 'Нет неоплаченных платежей' USING ERRCODE = 'RB003'; END IF;
+
+    v_new_balance := v_balance - p_amount;
+    v_months := v_left;
+
+    -- Сокращение срока: наименьшее число платежей, при котором платеж не
+    -- больше прежнего (для дифференцированного - прежняя доля долга).
+    IF p_mode = 'term' THEN
+        IF v_type = 'differentiated' THEN
+            v_months := LEAST(v_left, CEIL(v_new_balance::NUMERIC / GREATEST(v_step, 1))::INT);
+        ELSE
+            v_months := 1;
+            WHILE v_months < v_left AND fn_calculate_annuity(v_new_balance, v_rate, v_months) > v_payment LOOP
+                v_months := v_months + 1;
+            END LOOP;
+        END IF;
+    END IF;
+
+    CALL sp_rebuild_schedule(p_contract_id, v_new_balance, v_months);
+
+    UPDATE loan_contracts
+    SET
+        balance = v_new_balance,
+        term_months = term_months - (v_left - v_months),
+        end_date = (
+            SELECT MAX(payment_date) FROM repayment_schedule WHERE contract_id = p_contract_id
+        )
+    WHERE id = p_contract_id;
+
+    INSERT INTO operations (contract_id, operation_type, amount, description, operation_date)
+    VALUES (
+        p_contract_id, 'early_repayment', p_amount,
+        CASE p_mode
+            WHEN 'term' THEN 'Частичное погашение, сокращение срока'
+            ELSE 'Частичное погашение, уменьшение платежа'
+        END,
+        NOW()
+    );
+
+    p_paid_amount := p_amount;
+END;
+$$;
@@ -0,0 +1,513 @@
+-- Неустойка за просрочку платежей. Условия продукта (и его версии):
+--
+--   penalty_rate - процент от просроченной суммы платежа за каждый день;
+--   penalty_cap  - предел пени по одному платежу в процентах от его суммы,
+--                  0 - без предела.
+--
+-- sp_accrue_penalties раз в день начисляет пени операциями penalty.
+-- Платеж по графику гасит сначала пени, затем проценты, затем основной
+-- долг; частичная оплата копится в paid_amount и penalty_paid.
+
+ALTER TYPE operation_type ADD VALUE IF NOT EXISTS 'penalty_payment';
+
+ALTER TABLE credit_products
+ADD COLUMN penalty_rate NUMERIC(6, 3) NOT NULL DEFAULT 0,
+ADD COLUMN penalty_cap NUMERIC(6, 2) NOT NULL DEFAULT 0,
+ADD CONSTRAINT chk_product_penalty CHECK (
+    penalty_rate >= 0 AND penalty_rate < 100 AND penalty_cap >= 0
+);
+
+ALTER TABLE credit_product_versions
+ADD COLUMN penalty_rate NUMERIC(6, 3) NOT NULL DEFAULT 0,
+ADD COLUMN penalty_cap NUMERIC(6, 2) NOT NULL DEFAULT 0;
+
+ALTER TABLE repayment_schedule
+ADD COLUMN paid_amount BIGINT NOT NULL DEFAULT 0,
+ADD COLUMN penalty_amount BIGINT NOT NULL DEFAULT 0,
+ADD COLUMN penalty_paid BIGINT NOT NULL DEFAULT 0,
+ADD COLUMN penalty_accrued_on DATE;
+
+UPDATE repayment_schedule SET paid_amount = payment_amount WHERE is_paid;
+
+DROP FUNCTION IF EXISTS fn_get_products (INT, BOOLEAN);
+
+DROP PROCEDURE IF EXISTS sp_create_product (BIGINT, VARCHAR, BIGINT, BIGINT, INT, INT, NUMERIC, VARCHAR, INT);
+
+DROP PROCEDURE IF EXISTS sp_update_product (BIGINT, INT, VARCHAR, BIGINT, BIGINT, INT, INT, NUMERIC, VARCHAR, INT);
+
+DROP FUNCTION IF EXISTS fn_get_product_versions (INT);
+
+DROP FUNCTION IF EXISTS fn_get_repayment_schedule (BIGINT);
+
+DROP PROCEDURE IF EXISTS sp_make_payment (BIGINT);
+
+-- GetProducts
+-- p_product_id NULL - все продукты.
+CREATE
+OR REPLACE FUNCTION fn_get_products (p_product_id INT, p_include_inactive BOOLEAN) RETURNS TABLE (
+    id INT,
+    name VARCHAR,
+    min_amount BIGINT,
+    max_amount BIGINT,
+    min_term_months INT,
+    max_term_months INT,
+    interest_rate NUMERIC,
+    is_active BOOLEAN,
+    current_version INT,
+    schedule_type VARCHAR,
+    penalty_rate NUMERIC,
+    penalty_cap NUMERIC
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT
+        cp.id,
+        cp.name,
+        cp.min_amount,
+        cp.max_amount,
+        cp.min_term_months,
+        cp.max_term_months,
+        cp.interest_rate,
+        COALESCE(cp.is_active, FALSE),
+        cp.current_version,
+        cp.schedule_type::VARCHAR,
+        cp.penalty_rate,
+        cp.penalty_cap
+    FROM credit_products cp
+    WHERE (p_product_id IS NULL OR cp.id = p_product_id)
+      AND (p_include_inactive OR cp.is_active)
+    ORDER BY cp.id;
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+-- CreateProduct
+CREATE
+OR REPLACE PROCEDURE sp_create_product (
+    p_actor_id BIGINT,
+    p_name VARCHAR,
+    p_min_amount BIGINT,
+    p_max_amount BIGINT,
+    p_min_term INT,
+    p_max_term INT,
+    p_rate NUMERIC,
+    p_schedule_type VARCHAR,
+    p_penalty_rate NUMERIC,
+    p_penalty_cap NUMERIC,
+    INOUT p_product_id INT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+BEGIN
+    PERFORM set_config('app.user_id', p_actor_id::TEXT, TRUE);
+
+    INSERT INTO credit_products (
+        name, min_amount, max_amount, min_term_months, max_term_months, interest_rate, schedule_type,
+        penalty_rate, penalty_cap, is_active
+    ) VALUES (
+        p_name, p_min_amount, p_max_amount, p_min_term, p_max_term, p_rate, p_schedule_type::schedule_type,
+        p_penalty_rate, p_penalty_cap, TRUE
+    ) RETURNING id INTO p_product_id;
+
+    INSERT INTO credit_product_versions (
+        product_id, version, min_amount, max_amount, min_term_months, max_term_months, interest_rate,
+        schedule_type, penalty_rate, penalty_cap, created_by
+    ) VALUES (
+        p_product_id, 1, p_min_amount, p_max_amount, p_min_term, p_max_term, p_rate,
+        p_schedule_type::schedule_type, p_penalty_rate, p_penalty_cap, p_actor_id
+    );
+
+    PERFORM set_config('app.user_id', '', TRUE);
+END;
+$$;
+
+-- UpdateProduct
+-- Новая версия создается только при изменении условий, включая тип
+-- графика и неустойку; переименование версию не меняет.
+CREATE
+OR REPLACE PROCEDURE sp_update_product (
+    p_actor_id BIGINT,
+    p_product_id INT,
+    p_name VARCHAR,
+    p_min_amount BIGINT,
+    p_max_amount BIGINT,
+    p_min_term INT,
+    p_max_term INT,
+    p_rate NUMERIC,
+    p_schedule_type VARCHAR,
+    p_penalty_rate NUMERIC,
+    p_penalty_cap NUMERIC,
+    INOUT p_version INT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_product credit_products%ROWTYPE;
+BEGIN
+    SELECT * INTO v_product FROM credit_products WHERE id = p_product_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Кредитный продукт % не найден', p_product_id USING ERRCODE = 'RB011';
+    END IF;
+
+    PERFORM set_config('app.user_id', p_actor_id::TEXT, TRUE);
+
+    p_version := v_product.current_version;
+
+    IF (v_product.min_amount, v_product.max_amount, v_product.min_term_months,
+        v_product.max_term_months, v_product.interest_rate, v_product.schedule_type,
+        v_product.penalty_rate, v_product.penalty_cap)
+       IS DISTINCT FROM
+       (p_min_amount, p_max_amount, p_min_term, p_max_term, p_rate::NUMERIC(5, 2), p_schedule_type::schedule_type,
+        p_penalty_rate::NUMERIC(6, 3), p_penalty_cap::NUMERIC(6, 2))
+    THEN
+        p_version := p_version + 1;
+
+        INSERT INTO credit_product_versions (
+            product_id, version, min_amount, max_amount, min_term_months, max_term_months, interest_rate,
+            schedule_type, penalty_rate, penalty_cap, created_by
+        ) VALUES (
+            p_product_id, p_version, p_min_amount, p_max_amount, p_min_term, p_max_term, p_rate,
+            p_schedule_type::schedule_type, p_penalty_rate, p_penalty_cap, p_actor_id
+        );
+    END IF;
+
+    UPDATE credit_products
+    SET name = p_name,
+        min_amount = p_min_amount,
+        max_amount = p_max_amount,
+        min_term_months = p_min_term,
+        max_term_months = p_max_term,
+        interest_rate = p_rate,
+        schedule_type = p_schedule_type::schedule_type,
+        penalty_rate = p_penalty_rate,
+        penalty_cap = p_penalty_cap,
+        current_version = p_version
+    WHERE id = p_product_id
+      AND (name, current_version) IS DISTINCT FROM (p_name, p_version);
+
+    PERFORM set_config('app.user_id', '', TRUE);
+END;
+$$;
+
+-- GetProductVersions
+CREATE
+OR REPLACE FUNCTION fn_get_product_versions (p_product_id INT) RETURNS TABLE (
+    id INT,
+    version INT,
+    min_amount BIGINT,
+    max_amount BIGINT,
+    min_term_months INT,
+    max_term_months INT,
+    interest_rate NUMERIC,
+    schedule_type VARCHAR,
+    penalty_rate NUMERIC,
+    penalty_cap NUMERIC,
+    created_at TIMESTAMPTZ,
+    login VARCHAR,
+    first_name VARCHAR,
+    last_name VARCHAR,
+    contracts BIGINT
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT
+        v.id,
+        v.version,
+        v.min_amount,
+        v.max_amount,
+        v.min_term_months,
+        v.max_term_months,
+        v.interest_rate,
+        v.schedule_type::VARCHAR,
+        v.penalty_rate,
+        v.penalty_cap,
+        v.created_at,
+        u.login,
+        e.first_name,
+        e.last_name,
+        (SELECT COUNT(*) FROM loan_contracts lc WHERE lc.product_version_id = v.id)
+    FROM credit_product_versions v
+    LEFT JOIN users u ON v.created_by = u.id
+    LEFT JOIN employees e ON u.id = e.user_id
+    WHERE v.product_id = p_product_id
+    ORDER BY v.version DESC;
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+-- GetSchedule
+CREATE
+OR REPLACE FUNCTION fn_get_repayment_schedule (p_contract_id BIGINT) RETURNS TABLE (
+    id BIGINT,
+    payment_date DATE,
+    payment_amount BIGINT,
+    principal_amount BIGINT,
+    interest_amount BIGINT,
+    remaining_balance BIGINT,
+    is_paid BOOLEAN,
+    paid_amount BIGINT,
+    penalty_amount BIGINT,
+    penalty_paid BIGINT
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT rs.id, rs.payment_date, rs.payment_amount, rs.principal_amount,
+           rs.interest_amount, rs.remaining_balance, rs.is_paid,
+           rs.paid_amount, rs.penalty_amount, rs.penalty_paid
+    FROM repayment_schedule rs
+    WHERE rs.contract_id = p_contract_id
+    ORDER BY rs.payment_date ASC;
+END;
+$$ LANGUAGE plpgsql;
+
+-- AccruePenalties
+-- Начисляет пени по просроченным платежам на дату p_date. Пропущенные дни
+-- доначисляются, повторный запуск за ту же дату ничего не добавляет.
+CREATE
+OR REPLACE PROCEDURE sp_accrue_penalties (
+    p_date DATE,
+    INOUT p_installments INT DEFAULT 0,
+    INOUT p_amount BIGINT DEFAULT 0
+) LANGUAGE plpgsql AS $$
+DECLARE
+    r RECORD;
+    v_days INT;
+    v_charge BIGINT;
+BEGIN
+    p_installments := 0;
+    p_amount := 0;
+
+    FOR r IN
+        SELECT
+            rs.id, rs.contract_id, rs.payment_date, rs.payment_amount, rs.paid_amount,
+            rs.penalty_amount, rs.penalty_accrued_on, v.penalty_rate, v.penalty_cap
+        FROM repayment_schedule rs
+        JOIN loan_contracts lc ON lc.id = rs.contract_id
+        JOIN credit_product_versions v ON v.id = lc.product_version_id
+        WHERE rs.is_paid = FALSE
+          AND rs.payment_date < p_date
+          AND (rs.penalty_accrued_on IS NULL OR rs.penalty_accrued_on < p_date)
+          AND lc.status IN ('active', 'defaulted')
+          AND v.penalty_rate > 0
+        ORDER BY rs.contract_id, rs.payment_date
+        FOR UPDATE OF rs
+    LOOP
+        v_days := p_date - GREATEST(r.payment_date, COALESCE(r.penalty_accrued_on, r.payment_date));
+        v_charge := ROUND((r.payment_amount - r.paid_amount) * r.penalty_rate / 100)::BIGINT * v_days;
+
+        IF r.penalty_cap > 0 THEN
+            v_charge := LEAST(
+                v_charge,
+                GREATEST(ROUND(r.payment_amount * r.penalty_cap / 100)::BIGINT - r.penalty_amount, 0)
+            );
+        END IF;
+
+        UPDATE repayment_schedule
+        SET penalty_amount = penalty_amount + v_charge, penalty_accrued_on = p_date
+        WHERE id = r.id;
+
+        IF v_charge > 0 THEN
+            INSERT INTO operations (contract_id, operation_type, amount, description, operation_date)
+            VALUES (
+                r.contract_id, 'penalty', v_charge,
+                format('Пени за %s дн. просрочки платежа от %s', v_days, to_char(r.payment_date, 'DD.MM.YYYY')),
+                NOW()
+            );
+
+            p_installments := p_installments + 1;
+            p_amount := p_amount + v_charge;
+        END IF;
+    END LOOP;
+END;
+$$;
+
+-- MakePayment
+-- p_amount NULL - все, что причитается по строке графика. Порядок
+-- погашения: пени, проценты, основной долг.
+CREATE
+OR REPLACE PROCEDURE sp_make_payment (
+    p_schedule_id BIGINT,
+    p_amount BIGINT,
+    INOUT p_penalty BIGINT DEFAULT 0,
+    INOUT p_interest BIGINT DEFAULT 0,
+    INOUT p_principal BIGINT DEFAULT 0
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_row repayment_schedule%ROWTYPE;
+    v_due_penalty BIGINT;
+    v_due_interest BIGINT;
+    v_due BIGINT;
+    v_amount BIGINT;
+    v_new_balance BIGINT;
+BEGIN
+    SELECT * INTO v_row FROM repayment_schedule WHERE id = p_schedule_id FOR UPDATE;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Платеж не найден' USING ERRCODE = 'RB001';
+    END IF;
+
+    IF v_row.is_paid THEN
+        RAISE EXCEPTION 'Этот платеж уже оплачен' USING ERRCODE = 'RB002';
+    END IF;
+
+    v_due_penalty := v_row.penalty_amount - v_row.penalty_paid;
+    v_due_interest := GREATEST(v_row.interest_amount - v_row.paid_amount, 0);
+    v_due := v_due_penalty + v_row.payment_amount - v_row.paid_amount;
+    v_amount := COALESCE(p_amount, v_due);
+
+    IF v_amount <= 0 OR v_amount > v_due THEN
+        RAISE EXCEPTION 'Сумма платежа должна быть от 0.01 до %', fn_format_amount(v_due)
+            USING ERRCODE = 'RB025';
+    END IF;
+
+    p_penalty := LEAST(v_amount, v_due_penalty);
+    p_interest := LEAST(v_amount - p_penalty, v_due_interest);
+    p_principal := v_amount - p_penalty - p_interest;
+
+    UPDATE repayment_schedule
+    SET
+        penalty_paid = penalty_paid + p_penalty,
+        paid_amount = paid_amount + p_interest + p_principal,
+        is_paid = paid_amount + p_interest + p_principal = payment_amount,
+        paid_at = CASE WHEN paid_amount + p_interest + p_principal = payment_amount THEN NOW() END
+    WHERE id = p_schedule_id;
+
+    UPDATE loan_contracts
+    SET balance = balance - p_principal
+    WHERE id = v_row.contract_id
+    RETURNING balance INTO v_new_balance;
+
+    IF p_penalty > 0 THEN
+        INSERT INTO operations (contract_id, operation_type, amount, description)
+        VALUES (v_row.contract_id, 'penalty_payment', p_penalty, 'Оплата пени');
+    END IF;
+
+    IF p_interest + p_principal > 0 THEN
+        INSERT INTO operations (contract_id, operation_type, amount, description)
+        VALUES (
+            v_row.contract_id, 'scheduled_payment', p_interest + p_principal,
+            CASE WHEN v_row.paid_amount + p_interest + p_principal = v_row.payment_amount
+                THEN 'Платеж по графику'
+                ELSE 'Частичный платеж по графику'
+            END
+        );
+    END IF;
+
+    IF v_new_balance <= 0 THEN
+        UPDATE loan_contracts
+        SET status = 'closed', closed_at = NOW(), balance = 0
+        WHERE id = v_row.contract_id;
+    END IF;
+END;
+$$;
+
+-- EarlyRepayement
+-- p_amount NULL - погашение всего остатка. При просрочке недоступно.
+CREATE
+OR REPLACE PROCEDURE sp_early_repayment (
+    p_contract_id BIGINT,
+    p_user_id BIGINT,
+    p_amount BIGINT,
+    p_mode VARCHAR,
+    INOUT p_paid_amount BIGINT DEFAULT 0
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_balance BIGINT;
+    v_status VARCHAR;
+    v_owner_id BIGINT;
+    v_rate NUMERIC;
+    v_type schedule_type;
+    v_left INT;
+    v_payment BIGINT;
+    v_step BIGINT;
+    v_months INT;
+    v_new_balance BIGINT;
+BEGIN
+    SELECT lc.balance, lc.status, cl.user_id, lc.interest_rate, COALESCE(v.schedule_type, 'annuity')
+    INTO v_balance, v_status, v_owner_id, v_rate, v_type
+    FROM loan_contracts lc
+    JOIN clients cl ON lc.client_id = cl.id
+    LEFT JOIN credit_product_versions v ON v.id = lc.product_version_id
+    WHERE lc.id = p_contract_id;
+
+    IF v_balance IS NULL OR v_owner_id IS DISTINCT FROM p_user_id THEN
+        RAISE EXCEPTION 'Договор не найден' USING ERRCODE = 'RB013';
+    END IF;
+
+    IF v_balance <= 0 THEN RAISE EXCEPTION 'Нет долга' USING ERRCODE = 'RB003'; END IF;
+
+    -- Пересчет удаляет неоплаченные строки графика вместе с их пени, поэтому
+    -- сначала нужно закрыть просрочку.
+    IF EXISTS (
+        SELECT 1 FROM repayment_schedule
+        WHERE contract_id = p_contract_id AND is_paid = FALSE
+          AND (payment_date < CURRENT_DATE OR penalty_amount > penalty_paid)
+    ) THEN
+        RAISE EXCEPTION 'По договору % есть просроченные платежи', p_contract_id USING ERRCODE = 'RB026';
+    END IF;
+
+    IF p_amount IS NULL OR p_amount = v_balance THEN
+        p_paid_amount := v_balance;
+
+        UPDATE loan_contracts SET balance = 0, status = 'closed', closed_at = NOW() WHERE id = p_contract_id;
+        DELETE FROM repayment_schedule WHERE contract_id = p_contract_id AND is_paid = FALSE;
+
+        INSERT INTO operations (contract_id, operation_type, amount, description, operation_date)
+        VALUES (p_contract_id, 'early_repayment', v_balance, 'Полное погашение', NOW());
+        RETURN;
+    END IF;
+
+    IF p_amount <= 0 OR p_amount > v_balance THEN
+        RAISE EXCEPTION 'Сумма досрочного погашения должна быть от 0.01 до %', fn_format_amount(v_balance)
+            USING ERRCODE = 'RB023';
+    END IF;
+
+    IF p_mode IS NULL OR p_mode NOT IN ('term', 'payment') OR (p_mode = 'term' AND v_type = 'bullet') THEN
+        RAISE EXCEPTION 'Способ пересчета графика % недоступен', COALESCE(p_mode, 'NULL')
+            USING ERRCODE = 'RB024';
+    END IF;
+
+    SELECT
+        COUNT(*),
+        (ARRAY_AGG(payment_amount ORDER BY payment_date))[1],
+        (ARRAY_AGG(principal_amount ORDER BY payment_date))[1]
+    INTO v_left, v_payment, v_step
+    FROM repayment_schedule
+    WHERE contract_id = p_contract_id AND is_paid = FALSE;
+
+    IF v_left = 0 THEN RAISE EXCEPTION 'Нет неоплаченных платежей' USING ERRCODE = 'RB003'; END IF;
+
+    v_new_balance := v_balance - p_amount;
+    v_months := v_left;
+
+    -- Сокращение срока: наименьшее число платежей, при котором платеж не
+    -- больше прежнего (для дифференцированного - прежняя доля долга).
+    IF p_mode = 'term' THEN
+        IF v_type = 'differentiated' THEN
+            v_months := LEAST(v_left, CEIL(v_new_balance::NUMERIC / GREATEST(v_step, 1))::INT);
+        ELSE
+            v_months := 1;
+            WHILE v_months < v_left AND fn_calculate_annuity(v_new_balance, v_rate, v_months) > v_payment LOOP
+                v_months := v_months + 1;
+            END LOOP;
+        END IF;
+    END IF;
+
+    CALL sp_rebuild_schedule(p_contract_id, v_new_balance, v_months);
+
+    UPDATE loan_contracts
+    SET
+        balance = v_new_balance,
+        term_months = term_months - (v_left - v_months),
+        end_date = (
+            SELECT MAX(payment_date) FROM repayment_schedule WHERE contract_id = p_contract_id
+        )
+    WHERE id = p_contract_id;
+
+    INSERT INTO operations (contract_id, operation_type, amount, description, operation_date)
+    VALUES (
+        p_contract_id, 'early_repayment', p_amount,
+        CASE p_mode
+            WHEN 'term' THEN 'Частичное погашение, сокращение срока'
+            ELSE 'Частичное погашение, уменьшение платежа'
+        END,
+        NOW()
+    );
+
+    p_paid_amount := p_amount;
+END;
+$$;
@@ -0,0 +1,93 @@
+-- Договоры, переведенные в defaulted, остаются в этом статусе: статус
+-- существовал и до учета просрочки.
+
+DROP FUNCTION IF EXISTS fn_process_delinquency (DATE, INT);
+
+DROP FUNCTION IF EXISTS fn_delinquency_bucket (INT);
+
+DROP MATERIALIZED VIEW IF EXISTS mv_dashboard_cache;
+
+CREATE MATERIALIZED VIEW
+    mv_dashboard_cache AS
+WITH
+    issued AS (
+        SELECT
+            COALESCE(SUM(amount), 0) AS total
+        FROM
+            loan_contracts
+        WHERE
+            status IN ('active', 'closed', 'defaulted')
+    ),
+    repaid AS (
+        SELECT
+            COALESCE(SUM(amount), 0) AS total
+        FROM
+            operations
+        WHERE
+            operation_type IN ('scheduled_payment', 'early_repayment')
+    ),
+    interest AS (
+        SELECT
+            COALESCE(SUM(rs.interest_amount), 0) AS total
+        FROM
+            repayment_schedule rs
+            JOIN loan_contracts lc ON rs.contract_id = lc.id
+        WHERE
+            lc.status IN ('active', 'closed', 'defaulted')
+    ),
+    dist AS (
+        SELECT
+            json_agg(row_to_json(t)) AS data
+        FROM
+            (
+                SELECT
+                    cp.name AS "label",
+                    COUNT(lc.id) AS "value"
+                FROM
+                    loan_contracts lc
+                    JOIN credit_products cp ON lc.product_id = cp.id
+                GROUP BY
+                    cp.name
+            ) t
+    )
+SELECT
+    1 AS id,
+    json_build_object(
+        'totalIssued',
+        (
+            SELECT
+                total
+            FROM
+                issued
+        ),
+        'totalRepaid',
+        (
+            SELECT
+                total
+            FROM
+                repaid
+        ),
+        'totalInterest',
+        (
+            SELECT
+                total
+            FROM
+                interest
+        ),
+        'distribution',
+        COALESCE(
+            (
+                SELECT
+                    data
+                FROM
+                    dist
+            ),
+            '[]'::json
+        )
+    ) AS stats_json;
+
+CREATE UNIQUE INDEX idx_mv_dashboard_cache ON mv_dashboard_cache (id);
+
+ALTER TABLE loan_contracts
+DROP COLUMN days_past_due,
+DROP COLUMN delinquency_bucket;
@@ -0,0 +1,224 @@
+-- Учет просрочки. Раз в день fn_process_delinquency считает дни просрочки
+-- действующих договоров по самому раннему неоплаченному платежу графика и
+-- раскладывает их по корзинам 1-30, 31-60, 61-90 и 90+. Договор с
+-- просрочкой от порога переходит в defaulted, а после погашения всех
+-- просроченных платежей возвращается в active.
+
+ALTER TABLE loan_contracts
+ADD COLUMN days_past_due INT NOT NULL DEFAULT 0,
+ADD COLUMN delinquency_bucket VARCHAR(5);
+
+CREATE
+OR REPLACE FUNCTION fn_delinquency_bucket (p_days INT) RETURNS VARCHAR AS $$
+BEGIN
+    RETURN CASE
+        WHEN p_days <= 0 THEN NULL
+        WHEN p_days <= 30 THEN '1-30'
+        WHEN p_days <= 60 THEN '31-60'
+        WHEN p_days <= 90 THEN '61-90'
+        ELSE '90+'
+    END;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+
+-- ProcessDelinquency
+-- Возвращает договоры, у которых сменилась корзина или статус. Смена
+-- статуса пишется в loan_transitions от имени системы.
+CREATE
+OR REPLACE FUNCTION fn_process_delinquency (p_date DATE, p_default_after INT) RETURNS TABLE (
+    contract_id BIGINT,
+    contract_number VARCHAR,
+    days_past_due INT,
+    from_bucket VARCHAR,
+    to_bucket VARCHAR,
+    from_status VARCHAR,
+    to_status VARCHAR
+) AS $$
+DECLARE
+    r RECORD;
+    v_bucket VARCHAR;
+    v_status VARCHAR;
+BEGIN
+    IF p_default_after IS NULL OR p_default_after <= 0 THEN
+        RAISE EXCEPTION 'Порог перевода в defaulted должен быть положительным';
+    END IF;
+
+    FOR r IN
+        SELECT
+            lc.id,
+            lc.contract_number,
+            lc.status::VARCHAR AS status,
+            lc.days_past_due,
+            lc.delinquency_bucket,
+            COALESCE(p_date - (
+                SELECT MIN(rs.payment_date)
+                FROM repayment_schedule rs
+                WHERE rs.contract_id = lc.id AND rs.is_paid = FALSE AND rs.payment_date < p_date
+            ), 0) AS days
+        FROM loan_contracts lc
+        WHERE lc.status IN ('active', 'defaulted')
+        ORDER BY lc.id
+        FOR UPDATE OF lc
+    LOOP
+        v_bucket := fn_delinquency_bucket(r.days);
+        v_status := CASE
+            WHEN r.days >= p_default_after THEN 'defaulted'
+            WHEN r.days = 0 THEN 'active'
+            ELSE r.status
+        END;
+
+        IF r.days_past_due = r.days
+            AND r.delinquency_bucket IS NOT DISTINCT FROM v_bucket
+            AND r.status = v_status THEN
+            CONTINUE;
+        END IF;
+
+        UPDATE loan_contracts lc
+        SET
+            days_past_due = r.days,
+            delinquency_bucket = v_bucket,
+            status = v_status::contract_status
+        WHERE lc.id = r.id;
+
+        IF r.status <> v_status THEN
+            INSERT INTO loan_transitions (contract_id, from_status, to_status, reason)
+            VALUES (
+                r.id, r.status::contract_status, v_status::contract_status,
+                CASE v_status
+                    WHEN 'defaulted' THEN format('Просрочка %s дн.', r.days)
+                    ELSE 'Просрочка погашена'
+                END
+            );
+        END IF;
+
+        IF r.delinquency_bucket IS DISTINCT FROM v_bucket OR r.status <> v_status THEN
+            contract_id := r.id;
+            contract_number := r.contract_number;
+            days_past_due := r.days;
+            from_bucket := r.delinquency_bucket;
+            to_bucket := v_bucket;
+            from_status := r.status;
+            to_status := v_status;
+            RETURN NEXT;
+        END IF;
+    END LOOP;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Панель статистики: число договоров в каждой корзине просрочки, включая
+-- пустые.
+DROP MATERIALIZED VIEW IF EXISTS mv_dashboard_cache;
+
+CREATE MATERIALIZED VIEW
+    mv_dashboard_cache AS
+WITH
+    issued AS (
+        SELECT
+            COALESCE(SUM(amount), 0) AS total
+        FROM
+            loan_contracts
+        WHERE
+            status IN ('active', 'closed', 'defaulted')
+    ),
+    repaid AS (
+        SELECT
+            COALESCE(SUM(amount), 0) AS total
+        FROM
+            operations
+        WHERE
+            operation_type IN ('scheduled_payment', 'early_repayment')
+    ),
+    interest AS (
+        SELECT
+            COALESCE(SUM(rs.interest_amount), 0) AS total
+        FROM
+            repayment_schedule rs
+            JOIN loan_contracts lc ON rs.contract_id = lc.id
+        WHERE
+            lc.status IN ('active', 'closed', 'defaulted')
+    ),
+    dist AS (
+        SELECT
+            json_agg(row_to_json(t)) AS data
+        FROM
+            (
+                SELECT
+                    cp.name AS "label",
+                    COUNT(lc.id) AS "value"
+                FROM
+                    loan_contracts lc
+                    JOIN credit_products cp ON lc.product_id = cp.id
+                GROUP BY
+                    cp.name
+            ) t
+    ),
+    delinquency AS (
+        SELECT
+            json_agg(
+                json_build_object('label', b.label, 'value', COALESCE(c.cnt, 0))
+                ORDER BY b.ord
+            ) AS data
+        FROM
+            (
+                VALUES
+                    (1, '1-30'),
+                    (2, '31-60'),
+                    (3, '61-90'),
+                    (4, '90+')
+            ) b (ord, label)
+            LEFT JOIN (
+                SELECT
+                    delinquency_bucket,
+                    COUNT(*) AS cnt
+                FROM
+                    loan_contracts
+                WHERE
+                    status IN ('active', 'defaulted')
+                GROUP BY
+                    delinquency_bucket
+            ) c ON c.delinquency_bucket = b.label
+    )
+SELECT
+    1 AS id,
+    json_build_object(
+        'totalIssued',
+        (
+            SELECT
+                total
+            FROM
+                issued
+        ),
+        'totalRepaid',
+        (
+            SELECT
+                total
+            FROM
+                repaid
+        ),
+        'totalInterest',
+        (
+            SELECT
+                total
+            FROM
+                interest
+        ),
+        'distribution',
+        COALESCE(
+            (
+                SELECT
+                    data
+                FROM
+                    dist
+            ),
+            '[]'::json
+        ),
+        'delinquency',
+        (
+            SELECT
+                data
+            FROM
+                delinquency
+        )
+    ) AS stats_json;
+
+CREATE UNIQUE INDEX idx_mv_dashboard_cache ON mv_dashboard_cache (id);
@@ -0,0 +1,3 @@
+DROP FUNCTION IF EXISTS fn_claim_payment_reminders (DATE, INT[], INT);
+
+DROP TABLE IF EXISTS payment_reminders;
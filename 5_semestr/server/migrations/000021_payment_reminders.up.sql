@@ -0,0 +1,93 @@
+-- Напоминания о платежах. Клиенту пишем за несколько дней до даты платежа
+-- (дни задаются в конфигурации сервера) и в первые дни после просрочки.
+-- Каждое отправленное напоминание записывается в payment_reminders, и
+-- уникальный ключ не дает отправить его повторно.
+
+CREATE TABLE
+    payment_reminders (
+        id BIGSERIAL PRIMARY KEY,
+        schedule_id BIGINT NOT NULL REFERENCES repayment_schedule (id) ON DELETE CASCADE,
+        kind VARCHAR(10) NOT NULL CHECK (kind IN ('upcoming', 'overdue')),
+        days_before INT NOT NULL DEFAULT 0,
+        sent_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        CONSTRAINT uq_payment_reminder UNIQUE (schedule_id, kind, days_before)
+    );
+
+-- ClaimPaymentReminders
+-- Отмечает и возвращает напоминания, которые пора отправить на дату
+-- p_date. Для предстоящего платежа берется наименьшее из p_days, не меньшее
+-- числа дней до платежа, поэтому пропущенный запуск догоняется, а каждое
+-- напоминание уходит один раз. О просрочке пишем, если платеж просрочен не
+-- больше p_overdue_window дней: старые долги при первом запуске не
+-- рассылаются.
+CREATE
+OR REPLACE FUNCTION fn_claim_payment_reminders (
+    p_date DATE,
+    p_days INT[],
+    p_overdue_window INT DEFAULT 7
+) RETURNS TABLE (
+    schedule_id BIGINT,
+    kind VARCHAR,
+    days_before INT,
+    payment_date DATE,
+    amount BIGINT,
+    contract_number VARCHAR,
+    email VARCHAR,
+    first_name VARCHAR,
+    last_name VARCHAR
+) AS $$
+BEGIN
+    RETURN QUERY
+    WITH
+        due AS (
+            SELECT
+                rs.id,
+                rs.payment_date,
+                rs.payment_amount - rs.paid_amount + rs.penalty_amount - rs.penalty_paid AS amount,
+                lc.contract_number,
+                cl.email,
+                cl.first_name,
+                cl.last_name,
+                (
+                    SELECT MIN(n.days)
+                    FROM UNNEST(p_days) AS n (days)
+                    WHERE n.days >= rs.payment_date - p_date
+                ) AS days_ahead
+            FROM repayment_schedule rs
+            JOIN loan_contracts lc ON lc.id = rs.contract_id
+            JOIN clients cl ON cl.id = lc.client_id
+            WHERE rs.is_paid = FALSE
+              AND lc.status IN ('active', 'defaulted')
+              AND NULLIF(btrim(cl.email), '') IS NOT NULL
+              AND rs.payment_date >= p_date - p_overdue_window
+        ),
+        candidates AS (
+            SELECT
+                d.*,
+                (CASE WHEN d.payment_date < p_date THEN 'overdue' ELSE 'upcoming' END)::VARCHAR AS reminder_kind,
+                CASE WHEN d.payment_date < p_date THEN 0 ELSE d.days_ahead END AS reminder_days
+            FROM due d
+            WHERE d.payment_date < p_date OR d.days_ahead IS NOT NULL
+        ),
+        claimed AS (
+            INSERT INTO payment_reminders AS pr (schedule_id, kind, days_before)
+            SELECT c.id, c.reminder_kind, c.reminder_days
+            FROM candidates c
+            ON CONFLICT ON CONSTRAINT uq_payment_reminder DO NOTHING
+            RETURNING pr.schedule_id, pr.kind, pr.days_before
+        )
+    SELECT
+        c.id,
+        c.reminder_kind,
+        c.reminder_days,
+        c.payment_date,
+        c.amount,
+        c.contract_number,
+        c.email,
+        c.first_name,
+        c.last_name
+    FROM claimed cl
+    JOIN candidates c ON c.id = cl.schedule_id
+    ORDER BY c.payment_date, c.id;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,11 @@
+DROP PROCEDURE IF EXISTS sp_mail_failed (BIGINT, TEXT);
+
+DROP PROCEDURE IF EXISTS sp_mail_retry (BIGINT, TIMESTAMPTZ, TEXT);
+
+DROP PROCEDURE IF EXISTS sp_mail_sent (BIGINT);
+
+DROP FUNCTION IF EXISTS fn_claim_mail (TIMESTAMPTZ, INTERVAL, INT);
+
+DROP PROCEDURE IF EXISTS sp_enqueue_mail (VARCHAR, VARCHAR, TEXT, TEXT, TEXT, BIGINT);
+
+DROP TABLE IF EXISTS mail_outbox;
@@ -0,0 +1,109 @@
+-- Очередь исходящих писем (outbox). Письмо записывается в той же
+-- транзакции, что и действие, по которому оно отправляется, а воркер
+-- сервера забирает готовые к отправке письма, отправляет их и при ошибке
+-- откладывает следующую попытку с растущей задержкой.
+
+CREATE TABLE
+    mail_outbox (
+        id BIGSERIAL PRIMARY KEY,
+        kind VARCHAR(50) NOT NULL,
+        recipient VARCHAR(255) NOT NULL,
+        subject TEXT NOT NULL,
+        body_text TEXT NOT NULL,
+        body_html TEXT NOT NULL,
+        status VARCHAR(10) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'failed')),
+        attempts INT NOT NULL DEFAULT 0,
+        next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        last_error TEXT,
+        created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        sent_at TIMESTAMPTZ
+    );
+
+CREATE INDEX idx_mail_outbox_pending ON mail_outbox (next_attempt_at)
+WHERE
+    status = 'pending';
+
+-- EnqueueMail
+CREATE
+OR REPLACE PROCEDURE sp_enqueue_mail (
+    p_kind VARCHAR,
+    p_recipient VARCHAR,
+    p_subject TEXT,
+    p_text TEXT,
+    p_html TEXT,
+    INOUT p_id BIGINT DEFAULT NULL
+) LANGUAGE plpgsql AS $$
+BEGIN
+    INSERT INTO mail_outbox (kind, recipient, subject, body_text, body_html)
+    VALUES (p_kind, p_recipient, p_subject, p_text, p_html)
+    RETURNING id INTO p_id;
+END;
+$$;
+
+-- ClaimMail
+-- Забирает до p_limit писем, которые пора отправить, увеличивает счетчик
+-- попыток и откладывает их на p_lease: если воркер упадет посреди
+-- отправки, письмо вернется в очередь по истечении этого срока.
+CREATE
+OR REPLACE FUNCTION fn_claim_mail (p_now TIMESTAMPTZ, p_lease INTERVAL, p_limit INT) RETURNS TABLE (
+    id BIGINT,
+    kind VARCHAR,
+    recipient VARCHAR,
+    subject TEXT,
+    body_text TEXT,
+    body_html TEXT,
+    attempts INT,
+    created_at TIMESTAMPTZ
+) AS $$
+BEGIN
+    RETURN QUERY
+    WITH
+        claimed AS (
+            UPDATE mail_outbox m
+            SET
+                attempts = m.attempts + 1,
+                next_attempt_at = p_now + p_lease
+            WHERE m.id IN (
+                SELECT o.id
+                FROM mail_outbox o
+                WHERE o.status = 'pending' AND o.next_attempt_at <= p_now
+                ORDER BY o.next_attempt_at, o.id
+                LIMIT p_limit
+                FOR UPDATE SKIP LOCKED
+            )
+            RETURNING m.id, m.kind, m.recipient, m.subject, m.body_text, m.body_html, m.attempts, m.created_at
+        )
+    SELECT c.* FROM claimed c ORDER BY c.id;
+END;
+$$ LANGUAGE plpgsql;
+
+-- MailSent
+CREATE
+OR REPLACE PROCEDURE sp_mail_sent (p_id BIGINT) LANGUAGE plpgsql AS $$
+BEGIN
+    UPDATE mail_outbox
+    SET status = 'sent', sent_at = NOW(), last_error = NULL
+    WHERE id = p_id;
+END;
+$$;
+
+-- MailRetry
+CREATE
+OR REPLACE PROCEDURE sp_mail_retry (p_id BIGINT, p_at TIMESTAMPTZ, p_error TEXT) LANGUAGE plpgsql AS $$
+BEGIN
+    UPDATE mail_outbox
+    SET next_attempt_at = p_at, last_error = p_error
+    WHERE id = p_id;
+END;
+$$;
+
+-- MailFailed
+-- Попытки исчерпаны, письмо больше не отправляется.
+CREATE
+OR REPLACE PROCEDURE sp_mail_failed (p_id BIGINT, p_error TEXT) LANGUAGE plpgsql AS $$
+BEGIN
+    UPDATE mail_outbox
+    SET status = 'failed', last_error = p_error
+    WHERE id = p_id;
+END;
+$$;
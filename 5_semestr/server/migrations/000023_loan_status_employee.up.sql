@@ -0,0 +1,64 @@
+-- Сотрудник, одобривший или выдавший кредит, передается в
+-- sp_change_loan_status явно, так же как в sp_issue_loan, а не ищется
+-- повторно по учетной записи.
+
+DROP PROCEDURE IF EXISTS sp_change_loan_status (BIGINT, BIGINT, VARCHAR, TEXT);
+
+-- ChangeLoanStatus
+-- p_actor_id - пользователь, выполняющий переход, p_employee_id - его
+-- сотрудник, которого сервер уже определил по сессии. При одобрении он
+-- записывается как одобривший, при активации - как выдавший.
+CREATE
+OR REPLACE PROCEDURE sp_change_loan_status (
+    p_actor_id BIGINT,
+    p_employee_id INT,
+    p_contract_id BIGINT,
+    p_status VARCHAR,
+    p_reason TEXT
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_contract loan_contracts%ROWTYPE;
+BEGIN
+    SELECT * INTO v_contract FROM loan_contracts WHERE id = p_contract_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Договор % не найден', p_contract_id USING ERRCODE = 'RB013';
+    END IF;
+
+    IF (v_contract.status::TEXT, p_status) NOT IN (
+        ('draft', 'review'),
+        ('review', 'approved'),
+        ('review', 'rejected'),
+        ('approved', 'signed'),
+        ('signed', 'active')
+    ) THEN
+        RAISE EXCEPTION 'Договор в статусе % нельзя перевести в статус %', v_contract.status, p_status
+            USING ERRCODE = 'RB020';
+    END IF;
+
+    IF p_status = 'rejected' AND NULLIF(btrim(p_reason), '') IS NULL THEN
+        RAISE EXCEPTION 'Укажите причину отказа' USING ERRCODE = 'RB021';
+    END IF;
+
+    IF p_status = 'approved' THEN
+        UPDATE loan_contracts SET approved_by_employee_id = p_employee_id WHERE id = p_contract_id;
+    END IF;
+
+    IF p_status = 'active' THEN
+        UPDATE loan_contracts
+        SET balance = amount,
+            start_date = CURRENT_DATE,
+            end_date = CURRENT_DATE + (term_months || ' months')::INTERVAL
+        WHERE id = p_contract_id;
+
+        CALL sp_build_schedule(p_contract_id);
+
+        INSERT INTO operations (contract_id, employee_id, operation_type, amount, description)
+        VALUES (p_contract_id, p_employee_id, 'issue', v_contract.amount, 'Выдача');
+    END IF;
+
+    UPDATE loan_contracts SET status = p_status::contract_status WHERE id = p_contract_id;
+
+    INSERT INTO loan_transitions (contract_id, from_status, to_status, reason, user_id)
+    VALUES (p_contract_id, v_contract.status, p_status::contract_status, NULLIF(btrim(p_reason), ''), p_actor_id);
+END;
+$$;
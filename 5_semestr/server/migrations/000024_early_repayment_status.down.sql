@@ -0,0 +1,116 @@
+-- EarlyRepayement
+-- p_amount NULL - погашение всего остатка. При просрочке недоступно.
+CREATE
+OR REPLACE PROCEDURE sp_early_repayment (
+    p_contract_id BIGINT,
+    p_user_id BIGINT,
+    p_amount BIGINT,
+    p_mode VARCHAR,
+    INOUT p_paid_amount BIGINT DEFAULT 0
+) LANGUAGE plpgsql AS $$
+DECLARE
+    v_balance BIGINT;
+    v_status VARCHAR;
+    v_owner_id BIGINT;
+    v_rate NUMERIC;
+    v_type schedule_type;
+    v_left INT;
+    v_payment BIGINT;
+    v_step BIGINT;
+    v_months INT;
+    v_new_balance BIGINT;
+BEGIN
+    SELECT lc.balance, lc.status, cl.user_id, lc.interest_rate, COALESCE(v.schedule_type, 'annuity')
+    INTO v_balance, v_status, v_owner_id, v_rate, v_type
+    FROM loan_contracts lc
+    JOIN clients cl ON lc.client_id = cl.id
+    LEFT JOIN credit_product_versions v ON v.id = lc.product_version_id
+    WHERE lc.id = p_contract_id;
+
+    IF v_balance IS NULL OR v_owner_id IS DISTINCT FROM p_user_id THEN
+        RAISE EXCEPTION 'Договор не найден' USING ERRCODE = 'RB013';
+    END IF;
+
+    IF v_balance <= 0 THEN RAISE EXCEPTION 'Нет долга' USING ERRCODE = 'RB003'; END IF;
+
+    -- Пересчет удаляет неоплаченные строки графика вместе с их пени, поэтому
+    -- сначала нужно закрыть просрочку.
+    IF EXISTS (
+        SELECT 1 FROM repayment_schedule
+        WHERE contract_id = p_contract_id AND is_paid = FALSE
+          AND (payment_date < CURRENT_DATE OR penalty_amount > penalty_paid)
+    ) THEN
+        RAISE EXCEPTION 'По договору % есть просроченные платежи', p_contract_id USING ERRCODE = 'RB026';
+    END IF;
+
+    IF p_amount IS NULL OR p_amount = v_balance THEN
+        p_paid_amount := v_balance;
+
+        UPDATE loan_contracts SET balance = 0, status = 'closed', closed_at = NOW() WHERE id = p_contract_id;
+        DELETE FROM repayment_schedule WHERE contract_id = p_contract_id AND is_paid = FALSE;
+
+        INSERT INTO operations (contract_id, operation_type, amount, description, operation_date)
+        VALUES (p_contract_id, 'early_repayment', v_balance, 'Полное погашение', NOW());
+        RETURN;
+    END IF;
+
+    IF p_amount <= 0 OR p_amount > v_balance THEN
+        RAISE EXCEPTION 'Сумма досрочного погашения должна быть от 0.01 до %', fn_format_amount(v_balance)
+            USING ERRCODE = 'RB023';
+    END IF;
+
+    IF p_mode IS NULL OR p_mode NOT IN ('term', 'payment') OR (p_mode = 'term' AND v_type = 'bullet') THEN
+        RAISE EXCEPTION 'Способ пересчета графика % недоступен', COALESCE(p_mode, 'NULL')
+            USING ERRCODE = 'RB024';
+    END IF;
+
+    SELECT
+        COUNT(*),
+        (ARRAY_AGG(payment_amount ORDER BY payment_date))[1],
+        (ARRAY_AGG(principal_amount ORDER BY payment_date))[1]
+    INTO v_left, v_payment, v_step
+    FROM repayment_schedule
+    WHERE contract_id = p_contract_id AND is_paid = FALSE;
+
+    IF v_left = 0 THEN RAISE EXCEPTION 'Нет неоплаченных платежей' USING ERRCODE = 'RB003'; END IF;
+
+    v_new_balance := v_balance - p_amount;
+    v_months := v_left;
+
+    -- Сокращение срока: наименьшее число платежей, при котором платеж не
+    -- больше прежнего (для дифференцированного - прежняя доля долга).
+    IF p_mode = 'term' THEN
+        IF v_type = 'differentiated' THEN
+            v_months := LEAST(v_left, CEIL(v_new_balance::NUMERIC / GREATEST(v_step, 1))::INT);
+        ELSE
+            v_months := 1;
+            WHILE v_months < v_left AND fn_calculate_annuity(v_new_balance, v_rate, v_months) > v_payment LOOP
+                v_months := v_months + 1;
+            END LOOP;
+        END IF;
+    END IF;
+
+    CALL sp_rebuild_schedule(p_contract_id, v_new_balance, v_months);
+
+    UPDATE loan_contracts
+    SET
+        balance = v_new_balance,
+        term_months = term_months - (v_left - v_months),
+        end_date = (
+            SELECT MAX(payment_date) FROM repayment_schedule WHERE contract_id = p_contract_id
+        )
+    WHERE id = p_contract_id;
+
+    INSERT INTO operations (contract_id, operation_type, amount, description, operation_date)
+    VALUES (
+        p_contract_id, 'early_repayment', p_amount,
+        CASE p_mode
+            WHEN 'term' THEN 'Частичное погашение, сокращение срока'
+            ELSE 'Частичное погашение, уменьшение платежа'
+        END,
+        NOW()
+    );
+
+    p_paid_amount := p_amount;
+END;
+$$;
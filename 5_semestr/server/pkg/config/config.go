@@ -0,0 +1,360 @@
+// Package config загружает настройки сервера из файла (YAML или TOML) и
+// переменных окружения и проверяет их при старте.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+type Config struct {
+	HTTP     HTTP     `yaml:"http" toml:"http"`
+	Database Database `yaml:"database" toml:"database"`
+	Auth     Auth     `yaml:"auth" toml:"auth"`
+	Mail     Mail     `yaml:"mail" toml:"mail"`
+	Backup   Backup   `yaml:"backup" toml:"backup"`
+	Jobs     Jobs     `yaml:"jobs" toml:"jobs"`
+}
+
+type HTTP struct {
+	Addr         string   `yaml:"addr" toml:"addr"`
+	AllowOrigins []string `yaml:"allowOrigins" toml:"allowOrigins"`
+	// ShutdownTimeout - сколько при остановке ждать текущие запросы и
+	// фоновые воркеры.
+	ShutdownTimeout Duration `yaml:"shutdownTimeout" toml:"shutdownTimeout"`
+}
+
+type Database struct {
+	URL string `yaml:"url" toml:"url"`
+}
+
+type Auth struct {
+	JWTKey           string   `yaml:"jwtKey" toml:"jwtKey"`
+	ActivationTTL    Duration `yaml:"activationTTL" toml:"activationTTL"`
+	PasswordResetTTL Duration `yaml:"passwordResetTTL" toml:"passwordResetTTL"`
+}
+
+// Mail - настройки отправки писем. Transport - smtp (по умолчанию) или
+// maildir: письма складываются файлами в каталог Dir. Для smtp пустой
+// Host отключает отправку писем. Письма идут через очередь в базе: воркер
+// проверяет ее каждые PollInterval, после ошибки повторяет попытку через
+// RetryBackoff, каждый раз вдвое позже, и сдается после MaxAttempts.
+type Mail struct {
+	Transport    string   `yaml:"transport" toml:"transport"`
+	Host         string   `yaml:"host" toml:"host"`
+	Port         string   `yaml:"port" toml:"port"`
+	User         string   `yaml:"user" toml:"user"`
+	Password     string   `yaml:"password" toml:"password"`
+	From         string   `yaml:"from" toml:"from"`
+	AppURL       string   `yaml:"appURL" toml:"appURL"`
+	Dir          string   `yaml:"dir" toml:"dir"`
+	PollInterval Duration `yaml:"pollInterval" toml:"pollInterval"`
+	RetryBackoff Duration `yaml:"retryBackoff" toml:"retryBackoff"`
+	MaxAttempts  int      `yaml:"maxAttempts" toml:"maxAttempts"`
+}
+
+// Транспорты писем.
+const (
+	MailSMTP    = "smtp"
+	MailMaildir = "maildir"
+)
+
+// Enabled сообщает, отправляются ли письма.
+func (m Mail) Enabled() bool {
+	return m.Transport == MailMaildir || m.Host != ""
+}
+
+// Backup - параметры pg_dump и расписания резервного копирования.
+type Backup struct {
+	Dir      string   `yaml:"dir" toml:"dir"`
+	Host     string   `yaml:"host" toml:"host"`
+	User     string   `yaml:"user" toml:"user"`
+	Password string   `yaml:"password" toml:"password"`
+	Database string   `yaml:"database" toml:"database"`
+	Interval Duration `yaml:"interval" toml:"interval"`
+}
+
+// Jobs - ежедневные задачи по договорам (начисление пени, учет просрочки).
+// Interval - период запуска; задачи идемпотентны в пределах дня, поэтому
+// лишний запуск, например после перезапуска сервера, ничего не удваивает.
+// DefaultAfterDays - сколько дней просрочки переводят договор в defaulted.
+// ReminderDays - за сколько дней до платежа клиенту приходит напоминание.
+type Jobs struct {
+	Interval         Duration `yaml:"interval" toml:"interval"`
+	DefaultAfterDays int      `yaml:"defaultAfterDays" toml:"defaultAfterDays"`
+	ReminderDays     []int    `yaml:"reminderDays" toml:"reminderDays"`
+}
+
+// Duration записывается в файле строкой вида "30m" или "72h".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+// Default возвращает настройки для локального запуска.
+func Default() Config {
+	return Config{
+		HTTP: HTTP{
+			Addr:            ":8080",
+			AllowOrigins:    []string{"http://localhost:3010"},
+			ShutdownTimeout: Duration(15 * time.Second),
+		},
+		Auth: Auth{
+			ActivationTTL:    Duration(72 * time.Hour),
+			PasswordResetTTL: Duration(30 * time.Minute),
+		},
+		Mail: Mail{
+			Transport:    MailSMTP,
+			AppURL:       "http://localhost:3010",
+			PollInterval: Duration(10 * time.Second),
+			RetryBackoff: Duration(time.Minute),
+			MaxAttempts:  8,
+		},
+		Backup: Backup{
+			Dir:      "/root/backups",
+			Interval: Duration(24 * time.Hour),
+		},
+		Jobs: Jobs{
+			Interval:         Duration(24 * time.Hour),
+			DefaultAfterDays: 90,
+			ReminderDays:     []int{5, 1},
+		},
+	}
+}
+
+// Load читает файл path (если указан), поверх него применяет переменные
+// окружения и проверяет результат.
+func Load(path string) (Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := cfg.applyEnv(os.LookupEnv); err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".toml":
+		err = toml.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("config %s: unsupported format, expected .yaml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parse config %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv переопределяет значения из окружения. Имена переменных
+// совпадают с теми, что уже задаются в docker-compose.
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	str := func(name string, dst *string) {
+		if v, ok := lookup(name); ok {
+			*dst = v
+		}
+	}
+	dur := func(name string, dst *Duration) error {
+		if v, ok := lookup(name); ok {
+			if err := dst.UnmarshalText([]byte(v)); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		return nil
+	}
+	num := func(name string, dst *int) error {
+		if v, ok := lookup(name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*dst = n
+		}
+		return nil
+	}
+	nums := func(name string, dst *[]int) error {
+		if v, ok := lookup(name); ok {
+			*dst = nil
+			for _, p := range splitList(v) {
+				n, err := strconv.Atoi(p)
+				if err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+				*dst = append(*dst, n)
+			}
+		}
+		return nil
+	}
+
+	str("HTTP_ADDR", &c.HTTP.Addr)
+	if v, ok := lookup("CORS_ORIGINS"); ok {
+		c.HTTP.AllowOrigins = splitList(v)
+	}
+
+	str("DATABASE_URL", &c.Database.URL)
+
+	str("JWTKey", &c.Auth.JWTKey)
+
+	str("MAIL_TRANSPORT", &c.Mail.Transport)
+	str("MAIL_DIR", &c.Mail.Dir)
+	str("SMTP_HOST", &c.Mail.Host)
+	str("SMTP_PORT", &c.Mail.Port)
+	str("SMTP_USER", &c.Mail.User)
+	str("SMTP_PASS", &c.Mail.Password)
+	str("SMTP_FROM", &c.Mail.From)
+	str("APP_URL", &c.Mail.AppURL)
+
+	str("BACKUP_DIR", &c.Backup.Dir)
+	str("DB_HOST", &c.Backup.Host)
+	str("POSTGRES_USER", &c.Backup.User)
+	str("POSTGRES_PASSWORD", &c.Backup.Password)
+	str("POSTGRES_DB", &c.Backup.Database)
+
+	return errors.Join(
+		dur("SHUTDOWN_TIMEOUT", &c.HTTP.ShutdownTimeout),
+		dur("ACTIVATION_TTL", &c.Auth.ActivationTTL),
+		dur("PASSWORD_RESET_TTL", &c.Auth.PasswordResetTTL),
+		dur("BACKUP_INTERVAL", &c.Backup.Interval),
+		dur("JOBS_INTERVAL", &c.Jobs.Interval),
+		dur("MAIL_POLL_INTERVAL", &c.Mail.PollInterval),
+		dur("MAIL_RETRY_BACKOFF", &c.Mail.RetryBackoff),
+		num("MAIL_MAX_ATTEMPTS", &c.Mail.MaxAttempts),
+		num("JOBS_DEFAULT_AFTER_DAYS", &c.Jobs.DefaultAfterDays),
+		nums("JOBS_REMINDER_DAYS", &c.Jobs.ReminderDays),
+	)
+}
+
+// Validate собирает все ошибки конфигурации сразу, чтобы их можно было
+// исправить за один запуск.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.HTTP.Addr == "" {
+		errs = append(errs, errors.New("http.addr is required"))
+	}
+	if len(c.HTTP.AllowOrigins) == 0 {
+		errs = append(errs, errors.New("http.allowOrigins must list at least one origin"))
+	}
+	if c.HTTP.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("http.shutdownTimeout must be positive"))
+	}
+
+	if c.Database.URL == "" {
+		errs = append(errs, errors.New("database.url (DATABASE_URL) is required"))
+	}
+
+	if c.Auth.JWTKey == "" {
+		errs = append(errs, errors.New("auth.jwtKey (JWTKey) is required"))
+	}
+	if c.Auth.ActivationTTL <= 0 {
+		errs = append(errs, errors.New("auth.activationTTL must be positive"))
+	}
+	if c.Auth.PasswordResetTTL <= 0 {
+		errs = append(errs, errors.New("auth.passwordResetTTL must be positive"))
+	}
+
+	switch c.Mail.Transport {
+	case MailSMTP:
+	case MailMaildir:
+		if c.Mail.Dir == "" {
+			errs = append(errs, errors.New("mail.dir is required for the maildir transport"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("mail.transport %q must be smtp or maildir", c.Mail.Transport))
+	}
+	if c.Mail.Transport == MailSMTP && c.Mail.Host != "" && c.Mail.Port == "" {
+		errs = append(errs, errors.New("mail.port is required when mail.host is set"))
+	}
+	if c.Mail.Enabled() {
+		if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+			errs = append(errs, fmt.Errorf("mail.from %q must be an email address when mail is enabled", c.Mail.From))
+		}
+	}
+	if u, err := url.Parse(c.Mail.AppURL); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, fmt.Errorf("mail.appURL %q must be an absolute URL", c.Mail.AppURL))
+	}
+	if c.Mail.PollInterval <= 0 {
+		errs = append(errs, errors.New("mail.pollInterval must be positive"))
+	}
+	if c.Mail.RetryBackoff <= 0 {
+		errs = append(errs, errors.New("mail.retryBackoff must be positive"))
+	}
+	if c.Mail.MaxAttempts <= 0 {
+		errs = append(errs, errors.New("mail.maxAttempts must be positive"))
+	}
+
+	if c.Backup.Dir == "" {
+		errs = append(errs, errors.New("backup.dir is required"))
+	}
+	if c.Backup.Interval <= 0 {
+		errs = append(errs, errors.New("backup.interval must be positive"))
+	}
+
+	if c.Jobs.Interval <= 0 {
+		errs = append(errs, errors.New("jobs.interval must be positive"))
+	}
+	if c.Jobs.DefaultAfterDays <= 0 {
+		errs = append(errs, errors.New("jobs.defaultAfterDays must be positive"))
+	}
+	for _, d := range c.Jobs.ReminderDays {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("jobs.reminderDays: %d must be positive", d))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadFileAndEnv(t *testing.T) {
+	for name, content := range map[string]string{
+		"config.yaml": "http:\n  addr: \":9000\"\nauth:\n  jwtKey: from-file\n  passwordResetTTL: 15m\n",
+		"config.toml": "[http]\naddr = \":9000\"\n[auth]\njwtKey = \"from-file\"\npasswordResetTTL = \"15m\"\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("DATABASE_URL", "postgres://localhost/test")
+			t.Setenv("JWTKey", "from-env")
+			t.Setenv("JOBS_REMINDER_DAYS", "3, 1")
+
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+			if cfg.HTTP.Addr != ":9000" || cfg.Auth.PasswordResetTTL.Std() != 15*time.Minute {
+				t.Errorf("File values not applied: %+v", cfg)
+			}
+			if cfg.Auth.JWTKey != "from-env" {
+				t.Errorf("Env should override file, got %q", cfg.Auth.JWTKey)
+			}
+			if !slices.Equal(cfg.Jobs.ReminderDays, []int{3, 1}) {
+				t.Errorf("Reminder days from env not applied, got %v", cfg.Jobs.ReminderDays)
+			}
+			if cfg.Backup.Interval.Std() != 24*time.Hour {
+				t.Errorf("Defaults should be kept, got %v", cfg.Backup.Interval.Std())
+			}
+		})
+	}
+}
+
+func TestValidateRequiresSecrets(t *testing.T) {
+	cfg := Default()
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected validation error for empty config")
+	}
+	for _, field := range []string{"jwtKey", "database.url"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("Error should mention %s: %v", field, err)
+		}
+	}
+}
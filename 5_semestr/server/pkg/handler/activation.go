@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/apperr"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/password"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+var errInvalidActivationToken = apperr.New(400, "invalid_token", "Ссылка активации недействительна или устарела")
+
+func (h *HandlerDriver) ActivateAccountHandler(c *gin.Context) {
+	var req models.ActivateAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Write(c, apperr.Invalid("Токен и пароль обязательны", err))
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var login string
+
+	// Токен гасится в одной транзакции с установкой пароля: любая ошибка
+	// откатывает погашение, поэтому ссылкой можно воспользоваться повторно.
+	err := h.store.InTx(ctx, func(tx repository.Store) error {
+		userID, err := tx.Users().ConsumeActivation(ctx, password.HashToken(req.Token))
+		if err != nil {
+			return errInvalidActivationToken.Wrap(err)
+		}
+
+		user, err := tx.Users().GetByID(ctx, userID)
+		if err != nil {
+			return errInvalidActivationToken.Wrap(err)
+		}
+		login = user.Login
+
+		if err := savePassword(ctx, tx, userID, login, req.NewPassword); err != nil {
+			return err
+		}
+
+		LogAction(ctx, tx.Audit(), userID, "ACCOUNT_ACTIVATED", "users", userID, map[string]string{
+			"ip": c.ClientIP(),
+		})
+		return nil
+	})
+	if err != nil {
+		apperr.Write(c, err)
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Учетная запись активирована", "login": login})
+}
+
+func (h *HandlerDriver) ResendActivationHandler(c *gin.Context) {
+	clientID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		apperr.Write(c, apperr.BadRequest("Неверный id клиента"))
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	target, err := h.store.Users().GetClientActivation(ctx, clientID)
+	if err != nil {
+		apperr.Write(c, apperr.NotFound("Клиент не найден"))
+		return
+	}
+	if target.Activated {
+		apperr.Write(c, apperr.New(409, "account_already_activated", "Учетная запись уже активирована"))
+		return
+	}
+	if target.Email == nil || *target.Email == "" {
+		apperr.Write(c, apperr.BadRequest("У клиента не указан email"))
+		return
+	}
+
+	token, tokenHash, err := password.GenerateToken()
+	if err != nil {
+		apperr.Write(c, apperr.Internal("Не удалось создать ссылку активации").Wrap(err))
+		return
+	}
+
+	err = h.store.InTx(ctx, func(tx repository.Store) error {
+		if err := tx.Users().CreateActivation(ctx, target.UserID, tokenHash, h.cfg.ActivationTTL.Std()); err != nil {
+			return err
+		}
+
+		LogAction(ctx, tx.Audit(), c.GetInt64("userId"), "ACTIVATION_RESENT", "clients", clientID, map[string]string{
+			"login": target.Login,
+		})
+
+		return h.mailer.SendClientActivationEmail(ctx, tx.Outbox(), *target.Email,
+			fmt.Sprintf("%s %s", target.FirstName, target.LastName), target.Login, token, h.cfg.ActivationTTL.Std())
+	})
+	if err != nil {
+		apperr.Write(c, err)
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Ссылка активации отправлена повторно"})
+}
@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/apperr"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+// clientLockedFields - поля карточки, которые указаны в договорах и не
+// меняются, пока у клиента есть действующие договоры.
+var clientLockedFields = []string{
+	"lastName", "firstName", "middleName",
+	"passportSeries", "passportNumber", "passportIssuedBy",
+	"dateOfBirth",
+}
+
+// clientColumns переводит колонки clients из истории изменений в имена
+// полей API.
+var clientColumns = map[string]string{
+	"first_name":         "firstName",
+	"last_name":          "lastName",
+	"middle_name":        "middleName",
+	"passport_series":    "passportSeries",
+	"passport_number":    "passportNumber",
+	"passport_issued_by": "passportIssuedBy",
+	"date_of_birth":      "dateOfBirth",
+	"address":            "address",
+	"phone":              "phone",
+	"email":              "email",
+	"is_active":          "isActive",
+}
+
+var errClientNotFound = apperr.New(http.StatusNotFound, "client_not_found", "Клиент не найден")
+
+func (h *HandlerDriver) GetClientHandler(c *gin.Context) {
+	profile, ok := h.loadClient(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(200, profile)
+}
+
+func (h *HandlerDriver) UpdateClientHandler(c *gin.Context) {
+	var req models.UpdateClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Write(c, apperr.Invalid("Ошибка валидации данных", err))
+		return
+	}
+
+	current, ok := h.loadClient(c)
+	if !ok {
+		return
+	}
+
+	// Та же проверка есть в sp_update_client; здесь она нужна, чтобы
+	// назвать клиенту конкретные поля.
+	if changed := changedLockedFields(current.Client, req); len(changed) > 0 && current.ActiveContracts > 0 {
+		apperr.Write(c, apperr.New(http.StatusConflict, "client_fields_locked",
+			"ФИО, паспорт и дату рождения нельзя менять при действующих договорах").
+			WithDetails(gin.H{"fields": changed}))
+		return
+	}
+
+	ctx := c.Request.Context()
+	err := h.store.Clients().Update(ctx, repository.ClientUpdate{
+		UpdateClientRequest: req,
+		ClientID:            current.ID,
+		ActorID:             c.GetInt64("userId"),
+	})
+	if err != nil {
+		apperr.Write(c, err)
+		return
+	}
+
+	h.GetClientHandler(c)
+}
+
+func (h *HandlerDriver) SetClientActiveHandler(c *gin.Context) {
+	clientID, ok := clientIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req models.SetClientActiveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Write(c, apperr.Invalid("Поле active обязательно", err))
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID, err := h.store.Clients().SetActive(ctx, c.GetInt64("userId"), clientID, *req.Active)
+	if err != nil {
+		apperr.Write(c, err)
+		return
+	}
+
+	if !*req.Active {
+		// В PostgreSQL sp_set_client_active сам отзывает refresh-токены.
+		if err := h.sessions.RevokeUser(ctx, userID); err != nil {
+			log.Printf("Revoke sessions error: %v", err)
+		}
+	}
+
+	message := "Учетная запись клиента заблокирована"
+	if *req.Active {
+		message = "Учетная запись клиента разблокирована"
+	}
+	c.JSON(200, gin.H{"message": message, "isActive": *req.Active})
+}
+
+func (h *HandlerDriver) GetClientHistoryHandler(c *gin.Context) {
+	profile, ok := h.loadClient(c)
+	if !ok {
+		return
+	}
+
+	history, err := h.store.Clients().History(c.Request.Context(), profile.ID)
+	if err != nil {
+		apperr.Write(c, err)
+		return
+	}
+
+	for _, entry := range history {
+		for i, ch := range entry.Changes {
+			if name, ok := clientColumns[ch.Field]; ok {
+				entry.Changes[i].Field = name
+			}
+		}
+	}
+
+	c.JSON(200, history)
+}
+
+func clientIDParam(c *gin.Context) (int64, bool) {
+	clientID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		apperr.Write(c, apperr.BadRequest("Неверный id клиента"))
+		return 0, false
+	}
+	return clientID, true
+}
+
+// loadClient читает карточку клиента из параметра :id; при ошибке ответ уже
+// отправлен.
+func (h *HandlerDriver) loadClient(c *gin.Context) (models.ClientProfile, bool) {
+	clientID, ok := clientIDParam(c)
+	if !ok {
+		return models.ClientProfile{}, false
+	}
+
+	profile, err := h.store.Clients().Get(c.Request.Context(), clientID)
+	if errors.Is(err, repository.ErrNotFound) {
+		apperr.Write(c, errClientNotFound)
+		return models.ClientProfile{}, false
+	}
+	if err != nil {
+		apperr.Write(c, err)
+		return models.ClientProfile{}, false
+	}
+
+	profile.LockedFields = []string{}
+	if profile.ActiveContracts > 0 {
+		profile.LockedFields = clientLockedFields
+	}
+	return profile, true
+}
+
+// changedLockedFields перечисляет закрепленные поля, которые отличаются в
+// запросе от текущей карточки.
+func changedLockedFields(cur models.Client, req models.UpdateClientRequest) []string {
+	values := map[string][2]string{
+		"lastName":         {cur.LastName, req.LastName},
+		"firstName":        {cur.FirstName, req.FirstName},
+		"middleName":       {deref(cur.MiddleName), req.MiddleName},
+		"passportSeries":   {cur.PassportSeries, req.PassportSeries},
+		"passportNumber":   {cur.PassportNumber, req.PassportNumber},
+		"passportIssuedBy": {deref(cur.PassportIssued), req.PassportIssued},
+		"dateOfBirth":      {cur.DateOfBirth, req.DateOfBirth},
+	}
+
+	var changed []string
+	for _, field := range clientLockedFields {
+		if v := values[field]; v[0] != v[1] {
+			changed = append(changed, field)
+		}
+	}
+	return changed
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
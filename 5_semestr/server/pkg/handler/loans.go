@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/apperr"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/auth"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+var errContractNotFound = apperr.New(http.StatusNotFound, "contract_not_found", "Договор не найден")
+
+// checkContractAccess пускает клиента только к его собственным договорам;
+// чужой договор для него выглядит как несуществующий. Сотрудникам доступны
+// все договоры.
+func (h *HandlerDriver) checkContractAccess(c *gin.Context, contractID int64) error {
+	if c.GetString("role") != auth.RoleClient {
+		return nil
+	}
+
+	err := h.store.Loans().OwnedBy(c.Request.Context(), contractID, c.GetInt64("userId"))
+	if errors.Is(err, repository.ErrNotFound) {
+		return errContractNotFound
+	}
+	return err
+}
+
+var errLoanTermsViolated = apperr.New(http.StatusUnprocessableEntity, "loan_terms_violated", "Условия кредита не соответствуют продукту")
+
+// loanTermViolations сверяет заявку с условиями продукта так же, как
+// sp_issue_loan.
+func loanTermViolations(p models.CreditProduct, amount models.Money, term int) []models.LoanTermViolation {
+	var violations []models.LoanTermViolation
+	add := func(field, rule, limit, format string, args ...any) {
+		violations = append(violations, models.LoanTermViolation{
+			Field: field, Rule: rule, Limit: limit, Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if !p.IsActive {
+		add("productId", "active", "", "Продукт «%s» не принимает новые заявки", p.Name)
+	}
+	if amount < p.MinAmount {
+		limit := p.MinAmount.Format()
+		add("amount", "min", limit, "Сумма ниже минимальной %s для продукта «%s»", limit, p.Name)
+	}
+	if amount > p.MaxAmount {
+		limit := p.MaxAmount.Format()
+		add("amount", "max", limit, "Сумма выше максимальной %s для продукта «%s»", limit, p.Name)
+	}
+	if term < p.MinTermMonths {
+		add("termMonths", "min", strconv.Itoa(p.MinTermMonths), "Срок меньше минимального %d мес. для продукта «%s»", p.MinTermMonths, p.Name)
+	}
+	if term > p.MaxTermMonths {
+		add("termMonths", "max", strconv.Itoa(p.MaxTermMonths), "Срок больше максимального %d мес. для продукта «%s»", p.MaxTermMonths, p.Name)
+	}
+	return violations
+}
+
+// loanTransitionActions - действия аудита для переходов по статусам.
+var loanTransitionActions = map[string]string{
+	models.LoanReview:   "SUBMIT_LOAN",
+	models.LoanApproved: "APPROVE_LOAN",
+	models.LoanRejected: "REJECT_LOAN",
+	models.LoanSigned:   "SIGN_LOAN",
+	models.LoanActive:   "ACTIVATE_LOAN",
+}
+
+// LoanTransitionHandler переводит договор в статус to. Причина в теле
+// запроса необязательна, кроме отказа.
+func (h *HandlerDriver) LoanTransitionHandler(to string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		contractID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			apperr.Write(c, apperr.BadRequest("Неверный id договора"))
+			return
+		}
+
+		var req models.LoanTransitionRequest
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			apperr.Write(c, apperr.Invalid("Ошибка валидации данных", err))
+			return
+		}
+
+		ctx := c.Request.Context()
+		userID := c.GetInt64("userId")
+
+		err = h.store.InTx(ctx, func(tx repository.Store) error {
+			err := tx.Loans().ChangeStatus(ctx, repository.LoanStatusChange{
+				ContractID: contractID,
+				ActorID:    userID,
+				EmployeeID: c.GetInt64("employeeId"),
+				To:         to,
+				Reason:     req.Reason,
+			})
+			if err != nil {
+				return err
+			}
+
+			LogAction(ctx, tx.Audit(), userID, loanTransitionActions[to], "loan_contracts", contractID, map[string]string{
+				"status": to,
+				"reason": req.Reason,
+			})
+			return nil
+		})
+
+		if err != nil {
+			apperr.Write(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{"message": "Статус договора изменен", "contractId": contractID, "status": to})
+	}
+}
+
+func (h *HandlerDriver) GetLoanTransitionsHandler(c *gin.Context) {
+	contractID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		apperr.Write(c, apperr.BadRequest("Неверный id договора"))
+		return
+	}
+
+	transitions, err := h.store.Loans().Transitions(c.Request.Context(), contractID)
+	if err != nil {
+		apperr.Write(c, err)
+		return
+	}
+
+	c.JSON(200, transitions)
+}
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/apperr"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+// pageResponse - ответ постраничного списка. NextCursor передается в
+// параметре cursor для получения следующей страницы.
+type pageResponse[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int64  `json:"total"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// cursorToken - содержимое курсора. Сортировка сохраняется в нем, чтобы
+// курсор нельзя было применить к списку с другим порядком.
+type cursorToken struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+func newPageResponse[T any](page repository.Page[T], req repository.PageRequest) pageResponse[T] {
+	resp := pageResponse[T]{Items: page.Items, Total: page.Total}
+	if page.Next != nil {
+		data, _ := json.Marshal(cursorToken{Sort: req.Sort, Desc: req.Desc, Value: page.Next.Value, ID: page.Next.ID})
+		resp.NextCursor = base64.RawURLEncoding.EncodeToString(data)
+	}
+	return resp
+}
+
+// listQuery разбирает параметры списка из строки запроса и копит ошибки,
+// чтобы вернуть их все одним ответом.
+type listQuery struct {
+	c    *gin.Context
+	errs []apperr.FieldError
+}
+
+func newListQuery(c *gin.Context) *listQuery {
+	return &listQuery{c: c}
+}
+
+func (q *listQuery) fail(field, rule, param string) {
+	q.errs = append(q.errs, apperr.FieldError{Field: field, Rule: rule, Param: param})
+}
+
+// page читает limit, sort, order и cursor. sorts - допустимые поля
+// сортировки, первое используется по умолчанию; порядок по умолчанию -
+// по убыванию.
+func (q *listQuery) page(sorts []string) repository.PageRequest {
+	p := repository.PageRequest{
+		Limit: q.limit(repository.DefaultPageLimit, repository.MaxPageLimit),
+		Sort:  sorts[0],
+		Desc:  true,
+	}
+
+	if v := q.c.Query("sort"); v != "" {
+		if slices.Contains(sorts, v) {
+			p.Sort = v
+		} else {
+			q.fail("sort", "oneof", strings.Join(sorts, " "))
+		}
+	}
+
+	switch q.c.Query("order") {
+	case "", "desc":
+	case "asc":
+		p.Desc = false
+	default:
+		q.fail("order", "oneof", "asc desc")
+	}
+
+	if v := q.c.Query("cursor"); v != "" {
+		var t cursorToken
+		data, err := base64.RawURLEncoding.DecodeString(v)
+		if err == nil {
+			err = json.Unmarshal(data, &t)
+		}
+		switch {
+		case err != nil:
+			q.fail("cursor", "cursor", "")
+		case t.Sort != p.Sort || t.Desc != p.Desc:
+			q.fail("cursor", "sort", "")
+		default:
+			p.After = &repository.Cursor{Value: t.Value, ID: t.ID}
+		}
+	}
+
+	return p
+}
+
+// limit читает размер страницы из параметра limit.
+func (q *listQuery) limit(def, max int) int {
+	v := q.c.Query("limit")
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	switch {
+	case err != nil || n < 1:
+		q.fail("limit", "min", "1")
+	case n > max:
+		q.fail("limit", "max", strconv.Itoa(max))
+	default:
+		return n
+	}
+	return def
+}
+
+func (q *listQuery) str(name string) string {
+	return strings.TrimSpace(q.c.Query(name))
+}
+
+func (q *listQuery) oneOf(name string, values []string) string {
+	v := q.str(name)
+	if v != "" && !slices.Contains(values, v) {
+		q.fail(name, "oneof", strings.Join(values, " "))
+		return ""
+	}
+	return v
+}
+
+func (q *listQuery) id(name string) int64 {
+	v := q.str(name)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 1 {
+		q.fail(name, "number", "")
+		return 0
+	}
+	return n
+}
+
+// date читает дату в формате YYYY-MM-DD.
+func (q *listQuery) date(name string) *time.Time {
+	v := q.str(name)
+	if v == "" {
+		return nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		q.fail(name, "datetime", time.DateOnly)
+		return nil
+	}
+	return &t
+}
+
+// timestamp читает момент времени: RFC 3339, значение поля datetime-local
+// или дату. Дата без времени в качестве верхней границы (endOfDay)
+// включает весь день.
+func (q *listQuery) timestamp(name string, endOfDay bool) *time.Time {
+	v := q.str(name)
+	if v == "" {
+		return nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return &t
+		}
+	}
+	t, err := time.ParseInLocation(time.DateOnly, v, time.Local)
+	if err != nil {
+		q.fail(name, "datetime", time.RFC3339)
+		return nil
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Microsecond)
+	}
+	return &t
+}
+
+// err возвращает ошибку валидации со списком неверных параметров.
+func (q *listQuery) err() error {
+	if len(q.errs) == 0 {
+		return nil
+	}
+	return apperr.New(http.StatusBadRequest, apperr.CodeValidation, "Неверные параметры списка").WithDetails(q.errs)
+}
@@ -0,0 +1,298 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/apperr"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/auth"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/lockout"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/password"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+func (h *HandlerDriver) ChangePasswordHandler(c *gin.Context) {
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Write(c, apperr.Invalid("Текущий и новый пароль обязательны", err))
+		return
+	}
+
+	userID := c.GetInt64("userId")
+	role := c.GetString("role")
+	ctx := c.Request.Context()
+
+	user, err := h.store.Users().GetByID(ctx, userID)
+	if err != nil {
+		apperr.Write(c, apperr.NotFound("Пользователь не найден"))
+		return
+	}
+
+	if !password.CheckPasswordHash(req.CurrentPassword, user.PasswordHash) {
+		LogAction(ctx, h.store.Audit(), userID, "PASSWORD_CHANGE_FAILED", "users", userID, map[string]string{
+			"ip": c.ClientIP(),
+		})
+		apperr.Write(c, apperr.New(403, "invalid_current_password", "Текущий пароль указан неверно"))
+		return
+	}
+
+	if req.NewPassword == req.CurrentPassword {
+		apperr.Write(c, apperr.New(400, "password_reused", "Новый пароль должен отличаться от текущего"))
+		return
+	}
+
+	if !h.setPassword(c, userID, user.Login, req.NewPassword, "PASSWORD_CHANGED") {
+		return
+	}
+
+	tokens, err := h.sessions.Issue(ctx, userID, role)
+	if err != nil {
+		auth.ClearSessionCookies(c)
+		c.JSON(200, gin.H{"message": "Пароль изменен, войдите заново"})
+		return
+	}
+	auth.SetSessionCookies(c, tokens)
+
+	c.JSON(200, gin.H{"message": "Пароль изменен, остальные сессии завершены"})
+}
+
+func (h *HandlerDriver) ForgotPasswordHandler(c *gin.Context) {
+	var req models.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Write(c, apperr.Invalid("Укажите логин или email", err))
+		return
+	}
+
+	ctx := c.Request.Context()
+	resp := gin.H{"message": "Если аккаунт существует, на его email отправлена ссылка для восстановления"}
+
+	key := "reset:" + lockout.IPKey(c.ClientIP())
+	if _, err := h.limiter.Check(ctx, key); errors.Is(err, lockout.ErrBlocked) {
+		apperr.Write(c, apperr.TooManyRequests("Слишком много запросов, попробуйте позже"))
+		return
+	}
+	h.limiter.Fail(ctx, key)
+
+	user, err := h.store.Users().FindForReset(ctx, req.Login)
+	if err != nil || user.Email == nil {
+		c.JSON(200, resp)
+		return
+	}
+
+	token, tokenHash, err := password.GenerateToken()
+	if err != nil {
+		apperr.Write(c, apperr.Internal("Не удалось создать ссылку").Wrap(err))
+		return
+	}
+
+	fullName := "Пользователь"
+	if user.FirstName != nil && user.LastName != nil {
+		fullName = fmt.Sprintf("%s %s", *user.FirstName, *user.LastName)
+	}
+
+	err = h.store.InTx(ctx, func(tx repository.Store) error {
+		if err := tx.Users().CreatePasswordReset(ctx, user.ID, tokenHash, h.cfg.PasswordResetTTL.Std()); err != nil {
+			return err
+		}
+
+		LogAction(ctx, tx.Audit(), user.ID, "PASSWORD_RESET_REQUESTED", "users", user.ID, map[string]string{
+			"ip": c.ClientIP(),
+		})
+
+		return h.mailer.SendPasswordResetEmail(ctx, tx.Outbox(), *user.Email, fullName, token, h.cfg.PasswordResetTTL.Std())
+	})
+	if err != nil {
+		apperr.Write(c, apperr.Internal("Не удалось создать ссылку").Wrap(err))
+		return
+	}
+
+	c.JSON(200, resp)
+}
+
+var errInvalidResetToken = apperr.New(400, "invalid_token", "Ссылка недействительна или устарела")
+
+func (h *HandlerDriver) ResetPasswordHandler(c *gin.Context) {
+	var req models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Write(c, apperr.Invalid("Токен и новый пароль обязательны", err))
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var userID int64
+	var login string
+
+	// Токен гасится в одной транзакции со сменой пароля: если пароль не
+	// подошел под политику или не сохранился, ссылка остается рабочей.
+	err := h.store.InTx(ctx, func(tx repository.Store) error {
+		var err error
+		userID, err = tx.Users().ConsumePasswordReset(ctx, password.HashToken(req.Token))
+		if err != nil {
+			return errInvalidResetToken.Wrap(err)
+		}
+
+		user, err := tx.Users().GetByID(ctx, userID)
+		if err != nil {
+			return errInvalidResetToken.Wrap(err)
+		}
+		login = user.Login
+
+		if err := savePassword(ctx, tx, userID, login, req.NewPassword); err != nil {
+			return err
+		}
+
+		LogAction(ctx, tx.Audit(), userID, "PASSWORD_RESET", "users", userID, map[string]string{
+			"ip": c.ClientIP(),
+		})
+		return nil
+	})
+	if err != nil {
+		apperr.Write(c, err)
+		return
+	}
+
+	if err := h.sessions.RevokeUser(ctx, userID); err != nil {
+		log.Printf("Revoke sessions error: %v", err)
+	}
+	h.limiter.Reset(ctx, lockout.LoginKey(login))
+
+	c.JSON(200, gin.H{"message": "Пароль изменен, войдите с новым паролем"})
+}
+
+func (h *HandlerDriver) requirePasswordChange(c *gin.Context, p repository.User, extra gin.H) {
+	challenge, err := h.sessions.GenerateChallenge(p.ID, p.Role, auth.PurposePasswordChange)
+	if err != nil {
+		apperr.Write(c, apperr.Internal("Не удалось создать токен").Wrap(err))
+		return
+	}
+
+	resp := gin.H{
+		"message":   "Password change required",
+		"status":    "password_change_required",
+		"challenge": challenge,
+	}
+	for k, v := range extra {
+		resp[k] = v
+	}
+	c.JSON(200, resp)
+}
+
+var errInvalidChallenge = apperr.New(401, "invalid_challenge", "Подтверждение входа недействительно или устарело")
+
+func (h *HandlerDriver) RequiredPasswordChangeHandler(c *gin.Context) {
+	var req models.RequiredPasswordChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Write(c, apperr.Invalid("Токен и новый пароль обязательны", err))
+		return
+	}
+
+	claims, err := h.sessions.ParseChallenge(req.Challenge, auth.PurposePasswordChange)
+	if err != nil {
+		apperr.Write(c, errInvalidChallenge)
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	user, err := h.store.Users().GetByID(ctx, claims.UserID)
+	if err != nil {
+		apperr.Write(c, apperr.Unauthorized("Пользователь не найден"))
+		return
+	}
+	// Пароль уже сменен по этому токену: повторно он не действует.
+	if !user.MustChangePassword {
+		apperr.Write(c, errInvalidChallenge)
+		return
+	}
+
+	if password.CheckPasswordHash(req.NewPassword, user.PasswordHash) {
+		apperr.Write(c, apperr.New(400, "password_reused", "Новый пароль должен отличаться от текущего"))
+		return
+	}
+
+	if !h.setPassword(c, claims.UserID, user.Login, req.NewPassword, "FORCED_PASSWORD_CHANGE") {
+		return
+	}
+
+	profile, err := h.store.Users().GetByID(ctx, claims.UserID)
+	if err != nil {
+		apperr.Write(c, apperr.Unauthorized("Пользователь не найден"))
+		return
+	}
+
+	h.completeLogin(c, profile, nil)
+}
+
+// setPassword проверяет политику, сохраняет новый хеш и завершает все
+// сессии пользователя. При ошибке сам отвечает клиенту.
+func (h *HandlerDriver) setPassword(c *gin.Context, userID int64, login string, newPassword string, action string) bool {
+	ctx := c.Request.Context()
+	if err := savePassword(ctx, h.store, userID, login, newPassword); err != nil {
+		apperr.Write(c, err)
+		return false
+	}
+
+	// В PostgreSQL sp_set_password сам отзывает refresh-токены; явный
+	// вызов нужен для хранилищ сессий вне БД.
+	if err := h.sessions.RevokeUser(ctx, userID); err != nil {
+		log.Printf("Revoke sessions error: %v", err)
+	}
+
+	LogAction(ctx, h.store.Audit(), userID, action, "users", userID, map[string]string{
+		"ip": c.ClientIP(),
+	})
+	return true
+}
+
+// savePassword проверяет новый пароль по политике и сохраняет его хеш
+// через store (обычно транзакцию). Ошибки уже готовы для ответа клиенту.
+func savePassword(ctx context.Context, store repository.Store, userID int64, login string, newPassword string) error {
+	if err := password.Validate(newPassword, login); err != nil {
+		return weakPassword(err)
+	}
+
+	hashed, err := password.HashPassword(newPassword)
+	if err != nil {
+		return apperr.Internal("Не удалось обработать пароль").Wrap(err)
+	}
+
+	if err := store.Users().SetPassword(ctx, userID, hashed); err != nil {
+		return apperr.Internal("Не удалось сохранить пароль").Wrap(err)
+	}
+	return nil
+}
+
+// weakPassword отдает клиенту список нарушенных требований к паролю.
+func weakPassword(err error) *apperr.Error {
+	e := apperr.New(400, "weak_password", err.Error())
+
+	var policy *password.PolicyError
+	if errors.As(err, &policy) {
+		e = e.WithDetails(gin.H{"violations": policy.Violations})
+	}
+	return e
+}
+
+// CheckSession отклоняет токены, выданные до последней смены пароля или
+// после деактивации пользователя.
+func (h *HandlerDriver) CheckSession(ctx context.Context, claims *auth.Claims) error {
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+
+	valid, err := h.store.Users().SessionValid(ctx, claims.UserID, issuedAt)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return auth.ErrInvalidToken
+	}
+	return nil
+}
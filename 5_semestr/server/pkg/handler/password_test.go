@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/config"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/auth"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/mail"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/password"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+func TestChangePassword(t *testing.T) {
+	api := newTestAPI(t)
+	api.addManager()
+
+	other := &testAPI{t: t, r: api.r, store: api.store, cookies: map[string]*http.Cookie{}}
+	other.login("manager", "Manager-pass1")
+	api.login("manager", "Manager-pass1")
+
+	w := api.do("POST", "/api/me/password", `{"currentPassword": "Wrong-pass1", "newPassword": "New-pass1"}`)
+	if w.Code != 403 || decode[map[string]any](t, w)["code"] != "invalid_current_password" {
+		t.Errorf("Wrong current password: expected 403 invalid_current_password, got %d: %s", w.Code, w.Body)
+	}
+
+	// Токены с точностью до секунды: смена пароля должна прийтись на
+	// следующую секунду после входа второй сессии.
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+
+	if w := api.do("POST", "/api/me/password", `{"currentPassword": "Manager-pass1", "newPassword": "New-pass1"}`); w.Code != 200 {
+		t.Fatalf("change password: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if w := api.do("GET", "/api/clients", ""); w.Code != 200 {
+		t.Errorf("Current session should be re-issued, got %d: %s", w.Code, w.Body)
+	}
+
+	if w := other.do("GET", "/api/clients", ""); w.Code != 401 {
+		t.Errorf("Other session access token: expected 401, got %d", w.Code)
+	}
+	if w := other.do("POST", "/api/refresh", ""); w.Code != 401 {
+		t.Errorf("Other session refresh: expected 401, got %d", w.Code)
+	}
+
+	other.login("manager", "New-pass1")
+}
+
+func TestForgotPassword(t *testing.T) {
+	api := newTestAPI(t, func(cfg *config.Config) {
+		cfg.Mail.Host = "smtp.example.com"
+		cfg.Mail.From = "RoseBank <noreply@rosebank.example>"
+	})
+	hash, _ := password.HashPassword("Manager-pass1")
+	api.store.AddEmployee(auth.RoleManager, repository.EmployeeRegistration{
+		Login: "manager", PasswordHash: hash, FirstName: "Анна", LastName: "Смирнова", Email: "anna@example.com",
+	})
+
+	forgot := func(login string) string {
+		w := api.do("POST", "/api/password/forgot", `{"login": "`+login+`"}`)
+		if w.Code != 200 {
+			t.Fatalf("forgot %s: expected 200, got %d: %s", login, w.Code, w.Body)
+		}
+		return decode[map[string]any](t, w)["message"].(string)
+	}
+
+	// Ответ не выдает, существует ли учетная запись.
+	if known, unknown := forgot("manager"), forgot("ghost"); known != unknown {
+		t.Errorf("Responses differ for known and unknown logins: %q vs %q", known, unknown)
+	}
+
+	queued := api.store.OutboxMessages("pending")
+	if len(queued) != 1 || queued[0].To != "anna@example.com" || queued[0].Kind != mail.KindPasswordReset {
+		t.Fatalf("Expected one reset email to the manager, got %+v", queued)
+	}
+	m := regexp.MustCompile(`reset-password\?token=([^\s"&<]+)`).FindStringSubmatch(queued[0].Text)
+	if m == nil {
+		t.Fatalf("No reset link in the email: %s", queued[0].Text)
+	}
+
+	reset := func(pwd string) int {
+		body, _ := json.Marshal(map[string]string{"token": m[1], "newPassword": pwd})
+		return api.do("POST", "/api/password/reset", string(body)).Code
+	}
+	if code := reset("New-pass1"); code != 200 {
+		t.Fatalf("reset: expected 200, got %d", code)
+	}
+	if code := reset("Other-pass1"); code != 400 {
+		t.Errorf("Reused reset token: expected 400, got %d", code)
+	}
+
+	api.login("manager", "New-pass1")
+}
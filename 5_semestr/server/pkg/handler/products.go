@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"cmp"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/apperr"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/auth"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+var errProductNotFound = apperr.New(http.StatusNotFound, "product_not_found", "Кредитный продукт не найден")
+
+// GetProducts возвращает активные продукты. Сотрудники с параметром
+// all=true получают и неактивные.
+func (h *HandlerDriver) GetProducts(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var products []models.CreditProduct
+	var err error
+	if c.Query("all") == "true" && slices.Contains(auth.Staff, c.GetString("role")) {
+		products, err = h.store.Products().List(ctx)
+	} else {
+		products, err = h.store.Products().ListActive(ctx)
+	}
+	if err != nil {
+		apperr.Write(c, err)
+		return
+	}
+
+	c.JSON(200, products)
+}
+
+func (h *HandlerDriver) GetProductHandler(c *gin.Context) {
+	productID, ok := productIDParam(c)
+	if !ok {
+		return
+	}
+
+	product, err := h.store.Products().Get(c.Request.Context(), productID)
+	if errors.Is(err, repository.ErrNotFound) {
+		apperr.Write(c, errProductNotFound)
+		return
+	}
+	if err != nil {
+		apperr.Write(c, err)
+		return
+	}
+
+	c.JSON(200, product)
+}
+
+func (h *HandlerDriver) CreateProductHandler(c *gin.Context) {
+	var req models.ProductRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Write(c, apperr.Invalid("Ошибка валидации данных", err))
+		return
+	}
+
+	productID, err := h.store.Products().Create(c.Request.Context(), productParams(c, req, models.CreditProduct{}))
+	if err != nil {
+		apperr.Write(c, err)
+		return
+	}
+
+	c.JSON(201, gin.H{"message": "Кредитный продукт создан", "id": productID})
+}
+
+func (h *HandlerDriver) UpdateProductHandler(c *gin.Context) {
+	productID, ok := productIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req models.ProductRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Write(c, apperr.Invalid("Ошибка валидации данных", err))
+		return
+	}
+
+	ctx := c.Request.Context()
+	current, err := h.store.Products().Get(ctx, productID)
+	if errors.Is(err, repository.ErrNotFound) {
+		apperr.Write(c, errProductNotFound)
+		return
+	}
+	if err != nil {
+		apperr.Write(c, err)
+		return
+	}
+
+	if _, err := h.store.Products().Update(ctx, productID, productParams(c, req, current)); err != nil {
+		apperr.Write(c, err)
+		return
+	}
+
+	h.GetProductHandler(c)
+}
+
+func (h *HandlerDriver) SetProductActiveHandler(c *gin.Context) {
+	productID, ok := productIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req models.SetProductActiveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Write(c, apperr.Invalid("Поле active обязательно", err))
+		return
+	}
+
+	err := h.store.Products().SetActive(c.Request.Context(), c.GetInt64("userId"), productID, *req.Active)
+	if err != nil {
+		apperr.Write(c, err)
+		return
+	}
+
+	h.GetProductHandler(c)
+}
+
+func (h *HandlerDriver) GetProductVersionsHandler(c *gin.Context) {
+	productID, ok := productIDParam(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := h.store.Products().Get(ctx, productID); errors.Is(err, repository.ErrNotFound) {
+		apperr.Write(c, errProductNotFound)
+		return
+	}
+
+	versions, err := h.store.Products().Versions(ctx, productID)
+	if err != nil {
+		apperr.Write(c, err)
+		return
+	}
+
+	c.JSON(200, versions)
+}
+
+func productIDParam(c *gin.Context) (int, bool) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		apperr.Write(c, apperr.BadRequest("Неверный id продукта"))
+		return 0, false
+	}
+	return productID, true
+}
+
+// productParams собирает условия продукта из запроса. Поля, пропущенные в
+// запросе, берутся из current: текущего продукта при изменении или пустого
+// при создании.
+func productParams(c *gin.Context, req models.ProductRequest, current models.CreditProduct) repository.ProductParams {
+	penaltyRate, penaltyCap := current.PenaltyRate, current.PenaltyCap
+	if req.PenaltyRate != nil {
+		penaltyRate = *req.PenaltyRate
+	}
+	if req.PenaltyCap != nil {
+		penaltyCap = *req.PenaltyCap
+	}
+
+	return repository.ProductParams{
+		Name:          req.Name,
+		MinAmount:     req.MinAmount,
+		MaxAmount:     req.MaxAmount,
+		MinTermMonths: req.MinTermMonths,
+		MaxTermMonths: req.MaxTermMonths,
+		InterestRate:  req.InterestRate,
+		ScheduleType:  cmp.Or(req.ScheduleType, current.ScheduleType, models.ScheduleAnnuity),
+		PenaltyRate:   penaltyRate,
+		PenaltyCap:    penaltyCap,
+		ActorID:       c.GetInt64("userId"),
+	}
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/apperr"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/auth"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+)
+
+// RegisterRoutes подключает все маршруты API к роутеру. Вынесено из main,
+// чтобы тесты поднимали тот же набор маршрутов поверх хранилища в памяти.
+func (h *HandlerDriver) RegisterRoutes(r *gin.Engine, authz *auth.Authorizer) {
+	api := r.Group("/api")
+	api.Use(apperr.RequestID())
+	{
+		api.POST("/login", h.LoginHandler)
+		api.POST("/login/2fa", h.SecondFactorVerifyHandler)
+		api.POST("/login/2fa/setup", h.SecondFactorSetupHandler)
+		api.POST("/login/password", h.RequiredPasswordChangeHandler)
+		api.POST("/refresh", h.RefreshHandler)
+		api.POST("/logout", h.LogoutHandler)
+		api.POST("/password/forgot", h.ForgotPasswordHandler)
+		api.POST("/password/reset", h.ResetPasswordHandler)
+		api.POST("/activate", h.ActivateAccountHandler)
+
+		protected := api.Group("/")
+		protected.Use(h.sessions.Middleware(h.CheckSession), authz.Enforce(), h.ResolveEmployee)
+		{
+
+			protected.POST("/me/password", h.ChangePasswordHandler)
+			protected.POST("/me/2fa", h.BeginTwoFactorHandler)
+			protected.POST("/me/2fa/confirm", h.ConfirmTwoFactorHandler)
+			protected.DELETE("/me/2fa", h.DisableTwoFactorHandler)
+
+			protected.GET("/clients", h.GetClients)
+			protected.GET("/clients/search", h.SearchClients)
+			protected.POST("/clients", h.CreateClient)
+			protected.GET("/clients/:id", h.GetClientHandler)
+			protected.PUT("/clients/:id", h.UpdateClientHandler)
+			protected.PUT("/clients/:id/active", h.SetClientActiveHandler)
+			protected.GET("/clients/:id/history", h.GetClientHistoryHandler)
+			protected.POST("/clients/:id/activation", h.ResendActivationHandler)
+
+			protected.GET("/products", h.GetProducts)
+			protected.POST("/products", h.CreateProductHandler)
+			protected.GET("/products/:id", h.GetProductHandler)
+			protected.PUT("/products/:id", h.UpdateProductHandler)
+			protected.PUT("/products/:id/active", h.SetProductActiveHandler)
+			protected.GET("/products/:id/versions", h.GetProductVersionsHandler)
+			protected.POST("/loans", h.IssueLoan)
+			protected.GET("/loans", h.GetLoans)
+			protected.POST("/loans/:id/submit", h.LoanTransitionHandler(models.LoanReview))
+			protected.POST("/loans/:id/sign", h.LoanTransitionHandler(models.LoanSigned))
+			protected.POST("/loans/:id/activate", h.LoanTransitionHandler(models.LoanActive))
+			protected.GET("/loans/:id/transitions", h.GetLoanTransitionsHandler)
+			protected.GET("/loans/:id/schedule", h.GetSchedule)
+			protected.GET("/loans/:id/operations", h.GetLoanOperationsHandler)
+			protected.GET("/my-loans", h.GetMyLoansHandler)
+
+			protected.POST("/pay", h.MakePaymentHandler)
+			protected.POST("/repay-early", h.EarlyRepaymentHandler)
+
+			protected.GET("/stats", h.GetStatsHandler)
+			protected.GET("/finance-report", h.GetFinanceReportHandler)
+
+			// Маршруты только для администратора: помимо таблицы прав
+			// их закрывает RequireRoles на всей группе.
+			admin := protected.Group("/", authz.RequireRoles(auth.RoleAdmin))
+			{
+				admin.POST("/register", h.RegisterHandler)
+				admin.PUT("/roles/:role/2fa", h.SetRoleTwoFactorHandler)
+				admin.POST("/loans/:id/approve", h.LoanTransitionHandler(models.LoanApproved))
+				admin.POST("/loans/:id/reject", h.LoanTransitionHandler(models.LoanRejected))
+				admin.GET("/employees", h.GetEmployeesHandler)
+				admin.POST("/users/unlock", h.UnlockAccountHandler)
+				admin.GET("/logs", h.GetLogsHandler)
+				admin.POST("/backup", h.CreateBackupHandler)
+			}
+		}
+	}
+}
@@ -2,79 +2,89 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/config"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/apperr"
 	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/auth"
 	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/backup"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/lockout"
 	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/mail"
 	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/password"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/translit"
 	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
 )
 
 type HandlerDriver struct {
-	db *pgxpool.Pool
+	cfg      config.Auth
+	store    repository.Store
+	sessions *auth.Sessions
+	limiter  lockout.Limiter
+	mailer   *mail.Sender
+	backups  *backup.Service
 }
 
-func NewHandlerDriver(db *pgxpool.Pool) *HandlerDriver {
+func NewHandlerDriver(cfg config.Auth, store repository.Store, sessions *auth.Sessions, limiter lockout.Limiter, mailer *mail.Sender, backups *backup.Service) *HandlerDriver {
 	return &HandlerDriver{
-		db: db,
+		cfg:      cfg,
+		store:    store,
+		sessions: sessions,
+		limiter:  limiter,
+		mailer:   mailer,
+		backups:  backups,
 	}
 }
 
 func (h *HandlerDriver) RegisterHandler(c *gin.Context) {
 	var req models.RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": "Ошибка валидации данных", "details": err.Error()})
+		apperr.Write(c, apperr.Invalid("Ошибка валидации данных", err))
 		return
 	}
 
 	hashedPwd, err := password.HashPassword(req.Password)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Не удалось обработать пароль"})
+		apperr.Write(c, apperr.Internal("Не удалось обработать пароль").Wrap(err))
 		return
 	}
 
 	ctx := c.Request.Context()
-	tx, err := h.db.Begin(ctx)
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Tx error"})
-		return
-	}
-	defer tx.Rollback(ctx)
-
-	var newUserID int64
-
-	err = tx.QueryRow(ctx, "CALL sp_register_employee($1, $2, $3, $4, $5, $6, NULL)",
-		req.Login,
-		hashedPwd,
-		req.FirstName,
-		req.LastName,
-		req.Position,
-		req.Email,
-	).Scan(&newUserID)
-
-	if err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
-		return
-	}
-
-	adminID, _ := c.Get("userId")
+	adminID := c.GetInt64("userId")
+
+	err = h.store.InTx(ctx, func(tx repository.Store) error {
+		newUserID, err := tx.Employees().Register(ctx, repository.EmployeeRegistration{
+			Login:        req.Login,
+			PasswordHash: hashedPwd,
+			FirstName:    req.FirstName,
+			LastName:     req.LastName,
+			Position:     req.Position,
+			Email:        req.Email,
+		})
+		if err != nil {
+			return err
+		}
 
-	LogAction(ctx, tx, adminID.(int64), "REGISTER_EMPLOYEE", "employees", newUserID, map[string]string{
-		"role":  req.Position,
-		"login": req.Login,
-		"name":  fmt.Sprintf("%s %s", req.FirstName, req.LastName),
-		"email": req.Email,
+		LogAction(ctx, tx.Audit(), adminID, "REGISTER_EMPLOYEE", "employees", newUserID, map[string]string{
+			"role":  req.Position,
+			"login": req.Login,
+			"name":  fmt.Sprintf("%s %s", req.FirstName, req.LastName),
+			"email": req.Email,
+		})
+		return nil
 	})
 
-	if err := tx.Commit(ctx); err != nil {
-		c.JSON(500, gin.H{"error": "Commit failed"})
+	if err != nil {
+		apperr.Write(c, err)
 		return
 	}
 
@@ -84,441 +94,442 @@ func (h *HandlerDriver) RegisterHandler(c *gin.Context) {
 func (h *HandlerDriver) LoginHandler(c *gin.Context) {
 	var req models.LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": "Логин и пароль обязательны"})
+		apperr.Write(c, apperr.Invalid("Логин и пароль обязательны", err))
 		return
 	}
 
-	var userID int64
-	var pwdHash, roleName string
-	var fName, lName, email *string
-
-	err := h.db.QueryRow(c.Request.Context(), "SELECT * FROM fn_get_user_by_login($1)", req.Login).
-		Scan(&userID, &pwdHash, &roleName, &fName, &lName, &email)
+	keys := []string{lockout.LoginKey(req.Login), lockout.IPKey(c.ClientIP())}
+	for _, key := range keys {
+		wait, err := h.limiter.Check(c.Request.Context(), key)
+		if errors.Is(err, lockout.ErrBlocked) {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			apperr.Write(c, apperr.New(429, "account_locked", "Слишком много неудачных попыток входа").WithDetails(gin.H{"retryAfter": retryAfter}))
+			return
+		}
+		if err != nil {
+			log.Printf("Login limiter error: %v", err)
+		}
+	}
 
+	profile, err := h.store.Users().GetByLogin(c.Request.Context(), req.Login)
 	if err != nil {
-		c.JSON(401, gin.H{"error": "Неверный логин или пароль"})
+		h.registerLoginFailure(c, keys, 0)
+		apperr.Write(c, apperr.New(401, "invalid_credentials", "Неверный логин или пароль"))
 		return
 	}
 
-	match := password.CheckPasswordHash(req.Password, pwdHash)
-	
-	if !match {
-        if req.Password == pwdHash {
-            match = true
-			newHash, _ := password.HashPassword(req.Password)
-			h.db.Exec(c.Request.Context(), `UPDATE users SET password_hash = $1 WHERE login = $2`, newHash, req.Login)
-        }
-    }
+	if !password.CheckPasswordHash(req.Password, profile.PasswordHash) {
+		h.registerLoginFailure(c, keys, profile.ID)
+		apperr.Write(c, apperr.New(401, "invalid_credentials", "Неверный логин или пароль"))
+		return
+	}
 
-	if !match {
-		c.JSON(401, gin.H{"error": "Неверный логин или пароль"})
+	if h.requireSecondFactor(c, profile) {
 		return
 	}
 
-	accessToken, err := auth.GenerateToken(userID, roleName, auth.AccessTokenDuration)
-	if err != nil {
-		log.Println(err)
-		c.JSON(500, gin.H{"error": "Token generation failed"})
+	h.completeLogin(c, profile, nil)
+}
+
+// completeLogin открывает полноценную сессию. Учетные записи, которым
+// нужно сменить пароль, вместо сессии получают токен смены пароля.
+func (h *HandlerDriver) completeLogin(c *gin.Context, p repository.User, extra gin.H) {
+	// Счетчик неудач снимается только с логина и только после всех
+	// факторов; блокировка IP истекает сама или снимается администратором.
+	if err := h.limiter.Reset(c.Request.Context(), lockout.LoginKey(p.Login)); err != nil {
+		log.Printf("Login limiter reset error: %v", err)
+	}
+
+	if p.MustChangePassword {
+		h.requirePasswordChange(c, p, extra)
 		return
 	}
-	refreshToken, err := auth.GenerateToken(userID, roleName, auth.RefreshTokenDuration)
+
+	tokens, err := h.sessions.Issue(c.Request.Context(), p.ID, p.Role)
 	if err != nil {
-		log.Println(err)
-		c.JSON(500, gin.H{"error": "Token generation failed"})
+		apperr.Write(c, apperr.Internal("Не удалось создать токен").Wrap(err))
 		return
 	}
 
-	c.SetCookie("access_token", accessToken, int(auth.AccessTokenDuration.Seconds()), "/", "", false, true)
-	c.SetCookie("refresh_token", refreshToken, int(auth.RefreshTokenDuration.Seconds()), "/api/refresh", "", false, true)
+	auth.SetSessionCookies(c, tokens)
 
 	fullName := "Пользователь"
-	if fName != nil && lName != nil {
-		fullName = fmt.Sprintf("%s %s", *fName, *lName)
+	if p.FirstName != nil && p.LastName != nil {
+		fullName = fmt.Sprintf("%s %s", *p.FirstName, *p.LastName)
 	}
 
-	if email != nil && *email != "" {
-		go mail.SendLoginEmail(*email, fullName, c.ClientIP())
+	if p.Email != nil && *p.Email != "" {
+		if err := h.mailer.SendLoginEmail(c.Request.Context(), h.store.Outbox(), *p.Email, fullName, c.ClientIP()); err != nil {
+			log.Printf("Failed to queue login email: %v", err)
+		}
 	}
 
-	c.JSON(200, gin.H{
+	resp := gin.H{
 		"message": "Login successful",
 		"user": gin.H{
-			"id":   userID,
-			"role": roleName,
+			"id":   p.ID,
+			"role": p.Role,
 			"name": fullName,
 		},
-	})
-}
-
-func (h *HandlerDriver) GetClients(c *gin.Context) {
-	rows, err := h.db.Query(c.Request.Context(), "SELECT * FROM fn_get_all_clients()")
-	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
 	}
-	defer rows.Close()
+	for k, v := range extra {
+		resp[k] = v
+	}
+	c.JSON(200, resp)
+}
 
-	var clients []gin.H
-	for rows.Next() {
-		var id int64
-		var fn, ln, ps, pn, ph, addr string
-		var mn, pi, em *string
-		var dob, createdAt time.Time
+func (h *HandlerDriver) registerLoginFailure(c *gin.Context, keys []string, userID int64) {
+	ctx := c.Request.Context()
 
-		err := rows.Scan(&id, &fn, &ln, &mn, &ps, &pn, &pi, &dob, &addr, &ph, &em, &createdAt)
+	for _, key := range keys {
+		st, err := h.limiter.Fail(ctx, key)
 		if err != nil {
-			log.Println("Scan error:", err)
+			log.Printf("Login limiter error: %v", err)
 			continue
 		}
 
-		clients = append(clients, gin.H{
-			"id": id, "firstName": fn, "lastName": ln, "middleName": mn,
-			"passportSeries": ps, "passportNumber": pn, "passportIssuedBy": pi,
-			"dateOfBirth": dob.Format("2006-01-02"),
-			"address":     addr, "phone": ph, "email": em,
-			"createdAt": createdAt,
-		})
-	}
-
-	if clients == nil {
-		clients = []gin.H{}
+		if st.Locked {
+			LogAction(ctx, h.store.Audit(), userID, "ACCOUNT_LOCKED", "users", userID, map[string]string{
+				"key":      key,
+				"failures": strconv.Itoa(st.Failures),
+				"until":    st.BlockedUntil.Format(time.RFC3339),
+				"ip":       c.ClientIP(),
+			})
+		}
 	}
-	c.JSON(200, clients)
 }
 
-func (h *HandlerDriver) CreateClient(c *gin.Context) {
-	var req models.CreateClientRequest
+func (h *HandlerDriver) UnlockAccountHandler(c *gin.Context) {
+	var req models.UnlockRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": "Ошибка валидации данных"})
+		apperr.Write(c, apperr.Invalid("Логин обязателен", err))
 		return
 	}
 
-	genLogin := password.GenerateRandomPassword(8)
-	genPassword := password.GenerateRandomPassword(8)
+	ctx := c.Request.Context()
 
-	hashedPwd, err := password.HashPassword(genPassword)
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Не удалось обработать пароль"})
+	// Блокировка IP снимается, только если адрес указан; иначе она истечет
+	// сама.
+	keys := []string{lockout.LoginKey(req.Login)}
+	if req.IP != "" {
+		keys = append(keys, lockout.IPKey(req.IP))
+	}
+	for _, key := range keys {
+		if err := h.limiter.Reset(ctx, key); err != nil {
+			apperr.Write(c, apperr.Internal("Не удалось снять блокировку").Wrap(err))
+			return
+		}
+	}
+
+	LogAction(ctx, h.store.Audit(), c.GetInt64("userId"), "ACCOUNT_UNLOCKED", "users", 0, map[string]string{
+		"login": req.Login,
+		"ip":    req.IP,
+	})
+
+	c.JSON(200, gin.H{"message": "Блокировка снята"})
+}
+
+func (h *HandlerDriver) GetClients(c *gin.Context) {
+	q := newListQuery(c)
+	filter := repository.ClientFilter{
+		CreatedFrom: q.date("from"),
+		CreatedTo:   q.date("to"),
+	}
+	page := q.page(repository.ClientSorts)
+	if err := q.err(); err != nil {
+		apperr.Write(c, err)
 		return
 	}
 
-	ctx := c.Request.Context()
-	tx, err := h.db.Begin(ctx)
+	clients, err := h.store.Clients().List(c.Request.Context(), filter, page)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Tx fail"})
+		apperr.Write(c, err)
 		return
 	}
-	defer tx.Rollback(ctx)
 
-	var clientID int64
+	c.JSON(200, newPageResponse(clients, page))
+}
 
-	err = tx.QueryRow(ctx, `CALL sp_create_client($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NULL)`,
-		genLogin, hashedPwd,
-		req.FirstName, req.LastName, req.MiddleName,
-		req.PassportSeries, req.PassportNumber, req.PassportIssued,
-		req.DateOfBirth, req.Address, req.Phone, req.Email,
-	).Scan(&clientID)
+// Размер выдачи поиска клиентов.
+const (
+	searchDefaultLimit = 20
+	searchMaxLimit     = 50
+)
 
-	if err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+func (h *HandlerDriver) SearchClients(c *gin.Context) {
+	q := newListQuery(c)
+	query := q.str("q")
+	if utf8.RuneCountInString(query) < 2 {
+		q.fail("q", "min", "2")
+	}
+	limit := q.limit(searchDefaultLimit, searchMaxLimit)
+	if err := q.err(); err != nil {
+		apperr.Write(c, err)
 		return
 	}
 
-	opID, _ := c.Get("userId")
-	adminID := int64(0)
-	if opID != nil {
-		adminID = opID.(int64)
+	matches, err := h.store.Clients().Search(c.Request.Context(), query, limit)
+	if err != nil {
+		apperr.Write(c, err)
+		return
 	}
 
-	LogAction(ctx, tx, adminID, "CREATE_CLIENT", "clients", clientID, map[string]string{
-		"login": genLogin,
-		"name":  fmt.Sprintf("%s %s", req.LastName, req.FirstName),
-	})
+	c.JSON(200, gin.H{"items": matches})
+}
 
-	if err := tx.Commit(ctx); err != nil {
-		c.JSON(500, gin.H{"error": "Commit failed"})
+func (h *HandlerDriver) CreateClient(c *gin.Context) {
+	var req models.CreateClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Write(c, apperr.Invalid("Ошибка валидации данных", err))
 		return
 	}
 
-	go mail.SendClientWelcomeEmail(
-		req.Email,
-		fmt.Sprintf("%s %s", req.FirstName, req.LastName),
-		genLogin,
-		genPassword,
-	)
-
-	c.JSON(201, gin.H{"message": "Client created", "id": clientID})
-}
+	// До активации у учетной записи нет известного никому пароля.
+	unusablePwd, _, err := password.GenerateToken()
+	if err != nil {
+		apperr.Write(c, apperr.Internal("Не удалось обработать пароль").Wrap(err))
+		return
+	}
+	hashedPwd, err := password.HashPassword(unusablePwd)
+	if err != nil {
+		apperr.Write(c, apperr.Internal("Не удалось обработать пароль").Wrap(err))
+		return
+	}
 
-func (h *HandlerDriver) GetProducts(c *gin.Context) {
-	rows, err := h.db.Query(c.Request.Context(), "SELECT * FROM fn_get_active_products()")
+	activationToken, activationHash, err := password.GenerateToken()
 	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		apperr.Write(c, apperr.Internal("Не удалось создать ссылку активации").Wrap(err))
 		return
 	}
-	defer rows.Close()
 
-	var products []gin.H
-	for rows.Next() {
-		var id, minTerm, maxTerm int
-		var name string
-		var minAmt, maxAmt int64
-		var rate float64
-		var isActive bool
+	ctx := c.Request.Context()
+	adminID := c.GetInt64("userId")
 
-		err := rows.Scan(&id, &name, &minAmt, &maxAmt, &minTerm, &maxTerm, &rate, &isActive)
+	var clientID int64
+	var genLogin string
+
+	err = h.store.InTx(ctx, func(tx repository.Store) error {
+		var err error
+		clientID, genLogin, err = tx.Clients().Register(ctx, repository.ClientRegistration{
+			CreateClientRequest: req,
+			LoginBase:           translit.LoginBase(req.LastName, req.FirstName),
+			PasswordHash:        hashedPwd,
+			ActivationHash:      activationHash,
+			ActivationTTL:       h.cfg.ActivationTTL.Std(),
+		})
 		if err != nil {
-			continue
+			return err
 		}
 
-		products = append(products, gin.H{
-			"id": id, "name": name,
-			"minAmount": float64(minAmt) / 100.0,
-			"maxAmount": float64(maxAmt) / 100.0,
-			"minTerm":   minTerm, "maxTerm": maxTerm,
-			"rate": rate, "isActive": isActive,
+		LogAction(ctx, tx.Audit(), adminID, "CREATE_CLIENT", "clients", clientID, map[string]string{
+			"login": genLogin,
+			"name":  fmt.Sprintf("%s %s", req.LastName, req.FirstName),
 		})
-	}
 
-	if products == nil {
-		products = []gin.H{}
+		return h.mailer.SendClientActivationEmail(ctx, tx.Outbox(),
+			req.Email,
+			fmt.Sprintf("%s %s", req.FirstName, req.LastName),
+			genLogin,
+			activationToken,
+			h.cfg.ActivationTTL.Std(),
+		)
+	})
+
+	if err != nil {
+		apperr.Write(c, err)
+		return
 	}
-	c.JSON(200, products)
+
+	c.JSON(201, gin.H{"message": "Client created", "id": clientID, "login": genLogin})
 }
 
 func (h *HandlerDriver) IssueLoan(c *gin.Context) {
 	var req models.IssueLoanRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid JSON"})
+		apperr.Write(c, apperr.Invalid("Неверный запрос", err))
 		return
 	}
 	ctx := c.Request.Context()
 
-	tx, err := h.db.Begin(ctx)
+	product, err := h.store.Products().Get(ctx, req.ProductID)
+	if errors.Is(err, repository.ErrNotFound) {
+		apperr.Write(c, errProductNotFound)
+		return
+	}
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Tx begin failed"})
+		apperr.Write(c, err)
+		return
+	}
+	if violations := loanTermViolations(product, req.Amount, req.TermMonths); len(violations) > 0 {
+		apperr.Write(c, errLoanTermsViolated.WithDetails(gin.H{"violations": violations}))
 		return
 	}
-	defer tx.Rollback(ctx)
 
 	var newContractID int64
-	amountKopecks := int64(req.Amount * 100)
-
-	err = tx.QueryRow(ctx, "CALL sp_issue_loan($1, $2, $3, $4, $5, NULL)",
-		req.ClientID,
-		req.ProductID,
-		amountKopecks,
-		req.TermMonths,
-		req.EmployeeID,
-	).Scan(&newContractID)
 
-	if err != nil {
-		log.Printf("Procedure call failed: %v", err)
-		c.JSON(500, gin.H{"error": "Failed to issue loan (DB Procedure Error)"})
-		return
-	}
+	err = h.store.InTx(ctx, func(tx repository.Store) error {
+		var err error
+		newContractID, err = tx.Loans().Issue(ctx, repository.IssueLoanParams{
+			ClientID:   req.ClientID,
+			ProductID:  req.ProductID,
+			Amount:     req.Amount,
+			TermMonths: req.TermMonths,
+			EmployeeID: c.GetInt64("employeeId"),
+		})
+		if err != nil {
+			return err
+		}
 
-	LogAction(ctx, tx, req.EmployeeID, "TOOK_LOAN", "loan_contracts", newContractID, map[string]string{
-		"amount": fmt.Sprintf("%.2f", req.Amount),
-		"type":   "via_stored_procedure",
+		LogAction(ctx, tx.Audit(), c.GetInt64("userId"), "TOOK_LOAN", "loan_contracts", newContractID, map[string]string{
+			"amount": req.Amount.String(),
+			"type":   "via_stored_procedure",
+		})
+		return nil
 	})
 
-	if err := tx.Commit(ctx); err != nil {
-		c.JSON(500, gin.H{"error": "Commit failed"})
+	if err != nil {
+		apperr.Write(c, err)
 		return
 	}
 
-	c.JSON(201, gin.H{"message": "Loan issued", "contractId": newContractID})
+	c.JSON(201, gin.H{"message": "Loan application created", "contractId": newContractID, "status": models.LoanDraft})
+}
+
+// loanStatuses - значения contract_status.
+var loanStatuses = []string{
+	models.LoanDraft, models.LoanReview, models.LoanApproved, models.LoanRejected,
+	models.LoanSigned, models.LoanActive, models.LoanClosed, models.LoanDefaulted,
 }
 
 func (h *HandlerDriver) GetLoans(c *gin.Context) {
-	rows, err := h.db.Query(c.Request.Context(), "SELECT * FROM fn_get_all_loans()")
-	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+	q := newListQuery(c)
+	filter := repository.LoanFilter{
+		Status:     q.oneOf("status", loanStatuses),
+		ProductID:  int(q.id("productId")),
+		ClientID:   q.id("clientId"),
+		EmployeeID: q.id("employeeId"),
+		From:       q.date("from"),
+		To:         q.date("to"),
+	}
+	page := q.page(repository.LoanSorts)
+	if err := q.err(); err != nil {
+		apperr.Write(c, err)
 		return
 	}
-	defer rows.Close()
-
-	var loans []gin.H
-	for rows.Next() {
-		var id int64
-		var contractNum, status, clientName, prodName string
-		var amount, balance int64
-		var rate float64
-		var startDate time.Time
-		var term int
-
-		err := rows.Scan(&id, &contractNum, &amount, &status, &startDate, &rate, &term, &balance, &clientName, &prodName)
-		if err != nil {
-			continue
-		}
 
-		loans = append(loans, gin.H{
-			"id":             id,
-			"contractNumber": contractNum,
-			"amount":         float64(amount) / 100.0,
-			"status":         status,
-			"startDate":      startDate,
-			"clientName":     clientName,
-			"productName":    prodName,
-			"interestRate":   rate,
-			"termMonths":     term,
-			"balance":        float64(balance) / 100.0,
-		})
+	loans, err := h.store.Loans().List(c.Request.Context(), filter, page)
+	if err != nil {
+		apperr.Write(c, err)
+		return
 	}
 
-	if loans == nil {
-		loans = []gin.H{}
-	}
-	c.JSON(200, loans)
+	c.JSON(200, newPageResponse(loans, page))
 }
 
 func (h *HandlerDriver) GetSchedule(c *gin.Context) {
-	contractID := c.Param("id")
-
-	rows, err := h.db.Query(c.Request.Context(), "SELECT * FROM fn_get_repayment_schedule($1)", contractID)
+	contractID, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		apperr.Write(c, apperr.BadRequest("Неверный id договора"))
 		return
 	}
-	defer rows.Close()
 
-	var schedule []gin.H
-	for rows.Next() {
-		var id int64
-		var paymentDate time.Time
-		var paymentAmount, principal, interest, balance int64
-		var isPaid bool
-
-		if err := rows.Scan(&id, &paymentDate, &paymentAmount, &principal, &interest, &balance, &isPaid); err != nil {
-			continue
-		}
-
-		schedule = append(schedule, gin.H{
-			"id":               id,
-			"paymentDate":      paymentDate,
-			"paymentAmount":    float64(paymentAmount) / 100.0,
-			"principal":        float64(principal) / 100.0,
-			"interest":         float64(interest) / 100.0,
-			"remainingBalance": float64(balance) / 100.0,
-			"isPaid":           isPaid,
-		})
+	if err := h.checkContractAccess(c, contractID); err != nil {
+		apperr.Write(c, err)
+		return
 	}
 
-	if schedule == nil {
-		schedule = []gin.H{}
+	schedule, err := h.store.Schedules().ForContract(c.Request.Context(), contractID)
+	if errors.Is(err, repository.ErrNotFound) {
+		apperr.Write(c, errContractNotFound)
+		return
 	}
-	c.JSON(200, schedule)
-}
-
-func (h *HandlerDriver) GetEmployeesHandler(c *gin.Context) {
-	rows, err := h.db.Query(c.Request.Context(), "SELECT * FROM fn_get_all_employees()")
 	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		apperr.Write(c, err)
 		return
 	}
-	defer rows.Close()
 
-	var employees []gin.H
-	for rows.Next() {
-		var id int64
-		var fn, ln, pos, login, role string
-		if err := rows.Scan(&id, &fn, &ln, &pos, &login, &role); err != nil {
-			log.Println("Scan error:", err)
-			continue
-		}
-		employees = append(employees, gin.H{
-			"id": id, "firstName": fn, "lastName": ln,
-			"position": pos, "login": login, "role": role,
-		})
-	}
-
-	if employees == nil {
-		employees = []gin.H{}
-	}
-	c.JSON(200, employees)
+	c.JSON(200, schedule)
 }
 
-func (h *HandlerDriver) GetLogsHandler(c *gin.Context) {
-	action := c.Query("action")
-	fromDate := c.Query("from")
+var errNoEmployee = apperr.New(http.StatusForbidden, "employee_not_found", "Учетная запись не привязана к сотруднику")
 
-	rows, err := h.db.Query(c.Request.Context(), "SELECT * FROM fn_get_audit_logs($1, $2)", action, fromDate)
-	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+// ResolveEmployee находит сотрудника по учетной записи из токена и кладет
+// его id в контекст как employeeId. Запросы клиентов пропускает без
+// изменений.
+func (h *HandlerDriver) ResolveEmployee(c *gin.Context) {
+	if !slices.Contains(auth.Staff, c.GetString("role")) {
+		c.Next()
 		return
 	}
-	defer rows.Close()
 
-	var logs []gin.H
-	for rows.Next() {
-		var id, entID int64
-		var act, ent string
-		var details map[string]interface{}
-		var ts time.Time
-		var login, fn, ln *string
-
-		err := rows.Scan(&id, &act, &ent, &entID, &ts, &details, &login, &fn, &ln)
-		if err != nil {
-			log.Printf("Scan error: %v", err)
-			continue
-		}
+	employeeID, err := h.store.Employees().ByUser(c.Request.Context(), c.GetInt64("userId"))
+	if errors.Is(err, repository.ErrNotFound) {
+		apperr.Write(c, errNoEmployee)
+		return
+	}
+	if err != nil {
+		apperr.Write(c, err)
+		return
+	}
 
-		userName := "Система/Неизвестный"
-		if login != nil {
-			userName = *login
-			if fn != nil && ln != nil {
-				userName = fmt.Sprintf("%s %s (%s)", *ln, *fn, *login)
-			}
-		}
+	c.Set("employeeId", employeeID)
+	c.Next()
+}
 
-		logs = append(logs, gin.H{
-			"id":       id,
-			"action":   act,
-			"entity":   ent,
-			"entityId": entID,
-			"date":     ts,
-			"details":  details,
-			"user":     userName,
-		})
+func (h *HandlerDriver) GetEmployeesHandler(c *gin.Context) {
+	q := newListQuery(c)
+	filter := repository.EmployeeFilter{
+		Role: q.oneOf("role", []string{"admin", "manager"}),
 	}
-
-	if logs == nil {
-		logs = []gin.H{}
+	page := q.page(repository.EmployeeSorts)
+	if err := q.err(); err != nil {
+		apperr.Write(c, err)
+		return
 	}
-	c.JSON(200, logs)
-}
 
-func (h *HandlerDriver) CreateBackupHandler(c *gin.Context) {
-	role, exists := c.Get("role")
-	if !exists || role != "admin" {
-		c.JSON(403, gin.H{"error": "Access denied"})
+	employees, err := h.store.Employees().List(c.Request.Context(), filter, page)
+	if err != nil {
+		apperr.Write(c, err)
 		return
 	}
 
-	ctx := c.Request.Context()
+	c.JSON(200, newPageResponse(employees, page))
+}
 
-	filename, err := backup.PerformBackup()
-	if err != nil {
-		log.Printf("Backup handler error: %v", err)
-		c.JSON(500, gin.H{"error": "Backup failed", "details": err.Error()})
+func (h *HandlerDriver) GetLogsHandler(c *gin.Context) {
+	q := newListQuery(c)
+	filter := repository.AuditFilter{
+		Action: q.str("action"),
+		Entity: q.str("entity"),
+		UserID: q.id("userId"),
+		From:   q.timestamp("from", false),
+		To:     q.timestamp("to", true),
+	}
+	page := q.page(repository.AuditSorts)
+	if err := q.err(); err != nil {
+		apperr.Write(c, err)
 		return
 	}
 
-	tx, err := h.db.Begin(ctx)
+	logs, err := h.store.Audit().List(c.Request.Context(), filter, page)
 	if err != nil {
-		log.Printf("Failed to create transaction in log creating: %v", err)
-		c.JSON(500, gin.H{"error": "Backup failed", "details": err.Error()})
+		apperr.Write(c, err)
+		return
 	}
 
-	userId, _ := c.Get("userId")
-	LogAction(context.Background(), tx, userId.(int64), "BACKUP_DB", "system", 0, map[string]string{"file": filename})
+	c.JSON(200, newPageResponse(logs, page))
+}
 
-	if err := tx.Commit(ctx); err != nil {
-		c.JSON(500, gin.H{"error": "Commit failed"})
+func (h *HandlerDriver) CreateBackupHandler(c *gin.Context) {
+	filename, err := h.backups.PerformBackup()
+	if err != nil {
+		apperr.Write(c, apperr.Internal("Не удалось создать резервную копию").Wrap(err))
 		return
 	}
 
+	LogAction(context.Background(), h.store.Audit(), c.GetInt64("userId"), "BACKUP_DB", "system", 0, map[string]string{"file": filename})
+
 	c.JSON(200, gin.H{
 		"message": "Backup created successfully",
 		"file":    filename,
@@ -526,254 +537,244 @@ func (h *HandlerDriver) CreateBackupHandler(c *gin.Context) {
 }
 
 func (h *HandlerDriver) GetMyLoansHandler(c *gin.Context) {
-	userID, _ := c.Get("userId")
-
-	rows, err := h.db.Query(c.Request.Context(), "SELECT * FROM fn_get_client_loans($1)", userID)
+	loans, err := h.store.Loans().ListForUser(c.Request.Context(), c.GetInt64("userId"))
 	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		apperr.Write(c, err)
 		return
 	}
-	defer rows.Close()
-
-	var loans []gin.H
-	for rows.Next() {
-		var id int64
-		var num, status, prodName string
-		var amount, balance int64
-		var date time.Time
-		var paid, total int64
-
-		err := rows.Scan(&id, &num, &amount, &status, &date, &balance, &prodName, &paid, &total)
-		if err != nil {
-			log.Println("Scan error:", err)
-			continue
-		}
 
-		loans = append(loans, gin.H{
-			"id":             id,
-			"contractNumber": num,
-			"amount":         float64(amount) / 100.0,
-			"status":         status,
-			"startDate":      date,
-			"productName":    prodName,
-			"balance":        float64(balance) / 100.0,
-			"progress":       fmt.Sprintf("%d/%d", paid, total),
-		})
-	}
-
-	if loans == nil {
-		loans = []gin.H{}
-	}
 	c.JSON(200, loans)
 }
 
 func (h *HandlerDriver) MakePaymentHandler(c *gin.Context) {
 	var req models.PaymentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": "Неверный запрос"})
+		apperr.Write(c, apperr.Invalid("Неверный запрос", err))
 		return
 	}
 
-	userID, _ := c.Get("userId")
+	userID := c.GetInt64("userId")
 	ctx := c.Request.Context()
 
-	tx, err := h.db.Begin(ctx)
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Tx error"})
-		return
+	var amount models.Money
+	if req.Amount != nil {
+		amount = *req.Amount
 	}
-	defer tx.Rollback(ctx)
 
-	var contractID int64
-	var paymentAmount float64
+	var paid models.PaymentAllocation
 
-	err = tx.QueryRow(ctx, `
-		SELECT rs.contract_id, rs.payment_amount
-		FROM repayment_schedule rs
-		JOIN loan_contracts lc ON rs.contract_id = lc.id
-		JOIN clients cl ON lc.client_id = cl.id
-		WHERE rs.id = $1 AND cl.user_id = $2
-	`, req.ScheduleID, userID).Scan(&contractID, &paymentAmount)
+	err := h.store.InTx(ctx, func(tx repository.Store) error {
+		if _, err := tx.Schedules().GetForUser(ctx, req.ScheduleID, userID); err != nil {
+			return err
+		}
 
-	if err != nil {
-		c.JSON(403, gin.H{"error": "Платеж не найден или доступ запрещен"})
-		return
-	}
+		var err error
+		paid, err = tx.Schedules().Pay(ctx, req.ScheduleID, amount)
+		if err != nil {
+			return err
+		}
 
-	_, err = tx.Exec(ctx, "CALL sp_make_payment($1)", req.ScheduleID)
+		LogAction(ctx, tx.Audit(), userID, "PAYMENT", "repayment_schedule", req.ScheduleID, map[string]string{
+			"amount":    (paid.Penalty + paid.Interest + paid.Principal).String(),
+			"penalty":   paid.Penalty.String(),
+			"interest":  paid.Interest.String(),
+			"principal": paid.Principal.String(),
+			"method":    "via_stored_procedure",
+		})
+		return nil
+	})
 
-	if err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+	if errors.Is(err, repository.ErrNotFound) {
+		apperr.Write(c, apperr.New(403, "payment_not_found", "Платеж не найден или доступ запрещен"))
 		return
 	}
-
-	LogAction(ctx, tx, userID.(int64), "PAYMENT", "repayment_schedule", req.ScheduleID, map[string]string{
-		"amount": fmt.Sprintf("%.2f", paymentAmount),
-		"method": "via_stored_procedure",
-	})
-
-	if err := tx.Commit(ctx); err != nil {
-		c.JSON(500, gin.H{"error": "Commit failed"})
+	if err != nil {
+		apperr.Write(c, err)
 		return
 	}
 
-	c.JSON(200, gin.H{"message": "Payment successful"})
+	c.JSON(200, gin.H{"message": "Payment successful", "paid": paid})
 }
 
+// EarlyRepaymentHandler вносит досрочный платеж. В ответе - график до и
+// после пересчета, чтобы клиент мог их сравнить.
 func (h *HandlerDriver) EarlyRepaymentHandler(c *gin.Context) {
 	var req models.EarlyRepaymentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid request"})
+		apperr.Write(c, apperr.Invalid("Неверный запрос", err))
 		return
 	}
 
-	userID, _ := c.Get("userId")
+	userID := c.GetInt64("userId")
 	ctx := c.Request.Context()
 
-	tx, err := h.db.Begin(ctx)
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Tx error"})
-		return
+	params := repository.EarlyRepayParams{ContractID: req.ContractID, UserID: userID, Mode: req.Mode}
+	if req.Amount != nil {
+		params.Amount = *req.Amount
 	}
-	defer tx.Rollback(ctx)
 
-	var paidAmount int64
+	var paidAmount models.Money
+	var before, after models.RepaymentSchedule
 
-	err = tx.QueryRow(ctx, "CALL sp_early_repayment($1, $2, NULL)",
-		req.ContractID, userID).Scan(&paidAmount)
+	err := h.store.InTx(ctx, func(tx repository.Store) error {
+		var err error
+		if before, err = tx.Schedules().ForContract(ctx, req.ContractID); err != nil {
+			return err
+		}
 
-	if err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
-		return
-	}
+		paidAmount, err = tx.Loans().EarlyRepay(ctx, params)
+		if err != nil {
+			return err
+		}
 
-	LogAction(ctx, tx, userID.(int64), "EARLY_REPAYMENT", "loan_contracts", req.ContractID, map[string]string{
-		"amount": fmt.Sprintf("%.2f", float64(paidAmount)/100.0),
-		"method": "via_stored_procedure",
+		if after, err = tx.Schedules().ForContract(ctx, req.ContractID); err != nil {
+			return err
+		}
+
+		details := map[string]string{
+			"amount": paidAmount.String(),
+			"method": "via_stored_procedure",
+		}
+		if req.Mode != "" {
+			details["mode"] = req.Mode
+		}
+		LogAction(ctx, tx.Audit(), userID, "EARLY_REPAYMENT", "loan_contracts", req.ContractID, details)
+		return nil
 	})
 
-	if err := tx.Commit(ctx); err != nil {
-		c.JSON(500, gin.H{"error": "Commit failed"})
+	if errors.Is(err, repository.ErrNotFound) {
+		apperr.Write(c, errContractNotFound)
+		return
+	}
+	if err != nil {
+		apperr.Write(c, err)
 		return
 	}
 
+	message := "Кредит погашен досрочно"
+	if len(after.Payments) > 0 && !after.Payments[len(after.Payments)-1].IsPaid {
+		message = "Внесено частичное досрочное погашение, график пересчитан"
+	}
+
 	c.JSON(200, gin.H{
-		"message":    "Кредит погашен досрочно",
-		"paidAmount": float64(paidAmount) / 100.0,
+		"message":    message,
+		"paidAmount": paidAmount,
+		"before":     before,
+		"after":      after,
 	})
 }
 
 func (h *HandlerDriver) GetLoanOperationsHandler(c *gin.Context) {
-	contractID := c.Param("id")
-
-	rows, err := h.db.Query(c.Request.Context(), "SELECT * FROM fn_get_loan_operations($1)", contractID)
+	contractID, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		apperr.Write(c, apperr.BadRequest("Неверный id договора"))
 		return
 	}
-	defer rows.Close()
-
-	var ops []gin.H
-	for rows.Next() {
-		var opType, desc string
-		var amount float64
-		var date time.Time
 
-		if err := rows.Scan(&opType, &amount, &date, &desc); err != nil {
-			continue
-		}
-
-		ops = append(ops, gin.H{
-			"type":   opType,
-			"amount": float64(amount) / 100.0,
-			"date":   date,
-			"desc":   desc,
-		})
+	if err := h.checkContractAccess(c, contractID); err != nil {
+		apperr.Write(c, err)
+		return
 	}
 
-	if ops == nil {
-		ops = []gin.H{}
+	ops, err := h.store.Loans().Operations(c.Request.Context(), contractID)
+	if err != nil {
+		apperr.Write(c, err)
+		return
 	}
+
 	c.JSON(200, ops)
 }
 
 func (h *HandlerDriver) GetStatsHandler(c *gin.Context) {
-	var jsonStats []byte
-
-	err := h.db.QueryRow(c.Request.Context(), "SELECT fn_get_dashboard_stats()").Scan(&jsonStats)
+	stats, err := h.store.Reports().DashboardStats(c.Request.Context())
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to get stats: " + err.Error()})
+		apperr.Write(c, err)
 		return
 	}
 
-	c.Data(200, "application/json", jsonStats)
+	c.JSON(200, stats)
 }
 
 func (h *HandlerDriver) GetFinanceReportHandler(c *gin.Context) {
-	rows, err := h.db.Query(c.Request.Context(), "SELECT * FROM fn_get_financial_report()")
+	report, err := h.store.Reports().FinanceReport(c.Request.Context())
 	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		apperr.Write(c, err)
 		return
 	}
-	defer rows.Close()
-
-	var report []gin.H
-	for rows.Next() {
-		var month string
-		var issued, repaid, net int64
-		rows.Scan(&month, &issued, &repaid, &net)
 
-		report = append(report, gin.H{
-			"month":  month,
-			"issued": float64(issued) / 100.0,
-			"repaid": float64(repaid) / 100.0,
-			"net":    float64(net) / 100.0,
-		})
-	}
-	if report == nil {
-		report = []gin.H{}
-	}
 	c.JSON(200, report)
 }
 
 
-func RefreshHandler(c *gin.Context) {
+func (h *HandlerDriver) RefreshHandler(c *gin.Context) {
 	cookie, err := c.Cookie("refresh_token")
 	if err != nil {
-		c.JSON(401, gin.H{"error": "Refresh token required"})
+		apperr.Write(c, apperr.Unauthorized("Требуется повторный вход"))
 		return
 	}
 
-	claims := &auth.Claims{}
-	token, err := jwt.ParseWithClaims(cookie, claims, func(token *jwt.Token) (interface{}, error) {
-		return auth.JWTKey, nil
-	})
+	ctx := c.Request.Context()
 
-	if err != nil || !token.Valid {
-		c.JSON(401, gin.H{"error": "Invalid refresh token"})
-		return
+	// Смена пароля или деактивация учетной записи обрывают и обновление
+	// сессии, а не только текущие access-токены.
+	if claims, err := h.sessions.ParseToken(cookie); err == nil {
+		if err := h.CheckSession(ctx, claims); err != nil {
+			if err := h.sessions.Revoke(ctx, cookie); err != nil {
+				log.Printf("Refresh revoke failed: %v", err)
+			}
+			auth.ClearSessionCookies(c)
+			apperr.Write(c, apperr.New(401, "invalid_token", "Сессия недействительна, войдите заново"))
+			return
+		}
 	}
 
-	newAccess, _ := auth.GenerateToken(claims.UserID, claims.Role, auth.AccessTokenDuration)
-	newRefresh, _ := auth.GenerateToken(claims.UserID, claims.Role, auth.RefreshTokenDuration)
+	tokens, claims, err := h.sessions.Refresh(ctx, cookie)
+	if errors.Is(err, auth.ErrTokenReused) {
+		LogAction(ctx, h.store.Audit(), claims.UserID, "REFRESH_TOKEN_REUSE", "refresh_tokens", 0, map[string]string{
+			"jti": claims.ID,
+			"ip":  c.ClientIP(),
+		})
+	}
+	if err != nil {
+		auth.ClearSessionCookies(c)
+		apperr.Write(c, apperr.New(401, "invalid_token", "Сессия недействительна, войдите заново"))
+		return
+	}
 
-	c.SetCookie("access_token", newAccess, int(auth.AccessTokenDuration.Seconds()), "/", "", false, true)
-	c.SetCookie("refresh_token", newRefresh, int(auth.RefreshTokenDuration.Seconds()), "/api/refresh", "", false, true)
+	auth.SetSessionCookies(c, tokens)
 
 	c.JSON(200, gin.H{"message": "Tokens refreshed"})
 }
 
 
-func LogoutHandler(c *gin.Context) {
-	c.SetCookie("access_token", "", -1, "/", "", false, true)
-	c.SetCookie("refresh_token", "", -1, "/api/refresh", "", false, true)
+func (h *HandlerDriver) LogoutHandler(c *gin.Context) {
+	if cookie, err := c.Cookie("refresh_token"); err == nil {
+		if err := h.sessions.Revoke(c.Request.Context(), cookie); err != nil {
+			log.Printf("Logout revoke failed: %v", err)
+		}
+	}
+
+	auth.ClearSessionCookies(c)
 	c.JSON(200, gin.H{"message": "Logged out"})
 }
 
 
-func LogAction(ctx context.Context, tx pgx.Tx, userID int64, action string, entity string, entityID int64, details map[string]string) {
-	_, err := tx.Exec(ctx, "CALL sp_audit_log($1, $2, $3, $4, $5)",
-		userID, action, entity, entityID, details)
+func (h *HandlerDriver) AuditDenied(c *gin.Context, userID int64, role string, route string) {
+	LogAction(c.Request.Context(), h.store.Audit(), userID, "ACCESS_DENIED", "routes", 0, map[string]string{
+		"route": route,
+		"role":  role,
+		"ip":    c.ClientIP(),
+	})
+}
+
+func LogAction(ctx context.Context, audit repository.AuditRepository, userID int64, action string, entity string, entityID int64, details map[string]string) {
+	err := audit.Log(ctx, repository.AuditEntry{
+		UserID:   userID,
+		Action:   action,
+		Entity:   entity,
+		EntityID: entityID,
+		Details:  details,
+	})
 
 	if err != nil {
 		log.Printf("AUDIT ERROR: %v", err)
@@ -2,53 +2,297 @@ package handler
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/config"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/auth"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/backup"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/jobs"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/lockout"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/mail"
 	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/password"
 	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository/memory"
 )
 
-func TestRegisterHandlerValidation(t *testing.T) {
+const testAdminPassword = "Adm1n-pass"
+
+// testClientIP - адрес, с которого тестовый клиент шлет запросы.
+const testClientIP = "192.0.2.1"
+
+// testAPI - весь HTTP API поверх хранилища в памяти и клиент с куками.
+type testAPI struct {
+	t       *testing.T
+	r       *gin.Engine
+	store   *memory.Store
+	cookies map[string]*http.Cookie
+}
+
+// newTestAPI поднимает API; opts меняют конфигурацию до сборки
+// обработчиков.
+func newTestAPI(t *testing.T, opts ...func(*config.Config)) *testAPI {
 	gin.SetMode(gin.TestMode)
-	r := gin.Default()
-	
-	r.POST("/register", func(c *gin.Context) {
-		var req models.RegisterRequest
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
-			return
-		}
-		c.Status(200)
+
+	store := memory.NewStore()
+	hash, _ := password.HashPassword(testAdminPassword)
+	store.AddEmployee(auth.RoleAdmin, repository.EmployeeRegistration{
+		Login: "admin", PasswordHash: hash, FirstName: "Иван", LastName: "Петров",
 	})
 
-	reqBody := []byte(`{}`)
-	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/register", bytes.NewBuffer(reqBody))
+	cfg := config.Default()
+	cfg.Auth.JWTKey = "test-key"
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	driver := NewHandlerDriver(cfg.Auth, store,
+		auth.NewSessions(cfg.Auth, auth.NewMemoryRefreshStore()),
+		lockout.NewMemoryLimiter(lockout.DefaultPolicy),
+		mail.NewSender(cfg.Mail),
+		backup.New(cfg.Backup))
+
+	r := gin.New()
+	driver.RegisterRoutes(r, auth.NewAuthorizer(auth.RoutePolicy, driver.AuditDenied))
+
+	return &testAPI{t: t, r: r, store: store, cookies: map[string]*http.Cookie{}}
+}
+
+func (a *testAPI) do(method, path string, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
 	req.Header.Set("Content-Type", "application/json")
-	r.ServeHTTP(w, req)
+	req.RemoteAddr = testClientIP + ":40000"
+	for _, ck := range a.cookies {
+		req.AddCookie(ck)
+	}
 
-	if w.Code != 400 {
+	w := httptest.NewRecorder()
+	a.r.ServeHTTP(w, req)
+
+	// Куки различаются по имени и пути: refresh_token ставится на одном
+	// пути и одновременно удаляется со старого.
+	for _, ck := range w.Result().Cookies() {
+		if ck.MaxAge < 0 {
+			delete(a.cookies, ck.Name+ck.Path)
+		} else {
+			a.cookies[ck.Name+ck.Path] = ck
+		}
+	}
+	return w
+}
+
+// cookie возвращает значение куки name или "".
+func (a *testAPI) cookie(name string) string {
+	for _, ck := range a.cookies {
+		if ck.Name == name {
+			return ck.Value
+		}
+	}
+	return ""
+}
+
+func (a *testAPI) login(login, pwd string) {
+	body, _ := json.Marshal(map[string]string{"login": login, "password": pwd})
+	if w := a.do("POST", "/api/login", string(body)); w.Code != 200 {
+		a.t.Fatalf("login %s: expected 200, got %d: %s", login, w.Code, w.Body)
+	}
+}
+
+// activateLoan проводит заявку через рассмотрение, одобрение и подписание
+// до выдачи. Одобрять может только администратор.
+func (a *testAPI) activateLoan(contractID int64) {
+	for _, step := range []string{"submit", "approve", "sign", "activate"} {
+		path := "/api/loans/" + strconv.FormatInt(contractID, 10) + "/" + step
+		if w := a.do("POST", path, ""); w.Code != 200 {
+			a.t.Fatalf("%s loan: expected 200, got %d: %s", step, w.Code, w.Body)
+		}
+	}
+}
+
+// loginClient активирует учетную запись клиента, как по ссылке из
+// письма, и входит под ней.
+func (a *testAPI) loginClient(clientID int64, pwd string) {
+	ctx := context.Background()
+	target, err := a.store.Users().GetClientActivation(ctx, clientID)
+	if err != nil {
+		a.t.Fatalf("client %d: %v", clientID, err)
+	}
+	token, hash, _ := password.GenerateToken()
+	if err := a.store.Users().CreateActivation(ctx, target.UserID, hash, time.Hour); err != nil {
+		a.t.Fatalf("create activation: %v", err)
+	}
+
+	body, _ := json.Marshal(map[string]string{"token": token, "newPassword": pwd})
+	if w := a.do("POST", "/api/activate", string(body)); w.Code != 200 {
+		a.t.Fatalf("activate %s: expected 200, got %d: %s", target.Login, w.Code, w.Body)
+	}
+	a.login(target.Login, pwd)
+}
+
+func decode[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	var v T
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("bad JSON %q: %v", w.Body, err)
+	}
+	return v
+}
+
+// listPage - ответ постраничного списка.
+type listPage struct {
+	Items      []map[string]any `json:"items"`
+	Total      int              `json:"total"`
+	NextCursor string           `json:"nextCursor"`
+}
+
+func TestRegisterHandlerValidation(t *testing.T) {
+	api := newTestAPI(t)
+	api.login("admin", testAdminPassword)
+
+	if w := api.do("POST", "/api/register", `{}`); w.Code != 400 {
 		t.Errorf("Expected 400 for empty JSON, got %d", w.Code)
 	}
 
-	reqBody = []byte(`{
-		"login": "user1", 
-		"password": "password123", 
-		"firstName": "Test", 
-		"lastName": "User", 
+	w := api.do("POST", "/api/register", `{
+		"login": "user1",
+		"password": "password123",
+		"firstName": "Test",
+		"lastName": "User",
 		"email": "bad-email"
 	}`)
-	w = httptest.NewRecorder()
-	req, _ = http.NewRequest("POST", "/register", bytes.NewBuffer(reqBody))
-	req.Header.Set("Content-Type", "application/json")
-	r.ServeHTTP(w, req)
-
 	if w.Code != 400 {
 		t.Errorf("Expected 400 for bad email, got %d", w.Code)
 	}
+
+	w = api.do("POST", "/api/register", `{
+		"login": "manager1",
+		"password": "password123",
+		"firstName": "Test",
+		"lastName": "User",
+		"email": "manager@bank.ru"
+	}`)
+	if w.Code != 201 {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body)
+	}
+
+	employees := decode[listPage](t, api.do("GET", "/api/employees", ""))
+	if employees.Total != 2 || len(employees.Items) != 2 || employees.Items[0]["login"] != "manager1" {
+		t.Errorf("Unexpected employees list: %v", employees)
+	}
+}
+
+func TestUnauthenticatedRequestRejected(t *testing.T) {
+	api := newTestAPI(t)
+
+	if w := api.do("GET", "/api/clients", ""); w.Code != 401 {
+		t.Errorf("Expected 401 without session, got %d", w.Code)
+	}
+}
+
+func TestIssueLoanFlow(t *testing.T) {
+	api := newTestAPI(t)
+	productID := api.store.AddProduct("Потребительский", 1_000_000, 500_000_000, 3, 60, 12)
+	api.login("admin", testAdminPassword)
+
+	w := api.do("POST", "/api/clients", `{
+		"firstName": "Иван",
+		"lastName": "Распутин",
+		"passportSeries": "4510",
+		"passportNumber": "123456",
+		"dateOfBirth": "1990-05-01",
+		"phone": "+79990001122",
+		"email": "client@example.com"
+	}`)
+	if w.Code != 201 {
+		t.Fatalf("create client: expected 201, got %d: %s", w.Code, w.Body)
+	}
+	created := decode[map[string]any](t, w)
+	if created["login"] != "rasputin.i" {
+		t.Errorf("Expected generated login rasputin.i, got %v", created["login"])
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"clientId": created["id"], "productId": productID, "amount": 100000, "termMonths": 12,
+	})
+	w = api.do("POST", "/api/loans", string(body))
+	if w.Code != 201 {
+		t.Fatalf("issue loan: expected 201, got %d: %s", w.Code, w.Body)
+	}
+	contractID := int64(decode[map[string]any](t, w)["contractId"].(float64))
+	api.activateLoan(contractID)
+
+	schedule := decode[models.RepaymentSchedule](t, api.do("GET", "/api/loans/"+strconv.FormatInt(contractID, 10)+"/schedule", ""))
+	if len(schedule.Payments) != 12 || schedule.ScheduleType != models.ScheduleAnnuity {
+		t.Fatalf("Expected 12 annuity payments, got %+v", schedule)
+	}
+	if last := schedule.Payments[11].RemainingBalance; last != 0 || schedule.Total.Principal != 10_000_000 {
+		t.Errorf("Schedule should repay the whole amount, remaining %v, total %+v", last, schedule.Total)
+	}
+
+	loans := decode[listPage](t, api.do("GET", "/api/loans?status=active", ""))
+	if loans.Total != 1 || loans.Items[0]["balance"] != "100000.00" {
+		t.Errorf("Unexpected loans list: %v", loans)
+	}
+	if closed := decode[listPage](t, api.do("GET", "/api/loans?status=closed", "")); closed.Total != 0 {
+		t.Errorf("Expected no closed loans, got %v", closed)
+	}
+
+	logs := decode[listPage](t, api.do("GET", "/api/logs?action=CREATE_CLIENT", ""))
+	if logs.Total != 1 {
+		t.Errorf("Expected one CREATE_CLIENT audit entry, got %d", logs.Total)
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	api := newTestAPI(t)
+	api.login("admin", testAdminPassword)
+
+	client := `{
+		"firstName": "Иван",
+		"lastName": "Распутин",
+		"passportSeries": "4510",
+		"passportNumber": "123456",
+		"dateOfBirth": "%s",
+		"phone": "+79990001122",
+		"email": "client@example.com"
+	}`
+	if w := api.do("POST", "/api/clients", fmt.Sprintf(client, "1990-05-01")); w.Code != 201 {
+		t.Fatalf("create client: expected 201, got %d: %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"duplicate passport", fmt.Sprintf(client, "1990-05-01"), 409, "passport_taken"},
+		{"underage", strings.Replace(fmt.Sprintf(client, time.Now().AddDate(-10, 0, 0).Format("2006-01-02")), "123456", "654321", 1), 422, "client_underage"},
+		{"binding", `{"firstName": "И"}`, 400, "validation_failed"},
+	}
+	for _, tt := range tests {
+		w := api.do("POST", "/api/clients", tt.body)
+		resp := decode[map[string]any](t, w)
+		if w.Code != tt.status || resp["code"] != tt.code {
+			t.Errorf("%s: expected %d %s, got %d %v", tt.name, tt.status, tt.code, w.Code, resp)
+		}
+		if resp["requestId"] == "" || resp["requestId"] != w.Header().Get("X-Request-ID") {
+			t.Errorf("%s: requestId missing or differs from header: %v", tt.name, resp)
+		}
+		if resp["details"] == nil {
+			t.Errorf("%s: expected details, got %v", tt.name, resp)
+		}
+	}
 }
 
 func TestHashLogic(t *testing.T) {
@@ -62,4 +306,1104 @@ func TestHashLogic(t *testing.T) {
 	if !password.CheckPasswordHash(pwd, hash) {
 		t.Error("Hash verification failed")
 	}
-}
\ No newline at end of file
+}
+
+func TestListPagination(t *testing.T) {
+	api := newTestAPI(t)
+	for i, name := range []string{"Васильев", "Алексеев", "Борисов"} {
+		api.store.AddEmployee(auth.RoleManager, repository.EmployeeRegistration{
+			Login: fmt.Sprintf("manager%d", i), FirstName: "Тест", LastName: name,
+		})
+	}
+	api.login("admin", testAdminPassword)
+
+	var names []string
+	path := "/api/employees?sort=lastName&order=asc&limit=2"
+	for page := 0; ; page++ {
+		w := api.do("GET", path, "")
+		if w.Code != 200 {
+			t.Fatalf("page %d: expected 200, got %d: %s", page, w.Code, w.Body)
+		}
+		list := decode[listPage](t, w)
+		if list.Total != 4 {
+			t.Errorf("page %d: expected total 4, got %d", page, list.Total)
+		}
+		for _, e := range list.Items {
+			names = append(names, e["lastName"].(string))
+		}
+		if list.NextCursor == "" {
+			break
+		}
+		path = "/api/employees?sort=lastName&order=asc&limit=2&cursor=" + list.NextCursor
+	}
+
+	want := "Алексеев Борисов Васильев Петров"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	first := decode[listPage](t, api.do("GET", "/api/employees?sort=lastName&limit=1", ""))
+	w := api.do("GET", "/api/employees?sort=lastName&order=asc&cursor="+first.NextCursor, "")
+	if w.Code != 400 {
+		t.Errorf("Cursor with another order: expected 400, got %d", w.Code)
+	}
+
+	w = api.do("GET", "/api/loans?limit=1000&sort=name&status=lost", "")
+	if w.Code != 400 {
+		t.Fatalf("Bad list params: expected 400, got %d", w.Code)
+	}
+	var resp struct {
+		Code    string `json:"code"`
+		Details []struct {
+			Field string `json:"field"`
+		} `json:"details"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Code != "validation_failed" || len(resp.Details) != 3 {
+		t.Errorf("Expected three invalid params, got %s", w.Body)
+	}
+}
+
+func TestSearchClients(t *testing.T) {
+	api := newTestAPI(t)
+	api.login("admin", testAdminPassword)
+
+	for _, body := range []string{
+		`{"firstName": "Иван", "lastName": "Распутин", "passportSeries": "4510", "passportNumber": "123456",
+		  "dateOfBirth": "1990-05-01", "phone": "+7 (999) 000-11-22", "email": "rasputin@example.com"}`,
+		`{"firstName": "Пётр", "lastName": "Ёлкин", "passportSeries": "4511", "passportNumber": "654321",
+		  "dateOfBirth": "1985-01-10", "phone": "+79995554433", "email": "elkin@example.com"}`,
+	} {
+		if w := api.do("POST", "/api/clients", body); w.Code != 201 {
+			t.Fatalf("create client: expected 201, got %d: %s", w.Code, w.Body)
+		}
+	}
+
+	tests := []struct {
+		query     string
+		lastName  string
+		matchedBy string
+	}{
+		{"распут", "Распутин", "name"},
+		{"елкин петр", "Ёлкин", "name"},
+		{"4510 123456", "Распутин", "passport"},
+		{"8 999 000-11-22", "Распутин", "phone"},
+		{"ELKIN@example.com", "Ёлкин", "email"},
+	}
+	for _, tt := range tests {
+		w := api.do("GET", "/api/clients/search?q="+url.QueryEscape(tt.query), "")
+		if w.Code != 200 {
+			t.Fatalf("%q: expected 200, got %d: %s", tt.query, w.Code, w.Body)
+		}
+		res := decode[listPage](t, w)
+		if len(res.Items) != 1 || res.Items[0]["lastName"] != tt.lastName || res.Items[0]["matchedBy"] != tt.matchedBy {
+			t.Errorf("%q: expected %s by %s, got %v", tt.query, tt.lastName, tt.matchedBy, res.Items)
+		}
+	}
+
+	if w := api.do("GET", "/api/clients/search?q=a", ""); w.Code != 400 {
+		t.Errorf("Too short query: expected 400, got %d", w.Code)
+	}
+}
+
+func TestClientProfile(t *testing.T) {
+	api := newTestAPI(t)
+	productID := api.store.AddProduct("Потребительский", 1_000_000, 500_000_000, 3, 60, 12)
+	api.login("admin", testAdminPassword)
+
+	client := `{"firstName": "Иван", "lastName": "%s", "passportSeries": "4510", "passportNumber": "123456",
+		"dateOfBirth": "1990-05-01", "address": "%s", "phone": "+79990001122", "email": "client@example.com"}`
+	w := api.do("POST", "/api/clients", fmt.Sprintf(client, "Распутин", "Москва"))
+	if w.Code != 201 {
+		t.Fatalf("create client: expected 201, got %d: %s", w.Code, w.Body)
+	}
+	clientID := int64(decode[map[string]any](t, w)["id"].(float64))
+	path := "/api/clients/" + strconv.FormatInt(clientID, 10)
+
+	body, _ := json.Marshal(map[string]any{
+		"clientId": clientID, "productId": productID, "amount": 100000, "termMonths": 12,
+	})
+	w = api.do("POST", "/api/loans", string(body))
+	if w.Code != 201 {
+		t.Fatalf("issue loan: expected 201, got %d: %s", w.Code, w.Body)
+	}
+	api.activateLoan(int64(decode[map[string]any](t, w)["contractId"].(float64)))
+
+	w = api.do("PUT", path, fmt.Sprintf(client, "Распутина", "Москва"))
+	if w.Code != 409 || !strings.Contains(w.Body.String(), `"fields":["lastName"]`) {
+		t.Errorf("Locked field: expected 409 with lastName, got %d: %s", w.Code, w.Body)
+	}
+
+	w = api.do("PUT", path, fmt.Sprintf(client, "Распутин", "Санкт-Петербург"))
+	if w.Code != 200 {
+		t.Fatalf("update address: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	profile := decode[map[string]any](t, w)
+	if profile["address"] != "Санкт-Петербург" || profile["activeContracts"] != 1.0 {
+		t.Errorf("Unexpected profile: %v", profile)
+	}
+
+	if w := api.do("PUT", path+"/active", `{"active": false}`); w.Code != 409 {
+		t.Errorf("Deactivate with active loan: expected 409, got %d: %s", w.Code, w.Body)
+	}
+
+	history := decode[[]map[string]any](t, api.do("GET", path+"/history", ""))
+	if len(history) != 2 || history[0]["action"] != "UPDATE" || history[1]["action"] != "INSERT" {
+		t.Fatalf("Unexpected history: %v", history)
+	}
+	changes := history[0]["changes"].([]any)
+	if len(changes) != 1 || changes[0].(map[string]any)["field"] != "address" ||
+		changes[0].(map[string]any)["new"] != "Санкт-Петербург" {
+		t.Errorf("Unexpected changes: %v", changes)
+	}
+
+	w = api.do("POST", "/api/clients", `{"firstName": "Пётр", "lastName": "Ёлкин", "passportSeries": "4511",
+		"passportNumber": "654321", "dateOfBirth": "1985-01-10", "phone": "+79995554433", "email": "elkin@example.com"}`)
+	other := "/api/clients/" + strconv.FormatInt(int64(decode[map[string]any](t, w)["id"].(float64)), 10)
+	if w := api.do("PUT", other+"/active", `{"active": false}`); w.Code != 200 {
+		t.Fatalf("deactivate: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if p := decode[map[string]any](t, api.do("GET", other, "")); p["isActive"] != false {
+		t.Errorf("Expected deactivated client, got %v", p)
+	}
+
+	if w := api.do("GET", "/api/clients/999", ""); w.Code != 404 {
+		t.Errorf("Unknown client: expected 404, got %d", w.Code)
+	}
+}
+
+func TestProductManagement(t *testing.T) {
+	api := newTestAPI(t)
+	api.login("admin", testAdminPassword)
+
+	product := `{"name": "Потребительский", "minAmount": 10000, "maxAmount": 500000, "minTerm": 3, "maxTerm": 60, "rate": %v}`
+	w := api.do("POST", "/api/products", fmt.Sprintf(product, 12))
+	if w.Code != 201 {
+		t.Fatalf("create product: expected 201, got %d: %s", w.Code, w.Body)
+	}
+	productID := int64(decode[map[string]any](t, w)["id"].(float64))
+	path := "/api/products/" + strconv.FormatInt(productID, 10)
+
+	if w := api.do("POST", "/api/products", fmt.Sprintf(product, 12)); w.Code != 409 {
+		t.Errorf("Duplicate name: expected 409, got %d: %s", w.Code, w.Body)
+	}
+	if w := api.do("POST", "/api/products", `{"name": "Другой", "minAmount": 500, "maxAmount": 100, "minTerm": 3, "maxTerm": 60, "rate": 10}`); w.Code != 400 {
+		t.Errorf("Min above max: expected 400, got %d: %s", w.Code, w.Body)
+	}
+
+	w = api.do("PUT", path, fmt.Sprintf(product, 14.5))
+	if w.Code != 200 {
+		t.Fatalf("update product: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if p := decode[map[string]any](t, w); p["version"] != 2.0 || p["rate"] != 14.5 {
+		t.Errorf("Expected version 2 with rate 14.5, got %v", p)
+	}
+	if p := decode[map[string]any](t, api.do("PUT", path, fmt.Sprintf(product, 14.5))); p["version"] != 2.0 {
+		t.Errorf("Unchanged terms must keep the version, got %v", p)
+	}
+
+	w = api.do("POST", "/api/clients", `{"firstName": "Иван", "lastName": "Распутин", "passportSeries": "4510",
+		"passportNumber": "123456", "dateOfBirth": "1990-05-01", "phone": "+79990001122", "email": "client@example.com"}`)
+	loan, _ := json.Marshal(map[string]any{
+		"clientId": decode[map[string]any](t, w)["id"], "productId": productID, "amount": 100000, "termMonths": 12,
+	})
+	if w := api.do("POST", "/api/loans", string(loan)); w.Code != 201 {
+		t.Fatalf("issue loan: expected 201, got %d: %s", w.Code, w.Body)
+	}
+
+	versions := decode[[]map[string]any](t, api.do("GET", path+"/versions", ""))
+	if len(versions) != 2 || versions[0]["version"] != 2.0 || versions[0]["contracts"] != 1.0 || versions[1]["contracts"] != 0.0 {
+		t.Errorf("Unexpected versions: %v", versions)
+	}
+
+	if w := api.do("PUT", path+"/active", `{"active": false}`); w.Code != 200 {
+		t.Fatalf("deactivate product: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if w := api.do("POST", "/api/loans", string(loan)); w.Code != 422 || !strings.Contains(w.Body.String(), `"field":"productId"`) {
+		t.Errorf("Inactive product: expected 422 with productId, got %d: %s", w.Code, w.Body)
+	}
+	if active := decode[[]map[string]any](t, api.do("GET", "/api/products", "")); len(active) != 0 {
+		t.Errorf("Inactive product must be hidden, got %v", active)
+	}
+	if all := decode[[]map[string]any](t, api.do("GET", "/api/products?all=true", "")); len(all) != 1 {
+		t.Errorf("Expected the inactive product with all=true, got %v", all)
+	}
+
+	if logs := decode[listPage](t, api.do("GET", "/api/logs?entity=credit_products", "")); logs.Total != 3 {
+		t.Errorf("Expected 3 product audit entries, got %d", logs.Total)
+	}
+}
+
+func TestProductPartialUpdate(t *testing.T) {
+	api := newTestAPI(t)
+	api.login("admin", testAdminPassword)
+
+	w := api.do("POST", "/api/products", `{"name": "Ипотечный", "minAmount": 10000, "maxAmount": 5000000, "minTerm": 12,
+		"maxTerm": 360, "rate": 9, "scheduleType": "differentiated", "penaltyRate": 0.1, "penaltyCap": 5}`)
+	if w.Code != 201 {
+		t.Fatalf("create product: expected 201, got %d: %s", w.Code, w.Body)
+	}
+	path := "/api/products/" + strconv.FormatInt(int64(decode[map[string]any](t, w)["id"].(float64)), 10)
+
+	// Без scheduleType и пеней меняется только ставка.
+	product := `{"name": "Ипотечный", "minAmount": 10000, "maxAmount": 5000000, "minTerm": 12, "maxTerm": 360, "rate": 10%s}`
+	w = api.do("PUT", path, fmt.Sprintf(product, ""))
+	if w.Code != 200 {
+		t.Fatalf("update product: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if p := decode[map[string]any](t, w); p["version"] != 2.0 || p["rate"] != 10.0 ||
+		p["scheduleType"] != "differentiated" || p["penaltyRate"] != 0.1 || p["penaltyCap"] != 5.0 {
+		t.Errorf("Omitted fields must keep the current terms, got %v", p)
+	}
+
+	// Явный ноль отключает пени.
+	w = api.do("PUT", path, fmt.Sprintf(product, `, "penaltyRate": 0`))
+	if p := decode[map[string]any](t, w); w.Code != 200 || p["penaltyRate"] != 0.0 || p["penaltyCap"] != 5.0 {
+		t.Errorf("Explicit zero penalty rate: got %d: %v", w.Code, p)
+	}
+
+	if w := api.do("PUT", "/api/products/999", fmt.Sprintf(product, "")); w.Code != 404 {
+		t.Errorf("Unknown product: expected 404, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestScheduleTypes(t *testing.T) {
+	api := newTestAPI(t)
+	api.login("admin", testAdminPassword)
+
+	w := api.do("POST", "/api/clients", `{"firstName": "Иван", "lastName": "Распутин", "passportSeries": "4510",
+		"passportNumber": "123456", "dateOfBirth": "1990-05-01", "phone": "+79990001122", "email": "client@example.com"}`)
+	clientID := decode[map[string]any](t, w)["id"]
+
+	product := `{"name": "%s", "minAmount": 1000, "maxAmount": 5000000, "minTerm": 3, "maxTerm": 360, "rate": 12, "scheduleType": "%s"}`
+	if w := api.do("POST", "/api/products", fmt.Sprintf(product, "Неизвестный", "balloon")); w.Code != 400 {
+		t.Errorf("Unknown schedule type: expected 400, got %d: %s", w.Code, w.Body)
+	}
+
+	// 120 000 на 12 месяцев под 12% годовых: 1% в месяц.
+	schedules := map[string]models.RepaymentSchedule{}
+	for _, typ := range []string{models.ScheduleAnnuity, models.ScheduleDifferentiated, models.ScheduleBullet} {
+		w := api.do("POST", "/api/products", fmt.Sprintf(product, typ, typ))
+		if w.Code != 201 {
+			t.Fatalf("create %s product: expected 201, got %d: %s", typ, w.Code, w.Body)
+		}
+		body, _ := json.Marshal(map[string]any{
+			"clientId": clientID, "productId": decode[map[string]any](t, w)["id"], "amount": "120000", "termMonths": 12,
+		})
+		w = api.do("POST", "/api/loans", string(body))
+		if w.Code != 201 {
+			t.Fatalf("issue %s loan: expected 201, got %d: %s", typ, w.Code, w.Body)
+		}
+		contractID := int64(decode[map[string]any](t, w)["contractId"].(float64))
+		api.activateLoan(contractID)
+
+		s := decode[models.RepaymentSchedule](t, api.do("GET", "/api/loans/"+strconv.FormatInt(contractID, 10)+"/schedule", ""))
+		if s.ScheduleType != typ || len(s.Payments) != 12 || s.Total.Principal != 12_000_000 ||
+			s.Total.Payment != s.Total.Principal+s.Total.Interest || s.Payments[11].RemainingBalance != 0 {
+			t.Fatalf("Unexpected %s schedule: %+v", typ, s)
+		}
+		schedules[typ] = s
+	}
+
+	diff := schedules[models.ScheduleDifferentiated]
+	if first, last := diff.Payments[0], diff.Payments[11]; first.PrincipalAmount != 1_000_000 ||
+		first.InterestAmount != 120_000 || last.InterestAmount != 10_000 || diff.Total.Interest != 780_000 {
+		t.Errorf("Unexpected differentiated schedule: first %+v, last %+v, total %+v", first, last, diff.Total)
+	}
+
+	bullet := schedules[models.ScheduleBullet]
+	if first, last := bullet.Payments[0], bullet.Payments[11]; first.PaymentAmount != 120_000 ||
+		first.PrincipalAmount != 0 || last.PaymentAmount != 12_120_000 || bullet.Total.Interest != 1_440_000 {
+		t.Errorf("Unexpected bullet schedule: first %+v, last %+v, total %+v", first, last, bullet.Total)
+	}
+
+	annuity := schedules[models.ScheduleAnnuity]
+	if annuity.Total.Interest <= diff.Total.Interest || annuity.Total.Interest >= bullet.Total.Interest {
+		t.Errorf("Annuity interest %v should be between differentiated and bullet", annuity.Total.Interest)
+	}
+
+	stats := decode[models.DashboardStats](t, api.do("GET", "/api/stats", ""))
+	if want := annuity.Total.Interest + diff.Total.Interest + bullet.Total.Interest; stats.TotalInterest != want {
+		t.Errorf("Dashboard interest %v, schedules %v", stats.TotalInterest, want)
+	}
+}
+
+func TestPartialEarlyRepayment(t *testing.T) {
+	api := newTestAPI(t)
+	productID := api.store.AddProduct("Потребительский", 1_000_000, 500_000_000, 3, 60, 12)
+	api.login("admin", testAdminPassword)
+
+	w := api.do("POST", "/api/clients", `{"firstName": "Иван", "lastName": "Распутин", "passportSeries": "4510",
+		"passportNumber": "123456", "dateOfBirth": "1990-05-01", "phone": "+79990001122", "email": "client@example.com"}`)
+	clientID := decode[map[string]any](t, w)["id"]
+
+	w = api.do("POST", "/api/products", `{"name": "Инвестиционный", "minAmount": 1000, "maxAmount": 5000000,
+		"minTerm": 3, "maxTerm": 60, "rate": 12, "scheduleType": "bullet"}`)
+	bulletID := decode[map[string]any](t, w)["id"]
+
+	issue := func(productID any) int64 {
+		body, _ := json.Marshal(map[string]any{"clientId": clientID, "productId": productID, "amount": "120000", "termMonths": 12})
+		w := api.do("POST", "/api/loans", string(body))
+		if w.Code != 201 {
+			t.Fatalf("issue loan: expected 201, got %d: %s", w.Code, w.Body)
+		}
+		contractID := int64(decode[map[string]any](t, w)["contractId"].(float64))
+		api.activateLoan(contractID)
+		return contractID
+	}
+	contractID, bulletContractID := issue(productID), issue(bulletID)
+
+	body, _ := json.Marshal(map[string]any{"clientId": clientID, "productId": productID, "amount": "120000", "termMonths": 12})
+	rejectedID := int64(decode[map[string]any](t, api.do("POST", "/api/loans", string(body)))["contractId"].(float64))
+	rejectedPath := "/api/loans/" + strconv.FormatInt(rejectedID, 10)
+	api.do("POST", rejectedPath+"/submit", "")
+	if w := api.do("POST", rejectedPath+"/reject", `{"reason": "Недостаточный доход"}`); w.Code != 200 {
+		t.Fatalf("reject: expected 200, got %d: %s", w.Code, w.Body)
+	}
+
+	api.loginClient(int64(clientID.(float64)), "Client-pass1")
+
+	type result struct {
+		PaidAmount models.Money             `json:"paidAmount"`
+		Before     models.RepaymentSchedule `json:"before"`
+		After      models.RepaymentSchedule `json:"after"`
+	}
+	repay := func(contractID int64, amount, mode string) *httptest.ResponseRecorder {
+		req := map[string]any{"contractId": contractID}
+		if amount != "" {
+			req["amount"] = amount
+		}
+		if mode != "" {
+			req["mode"] = mode
+		}
+		body, _ := json.Marshal(req)
+		return api.do("POST", "/api/repay-early", string(body))
+	}
+
+	// 30 000 из 120 000: при прежнем платеже 10 661.85 хватает 9 месяцев.
+	w = repay(contractID, "30000", models.RepayReduceTerm)
+	if w.Code != 200 {
+		t.Fatalf("reduce term: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	res := decode[result](t, w)
+	before, after := res.Before, res.After
+	if res.PaidAmount != 3_000_000 || len(before.Payments) != 12 || len(after.Payments) != 9 ||
+		after.Total.Principal != 9_000_000 || after.Payments[8].RemainingBalance != 0 {
+		t.Fatalf("Unexpected term reduction: %+v", res)
+	}
+	for i, p := range after.Payments {
+		if p.PaymentAmount > before.Payments[0].PaymentAmount || !p.PaymentDate.Equal(before.Payments[i].PaymentDate) {
+			t.Errorf("Payment %d %+v should keep the date and not exceed %v", i, p, before.Payments[0].PaymentAmount)
+		}
+	}
+
+	w = repay(contractID, "10000", models.RepayReducePayment)
+	if w.Code != 200 {
+		t.Fatalf("reduce payment: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	res = decode[result](t, w)
+	if after := res.After; len(after.Payments) != 9 || after.Total.Principal != 8_000_000 ||
+		after.Payments[0].PaymentAmount >= res.Before.Payments[0].PaymentAmount {
+		t.Fatalf("Unexpected payment reduction: %+v", res)
+	}
+
+	ops := decode[[]models.LoanOperation](t, api.do("GET", "/api/loans/"+strconv.FormatInt(contractID, 10)+"/operations", ""))
+	if len(ops) < 2 || ops[0].Description != "Частичное погашение, уменьшение платежа" ||
+		ops[1].Description != "Частичное погашение, сокращение срока" || ops[1].Amount != 3_000_000 {
+		t.Errorf("Unexpected operations: %+v", ops)
+	}
+
+	for _, tc := range []struct {
+		name, amount, mode string
+		contractID         int64
+		code               int
+		errCode            string
+	}{
+		{"no mode", "1000", "", contractID, 400, "validation_failed"},
+		{"over balance", "80000.01", models.RepayReduceTerm, contractID, 422, "invalid_repayment_amount"},
+		{"bullet term", "1000", models.RepayReduceTerm, bulletContractID, 422, "invalid_repayment_mode"},
+	} {
+		w := repay(tc.contractID, tc.amount, tc.mode)
+		if got := decode[map[string]any](t, w)["code"]; w.Code != tc.code || got != tc.errCode {
+			t.Errorf("%s: expected %d %s, got %d: %s", tc.name, tc.code, tc.errCode, w.Code, w.Body)
+		}
+	}
+
+	w = repay(bulletContractID, "20000", models.RepayReducePayment)
+	if res := decode[result](t, w); w.Code != 200 || len(res.After.Payments) != 12 ||
+		res.After.Payments[0].InterestAmount != 100_000 || res.After.Payments[11].PrincipalAmount != 10_000_000 {
+		t.Errorf("Unexpected bullet recalculation: %d %s", w.Code, w.Body)
+	}
+
+	w = repay(contractID, "", "")
+	if res := decode[result](t, w); w.Code != 200 || res.PaidAmount != 8_000_000 {
+		t.Fatalf("full repayment: expected 200 and 80000.00, got %d: %s", w.Code, w.Body)
+	}
+	loans := decode[[]models.ClientLoan](t, api.do("GET", "/api/my-loans", ""))
+	i := slices.IndexFunc(loans, func(l models.ClientLoan) bool { return l.ID == contractID })
+	if i < 0 || loans[i].Status != "closed" || loans[i].Balance != 0 {
+		t.Errorf("Expected closed loan %d, got %+v", contractID, loans)
+	}
+
+	// Закрытый и отклоненный договоры досрочно не погашаются.
+	for _, id := range []int64{contractID, rejectedID} {
+		w := repay(id, "", "")
+		if got := decode[map[string]any](t, w)["code"]; w.Code != 409 || got != "invalid_loan_transition" {
+			t.Errorf("Contract %d: expected 409 invalid_loan_transition, got %d: %s", id, w.Code, w.Body)
+		}
+	}
+}
+
+func TestPenalties(t *testing.T) {
+	api := newTestAPI(t)
+	api.login("admin", testAdminPassword)
+
+	product := `{"name": "Потребительский", "minAmount": 1000, "maxAmount": 5000000, "minTerm": 3, "maxTerm": 60,
+		"rate": 12, "penaltyRate": %v, "penaltyCap": 5}`
+	if w := api.do("POST", "/api/products", fmt.Sprintf(product, 150)); w.Code != 400 {
+		t.Errorf("Penalty rate 150%%: expected 400, got %d: %s", w.Code, w.Body)
+	}
+	w := api.do("POST", "/api/products", fmt.Sprintf(product, 0.1))
+	if w.Code != 201 {
+		t.Fatalf("create product: expected 201, got %d: %s", w.Code, w.Body)
+	}
+	productID := decode[map[string]any](t, w)["id"]
+
+	w = api.do("POST", "/api/clients", `{"firstName": "Иван", "lastName": "Распутин", "passportSeries": "4510",
+		"passportNumber": "123456", "dateOfBirth": "1990-05-01", "phone": "+79990001122", "email": "client@example.com"}`)
+	clientID := decode[map[string]any](t, w)["id"]
+
+	body, _ := json.Marshal(map[string]any{"clientId": clientID, "productId": productID, "amount": "120000", "termMonths": 12})
+	contractID := int64(decode[map[string]any](t, api.do("POST", "/api/loans", string(body)))["contractId"].(float64))
+	api.activateLoan(contractID)
+
+	schedulePath := "/api/loans/" + strconv.FormatInt(contractID, 10) + "/schedule"
+	schedule := decode[models.RepaymentSchedule](t, api.do("GET", schedulePath, ""))
+	first := schedule.Payments[0]
+
+	// Платеж 10 661.85, пени 0.1% в день - 10.66, за 10 дней - 106.60.
+	accrue := jobs.AccruePenalties(api.store)
+	for range 2 {
+		if err := accrue(context.Background(), first.PaymentDate.AddDate(0, 0, 10)); err != nil {
+			t.Fatalf("accrue penalties: %v", err)
+		}
+	}
+
+	schedule = decode[models.RepaymentSchedule](t, api.do("GET", schedulePath, ""))
+	first = schedule.Payments[0]
+	if first.Penalty != 10_660 || schedule.Total.Penalty != 10_660 || first.Due != first.PaymentAmount+10_660 {
+		t.Fatalf("Unexpected penalty after 10 days: %+v, total %+v", first, schedule.Total)
+	}
+
+	ops := decode[[]models.LoanOperation](t, api.do("GET", "/api/loans/"+strconv.FormatInt(contractID, 10)+"/operations", ""))
+	if op := ops[0]; op.Type != "penalty" || op.Amount != 10_660 ||
+		op.Description != "Пени за 10 дн. просрочки платежа от "+first.PaymentDate.Format("02.01.2006") {
+		t.Errorf("Unexpected penalty operation: %+v", ops)
+	}
+
+	api.loginClient(int64(clientID.(float64)), "Client-pass1")
+
+	if w := api.do("POST", "/api/repay-early", fmt.Sprintf(`{"contractId": %d}`, contractID)); w.Code != 409 {
+		t.Errorf("Early repayment with penalty: expected 409, got %d: %s", w.Code, w.Body)
+	}
+
+	pay := func(amount string) *httptest.ResponseRecorder {
+		req := map[string]any{"scheduleId": first.ID}
+		if amount != "" {
+			req["amount"] = amount
+		}
+		body, _ := json.Marshal(req)
+		return api.do("POST", "/api/pay", string(body))
+	}
+	paid := func(w *httptest.ResponseRecorder) models.PaymentAllocation {
+		if w.Code != 200 {
+			t.Fatalf("pay: expected 200, got %d: %s", w.Code, w.Body)
+		}
+		return decode[struct {
+			Paid models.PaymentAllocation `json:"paid"`
+		}](t, w).Paid
+	}
+
+	if w := pay("20000"); w.Code != 422 || decode[map[string]any](t, w)["code"] != "invalid_payment_amount" {
+		t.Errorf("Overpayment: expected 422 invalid_payment_amount, got %d: %s", w.Code, w.Body)
+	}
+
+	// Порядок погашения: пени, проценты (1 200.00), основной долг.
+	for _, tc := range []struct {
+		amount string
+		want   models.PaymentAllocation
+	}{
+		{"100", models.PaymentAllocation{Penalty: 10_000}},
+		{"1000", models.PaymentAllocation{Penalty: 660, Interest: 99_340}},
+		{"", models.PaymentAllocation{Interest: 20_660, Principal: first.PrincipalAmount}},
+	} {
+		if got := paid(pay(tc.amount)); got != tc.want {
+			t.Errorf("Pay %q: expected %+v, got %+v", tc.amount, tc.want, got)
+		}
+	}
+
+	schedule = decode[models.RepaymentSchedule](t, api.do("GET", schedulePath, ""))
+	if p := schedule.Payments[0]; !p.IsPaid || p.Due != 0 || p.PenaltyPaid != 10_660 {
+		t.Errorf("First payment should be settled: %+v", p)
+	}
+	loans := decode[[]models.ClientLoan](t, api.do("GET", "/api/my-loans", ""))
+	if want := 12_000_000 - first.PrincipalAmount; len(loans) != 1 || loans[0].Balance != want {
+		t.Errorf("Expected balance %v, got %+v", want, loans)
+	}
+
+	// Предел - 5% платежа: 533.09 вместо 1 066.00 за 100 дней.
+	second := schedule.Payments[1]
+	if err := accrue(context.Background(), second.PaymentDate.AddDate(0, 0, 100)); err != nil {
+		t.Fatalf("accrue penalties: %v", err)
+	}
+	schedule = decode[models.RepaymentSchedule](t, api.do("GET", schedulePath, ""))
+	if p := schedule.Payments[1]; p.Penalty != 53_309 {
+		t.Errorf("Penalty should stop at the cap 533.09, got %v", p.Penalty)
+	}
+}
+
+func TestDelinquency(t *testing.T) {
+	api := newTestAPI(t)
+	productID := api.store.AddProduct("Потребительский", 1_000_000, 500_000_000, 3, 60, 12)
+	api.login("admin", testAdminPassword)
+
+	w := api.do("POST", "/api/clients", `{"firstName": "Иван", "lastName": "Распутин", "passportSeries": "4510",
+		"passportNumber": "123456", "dateOfBirth": "1990-05-01", "phone": "+79990001122", "email": "client@example.com"}`)
+	clientID := int64(decode[map[string]any](t, w)["id"].(float64))
+
+	body := fmt.Sprintf(`{"clientId": %d, "productId": %d, "amount": "120000", "termMonths": 12}`, clientID, productID)
+	contractID := int64(decode[map[string]any](t, api.do("POST", "/api/loans", body))["contractId"].(float64))
+	api.activateLoan(contractID)
+
+	path := "/api/loans/" + strconv.FormatInt(contractID, 10)
+	schedule := decode[models.RepaymentSchedule](t, api.do("GET", path+"/schedule", ""))
+	due := schedule.Payments[0].PaymentDate
+
+	process := jobs.ProcessDelinquency(api.store, 90)
+	run := func(days int) {
+		t.Helper()
+		if err := process(context.Background(), due.AddDate(0, 0, days)); err != nil {
+			t.Fatalf("process delinquency: %v", err)
+		}
+	}
+	buckets := func() map[string]int {
+		t.Helper()
+		stats := decode[models.DashboardStats](t, api.do("GET", "/api/stats", ""))
+		counts := map[string]int{}
+		for _, b := range stats.Delinquency {
+			counts[b.Label] = b.Value
+		}
+		if len(stats.Delinquency) != len(models.DelinquencyBuckets) {
+			t.Errorf("Expected all buckets in stats, got %+v", stats.Delinquency)
+		}
+		return counts
+	}
+	status := func() string {
+		t.Helper()
+		loans := decode[listPage](t, api.do("GET", "/api/loans", ""))
+		if len(loans.Items) != 1 {
+			t.Fatalf("Unexpected loans: %v", loans.Items)
+		}
+		return loans.Items[0]["status"].(string)
+	}
+	audit := func(action string) int {
+		t.Helper()
+		return decode[listPage](t, api.do("GET", "/api/logs?action="+action, "")).Total
+	}
+
+	run(0)
+	if audit("DELINQUENCY_CHANGED") != 0 || buckets()["1-30"] != 0 {
+		t.Errorf("Payment due today is not overdue")
+	}
+
+	for range 2 {
+		run(10)
+	}
+	if audit("DELINQUENCY_CHANGED") != 1 || buckets()["1-30"] != 1 || status() != models.LoanActive {
+		t.Errorf("10 days past due: expected one change into 1-30, got %d", audit("DELINQUENCY_CHANGED"))
+	}
+
+	run(95)
+	if status() != models.LoanDefaulted || audit("LOAN_DEFAULTED") != 1 || buckets()["61-90"] != 0 || buckets()["90+"] != 1 {
+		t.Errorf("95 days past due: expected defaulted in 90+, got %s", status())
+	}
+	transitions := decode[[]map[string]any](t, api.do("GET", path+"/transitions", ""))
+	if last := transitions[len(transitions)-1]; last["to"] != "defaulted" || last["reason"] != "Просрочка 95 дн." {
+		t.Errorf("Unexpected transition: %v", last)
+	}
+
+	api.loginClient(clientID, "Client-pass1")
+	for _, p := range schedule.Payments {
+		if p.PaymentDate.Before(due.AddDate(0, 0, 95)) {
+			if w := api.do("POST", "/api/pay", fmt.Sprintf(`{"scheduleId": %d}`, p.ID)); w.Code != 200 {
+				t.Fatalf("pay %d: expected 200, got %d: %s", p.ID, w.Code, w.Body)
+			}
+		}
+	}
+
+	api.login("admin", testAdminPassword)
+	run(95)
+	if status() != models.LoanActive || audit("LOAN_CURED") != 1 || buckets()["90+"] != 0 {
+		t.Errorf("Cured contract should return to active, got %s", status())
+	}
+}
+
+// reminderRecorder запоминает напоминания вместо отправки; disabled
+// имитирует выключенную почту.
+type reminderRecorder struct {
+	sent     []string
+	disabled bool
+}
+
+func (r *reminderRecorder) Enabled() bool {
+	return !r.disabled
+}
+
+func (r *reminderRecorder) SendPaymentReminderEmail(ctx context.Context, outbox repository.OutboxRepository, toEmail string, fullName string, contractNumber string, amount models.Money, dueDate time.Time, overdue bool) error {
+	r.sent = append(r.sent, fmt.Sprintf("%s %s %s %v", toEmail, dueDate.Format(time.DateOnly), amount, overdue))
+	return nil
+}
+
+func TestPaymentReminders(t *testing.T) {
+	api := newTestAPI(t)
+	productID := api.store.AddProduct("Потребительский", 1_000_000, 500_000_000, 3, 60, 12)
+	api.login("admin", testAdminPassword)
+
+	w := api.do("POST", "/api/clients", `{"firstName": "Иван", "lastName": "Распутин", "passportSeries": "4510",
+		"passportNumber": "123456", "dateOfBirth": "1990-05-01", "phone": "+79990001122", "email": "client@example.com"}`)
+	clientID := int64(decode[map[string]any](t, w)["id"].(float64))
+
+	body := fmt.Sprintf(`{"clientId": %d, "productId": %d, "amount": "120000", "termMonths": 12}`, clientID, productID)
+	contractID := int64(decode[map[string]any](t, api.do("POST", "/api/loans", body))["contractId"].(float64))
+	api.activateLoan(contractID)
+
+	schedule := decode[models.RepaymentSchedule](t, api.do("GET", "/api/loans/"+strconv.FormatInt(contractID, 10)+"/schedule", ""))
+	first, second := schedule.Payments[0], schedule.Payments[1]
+
+	var sender reminderRecorder
+	remind := jobs.SendPaymentReminders(api.store, &sender, []int{5, 1})
+	reminder := func(p models.RepaymentScheduleItem, overdue bool) string {
+		return fmt.Sprintf("client@example.com %s %s %v", p.PaymentDate.Format(time.DateOnly), p.PaymentAmount, overdue)
+	}
+
+	// С выключенной почтой напоминание не расходуется.
+	sender.disabled = true
+	if err := remind(context.Background(), first.PaymentDate.AddDate(0, 0, -5)); err != nil {
+		t.Fatalf("send reminders: %v", err)
+	}
+	if len(sender.sent) != 0 {
+		t.Fatalf("Disabled mail: expected no reminders, got %v", sender.sent)
+	}
+	sender.disabled = false
+
+	for _, tc := range []struct {
+		day  time.Time
+		want []string
+	}{
+		{first.PaymentDate.AddDate(0, 0, -6), nil},
+		{first.PaymentDate.AddDate(0, 0, -5), []string{reminder(first, false)}},
+		{first.PaymentDate.AddDate(0, 0, -5), nil},
+		{first.PaymentDate.AddDate(0, 0, -3), nil},
+		{first.PaymentDate.AddDate(0, 0, -1), []string{reminder(first, false)}},
+		{first.PaymentDate, nil},
+		{first.PaymentDate.AddDate(0, 0, 1), []string{reminder(first, true)}},
+		{first.PaymentDate.AddDate(0, 0, 2), nil},
+		// Пропущенный запуск за 5 дней догоняется позже.
+		{second.PaymentDate.AddDate(0, 0, -4), []string{reminder(second, false)}},
+	} {
+		sender.sent = nil
+		if err := remind(context.Background(), tc.day); err != nil {
+			t.Fatalf("send reminders: %v", err)
+		}
+		if !slices.Equal(sender.sent, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.day.Format(time.DateOnly), tc.want, sender.sent)
+		}
+	}
+}
+
+func TestLoanLimits(t *testing.T) {
+	api := newTestAPI(t)
+	productID := api.store.AddProduct("Потребительский", 1_000_000, 500_000_000, 3, 60, 12)
+	api.login("admin", testAdminPassword)
+
+	w := api.do("POST", "/api/clients", `{"firstName": "Иван", "lastName": "Распутин", "passportSeries": "4510",
+		"passportNumber": "123456", "dateOfBirth": "1990-05-01", "phone": "+79990001122", "email": "client@example.com"}`)
+	clientID := decode[map[string]any](t, w)["id"]
+
+	loan := func(amount float64, term int) string {
+		body, _ := json.Marshal(map[string]any{"clientId": clientID, "productId": productID, "amount": amount, "termMonths": term})
+		return string(body)
+	}
+
+	w = api.do("POST", "/api/loans", loan(5000, 120))
+	if w.Code != 422 {
+		t.Fatalf("Out of limits: expected 422, got %d: %s", w.Code, w.Body)
+	}
+	resp := decode[struct {
+		Code    string `json:"code"`
+		Details struct {
+			Violations []models.LoanTermViolation `json:"violations"`
+		} `json:"details"`
+	}](t, w)
+	violations := resp.Details.Violations
+	if resp.Code != "loan_terms_violated" || len(violations) != 2 {
+		t.Fatalf("Expected 2 violations, got %+v", resp)
+	}
+	if v := violations[0]; v.Field != "amount" || v.Rule != "min" ||
+		v.Message != "Сумма ниже минимальной 10 000.00 для продукта «Потребительский»" {
+		t.Errorf("Unexpected amount violation: %+v", v)
+	}
+	if v := violations[1]; v.Field != "termMonths" || v.Rule != "max" || v.Limit != "60" {
+		t.Errorf("Unexpected term violation: %+v", v)
+	}
+
+	if w := api.do("POST", "/api/loans", fmt.Sprintf(`{"clientId": %v, "productId": %d, "termMonths": 12}`, clientID, productID)); w.Code != 400 {
+		t.Errorf("Missing amount: expected 400, got %d: %s", w.Code, w.Body)
+	}
+	if w := api.do("POST", "/api/loans", loan(10000, 3)); w.Code != 201 {
+		t.Errorf("Loan at the limits: expected 201, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestLoanWorkflow(t *testing.T) {
+	api := newTestAPI(t)
+	productID := api.store.AddProduct("Потребительский", 1_000_000, 500_000_000, 3, 60, 12)
+	hash, _ := password.HashPassword("Manager-pass1")
+	api.store.AddEmployee(auth.RoleManager, repository.EmployeeRegistration{
+		Login: "manager", PasswordHash: hash, FirstName: "Анна", LastName: "Смирнова",
+	})
+	api.login("manager", "Manager-pass1")
+
+	w := api.do("POST", "/api/clients", `{"firstName": "Иван", "lastName": "Распутин", "passportSeries": "4510",
+		"passportNumber": "123456", "dateOfBirth": "1990-05-01", "phone": "+79990001122", "email": "client@example.com"}`)
+	// employeeId из тела игнорируется: автор заявки - сотрудник сессии.
+	body, _ := json.Marshal(map[string]any{
+		"clientId": decode[map[string]any](t, w)["id"], "productId": productID, "amount": 100000, "termMonths": 12,
+		"employeeId": 1,
+	})
+	w = api.do("POST", "/api/loans", string(body))
+	if w.Code != 201 {
+		t.Fatalf("create application: expected 201, got %d: %s", w.Code, w.Body)
+	}
+	created := decode[map[string]any](t, w)
+	if created["status"] != "draft" {
+		t.Errorf("Expected draft application, got %v", created)
+	}
+	path := "/api/loans/" + strconv.FormatInt(int64(created["contractId"].(float64)), 10)
+
+	if schedule := decode[models.RepaymentSchedule](t, api.do("GET", path+"/schedule", "")); len(schedule.Payments) != 0 {
+		t.Errorf("Application must have no schedule, got %d payments", len(schedule.Payments))
+	}
+	if w := api.do("POST", path+"/activate", ""); w.Code != 409 {
+		t.Errorf("Activate draft: expected 409, got %d: %s", w.Code, w.Body)
+	}
+	if w := api.do("POST", path+"/submit", `{"reason": "Документы проверены"}`); w.Code != 200 {
+		t.Fatalf("submit: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if w := api.do("POST", path+"/approve", ""); w.Code != 403 {
+		t.Errorf("Manager approval: expected 403, got %d", w.Code)
+	}
+
+	api.login("admin", testAdminPassword)
+	if w := api.do("POST", path+"/reject", `{}`); w.Code != 400 || !strings.Contains(w.Body.String(), "reason_required") {
+		t.Errorf("Reject without reason: expected 400 reason_required, got %d: %s", w.Code, w.Body)
+	}
+	if w := api.do("POST", path+"/reject", `{"reason": "Недостаточный доход"}`); w.Code != 200 {
+		t.Fatalf("reject: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if w := api.do("POST", path+"/sign", ""); w.Code != 409 {
+		t.Errorf("Sign rejected application: expected 409, got %d: %s", w.Code, w.Body)
+	}
+
+	transitions := decode[[]map[string]any](t, api.do("GET", path+"/transitions", ""))
+	if len(transitions) != 3 {
+		t.Fatalf("Expected 3 transitions, got %v", transitions)
+	}
+	if last := transitions[2]; last["from"] != "review" || last["to"] != "rejected" ||
+		last["reason"] != "Недостаточный доход" || last["user"] != "Петров Иван (admin)" {
+		t.Errorf("Unexpected rejection transition: %v", last)
+	}
+	if first := transitions[0]; first["to"] != "draft" || first["from"] != nil || first["user"] != "Смирнова Анна (manager)" {
+		t.Errorf("Unexpected application transition: %v", first)
+	}
+
+	if stats := decode[map[string]any](t, api.do("GET", "/api/stats", "")); stats["totalIssued"] != "0.00" {
+		t.Errorf("Rejected application must not count as issued, got %v", stats)
+	}
+}
+
+func TestClientCannotReadForeignContract(t *testing.T) {
+	api := newTestAPI(t)
+	productID := api.store.AddProduct("Потребительский", 1_000_000, 500_000_000, 3, 60, 12)
+	api.login("admin", testAdminPassword)
+
+	issue := func(passport, phone, email string) (clientID, contractID int64) {
+		w := api.do("POST", "/api/clients", fmt.Sprintf(`{"firstName": "Иван", "lastName": "Распутин", "passportSeries": "4510",
+			"passportNumber": "%s", "dateOfBirth": "1990-05-01", "phone": "%s", "email": "%s"}`, passport, phone, email))
+		clientID = int64(decode[map[string]any](t, w)["id"].(float64))
+
+		body := fmt.Sprintf(`{"clientId": %d, "productId": %d, "amount": "120000", "termMonths": 12}`, clientID, productID)
+		contractID = int64(decode[map[string]any](t, api.do("POST", "/api/loans", body))["contractId"].(float64))
+		api.activateLoan(contractID)
+		return clientID, contractID
+	}
+	clientA, ownContract := issue("123456", "+79990001122", "a@example.com")
+	_, foreignContract := issue("654321", "+79990003344", "b@example.com")
+
+	api.loginClient(clientA, "Client-pass1")
+
+	own := "/api/loans/" + strconv.FormatInt(ownContract, 10)
+	for _, path := range []string{own + "/schedule", own + "/operations"} {
+		if w := api.do("GET", path, ""); w.Code != 200 {
+			t.Errorf("GET %s: expected 200, got %d: %s", path, w.Code, w.Body)
+		}
+	}
+
+	foreign := "/api/loans/" + strconv.FormatInt(foreignContract, 10)
+	for _, path := range []string{foreign + "/schedule", foreign + "/operations", "/api/loans/999/schedule"} {
+		w := api.do("GET", path, "")
+		if resp := decode[map[string]any](t, w); w.Code != 404 || resp["code"] != "contract_not_found" {
+			t.Errorf("GET %s: expected 404 contract_not_found, got %d: %v", path, w.Code, resp)
+		}
+	}
+
+	api.login("admin", testAdminPassword)
+	if w := api.do("GET", foreign+"/schedule", ""); w.Code != 200 {
+		t.Errorf("Staff should read any schedule, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestResetPasswordKeepsTokenOnFailure(t *testing.T) {
+	api := newTestAPI(t)
+	ctx := context.Background()
+
+	hash, _ := password.HashPassword("Start-pass1")
+	userID := api.store.AddEmployee(auth.RoleManager, repository.EmployeeRegistration{
+		Login: "Anna-Smirnova1", PasswordHash: hash, FirstName: "Анна", LastName: "Смирнова",
+	})
+	token, tokenHash, _ := password.GenerateToken()
+	if err := api.store.Users().CreatePasswordReset(ctx, userID, tokenHash, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	reset := func(pwd string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"token": token, "newPassword": pwd})
+		return api.do("POST", "/api/password/reset", string(body))
+	}
+
+	// Пароль, совпадающий с логином, отклоняется, а ссылка остается рабочей.
+	if w := reset("anna-smirnova1"); w.Code != 400 || !strings.Contains(w.Body.String(), "weak_password") {
+		t.Fatalf("Password equal to login: expected 400 weak_password, got %d: %s", w.Code, w.Body)
+	}
+	if w := reset("New-pass1"); w.Code != 200 {
+		t.Fatalf("reset: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if w := reset("Other-pass1"); w.Code != 400 || !strings.Contains(w.Body.String(), "invalid_token") {
+		t.Errorf("Reused link: expected 400 invalid_token, got %d: %s", w.Code, w.Body)
+	}
+
+	api.login("Anna-Smirnova1", "New-pass1")
+}
+
+func TestActivationKeepsTokenOnWeakPassword(t *testing.T) {
+	api := newTestAPI(t)
+	api.login("admin", testAdminPassword)
+
+	w := api.do("POST", "/api/clients", `{"firstName": "Иван", "lastName": "Распутин", "passportSeries": "4510",
+		"passportNumber": "123456", "dateOfBirth": "1990-05-01", "phone": "+79990001122", "email": "client@example.com"}`)
+	clientID := int64(decode[map[string]any](t, w)["id"].(float64))
+
+	ctx := context.Background()
+	target, err := api.store.Users().GetClientActivation(ctx, clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, tokenHash, _ := password.GenerateToken()
+	if err := api.store.Users().CreateActivation(ctx, target.UserID, tokenHash, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	activate := func(pwd string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"token": token, "newPassword": pwd})
+		return api.do("POST", "/api/activate", string(body))
+	}
+
+	if w := activate("short"); w.Code != 400 || decode[map[string]any](t, w)["code"] != "weak_password" {
+		t.Fatalf("Weak password: expected 400 weak_password, got %d: %s", w.Code, w.Body)
+	}
+	if w := activate("Client-pass1"); w.Code != 200 {
+		t.Fatalf("activate: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if w := activate("Client-pass2"); w.Code != 400 || decode[map[string]any](t, w)["code"] != "invalid_token" {
+		t.Errorf("Reused link: expected 400 invalid_token, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestLoginThrottleByIP(t *testing.T) {
+	api := newTestAPI(t)
+	api.login("admin", testAdminPassword)
+
+	attempt := func(login, pwd string) int {
+		body, _ := json.Marshal(map[string]string{"login": login, "password": pwd})
+		return api.do("POST", "/api/login", string(body)).Code
+	}
+	// Каждый раз новый логин, чтобы копился только счетчик IP.
+	var n int
+	fail := func() int {
+		n++
+		return attempt(fmt.Sprintf("ghost%d", n), "wrong")
+	}
+
+	// Успешный вход не сбрасывает счетчик IP: иначе, входя в свою учетную
+	// запись, можно подбирать пароли к чужим с того же адреса.
+	for range 2 {
+		fail()
+	}
+	if code := attempt("admin", testAdminPassword); code != 200 {
+		t.Fatalf("login: expected 200, got %d", code)
+	}
+	fail()
+	if code := fail(); code != 429 {
+		t.Fatalf("Successful login must not reset the IP counter: expected 429, got %d", code)
+	}
+	if code := attempt("admin", testAdminPassword); code != 429 {
+		t.Fatalf("IP should be throttled after 3 failures, got %d", code)
+	}
+
+	if w := api.do("POST", "/api/users/unlock", `{"login": "ghost1", "ip": "not-an-ip"}`); w.Code != 400 {
+		t.Errorf("Invalid IP: expected 400, got %d: %s", w.Code, w.Body)
+	}
+	if w := api.do("POST", "/api/users/unlock", `{"login": "ghost1"}`); w.Code != 200 {
+		t.Fatalf("unlock: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if code := attempt("admin", testAdminPassword); code != 429 {
+		t.Errorf("Unlock without IP must keep the IP throttled, got %d", code)
+	}
+
+	if w := api.do("POST", "/api/users/unlock", `{"login": "ghost1", "ip": "`+testClientIP+`"}`); w.Code != 200 {
+		t.Fatalf("unlock: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if code := attempt("admin", testAdminPassword); code != 200 {
+		t.Errorf("Unlock with IP should lift the IP throttle, got %d", code)
+	}
+}
+
+func TestRequiredPasswordChange(t *testing.T) {
+	api := newTestAPI(t)
+	ctx := context.Background()
+
+	hash, _ := password.HashPassword("Start-pass1")
+	userID := api.store.AddEmployee(auth.RoleManager, repository.EmployeeRegistration{
+		Login: "manager", PasswordHash: hash, FirstName: "Анна", LastName: "Смирнова",
+	})
+	if err := api.store.Users().ReplaceCredential(ctx, userID, hash); err != nil {
+		t.Fatal(err)
+	}
+
+	w := api.do("POST", "/api/login", `{"login": "manager", "password": "Start-pass1"}`)
+	resp := decode[map[string]any](t, w)
+	if w.Code != 200 || resp["status"] != "password_change_required" || resp["challenge"] == "" {
+		t.Fatalf("Flagged login: expected password_change_required, got %d: %v", w.Code, resp)
+	}
+	if len(w.Result().Cookies()) != 0 || len(api.cookies) != 0 {
+		t.Fatalf("Flagged login must not open a session, got cookies %v", w.Result().Cookies())
+	}
+	if w := api.do("GET", "/api/clients", ""); w.Code != 401 {
+		t.Errorf("No session before password change: expected 401, got %d", w.Code)
+	}
+
+	change := func(challenge, pwd string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"challenge": challenge, "newPassword": pwd})
+		return api.do("POST", "/api/login/password", string(body))
+	}
+	challenge := resp["challenge"].(string)
+
+	if w := change(challenge, "Start-pass1"); w.Code != 400 || decode[map[string]any](t, w)["code"] != "password_reused" {
+		t.Errorf("Same password: expected 400 password_reused, got %d: %s", w.Code, w.Body)
+	}
+	if w := change(challenge, "New-pass1"); w.Code != 200 {
+		t.Fatalf("change password: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if w := api.do("GET", "/api/clients", ""); w.Code != 200 {
+		t.Errorf("Session after password change: expected 200, got %d: %s", w.Code, w.Body)
+	}
+
+	for name, token := range map[string]string{
+		"reused challenge": challenge,
+		"access token":     api.cookie("access_token"),
+		"garbage":          "not-a-token",
+	} {
+		if w := change(token, "Other-pass1"); w.Code != 401 || decode[map[string]any](t, w)["code"] != "invalid_challenge" {
+			t.Errorf("%s: expected 401 invalid_challenge, got %d: %s", name, w.Code, w.Body)
+		}
+	}
+
+	api.cookies = map[string]*http.Cookie{}
+	api.login("manager", "New-pass1")
+}
+
+func TestRefreshAndLogout(t *testing.T) {
+	api := newTestAPI(t)
+	api.login("admin", testAdminPassword)
+
+	refresh := func(token string) int {
+		api.cookies["refresh_token"+auth.RefreshCookiePath] = &http.Cookie{Name: "refresh_token", Value: token}
+		return api.do("POST", "/api/refresh", "").Code
+	}
+
+	first := api.cookie("refresh_token")
+	if code := refresh(first); code != 200 {
+		t.Fatalf("refresh: expected 200, got %d", code)
+	}
+	second := api.cookie("refresh_token")
+	if second == first {
+		t.Fatal("Refresh should rotate the refresh token")
+	}
+	if w := api.do("GET", "/api/logs", ""); w.Code != 200 {
+		t.Errorf("New access token: expected 200, got %d", w.Code)
+	}
+
+	// Повторное предъявление старого токена отзывает всё семейство.
+	if code := refresh(first); code != 401 {
+		t.Errorf("Reused refresh token: expected 401, got %d", code)
+	}
+	if code := refresh(second); code != 401 {
+		t.Errorf("Family should be revoked after reuse, got %d", code)
+	}
+
+	api.login("admin", testAdminPassword)
+	if logs := decode[listPage](t, api.do("GET", "/api/logs?action=REFRESH_TOKEN_REUSE", "")); logs.Total != 1 {
+		t.Errorf("Expected the reuse in the audit log, got %d", logs.Total)
+	}
+
+	token := api.cookie("refresh_token")
+	if w := api.do("POST", "/api/logout", ""); w.Code != 200 {
+		t.Fatalf("logout: expected 200, got %d", w.Code)
+	}
+	if code := refresh(token); code != 401 {
+		t.Errorf("Refresh after logout: expected 401, got %d", code)
+	}
+}
+
+func TestRefreshChecksSession(t *testing.T) {
+	api := newTestAPI(t)
+	api.addManager()
+	api.login("manager", "Manager-pass1")
+
+	// Пароль меняется в обход обработчиков, так что семейство refresh-
+	// токенов не отозвано; обновление все равно должно отказать.
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+
+	user, err := api.store.Users().GetByLogin(context.Background(), "manager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := password.HashPassword("New-pass1")
+	if err := api.store.Users().SetPassword(context.Background(), user.ID, hash); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := api.do("POST", "/api/refresh", ""); w.Code != 401 {
+		t.Errorf("Refresh after password change: expected 401, got %d: %s", w.Code, w.Body)
+	}
+}
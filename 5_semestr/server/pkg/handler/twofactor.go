@@ -0,0 +1,314 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/apperr"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/auth"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/lockout"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/totp"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+const totpIssuer = "RoseBank"
+
+// requireSecondFactor отвечает клиенту шагом "нужен второй фактор", если
+// он подключен у пользователя или обязателен для его роли.
+func (h *HandlerDriver) requireSecondFactor(c *gin.Context, p repository.User) bool {
+	st, err := h.store.TwoFactor().Get(c.Request.Context(), p.ID)
+	if err != nil {
+		apperr.Write(c, apperr.Internal("Не удалось проверить настройки 2FA").Wrap(err))
+		return true
+	}
+
+	if !st.Confirmed && !st.Required {
+		return false
+	}
+
+	challenge, err := h.sessions.GenerateChallenge(p.ID, p.Role, auth.PurposeSecondFactor)
+	if err != nil {
+		apperr.Write(c, apperr.Internal("Не удалось создать токен").Wrap(err))
+		return true
+	}
+
+	status := "second_factor_required"
+	if !st.Confirmed {
+		status = "second_factor_setup_required"
+	}
+
+	c.JSON(200, gin.H{
+		"message":   "Second factor required",
+		"status":    status,
+		"challenge": challenge,
+	})
+	return true
+}
+
+func (h *HandlerDriver) SecondFactorSetupHandler(c *gin.Context) {
+	var req models.TwoFactorChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Write(c, apperr.Invalid("Неверный запрос", err))
+		return
+	}
+
+	claims, err := h.sessions.ParseChallenge(req.Challenge, auth.PurposeSecondFactor)
+	if err != nil {
+		apperr.Write(c, apperr.New(401, "invalid_challenge", "Подтверждение входа недействительно или устарело"))
+		return
+	}
+
+	h.beginEnrollment(c, claims.UserID)
+}
+
+func (h *HandlerDriver) SecondFactorVerifyHandler(c *gin.Context) {
+	var req models.TwoFactorVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+		apperr.Write(c, apperr.Invalid("Нужен код подтверждения или код восстановления", err))
+		return
+	}
+
+	claims, err := h.sessions.ParseChallenge(req.Challenge, auth.PurposeSecondFactor)
+	if err != nil {
+		apperr.Write(c, apperr.New(401, "invalid_challenge", "Подтверждение входа недействительно или устарело"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	key := lockout.SecondFactorKey(claims.UserID)
+
+	if _, err := h.limiter.Check(ctx, key); errors.Is(err, lockout.ErrBlocked) {
+		apperr.Write(c, apperr.TooManyRequests("Слишком много неверных кодов"))
+		return
+	}
+
+	st, err := h.store.TwoFactor().Get(ctx, claims.UserID)
+	if err != nil {
+		apperr.Write(c, apperr.Internal("Не удалось проверить настройки 2FA").Wrap(err))
+		return
+	}
+
+	var recoveryCodes []string
+
+	switch {
+	case !st.Confirmed:
+		if st.Secret == nil || req.Code == "" {
+			apperr.Write(c, apperr.New(400, "two_factor_not_enabled", "Сначала настройте приложение-аутентификатор"))
+			return
+		}
+		recoveryCodes, err = h.confirmEnrollment(ctx, claims.UserID, *st.Secret, req.Code)
+	case req.RecoveryCode != "":
+		err = h.useRecoveryCode(ctx, claims.UserID, req.RecoveryCode)
+	default:
+		err = h.useTotpCode(ctx, claims.UserID, *st.Secret, req.Code)
+	}
+
+	if errors.Is(err, errInvalidSecondFactor) {
+		if _, lerr := h.limiter.Fail(ctx, key); lerr != nil {
+			log.Printf("Login limiter error: %v", lerr)
+		}
+		LogAction(ctx, h.store.Audit(), claims.UserID, "2FA_FAILED", "users", claims.UserID, map[string]string{
+			"ip": c.ClientIP(),
+		})
+		apperr.Write(c, apperr.New(401, "invalid_code", "Неверный код"))
+		return
+	}
+	if err != nil {
+		apperr.Write(c, apperr.Internal("Не удалось проверить код").Wrap(err))
+		return
+	}
+
+	h.limiter.Reset(ctx, key)
+
+	profile, err := h.store.Users().GetByID(ctx, claims.UserID)
+	if err != nil {
+		apperr.Write(c, apperr.Unauthorized("Пользователь не найден"))
+		return
+	}
+
+	var extra gin.H
+	if recoveryCodes != nil {
+		extra = gin.H{"recoveryCodes": recoveryCodes}
+	}
+	h.completeLogin(c, profile, extra)
+}
+
+func (h *HandlerDriver) BeginTwoFactorHandler(c *gin.Context) {
+	userID := c.GetInt64("userId")
+	h.beginEnrollment(c, userID)
+}
+
+func (h *HandlerDriver) ConfirmTwoFactorHandler(c *gin.Context) {
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Write(c, apperr.Invalid("Код обязателен", err))
+		return
+	}
+
+	userID := c.GetInt64("userId")
+	ctx := c.Request.Context()
+
+	st, err := h.store.TwoFactor().Get(ctx, userID)
+	if err != nil || st.Secret == nil || st.Confirmed {
+		apperr.Write(c, apperr.New(400, "two_factor_not_pending", "Нет ожидающей подтверждения настройки 2FA"))
+		return
+	}
+
+	codes, err := h.confirmEnrollment(ctx, userID, *st.Secret, req.Code)
+	if errors.Is(err, errInvalidSecondFactor) {
+		apperr.Write(c, apperr.New(400, "invalid_code", "Неверный код"))
+		return
+	}
+	if err != nil {
+		apperr.Write(c, apperr.Internal("Не удалось подключить 2FA").Wrap(err))
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Двухфакторная аутентификация подключена", "recoveryCodes": codes})
+}
+
+func (h *HandlerDriver) DisableTwoFactorHandler(c *gin.Context) {
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Write(c, apperr.Invalid("Код обязателен", err))
+		return
+	}
+
+	userID := c.GetInt64("userId")
+	ctx := c.Request.Context()
+
+	st, err := h.store.TwoFactor().Get(ctx, userID)
+	if err != nil || !st.Confirmed {
+		apperr.Write(c, apperr.New(400, "two_factor_not_enabled", "Двухфакторная аутентификация не подключена"))
+		return
+	}
+	if st.Required {
+		apperr.Write(c, apperr.New(403, "two_factor_required", "Для вашей роли 2FA обязательна"))
+		return
+	}
+
+	if err := h.useTotpCode(ctx, userID, *st.Secret, req.Code); err != nil {
+		apperr.Write(c, apperr.New(400, "invalid_code", "Неверный код"))
+		return
+	}
+
+	if err := h.store.TwoFactor().Disable(ctx, userID); err != nil {
+		apperr.Write(c, apperr.Internal("Не удалось отключить 2FA").Wrap(err))
+		return
+	}
+
+	LogAction(ctx, h.store.Audit(), userID, "2FA_DISABLED", "users", userID, map[string]string{})
+
+	c.JSON(200, gin.H{"message": "Двухфакторная аутентификация отключена"})
+}
+
+func (h *HandlerDriver) SetRoleTwoFactorHandler(c *gin.Context) {
+	var req models.RoleTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apperr.Write(c, apperr.Invalid("Поле required обязательно", err))
+		return
+	}
+
+	role := c.Param("role")
+	ctx := c.Request.Context()
+
+	if err := h.store.TwoFactor().SetRoleRequired(ctx, role, *req.Required); err != nil {
+		apperr.Write(c, err)
+		return
+	}
+
+	adminID := c.GetInt64("userId")
+	LogAction(ctx, h.store.Audit(), adminID, "ROLE_2FA_POLICY", "roles", 0, map[string]string{
+		"role":     role,
+		"required": strconv.FormatBool(*req.Required),
+	})
+
+	c.JSON(200, gin.H{"message": "Политика 2FA обновлена", "role": role, "required": *req.Required})
+}
+
+var errInvalidSecondFactor = errors.New("invalid second factor")
+
+func (h *HandlerDriver) beginEnrollment(c *gin.Context, userID int64) {
+	ctx := c.Request.Context()
+
+	profile, err := h.store.Users().GetByID(ctx, userID)
+	if err != nil {
+		apperr.Write(c, apperr.NotFound("Пользователь не найден"))
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		apperr.Write(c, apperr.Internal("Не удалось сгенерировать секрет").Wrap(err))
+		return
+	}
+
+	if err := h.store.TwoFactor().BeginEnrollment(ctx, userID, secret); err != nil {
+		apperr.Write(c, err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"secret":          secret,
+		"provisioningUri": totp.ProvisioningURI(totpIssuer, profile.Login, secret),
+	})
+}
+
+func (h *HandlerDriver) confirmEnrollment(ctx context.Context, userID int64, secret string, code string) ([]string, error) {
+	step, ok := totp.Verify(secret, code, time.Now())
+	if !ok {
+		return nil, errInvalidSecondFactor
+	}
+
+	codes, err := totp.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, rc := range codes {
+		hashes[i] = totp.HashRecoveryCode(rc)
+	}
+
+	if err := h.store.TwoFactor().Confirm(ctx, userID, step, hashes); err != nil {
+		return nil, err
+	}
+
+	LogAction(ctx, h.store.Audit(), userID, "2FA_ENABLED", "users", userID, map[string]string{})
+
+	return codes, nil
+}
+
+func (h *HandlerDriver) useTotpCode(ctx context.Context, userID int64, secret string, code string) error {
+	step, ok := totp.Verify(secret, code, time.Now())
+	if !ok {
+		return errInvalidSecondFactor
+	}
+
+	fresh, err := h.store.TwoFactor().UseStep(ctx, userID, step)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return errInvalidSecondFactor
+	}
+	return nil
+}
+
+func (h *HandlerDriver) useRecoveryCode(ctx context.Context, userID int64, code string) error {
+	used, err := h.store.TwoFactor().UseRecoveryCode(ctx, userID, totp.HashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return errInvalidSecondFactor
+	}
+
+	LogAction(ctx, h.store.Audit(), userID, "2FA_RECOVERY_CODE_USED", "users", userID, map[string]string{})
+	return nil
+}
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/auth"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/password"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/totp"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+// addManager заводит менеджера manager с паролем Manager-pass1.
+func (a *testAPI) addManager() {
+	hash, _ := password.HashPassword("Manager-pass1")
+	a.store.AddEmployee(auth.RoleManager, repository.EmployeeRegistration{
+		Login: "manager", PasswordHash: hash, FirstName: "Анна", LastName: "Смирнова",
+	})
+}
+
+// loginChallenge входит паролем и ожидает шаг второго фактора со статусом
+// status; возвращает токен этого шага.
+func (a *testAPI) loginChallenge(login, pwd, status string) string {
+	a.t.Helper()
+	a.cookies = map[string]*http.Cookie{}
+
+	body, _ := json.Marshal(map[string]string{"login": login, "password": pwd})
+	w := a.do("POST", "/api/login", string(body))
+	resp := decode[map[string]any](a.t, w)
+	if w.Code != 200 || resp["status"] != status {
+		a.t.Fatalf("login %s: expected %s, got %d: %v", login, status, w.Code, resp)
+	}
+	if len(a.cookies) != 0 {
+		a.t.Fatalf("login %s: session opened before the second factor", login)
+	}
+	return resp["challenge"].(string)
+}
+
+func (a *testAPI) verifySecondFactor(challenge string, code, recovery string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]string{"challenge": challenge, "code": code, "recoveryCode": recovery})
+	return a.do("POST", "/api/login/2fa", string(body))
+}
+
+func totpCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+	code, err := totp.Code(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestSecondFactorLogin(t *testing.T) {
+	api := newTestAPI(t)
+	api.addManager()
+	api.login("manager", "Manager-pass1")
+
+	secret := decode[map[string]any](t, api.do("POST", "/api/me/2fa", ""))["secret"].(string)
+	step := totp.Step(time.Now())
+
+	w := api.do("POST", "/api/me/2fa/confirm", `{"code": "`+totpCode(t, secret, step)+`"}`)
+	if w.Code != 200 {
+		t.Fatalf("confirm 2fa: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var recovery []string
+	for _, rc := range decode[map[string]any](t, w)["recoveryCodes"].([]any) {
+		recovery = append(recovery, rc.(string))
+	}
+	if len(recovery) < 2 {
+		t.Fatalf("Expected recovery codes, got %v", recovery)
+	}
+
+	// Код следующего шага принимается (допуск ±1 шаг) и открывает сессию.
+	next := totpCode(t, secret, step+1)
+	challenge := api.loginChallenge("manager", "Manager-pass1", "second_factor_required")
+	if w := api.verifySecondFactor(challenge, next, ""); w.Code != 200 {
+		t.Fatalf("verify: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if w := api.do("GET", "/api/clients", ""); w.Code != 200 {
+		t.Errorf("Session after 2fa: expected 200, got %d", w.Code)
+	}
+
+	// Тот же код второй раз не принимается, даже с новым входом.
+	challenge = api.loginChallenge("manager", "Manager-pass1", "second_factor_required")
+	if w := api.verifySecondFactor(challenge, next, ""); w.Code != 401 || decode[map[string]any](t, w)["code"] != "invalid_code" {
+		t.Errorf("Replayed code: expected 401 invalid_code, got %d: %s", w.Code, w.Body)
+	}
+
+	// Код восстановления действует один раз.
+	if w := api.verifySecondFactor(challenge, "", recovery[0]); w.Code != 200 {
+		t.Fatalf("recovery code: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	challenge = api.loginChallenge("manager", "Manager-pass1", "second_factor_required")
+	if w := api.verifySecondFactor(challenge, "", recovery[0]); w.Code != 401 {
+		t.Errorf("Reused recovery code: expected 401, got %d: %s", w.Code, w.Body)
+	}
+
+	// После трех неверных кодов проверка блокируется даже для верного кода.
+	for range 2 {
+		api.verifySecondFactor(challenge, "", "wrong-code")
+	}
+	if w := api.verifySecondFactor(challenge, "", recovery[1]); w.Code != 429 {
+		t.Errorf("Second factor lockout: expected 429, got %d: %s", w.Code, w.Body)
+	}
+	api.login("admin", testAdminPassword)
+	if logs := decode[listPage](t, api.do("GET", "/api/logs?action=2FA_FAILED", "")); logs.Total != 4 {
+		t.Errorf("Expected 4 failed 2fa attempts in the audit log, got %d", logs.Total)
+	}
+}
+
+func TestRoleRequiresSecondFactor(t *testing.T) {
+	api := newTestAPI(t)
+	api.addManager()
+	api.login("admin", testAdminPassword)
+
+	if w := api.do("PUT", "/api/roles/manager/2fa", `{"required": true}`); w.Code != 200 {
+		t.Fatalf("require 2fa: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if w := api.do("PUT", "/api/roles/nobody/2fa", `{"required": true}`); w.Code < 400 {
+		t.Errorf("Unknown role: expected an error, got %d", w.Code)
+	}
+
+	challenge := api.loginChallenge("manager", "Manager-pass1", "second_factor_setup_required")
+
+	if w := api.verifySecondFactor(challenge, "123456", ""); w.Code != 400 || decode[map[string]any](t, w)["code"] != "two_factor_not_enabled" {
+		t.Errorf("Verify before setup: expected 400 two_factor_not_enabled, got %d: %s", w.Code, w.Body)
+	}
+
+	w := api.do("POST", "/api/login/2fa/setup", `{"challenge": "`+challenge+`"}`)
+	if w.Code != 200 {
+		t.Fatalf("setup: expected 200, got %d: %s", w.Code, w.Body)
+	}
+	secret := decode[map[string]any](t, w)["secret"].(string)
+
+	w = api.verifySecondFactor(challenge, totpCode(t, secret, totp.Step(time.Now())), "")
+	if w.Code != 200 || decode[map[string]any](t, w)["recoveryCodes"] == nil {
+		t.Fatalf("Enrollment during login: expected 200 with recovery codes, got %d: %s", w.Code, w.Body)
+	}
+
+	if w := api.do("DELETE", "/api/me/2fa", `{"code": "123456"}`); w.Code != 403 || decode[map[string]any](t, w)["code"] != "two_factor_required" {
+		t.Errorf("Disable mandatory 2fa: expected 403 two_factor_required, got %d: %s", w.Code, w.Body)
+	}
+
+	api.loginChallenge("manager", "Manager-pass1", "second_factor_required")
+}
@@ -0,0 +1,110 @@
+// Package apperr описывает ошибки API: стабильный код, HTTP-статус и
+// сообщение для пользователя. Ошибки БД классифицируются по SQLSTATE,
+// собственным кодам процедур (класс RB) и именам ограничений.
+package apperr
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Общие коды. Более конкретные коды задаются в таблицах pg.go и в
+// обработчиках через New.
+const (
+	CodeBadRequest      = "bad_request"
+	CodeValidation      = "validation_failed"
+	CodeUnauthorized    = "unauthorized"
+	CodeForbidden       = "forbidden"
+	CodeNotFound        = "not_found"
+	CodeConflict        = "conflict"
+	CodeTooManyRequests = "too_many_requests"
+	CodeInternal        = "internal_error"
+	CodeUnavailable     = "service_unavailable"
+)
+
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Details any
+
+	cause error
+}
+
+func New(status int, code string, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return e.Code + ": " + e.cause.Error()
+	}
+	return e.Code + ": " + e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// WithDetails возвращает копию ошибки с дополнительными данными для
+// клиента (например, списком неверных полей).
+func (e *Error) WithDetails(details any) *Error {
+	cp := *e
+	cp.Details = details
+	return &cp
+}
+
+// Wrap возвращает копию ошибки с исходной причиной. Причина попадает
+// только в лог, клиенту она не отдается.
+func (e *Error) Wrap(cause error) *Error {
+	cp := *e
+	cp.cause = cause
+	return &cp
+}
+
+func BadRequest(message string) *Error {
+	return New(http.StatusBadRequest, CodeBadRequest, message)
+}
+
+func Unauthorized(message string) *Error {
+	return New(http.StatusUnauthorized, CodeUnauthorized, message)
+}
+
+func Forbidden(message string) *Error {
+	return New(http.StatusForbidden, CodeForbidden, message)
+}
+
+func NotFound(message string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, message)
+}
+
+func Conflict(message string) *Error {
+	return New(http.StatusConflict, CodeConflict, message)
+}
+
+func TooManyRequests(message string) *Error {
+	return New(http.StatusTooManyRequests, CodeTooManyRequests, message)
+}
+
+// Internal - ошибка сервера. Сообщение уходит клиенту, поэтому в нем не
+// должно быть подробностей; их передают через Wrap.
+func Internal(message string) *Error {
+	return New(http.StatusInternalServerError, CodeInternal, message)
+}
+
+// From приводит любую ошибку к *Error: готовые ошибки возвращаются как
+// есть, ошибки PostgreSQL классифицируются, остальное считается
+// внутренней ошибкой.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	if e := fromPg(err); e != nil {
+		return e.Wrap(err)
+	}
+	if e := fromRepository(err); e != nil {
+		return e.Wrap(err)
+	}
+	return Internal("Внутренняя ошибка сервера").Wrap(err)
+}
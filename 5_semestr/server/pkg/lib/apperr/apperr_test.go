@@ -0,0 +1,61 @@
+package apperr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+func TestFromClassifiesErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"custom sqlstate", &pgconn.PgError{Code: "RB002", Message: "Этот платеж уже оплачен"}, 409, "payment_already_paid"},
+		{"constraint wins over sqlstate", &pgconn.PgError{Code: "23514", ConstraintName: "chk_client_adult"}, 422, "client_underage"},
+		{"unique passport", &pgconn.PgError{Code: "23505", ConstraintName: "uq_clients_passport"}, 409, "passport_taken"},
+		{"unknown unique", &pgconn.PgError{Code: "23505", ConstraintName: "something_key"}, 409, "constraint_violation"},
+		{"data exception class", &pgconn.PgError{Code: "22007"}, 400, "invalid_input"},
+		{"plain raise", &pgconn.PgError{Code: "P0001", Message: "Роль manager не найдена в БД"}, 500, CodeInternal},
+		{"wrapped", fmt.Errorf("issue loan: %w", &pgconn.PgError{Code: "RB004"}), 422, "client_has_overdue_debt"},
+		{"not found", repository.ErrNotFound, 404, CodeNotFound},
+		{"ready error", Conflict("x"), 409, CodeConflict},
+		{"unknown", errors.New("dial tcp: connection refused"), 500, CodeInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := From(tt.err)
+			if e.Status != tt.status || e.Code != tt.code {
+				t.Errorf("got %d %s, want %d %s", e.Status, e.Code, tt.status, tt.code)
+			}
+		})
+	}
+}
+
+func TestFromKeepsJSONDetail(t *testing.T) {
+	detail := `{"violations": [{"field": "amount", "rule": "min", "limit": "10 000.00"}]}`
+	e := From(&pgconn.PgError{Code: "RB022", Detail: detail})
+
+	if raw, ok := e.Details.(json.RawMessage); !ok || string(raw) != detail {
+		t.Errorf("expected JSON detail to be passed through, got %#v", e.Details)
+	}
+	if e := From(&pgconn.PgError{Code: "RB022", Detail: "not json"}); e.Details != nil {
+		t.Errorf("plain detail must not be exposed, got %#v", e.Details)
+	}
+}
+
+func TestFromHidesCause(t *testing.T) {
+	cause := &pgconn.PgError{Code: "42P01", Message: `relation "clients" does not exist`}
+	e := From(cause)
+
+	if e.Message == cause.Message || !errors.Is(e, cause) {
+		t.Errorf("cause should be kept for logs but not shown: %+v", e)
+	}
+}
@@ -0,0 +1,101 @@
+package apperr
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// envelope - единый формат ответа с ошибкой.
+type envelope struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"requestId"`
+}
+
+// RequestID присваивает запросу идентификатор (или берет корректный из
+// заголовка X-Request-ID) и возвращает его в ответе.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+
+		c.Set("requestId", id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// Write прерывает обработку запроса и отвечает ошибкой в едином формате.
+// Ошибки 5xx пишутся в лог вместе с причиной.
+func Write(c *gin.Context, err error) {
+	e := From(err)
+	requestID := c.GetString("requestId")
+
+	if e.Status >= http.StatusInternalServerError {
+		log.Printf("[%s] %s %s: %v", requestID, c.Request.Method, c.Request.URL.Path, err)
+	}
+
+	c.AbortWithStatusJSON(e.Status, envelope{
+		Code:      e.Code,
+		Message:   e.Message,
+		Details:   e.Details,
+		RequestID: requestID,
+	})
+}
+
+// FieldError - поле запроса, не прошедшее проверку binding.
+type FieldError struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+	Param string `json:"param,omitempty"`
+}
+
+// Invalid описывает ошибку разбора тела запроса. Для ошибок валидации в
+// details перечисляются поля (по именам из JSON) и нарушенные правила.
+func Invalid(message string, err error) *Error {
+	e := New(http.StatusBadRequest, CodeValidation, message).Wrap(err)
+
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return e
+	}
+
+	list := make([]FieldError, 0, len(verrs))
+	for _, fe := range verrs {
+		list = append(list, FieldError{Field: fe.Field(), Rule: fe.Tag(), Param: fe.Param()})
+	}
+	return e.WithDetails(list)
+}
+
+func init() {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(jsonFieldName)
+	}
+}
+
+func jsonFieldName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return f.Name
+	}
+	return name
+}
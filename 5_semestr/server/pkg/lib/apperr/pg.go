@@ -0,0 +1,132 @@
+package apperr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+// sqlStates - собственные коды, которые процедуры передают через
+// RAISE ... USING ERRCODE (миграция 000009_error_codes), и отдельные
+// стандартные SQLSTATE.
+var sqlStates = map[string]*Error{
+	"RB001": New(http.StatusNotFound, "payment_not_found", "Платеж не найден"),
+	"RB002": New(http.StatusConflict, "payment_already_paid", "Этот платеж уже оплачен"),
+	"RB003": New(http.StatusConflict, "no_outstanding_debt", "По договору нет задолженности"),
+	"RB004": New(http.StatusUnprocessableEntity, "client_has_overdue_debt", "У клиента имеется непогашенная просроченная задолженность"),
+	"RB005": New(http.StatusBadRequest, "invalid_birth_date", "Неверный формат даты рождения (YYYY-MM-DD)"),
+	"RB006": New(http.StatusConflict, "operation_history_immutable", "Историю операций нельзя изменять"),
+	"RB007": New(http.StatusConflict, "two_factor_already_enabled", "Двухфакторная аутентификация уже подключена"),
+	"RB008": New(http.StatusConflict, "two_factor_not_pending", "Нет ожидающей подтверждения настройки 2FA"),
+	"RB009": New(http.StatusConflict, "account_already_activated", "Учетная запись уже активирована"),
+	"RB010": New(http.StatusNotFound, "user_not_found", "Пользователь не найден"),
+	"RB011": New(http.StatusNotFound, "product_not_found", "Кредитный продукт не найден"),
+	"RB012": New(http.StatusNotFound, "client_not_found", "Клиент не найден"),
+	"RB013": New(http.StatusNotFound, "contract_not_found", "Договор не найден"),
+	"RB014": New(http.StatusUnprocessableEntity, "invalid_loan_terms", "Неверные параметры кредита"),
+	"RB015": New(http.StatusNotFound, "role_not_found", "Роль не найдена"),
+	"RB016": New(http.StatusBadRequest, "invalid_sort", "Недопустимое поле сортировки"),
+	"RB017": New(http.StatusConflict, "client_fields_locked", "ФИО, паспорт и дату рождения нельзя менять при действующих договорах"),
+	"RB018": New(http.StatusConflict, "client_has_active_contracts", "У клиента есть действующие договоры"),
+	"RB019": New(http.StatusConflict, "product_inactive", "Кредитный продукт не активен"),
+	"RB020": New(http.StatusConflict, "invalid_loan_transition", "Переход недопустим из текущего статуса договора"),
+	"RB021": New(http.StatusBadRequest, "reason_required", "Укажите причину отказа").WithDetails(fields("reason")),
+	"RB022": New(http.StatusUnprocessableEntity, "loan_terms_violated", "Условия кредита не соответствуют продукту"),
+	"RB023": New(http.StatusUnprocessableEntity, "invalid_repayment_amount", "Сумма досрочного погашения должна быть больше нуля и не больше остатка долга").
+		WithDetails(fields("amount")),
+	"RB024": New(http.StatusUnprocessableEntity, "invalid_repayment_mode", "Выбранный способ пересчета графика недоступен для этого договора").
+		WithDetails(fields("mode")),
+	"RB025": New(http.StatusUnprocessableEntity, "invalid_payment_amount", "Сумма платежа должна быть больше нуля и не больше суммы к оплате").
+		WithDetails(fields("amount")),
+	"RB026": New(http.StatusConflict, "loan_overdue", "Сначала погасите просроченные платежи и пени"),
+
+	"23502": New(http.StatusUnprocessableEntity, "missing_field", "Не заполнено обязательное поле"),
+	"23503": New(http.StatusUnprocessableEntity, "reference_not_found", "Связанная запись не найдена"),
+}
+
+// constraints - ограничения схемы, нарушение которых означает ошибку во
+// входных данных. В details указываются поля запроса.
+var constraints = map[string]*Error{
+	"chk_client_adult": New(http.StatusUnprocessableEntity, "client_underage", "Клиенту должно быть не меньше 18 лет").
+		WithDetails(fields("dateOfBirth")),
+	"uq_clients_passport": New(http.StatusConflict, "passport_taken", "Клиент с таким паспортом уже зарегистрирован").
+		WithDetails(fields("passportSeries", "passportNumber")),
+	"users_login_key": New(http.StatusConflict, "login_taken", "Пользователь с таким логином уже существует").
+		WithDetails(fields("login")),
+	"chk_loan_amount_positive": New(http.StatusUnprocessableEntity, "invalid_loan_amount", "Сумма кредита должна быть положительной").
+		WithDetails(fields("amount")),
+	"chk_interest_rate_valid": New(http.StatusUnprocessableEntity, "invalid_interest_rate", "Недопустимая процентная ставка").
+		WithDetails(fields("interestRate")),
+	"uq_products_name": New(http.StatusConflict, "product_name_taken", "Кредитный продукт с таким названием уже существует").
+		WithDetails(fields("name")),
+	"chk_product_amounts": New(http.StatusUnprocessableEntity, "invalid_product_amounts", "Минимальная сумма должна быть положительной и не больше максимальной").
+		WithDetails(fields("minAmount", "maxAmount")),
+	"chk_product_terms": New(http.StatusUnprocessableEntity, "invalid_product_terms", "Минимальный срок должен быть положительным и не больше максимального").
+		WithDetails(fields("minTerm", "maxTerm")),
+	"chk_product_rate": New(http.StatusUnprocessableEntity, "invalid_interest_rate", "Ставка должна быть больше 0 и меньше 100%").
+		WithDetails(fields("rate")),
+	"chk_product_penalty": New(http.StatusUnprocessableEntity, "invalid_penalty", "Пени должны быть не меньше 0 и меньше 100% в день, предел - не меньше 0").
+		WithDetails(fields("penaltyRate", "penaltyCap")),
+}
+
+// sqlStateClasses - классы SQLSTATE для ошибок, не попавших в таблицы выше.
+var sqlStateClasses = map[string]*Error{
+	"22": New(http.StatusBadRequest, "invalid_input", "Неверный формат данных"),
+	"23": New(http.StatusConflict, "constraint_violation", "Данные противоречат ограничениям базы"),
+	"40": New(http.StatusConflict, "concurrent_update", "Данные изменились параллельно, повторите запрос"),
+	"08": New(http.StatusServiceUnavailable, CodeUnavailable, "База данных недоступна"),
+	"53": New(http.StatusServiceUnavailable, CodeUnavailable, "База данных перегружена"),
+	"57": New(http.StatusServiceUnavailable, CodeUnavailable, "Запрос к базе данных прерван"),
+}
+
+func fromPg(err error) *Error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return nil
+	}
+
+	if e, ok := constraints[pgErr.ConstraintName]; ok {
+		return e
+	}
+	if e, ok := sqlStates[pgErr.Code]; ok {
+		return withDetail(e, pgErr)
+	}
+	if len(pgErr.Code) == 5 {
+		if e, ok := sqlStateClasses[pgErr.Code[:2]]; ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// withDetail передает клиенту DETAIL процедуры, если это JSON-объект
+// (например, список нарушений из sp_issue_loan).
+func withDetail(e *Error, pgErr *pgconn.PgError) *Error {
+	if strings.HasPrefix(pgErr.Detail, "{") && json.Valid([]byte(pgErr.Detail)) {
+		return e.WithDetails(json.RawMessage(pgErr.Detail))
+	}
+	return e
+}
+
+func fromRepository(err error) *Error {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return NotFound("Запись не найдена")
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return New(http.StatusServiceUnavailable, CodeUnavailable, "Запрос не успел выполниться")
+	}
+	return nil
+}
+
+type fieldList struct {
+	Fields []string `json:"fields"`
+}
+
+func fields(names ...string) fieldList {
+	return fieldList{Fields: names}
+}
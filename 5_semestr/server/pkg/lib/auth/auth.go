@@ -1,45 +1,61 @@
 package auth
 
 import (
+	"context"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/apperr"
 )
 
-var JWTKey = []byte(os.Getenv("JWTKey"))
-
 const (
 	AccessTokenDuration  = 15 * time.Minute 
 	RefreshTokenDuration = 30 * 24 * time.Hour
+
+	ChallengeTokenDuration = 5 * time.Minute
+
+	PurposeSecondFactor   = "second_factor"
+	PurposePasswordChange = "password_change"
+
+	RefreshCookiePath       = "/api"
+	legacyRefreshCookiePath = "/api/refresh"
 )
 
 type Claims struct {
-	UserID int64  `json:"userId"`
-	Role   string `json:"role"`
+	UserID  int64  `json:"userId"`
+	Role    string `json:"role"`
+	Purpose string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func AuthMiddleware() gin.HandlerFunc {
+// SessionCheck дополнительно проверяет действующий токен, например, что он
+// выдан после последней смены пароля.
+type SessionCheck func(ctx context.Context, claims *Claims) error
+
+// Middleware пропускает запрос только с действующим access-токеном.
+func (s *Sessions) Middleware(check SessionCheck) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenString, err := c.Cookie("access_token")
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			apperr.Write(c, apperr.Unauthorized("Требуется авторизация"))
 			return
 		}
 
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return JWTKey, nil
-		})
-
-		if err != nil || !token.Valid {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		claims, err := s.ParseToken(tokenString)
+		if err != nil {
+			apperr.Write(c, apperr.New(http.StatusUnauthorized, "invalid_token", "Сессия недействительна, войдите заново"))
 			return
 		}
 
+		if check != nil {
+			if err := check(c.Request.Context(), claims); err != nil {
+				apperr.Write(c, apperr.New(http.StatusUnauthorized, "session_expired", "Сессия завершена, войдите заново"))
+				return
+			}
+		}
+
 		c.Set("userId", claims.UserID)
 		c.Set("role", claims.Role)
 		c.Next()
@@ -47,14 +63,74 @@ func AuthMiddleware() gin.HandlerFunc {
 }
 
 
-func GenerateToken(userID int64, role string, duration time.Duration) (string, error) {
-	claims := &Claims{
+func (s *Sessions) GenerateToken(userID int64, role string, duration time.Duration) (string, error) {
+	return s.signToken(&Claims{
 		UserID: userID,
 		Role:   role,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        newTokenID(),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(duration)),
 		},
+	})
+}
+
+// GenerateChallenge выдает короткоживущий токен промежуточного шага входа
+// (например, ввода второго фактора). Такой токен не открывает сессию.
+func (s *Sessions) GenerateChallenge(userID int64, role string, purpose string) (string, error) {
+	return s.signToken(&Claims{
+		UserID:  userID,
+		Role:    role,
+		Purpose: purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        newTokenID(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ChallengeTokenDuration)),
+		},
+	})
+}
+
+func (s *Sessions) ParseChallenge(tokenString string, purpose string) (*Claims, error) {
+	claims, err := s.parseClaims(tokenString)
+	if err != nil || claims.Purpose != purpose {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// ParseToken разбирает сессионный (access или refresh) токен.
+func (s *Sessions) ParseToken(tokenString string) (*Claims, error) {
+	claims, err := s.parseClaims(tokenString)
+	if err != nil || claims.Purpose != "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (s *Sessions) parseClaims(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return s.key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}))
+
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
 	}
+	return claims, nil
+}
+
+func SetSessionCookies(c *gin.Context, pair TokenPair) {
+	c.SetCookie("access_token", pair.Access, int(AccessTokenDuration.Seconds()), "/", "", false, true)
+	c.SetCookie("refresh_token", pair.Refresh, int(RefreshTokenDuration.Seconds()), RefreshCookiePath, "", false, true)
+	c.SetCookie("refresh_token", "", -1, legacyRefreshCookiePath, "", false, true)
+}
+
+func ClearSessionCookies(c *gin.Context) {
+	c.SetCookie("access_token", "", -1, "/", "", false, true)
+	c.SetCookie("refresh_token", "", -1, RefreshCookiePath, "", false, true)
+	c.SetCookie("refresh_token", "", -1, legacyRefreshCookiePath, "", false, true)
+}
+
+func (s *Sessions) signToken(claims *Claims) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(JWTKey)
+	return token.SignedString(s.key)
 }
\ No newline at end of file
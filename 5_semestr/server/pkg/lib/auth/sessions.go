@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/config"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+type TokenPair struct {
+	Access  string
+	Refresh string
+}
+
+// Sessions выдает пары access/refresh токенов и ротирует refresh-токены
+// через RefreshStore.
+type Sessions struct {
+	key   []byte
+	store RefreshStore
+}
+
+func NewSessions(cfg config.Auth, store RefreshStore) *Sessions {
+	return &Sessions{
+		key:   []byte(cfg.JWTKey),
+		store: store,
+	}
+}
+
+// Issue открывает новую сессию (новое семейство refresh-токенов).
+func (s *Sessions) Issue(ctx context.Context, userID int64, role string) (TokenPair, error) {
+	return s.issue(ctx, userID, role, newTokenID())
+}
+
+// Refresh обменивает refresh-токен на новую пару. Повторное предъявление
+// уже использованного токена отзывает всё его семейство.
+func (s *Sessions) Refresh(ctx context.Context, refreshToken string) (TokenPair, *Claims, error) {
+	claims, err := s.ParseToken(refreshToken)
+	if err != nil || claims.ID == "" {
+		return TokenPair{}, nil, ErrInvalidToken
+	}
+
+	rec, err := s.store.Consume(ctx, claims.ID)
+	if errors.Is(err, ErrTokenReused) {
+		if revokeErr := s.store.RevokeFamily(ctx, rec.FamilyID); revokeErr != nil {
+			return TokenPair{}, claims, revokeErr
+		}
+		return TokenPair{}, claims, err
+	}
+	if err != nil {
+		return TokenPair{}, claims, err
+	}
+	if rec.UserID != claims.UserID {
+		return TokenPair{}, claims, ErrInvalidToken
+	}
+
+	pair, err := s.issue(ctx, claims.UserID, claims.Role, rec.FamilyID)
+	return pair, claims, err
+}
+
+// Revoke отзывает семейство, к которому принадлежит refresh-токен.
+func (s *Sessions) Revoke(ctx context.Context, refreshToken string) error {
+	claims, err := s.ParseToken(refreshToken)
+	if err != nil || claims.ID == "" {
+		return ErrInvalidToken
+	}
+
+	rec, err := s.store.Consume(ctx, claims.ID)
+	if err != nil && rec.FamilyID == "" {
+		return err
+	}
+	return s.store.RevokeFamily(ctx, rec.FamilyID)
+}
+
+// RevokeUser завершает все сессии пользователя.
+func (s *Sessions) RevokeUser(ctx context.Context, userID int64) error {
+	return s.store.RevokeUser(ctx, userID)
+}
+
+func (s *Sessions) issue(ctx context.Context, userID int64, role string, familyID string) (TokenPair, error) {
+	access, err := s.GenerateToken(userID, role, AccessTokenDuration)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	rec := RefreshToken{
+		ID:        newTokenID(),
+		FamilyID:  familyID,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(RefreshTokenDuration),
+	}
+	refresh, err := s.signToken(&Claims{
+		UserID: userID,
+		Role:   role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        rec.ID,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(rec.ExpiresAt),
+		},
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if err := s.store.Save(ctx, rec); err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{Access: access, Refresh: refresh}, nil
+}
+
+func newTokenID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
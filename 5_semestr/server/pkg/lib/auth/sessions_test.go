@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/config"
+)
+
+func TestRefreshRotationAndReuse(t *testing.T) {
+	ctx := context.Background()
+	sessions := NewSessions(config.Auth{JWTKey: "test-key"}, NewMemoryRefreshStore())
+
+	first, err := sessions.Issue(ctx, 7, RoleClient)
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+
+	second, claims, err := sessions.Refresh(ctx, first.Refresh)
+	if err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if claims.UserID != 7 || second.Refresh == first.Refresh {
+		t.Fatal("Refresh should rotate the token for the same user")
+	}
+
+	if _, _, err := sessions.Refresh(ctx, first.Refresh); !errors.Is(err, ErrTokenReused) {
+		t.Fatalf("Expected ErrTokenReused, got %v", err)
+	}
+
+	if _, _, err := sessions.Refresh(ctx, second.Refresh); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("Reuse should revoke the whole family, got %v", err)
+	}
+}
+
+func TestLogoutRevokesFamily(t *testing.T) {
+	ctx := context.Background()
+	sessions := NewSessions(config.Auth{JWTKey: "test-key"}, NewMemoryRefreshStore())
+
+	pair, _ := sessions.Issue(ctx, 1, RoleAdmin)
+	if err := sessions.Revoke(ctx, pair.Refresh); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+
+	if _, _, err := sessions.Refresh(ctx, pair.Refresh); err == nil {
+		t.Fatal("Refresh after logout should fail")
+	}
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTokenNotFound = errors.New("refresh token not found")
+	ErrTokenReused   = errors.New("refresh token reuse detected")
+	ErrTokenRevoked  = errors.New("refresh token revoked")
+	ErrTokenExpired  = errors.New("refresh token expired")
+)
+
+type RefreshToken struct {
+	ID        string
+	FamilyID  string
+	UserID    int64
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+// RefreshStore хранит выданные refresh-токены. Consume атомарно помечает
+// токен использованным: повторный Consume того же токена возвращает
+// ErrTokenReused вместе с записью, чтобы можно было отозвать всё семейство.
+type RefreshStore interface {
+	Save(ctx context.Context, t RefreshToken) error
+	Consume(ctx context.Context, id string) (RefreshToken, error)
+	RevokeFamily(ctx context.Context, familyID string) error
+	RevokeUser(ctx context.Context, userID int64) error
+}
+
+type MemoryRefreshStore struct {
+	mu     sync.Mutex
+	tokens map[string]RefreshToken
+}
+
+func NewMemoryRefreshStore() *MemoryRefreshStore {
+	return &MemoryRefreshStore{
+		tokens: make(map[string]RefreshToken),
+	}
+}
+
+func (s *MemoryRefreshStore) Save(ctx context.Context, t RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[t.ID] = t
+	return nil
+}
+
+func (s *MemoryRefreshStore) Consume(ctx context.Context, id string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[id]
+	if !ok {
+		return RefreshToken{}, ErrTokenNotFound
+	}
+	if err := checkConsumable(t, time.Now()); err != nil {
+		return t, err
+	}
+
+	now := time.Now()
+	t.UsedAt = &now
+	s.tokens[id] = t
+	return t, nil
+}
+
+func (s *MemoryRefreshStore) RevokeFamily(ctx context.Context, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, t := range s.tokens {
+		if t.FamilyID == familyID && t.RevokedAt == nil {
+			t.RevokedAt = &now
+			s.tokens[id] = t
+		}
+	}
+	return nil
+}
+
+func (s *MemoryRefreshStore) RevokeUser(ctx context.Context, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, t := range s.tokens {
+		if t.UserID == userID && t.RevokedAt == nil {
+			t.RevokedAt = &now
+			s.tokens[id] = t
+		}
+	}
+	return nil
+}
+
+func checkConsumable(t RefreshToken, now time.Time) error {
+	switch {
+	case t.RevokedAt != nil:
+		return ErrTokenRevoked
+	case t.UsedAt != nil:
+		return ErrTokenReused
+	case !now.Before(t.ExpiresAt):
+		return ErrTokenExpired
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PgRefreshStore struct {
+	db *pgxpool.Pool
+}
+
+func NewPgRefreshStore(db *pgxpool.Pool) *PgRefreshStore {
+	return &PgRefreshStore{
+		db: db,
+	}
+}
+
+func (s *PgRefreshStore) Save(ctx context.Context, t RefreshToken) error {
+	_, err := s.db.Exec(ctx, "CALL sp_save_refresh_token($1, $2, $3, $4)",
+		t.ID, t.FamilyID, t.UserID, t.ExpiresAt)
+	return err
+}
+
+func (s *PgRefreshStore) Consume(ctx context.Context, id string) (RefreshToken, error) {
+	t := RefreshToken{ID: id}
+
+	err := s.db.QueryRow(ctx, `
+		UPDATE refresh_tokens SET used_at = NOW()
+		WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+		RETURNING family_id, user_id, expires_at, used_at
+	`, id).Scan(&t.FamilyID, &t.UserID, &t.ExpiresAt, &t.UsedAt)
+
+	if err == nil {
+		return t, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return RefreshToken{}, err
+	}
+
+	err = s.db.QueryRow(ctx, `
+		SELECT family_id, user_id, expires_at, used_at, revoked_at
+		FROM refresh_tokens WHERE id = $1
+	`, id).Scan(&t.FamilyID, &t.UserID, &t.ExpiresAt, &t.UsedAt, &t.RevokedAt)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return RefreshToken{}, ErrTokenNotFound
+	}
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	if err := checkConsumable(t, time.Now()); err != nil {
+		return t, err
+	}
+	return RefreshToken{}, ErrTokenNotFound
+}
+
+func (s *PgRefreshStore) RevokeFamily(ctx context.Context, familyID string) error {
+	_, err := s.db.Exec(ctx, "CALL sp_revoke_refresh_family($1)", familyID)
+	return err
+}
+
+func (s *PgRefreshStore) RevokeUser(ctx context.Context, userID int64) error {
+	_, err := s.db.Exec(ctx, "CALL sp_revoke_user_sessions($1)", userID)
+	return err
+}
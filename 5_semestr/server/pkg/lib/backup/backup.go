@@ -1,19 +1,31 @@
 package backup
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"time"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/config"
 )
 
-func PerformBackup() (string, error) {
+// Service снимает дампы базы pg_dump в каталог из конфигурации.
+type Service struct {
+	cfg config.Backup
+}
+
+func New(cfg config.Backup) *Service {
+	return &Service{cfg: cfg}
+}
+
+func (s *Service) PerformBackup() (string, error) {
 	filename := fmt.Sprintf("backup_%s.sql", time.Now().Format("2006-01-02_15-04-05"))
-	absPath := filepath.Join("/root/backups", filename)
+	absPath := filepath.Join(s.cfg.Dir, filename)
 
-	if err := os.MkdirAll("/root/backups", 0777); err != nil {
+	if err := os.MkdirAll(s.cfg.Dir, 0777); err != nil {
 		return "", fmt.Errorf("failed to create directory: %v", err)
 	}
 
@@ -23,10 +35,10 @@ func PerformBackup() (string, error) {
 	}
 	defer outFile.Close()
 
-	pgPwd := os.Getenv("POSTGRES_PASSWORD")
-	pgHost := os.Getenv("DB_HOST")
-	pgUser := os.Getenv("POSTGRES_USER")
-	pgDb := os.Getenv("POSTGRES_DB")
+	pgPwd := s.cfg.Password
+	pgHost := s.cfg.Host
+	pgUser := s.cfg.User
+	pgDb := s.cfg.Database
 
 	cmd := exec.Command("pg_dump", "-h", pgHost, "-U", pgUser, "-d", pgDb)
 
@@ -53,22 +65,24 @@ func PerformBackup() (string, error) {
 	return filename, nil
 }
 
-func StartDailyBackupScheduler() {
-	
-	ticker := time.NewTicker(24 * time.Hour)
-	
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				log.Println("Starting automatic daily backup...")
-				_, err := PerformBackup()
-				if err != nil {
-					log.Printf("Auto-backup failed: %v", err)
-				}
+// RunScheduler снимает бэкап раз в cfg.Interval, пока не отменен ctx.
+// Начатый дамп доводится до конца.
+func (s *Service) RunScheduler(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval.Std())
+	defer ticker.Stop()
+
+	log.Printf("Backup scheduler started (every %s)", s.cfg.Interval.Std())
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			log.Println("Starting automatic backup...")
+			_, err := s.PerformBackup()
+			if err != nil {
+				log.Printf("Auto-backup failed: %v", err)
 			}
 		}
-	}()
-	
-	log.Println("Daily backup scheduler started (every 24h)")
-}
\ No newline at end of file
+	}
+}
@@ -0,0 +1,63 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/password"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+type Mode string
+
+const (
+	// ModeRehash хеширует сохраненный открытый пароль: войти им можно
+	// один раз, после чего пароль обязательно нужно сменить.
+	ModeRehash Mode = "rehash"
+	// ModeExpire заменяет пароль неизвестным значением: восстановить доступ
+	// можно только через сброс пароля.
+	ModeExpire Mode = "expire"
+)
+
+type Result struct {
+	Migrated []string
+}
+
+// Migrate находит учетные записи, у которых в password_hash лежит не
+// bcrypt-хеш, заменяет его и помечает must_change_password.
+func Migrate(ctx context.Context, store repository.Store, mode Mode) (Result, error) {
+	var res Result
+
+	if mode != ModeRehash && mode != ModeExpire {
+		return res, fmt.Errorf("unknown credentials migration mode %q", mode)
+	}
+
+	creds, err := store.Users().PlaintextCredentials(ctx)
+	if err != nil {
+		return res, err
+	}
+
+	for _, cr := range creds {
+		plain := cr.Value
+		if mode == ModeExpire {
+			if plain, _, err = password.GenerateToken(); err != nil {
+				return res, err
+			}
+		}
+
+		hashed, err := password.HashPassword(plain)
+		if err != nil {
+			return res, err
+		}
+
+		if err := store.Users().ReplaceCredential(ctx, cr.UserID, hashed); err != nil {
+			return res, fmt.Errorf("migrate credential for %s: %w", cr.Login, err)
+		}
+
+		res.Migrated = append(res.Migrated, cr.Login)
+		log.Printf("Credential for %s migrated (%s), password change required", cr.Login, mode)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,62 @@
+package credentials
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/auth"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/lib/password"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository/memory"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		mode         Mode
+		oldPassValid bool
+	}{
+		{ModeRehash, true},
+		{ModeExpire, false},
+	} {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			store := memory.NewStore()
+			hash, _ := password.HashPassword("Adm1n-pass")
+			store.AddEmployee(auth.RoleAdmin, repository.EmployeeRegistration{
+				Login: "admin", PasswordHash: hash, FirstName: "Иван", LastName: "Петров",
+			})
+			legacyID := store.AddEmployee(auth.RoleManager, repository.EmployeeRegistration{
+				Login: "manager", PasswordHash: "secret", FirstName: "Анна", LastName: "Смирнова",
+			})
+
+			res, err := Migrate(ctx, store, tc.mode)
+			if err != nil {
+				t.Fatalf("migrate: %v", err)
+			}
+			if !slices.Equal(res.Migrated, []string{"manager"}) {
+				t.Fatalf("Expected only the plaintext account migrated, got %v", res.Migrated)
+			}
+
+			user, err := store.Users().GetByID(ctx, legacyID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !user.MustChangePassword {
+				t.Errorf("Migrated account must change password")
+			}
+			if valid := password.CheckPasswordHash("secret", user.PasswordHash); valid != tc.oldPassValid {
+				t.Errorf("Old password valid = %v, expected %v", valid, tc.oldPassValid)
+			}
+
+			if res, err := Migrate(ctx, store, tc.mode); err != nil || len(res.Migrated) != 0 {
+				t.Errorf("Second run should find nothing, got %v, %v", res.Migrated, err)
+			}
+		})
+	}
+
+	if _, err := Migrate(ctx, memory.NewStore(), "plain"); err == nil {
+		t.Error("Unknown mode should fail")
+	}
+}
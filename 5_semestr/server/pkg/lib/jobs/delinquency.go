@@ -0,0 +1,58 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+// ProcessDelinquency пересчитывает просрочку договоров на дату now и
+// пишет в журнал аудита каждую смену корзины или статуса.
+func ProcessDelinquency(store repository.Store, defaultAfterDays int) Func {
+	return func(ctx context.Context, now time.Time) error {
+		return store.InTx(ctx, func(tx repository.Store) error {
+			changes, err := tx.Loans().ProcessDelinquency(ctx, now, defaultAfterDays)
+			if err != nil {
+				return err
+			}
+
+			for _, c := range changes {
+				action := "DELINQUENCY_CHANGED"
+				switch {
+				case c.ToStatus == c.FromStatus:
+				case c.ToStatus == models.LoanDefaulted:
+					action = "LOAN_DEFAULTED"
+				default:
+					action = "LOAN_CURED"
+				}
+
+				err := tx.Audit().Log(ctx, repository.AuditEntry{
+					Action:   action,
+					Entity:   "loan_contracts",
+					EntityID: c.ContractID,
+					Details: map[string]string{
+						"contract":    c.ContractNumber,
+						"date":        now.Format(time.DateOnly),
+						"daysPastDue": strconv.Itoa(c.DaysPastDue),
+						"fromBucket":  c.FromBucket,
+						"toBucket":    c.ToBucket,
+						"fromStatus":  c.FromStatus,
+						"toStatus":    c.ToStatus,
+					},
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			if len(changes) > 0 {
+				log.Printf("Delinquency processed: %d contract(s) changed", len(changes))
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,36 @@
+// Package jobs - периодические задачи по договорам, которые сервер
+// запускает как воркеры lifecycle.
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Func выполняет задачу на момент now.
+type Func func(ctx context.Context, now time.Time) error
+
+// Every возвращает воркер, который запускает fn сразу и затем каждые
+// interval, пока не отменен ctx. Ошибка запуска пишется в лог, следующий
+// запуск идет по расписанию.
+func Every(name string, interval time.Duration, fn Func) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		log.Printf("Job %s scheduled (every %s)", name, interval)
+
+		for {
+			if err := fn(ctx, time.Now()); err != nil && ctx.Err() == nil {
+				log.Printf("Job %s failed: %v", name, err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+}
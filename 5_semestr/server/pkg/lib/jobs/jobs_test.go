@@ -0,0 +1,37 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEveryRunsAtStartAndOnTicks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	runs := make(chan struct{}, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Every("test", 10*time.Millisecond, func(ctx context.Context, now time.Time) error {
+			runs <- struct{}{}
+			return errors.New("ошибка не останавливает расписание")
+		})(ctx)
+	}()
+
+	for i := range 3 {
+		select {
+		case <-runs:
+		case <-time.After(time.Second):
+			t.Fatalf("run %d did not happen", i+1)
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("worker did not stop after cancel")
+	}
+}
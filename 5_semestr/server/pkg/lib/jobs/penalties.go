@@ -0,0 +1,35 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+// AccruePenalties начисляет пени по просроченным платежам на дату now и
+// записывает итог в журнал аудита от имени системы.
+func AccruePenalties(store repository.Store) Func {
+	return func(ctx context.Context, now time.Time) error {
+		return store.InTx(ctx, func(tx repository.Store) error {
+			res, err := tx.Schedules().AccruePenalties(ctx, now)
+			if err != nil || res.Installments == 0 {
+				return err
+			}
+
+			log.Printf("Penalties accrued: %d installment(s), %s", res.Installments, res.Amount)
+
+			return tx.Audit().Log(ctx, repository.AuditEntry{
+				Action: "ACCRUE_PENALTIES",
+				Entity: "repayment_schedule",
+				Details: map[string]string{
+					"date":         now.Format(time.DateOnly),
+					"installments": strconv.Itoa(res.Installments),
+					"amount":       res.Amount.String(),
+				},
+			})
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+// ReminderSender ставит в очередь напоминание о платеже (mail.Sender).
+// Пока Enabled возвращает false, письма никуда не уходят.
+type ReminderSender interface {
+	Enabled() bool
+	SendPaymentReminderEmail(ctx context.Context, outbox repository.OutboxRepository, toEmail string, fullName string, contractNumber string, amount models.Money, dueDate time.Time, overdue bool) error
+}
+
+// SendPaymentReminders рассылает напоминания о платежах через daysBefore
+// дней и о только что просроченных. Напоминания отмечаются отправленными
+// в той же транзакции, в которой письма ставятся в очередь, так что ошибка
+// постановки откатывает и отметку. Пока почта выключена, напоминания не
+// выбираются вовсе и уйдут после ее включения.
+func SendPaymentReminders(store repository.Store, sender ReminderSender, daysBefore []int) Func {
+	return func(ctx context.Context, now time.Time) error {
+		if !sender.Enabled() {
+			return nil
+		}
+
+		return store.InTx(ctx, func(tx repository.Store) error {
+			reminders, err := tx.Schedules().ClaimReminders(ctx, now, daysBefore)
+			if err != nil {
+				return err
+			}
+
+			for _, r := range reminders {
+				err := sender.SendPaymentReminderEmail(ctx, tx.Outbox(), r.Email, r.ClientName, r.ContractNumber,
+					r.Amount, r.PaymentDate, r.Kind == repository.ReminderOverdue)
+				if err != nil {
+					return err
+				}
+			}
+
+			if len(reminders) > 0 {
+				log.Printf("Payment reminders queued: %d", len(reminders))
+			}
+			return nil
+		})
+	}
+}
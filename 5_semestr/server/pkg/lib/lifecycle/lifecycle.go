@@ -0,0 +1,85 @@
+// Package lifecycle запускает фоновые воркеры (планировщик бэкапов,
+// отправку почты) и останавливает их при завершении сервера.
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Manager отменяет контекст всех воркеров при Shutdown и ждет, пока они
+// доделают текущую работу.
+type Manager struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	running map[string]int
+}
+
+func New() *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		ctx:     ctx,
+		cancel:  cancel,
+		running: map[string]int{},
+	}
+}
+
+// Go запускает воркер. run должен вернуться после отмены ctx.
+func (m *Manager) Go(name string, run func(ctx context.Context)) {
+	m.mu.Lock()
+	m.running[name]++
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer m.finished(name)
+
+		log.Printf("Worker %s started", name)
+		run(m.ctx)
+		log.Printf("Worker %s stopped", name)
+	}()
+}
+
+func (m *Manager) finished(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.running[name]--; m.running[name] == 0 {
+		delete(m.running, name)
+	}
+}
+
+// Shutdown останавливает воркеры и ждет их завершения не дольше, чем
+// живет ctx. При таймауте возвращает имена незавершившихся воркеров.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		names := make([]string, 0, len(m.running))
+		for name := range m.running {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return fmt.Errorf("workers still running (%s): %w", strings.Join(names, ", "), ctx.Err())
+	}
+}
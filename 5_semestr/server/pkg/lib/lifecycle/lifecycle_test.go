@@ -0,0 +1,47 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShutdownWaitsForWorkers(t *testing.T) {
+	m := New()
+
+	finished := false
+	m.Go("slow", func(ctx context.Context) {
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		finished = true
+	})
+
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if !finished {
+		t.Error("Shutdown returned before the worker finished")
+	}
+}
+
+func TestShutdownTimeoutNamesStuckWorkers(t *testing.T) {
+	m := New()
+
+	release := make(chan struct{})
+	defer close(release)
+	m.Go("stuck", func(ctx context.Context) { <-release })
+	m.Go("quick", func(ctx context.Context) { <-ctx.Done() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := m.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "stuck") || strings.Contains(err.Error(), "quick") {
+		t.Errorf("Error should name only the stuck worker: %v", err)
+	}
+}
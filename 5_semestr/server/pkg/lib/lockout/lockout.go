@@ -0,0 +1,138 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrBlocked = errors.New("too many failed attempts")
+
+// Status - состояние ключа (логина или IP) после очередной неудачной попытки.
+type Status struct {
+	Failures     int
+	BlockedUntil time.Time
+	Locked       bool
+}
+
+// Limiter считает неудачные попытки входа по ключу. Check возвращает
+// ErrBlocked и время ожидания, пока ключ заблокирован.
+type Limiter interface {
+	Check(ctx context.Context, key string) (time.Duration, error)
+	Fail(ctx context.Context, key string) (Status, error)
+	Reset(ctx context.Context, key string) error
+}
+
+// Policy задает экспоненциальную задержку после FreeAttempts неудач и
+// временную блокировку после MaxFailures. Счетчик сбрасывается, если
+// с последней неудачи прошло больше Window.
+type Policy struct {
+	FreeAttempts    int
+	MaxFailures     int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutDuration time.Duration
+	Window          time.Duration
+}
+
+var DefaultPolicy = Policy{
+	FreeAttempts:    3,
+	MaxFailures:     10,
+	BaseDelay:       time.Second,
+	MaxDelay:        5 * time.Minute,
+	LockoutDuration: 30 * time.Minute,
+	Window:          time.Hour,
+}
+
+func LoginKey(login string) string {
+	return "login:" + login
+}
+
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+func SecondFactorKey(userID int64) string {
+	return "2fa:" + strconv.FormatInt(userID, 10)
+}
+
+func (p Policy) status(failures int, now time.Time) Status {
+	st := Status{Failures: failures}
+
+	switch {
+	case failures >= p.MaxFailures:
+		st.Locked = true
+		st.BlockedUntil = now.Add(p.LockoutDuration)
+	case failures >= p.FreeAttempts:
+		delay := p.BaseDelay << (failures - p.FreeAttempts)
+		if delay > p.MaxDelay || delay <= 0 {
+			delay = p.MaxDelay
+		}
+		st.BlockedUntil = now.Add(delay)
+	}
+	return st
+}
+
+type entry struct {
+	failures     int
+	blockedUntil time.Time
+	lastFailure  time.Time
+}
+
+type MemoryLimiter struct {
+	policy  Policy
+	mu      sync.Mutex
+	entries map[string]entry
+	now     func() time.Time
+}
+
+func NewMemoryLimiter(policy Policy) *MemoryLimiter {
+	return &MemoryLimiter{
+		policy:  policy,
+		entries: make(map[string]entry),
+		now:     time.Now,
+	}
+}
+
+func (l *MemoryLimiter) Check(ctx context.Context, key string) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		return 0, nil
+	}
+	if wait := e.blockedUntil.Sub(l.now()); wait > 0 {
+		return wait, ErrBlocked
+	}
+	return 0, nil
+}
+
+func (l *MemoryLimiter) Fail(ctx context.Context, key string) (Status, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	e := l.entries[key]
+	if now.Sub(e.lastFailure) > l.policy.Window {
+		e.failures = 0
+	}
+
+	e.failures++
+	e.lastFailure = now
+	st := l.policy.status(e.failures, now)
+	e.blockedUntil = st.BlockedUntil
+	l.entries[key] = e
+
+	return st, nil
+}
+
+func (l *MemoryLimiter) Reset(ctx context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.entries, key)
+	return nil
+}
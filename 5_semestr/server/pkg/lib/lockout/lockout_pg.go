@@ -0,0 +1,78 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PgLimiter struct {
+	policy Policy
+	db     *pgxpool.Pool
+}
+
+func NewPgLimiter(db *pgxpool.Pool, policy Policy) *PgLimiter {
+	return &PgLimiter{
+		policy: policy,
+		db:     db,
+	}
+}
+
+func (l *PgLimiter) Check(ctx context.Context, key string) (time.Duration, error) {
+	var blockedUntil *time.Time
+
+	err := l.db.QueryRow(ctx, "SELECT blocked_until FROM login_attempts WHERE key = $1", key).
+		Scan(&blockedUntil)
+
+	if errors.Is(err, pgx.ErrNoRows) || blockedUntil == nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if wait := time.Until(*blockedUntil); wait > 0 {
+		return wait, ErrBlocked
+	}
+	return 0, nil
+}
+
+func (l *PgLimiter) Fail(ctx context.Context, key string) (Status, error) {
+	tx, err := l.db.Begin(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var failures int
+	var now time.Time
+
+	err = tx.QueryRow(ctx, "SELECT * FROM fn_register_login_failure($1, $2)",
+		key, int(l.policy.Window.Seconds())).Scan(&failures, &now)
+	if err != nil {
+		return Status{}, err
+	}
+
+	st := l.policy.status(failures, now)
+
+	var blockedUntil *time.Time
+	if !st.BlockedUntil.IsZero() {
+		blockedUntil = &st.BlockedUntil
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE login_attempts SET blocked_until = $2, locked = $3 WHERE key = $1",
+		key, blockedUntil, st.Locked)
+	if err != nil {
+		return Status{}, err
+	}
+
+	return st, tx.Commit(ctx)
+}
+
+func (l *PgLimiter) Reset(ctx context.Context, key string) error {
+	_, err := l.db.Exec(ctx, "DELETE FROM login_attempts WHERE key = $1", key)
+	return err
+}
@@ -0,0 +1,71 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiterBackoffAndLockout(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	l := NewMemoryLimiter(DefaultPolicy)
+	l.now = func() time.Time { return now }
+
+	key := LoginKey("admin")
+
+	for i := 1; i < DefaultPolicy.FreeAttempts; i++ {
+		l.Fail(ctx, key)
+		if _, err := l.Check(ctx, key); err != nil {
+			t.Fatalf("Attempt %d should not be blocked yet", i)
+		}
+	}
+
+	st, _ := l.Fail(ctx, key)
+	if st.BlockedUntil.Sub(now) != DefaultPolicy.BaseDelay {
+		t.Errorf("Expected base delay after free attempts, got %v", st.BlockedUntil.Sub(now))
+	}
+
+	st, _ = l.Fail(ctx, key)
+	if st.BlockedUntil.Sub(now) != 2*DefaultPolicy.BaseDelay {
+		t.Errorf("Expected doubled delay, got %v", st.BlockedUntil.Sub(now))
+	}
+
+	for !st.Locked {
+		st, _ = l.Fail(ctx, key)
+	}
+	if st.Failures != DefaultPolicy.MaxFailures {
+		t.Errorf("Expected lockout at %d failures, got %d", DefaultPolicy.MaxFailures, st.Failures)
+	}
+
+	wait, err := l.Check(ctx, key)
+	if !errors.Is(err, ErrBlocked) || wait != DefaultPolicy.LockoutDuration {
+		t.Errorf("Expected lockout for %v, got %v (%v)", DefaultPolicy.LockoutDuration, wait, err)
+	}
+
+	l.Reset(ctx, key)
+	if _, err := l.Check(ctx, key); err != nil {
+		t.Error("Reset should unlock the key")
+	}
+}
+
+func TestMemoryLimiterWindowExpires(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	l := NewMemoryLimiter(DefaultPolicy)
+	l.now = func() time.Time { return now }
+
+	key := IPKey("10.0.0.1")
+	for i := 0; i < DefaultPolicy.FreeAttempts; i++ {
+		l.Fail(ctx, key)
+	}
+
+	now = now.Add(DefaultPolicy.Window + time.Minute)
+	st, _ := l.Fail(ctx, key)
+	if st.Failures != 1 {
+		t.Errorf("Counter should restart after the window, got %d", st.Failures)
+	}
+}
@@ -1,85 +1,91 @@
+// Package mail готовит письма клиентам и сотрудникам по шаблонам, ставит
+// их в очередь outbox в базе и отправляет воркером через Mailer (SMTP или
+// maildir).
 package mail
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"net/smtp"
-	"os"
 	"time"
-)
 
-func SendLoginEmail(toEmail string, userName string, ip string) {
-	host := os.Getenv("SMTP_HOST")
-	port := os.Getenv("SMTP_PORT")
-	user := os.Getenv("SMTP_USER")
-	pass := os.Getenv("SMTP_PASS")
-	from := os.Getenv("SMTP_FROM")
+	"github.com/stepan41k/Kursach/5_semestr/pkg/config"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
 
-	if host == "" || toEmail == "" {
-		return
-	}
+// Виды писем; совпадают с именами шаблонов.
+const (
+	KindLogin           = "login"
+	KindActivation      = "activation"
+	KindPasswordReset   = "password_reset"
+	KindPaymentReminder = "payment_reminder"
+)
 
-	headers := fmt.Sprintf("From: %s\r\n", from) +
-		fmt.Sprintf("To: %s\r\n", toEmail) +
-		"Subject: Оповещение о входе в RoseBank\r\n" +
-		"MIME-version: 1.0;\r\n" +
-		"Content-Type: text/plain; charset=\"UTF-8\";\r\n\r\n"
+// Sender заполняет шаблоны и кладет письма в outbox, который ему
+// передают: обычно это tx.Outbox() той же транзакции, что и действие, по
+// которому пишется письмо. Если отправка выключена (config.Mail.Enabled),
+// письма не ставятся в очередь.
+type Sender struct {
+	cfg config.Mail
+}
 
-	body := fmt.Sprintf("Здравствуйте, %s!\n\nБыл выполнен вход в ваш аккаунт.\nВремя: %s\nIP-адрес: %s\n\nЕсли это были не вы, срочно обратитесь к администратору.",
-		userName, time.Now().Format("02.01.2006 15:04"), ip)
+func NewSender(cfg config.Mail) *Sender {
+	return &Sender{cfg: cfg}
+}
 
-	msg := []byte(headers + body)
+// Enabled сообщает, ставятся ли письма в очередь.
+func (s *Sender) Enabled() bool {
+	return s.cfg.Enabled()
+}
 
-	auth := smtp.PlainAuth("", user, pass, host)
-	addr := fmt.Sprintf("%s:%s", host, port)
-	
-	err := smtp.SendMail(addr, auth, user, []string{toEmail}, msg)
+func (s *Sender) enqueue(ctx context.Context, outbox repository.OutboxRepository, kind string, toEmail string, data any) error {
+	if !s.Enabled() || toEmail == "" {
+		log.Printf("Mail not configured or email empty, %s email skipped", kind)
+		return nil
+	}
 
+	subject, text, html, err := render(kind, data)
 	if err != nil {
-		log.Printf("Failed to send login email to %s: %v", toEmail, err)
-	} else {
-		log.Printf("Login notification sent to %s", toEmail)
+		return fmt.Errorf("render %s email: %w", kind, err)
 	}
-}
-
 
-func SendClientWelcomeEmail(toEmail string, fullName string, login string, password string) {
-	host := os.Getenv("SMTP_HOST")
-	port := os.Getenv("SMTP_PORT")
-	user := os.Getenv("SMTP_USER") 
-	pass := os.Getenv("SMTP_PASS")
-	from := os.Getenv("SMTP_FROM")
-
-	if host == "" || toEmail == "" {
-		log.Println("SMTP not configured or email empty")
-		return
-	}
+	_, err = outbox.Enqueue(ctx, repository.OutboxMessage{
+		Kind:    kind,
+		To:      toEmail,
+		Subject: subject,
+		Text:    text,
+		HTML:    html,
+	})
+	return err
+}
 
-	headers := fmt.Sprintf("From: %s\r\n", from) +
-		fmt.Sprintf("To: %s\r\n", toEmail) +
-		"Subject: Добро пожаловать в RoseBank!\r\n" +
-		"MIME-version: 1.0;\r\n" +
-		"Content-Type: text/plain; charset=\"UTF-8\";\r\n\r\n"
+func (s *Sender) SendLoginEmail(ctx context.Context, outbox repository.OutboxRepository, toEmail string, userName string, ip string) error {
+	return s.enqueue(ctx, outbox, KindLogin, toEmail, struct {
+		Name, Time, IP string
+	}{userName, time.Now().Format("02.01.2006 15:04"), ip})
+}
 
-	body := fmt.Sprintf(
-		"Здравствуйте, %s!\n\n"+
-			"Вы были успешно зарегистрированы в системе RoseBank.\n"+
-			"Для входа в Личный кабинет клиента используйте следующие данные:\n\n"+
-			"Логин: %s\n"+
-			"Пароль: %s\n\n"+
-			"Пожалуйста, никому не сообщайте свой пароль.",
-		fullName, login, password,
-	)
+func (s *Sender) SendClientActivationEmail(ctx context.Context, outbox repository.OutboxRepository, toEmail string, fullName string, login string, token string, ttl time.Duration) error {
+	return s.enqueue(ctx, outbox, KindActivation, toEmail, struct {
+		Name, Login, Link string
+		Hours             int
+	}{fullName, login, s.cfg.AppURL + "/#activate?token=" + token, int(ttl.Hours())})
+}
 
-	msg := []byte(headers + body)
-	addr := fmt.Sprintf("%s:%s", host, port)
-	auth := smtp.PlainAuth("", user, pass, host)
+func (s *Sender) SendPasswordResetEmail(ctx context.Context, outbox repository.OutboxRepository, toEmail string, fullName string, token string, ttl time.Duration) error {
+	return s.enqueue(ctx, outbox, KindPasswordReset, toEmail, struct {
+		Name, Link string
+		Minutes    int
+	}{fullName, s.cfg.AppURL + "/#reset-password?token=" + token, int(ttl.Minutes())})
+}
 
-	err := smtp.SendMail(addr, auth, user, []string{toEmail}, msg)
-	
-	if err != nil {
-		log.Printf("Failed to send welcome email to %s: %v", toEmail, err)
-	} else {
-		log.Printf("Welcome email sent to %s", toEmail)
-	}
-}
\ No newline at end of file
+// SendPaymentReminderEmail напоминает о платеже dueDate по договору
+// contractNumber или, если overdue, о том, что платеж просрочен. amount -
+// сумма к оплате вместе с пени.
+func (s *Sender) SendPaymentReminderEmail(ctx context.Context, outbox repository.OutboxRepository, toEmail string, fullName string, contractNumber string, amount models.Money, dueDate time.Time, overdue bool) error {
+	return s.enqueue(ctx, outbox, KindPaymentReminder, toEmail, struct {
+		Name, Contract, Amount, Date, Link string
+		Overdue                            bool
+	}{fullName, contractNumber, amount.Format(), dueDate.Format("02.01.2006"), s.cfg.AppURL, overdue})
+}
@@ -0,0 +1,165 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/config"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository/memory"
+)
+
+func testConfig() config.Mail {
+	cfg := config.Default().Mail
+	cfg.Host = "smtp.example.com"
+	cfg.Port = "587"
+	cfg.From = "RoseBank <noreply@rosebank.example>"
+	cfg.MaxAttempts = 3
+	return cfg
+}
+
+func TestMessageBytes(t *testing.T) {
+	subject, text, html, err := render(KindLogin, struct{ Name, Time, IP string }{"<b>Иван</b>", "01.02.2025 10:00", "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+
+	raw, err := Message{
+		ID: "1.2@rosebank.example", From: "RoseBank <noreply@rosebank.example>", To: "client@example.com",
+		Subject: subject, Text: text, HTML: html, Date: time.Now(),
+	}.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: %v", err)
+	}
+
+	msg, err := netmail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+	if h := msg.Header.Get("Subject"); !strings.HasPrefix(h, "=?UTF-8?b?") {
+		t.Errorf("Subject should be RFC 2047 encoded, got %q", h)
+	}
+	if got, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject")); got != "Оповещение о входе в RoseBank" {
+		t.Errorf("Unexpected subject %q", got)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Expected multipart/alternative, got %q", msg.Header.Get("Content-Type"))
+	}
+
+	parts := map[string]string{}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read part: %v", err)
+		}
+		body, _ := io.ReadAll(p)
+		contentType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		parts[contentType] = string(body)
+	}
+
+	if !strings.Contains(parts["text/plain"], "Здравствуйте, <b>Иван</b>!") {
+		t.Errorf("Text part should contain the name as is: %q", parts["text/plain"])
+	}
+	if !strings.Contains(parts["text/html"], "Здравствуйте, &lt;b&gt;Иван&lt;/b&gt;!") {
+		t.Errorf("HTML part should escape the name: %q", parts["text/html"])
+	}
+}
+
+func TestWorkerRetriesWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	cfg := testConfig()
+	store := memory.NewStore()
+	sender := NewSender(cfg)
+
+	if err := NewSender(config.Default().Mail).SendLoginEmail(ctx, store.Outbox(), "client@example.com", "Иван", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(store.OutboxMessages("pending")); n != 0 {
+		t.Fatalf("Disabled mail should not be queued, got %d", n)
+	}
+
+	if err := sender.SendLoginEmail(ctx, store.Outbox(), "client@example.com", "Иван", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	transport := &Memory{}
+	transport.Fail(errors.New("connection refused"))
+	w := NewWorker(store.Outbox(), transport, cfg)
+
+	// Попытки: сразу, через минуту, еще через две; третья последняя.
+	now := time.Now()
+	for _, at := range []time.Duration{0, 59 * time.Second, time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		if _, err := w.Deliver(ctx, now.Add(at)); err != nil {
+			t.Fatalf("deliver at +%s: %v", at, err)
+		}
+	}
+	if failed := store.OutboxMessages("failed"); len(failed) != 1 || failed[0].Attempts != 3 {
+		t.Fatalf("Expected the message to fail after 3 attempts, got %+v", failed)
+	}
+
+	if err := sender.SendLoginEmail(ctx, store.Outbox(), "client@example.com", "Иван", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	transport.Fail(nil)
+
+	now = time.Now()
+	for range 2 {
+		if _, err := w.Deliver(ctx, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sent := transport.Sent()
+	if len(sent) != 1 || sent[0].To != "client@example.com" || sent[0].Subject != "Оповещение о входе в RoseBank" {
+		t.Fatalf("Expected one delivered message, got %+v", sent)
+	}
+	if !strings.HasSuffix(sent[0].ID, "@rosebank.example") {
+		t.Errorf("Message-ID should use the sender domain, got %q", sent[0].ID)
+	}
+	if n := len(store.OutboxMessages("sent")); n != 1 {
+		t.Errorf("Expected one sent message in the outbox, got %d", n)
+	}
+}
+
+func TestMaildir(t *testing.T) {
+	dir := t.TempDir()
+	md, err := NewMaildir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = md.Send(context.Background(), Message{
+		From: "noreply@rosebank.example", To: "client@example.com",
+		Subject: "Проверка", Text: "текст", HTML: "<p>текст</p>", Date: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	files, _ := os.ReadDir(filepath.Join(dir, "new"))
+	if len(files) != 1 {
+		t.Fatalf("Expected one message in new/, got %d", len(files))
+	}
+	if tmp, _ := os.ReadDir(filepath.Join(dir, "tmp")); len(tmp) != 0 {
+		t.Errorf("tmp/ should be empty, got %d files", len(tmp))
+	}
+
+	raw, _ := os.ReadFile(filepath.Join(dir, "new", files[0].Name()))
+	if _, err := netmail.ReadMessage(bytes.NewReader(raw)); err != nil {
+		t.Errorf("Maildir file is not a valid message: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Шаблон письма kind лежит в templates/<kind>.tmpl и определяет subject,
+// text (текстовая версия) и content (тело HTML-версии, которое
+// оборачивает html из layout.html.tmpl). Текст собирается text/template,
+// HTML - html/template, поэтому данные в HTML экранируются.
+//
+//go:embed templates
+var templateFS embed.FS
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+var templates = parseTemplates("login", "activation", "password_reset", "payment_reminder")
+
+func parseTemplates(kinds ...string) map[string]emailTemplate {
+	out := make(map[string]emailTemplate, len(kinds))
+	for _, kind := range kinds {
+		file := "templates/" + kind + ".tmpl"
+		out[kind] = emailTemplate{
+			text: texttemplate.Must(texttemplate.ParseFS(templateFS, file)),
+			html: htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/layout.html.tmpl", file)),
+		}
+	}
+	return out
+}
+
+// render заполняет шаблон kind данными data.
+func render(kind string, data any) (subject string, text string, html string, err error) {
+	t, ok := templates[kind]
+	if !ok {
+		return "", "", "", fmt.Errorf("unknown email template %q", kind)
+	}
+
+	var buf bytes.Buffer
+	if err := t.text.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return "", "", "", err
+	}
+	subject = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err := t.text.ExecuteTemplate(&buf, "text", data); err != nil {
+		return "", "", "", err
+	}
+	text = strings.TrimSpace(buf.String()) + "\n"
+
+	buf.Reset()
+	if err := t.html.ExecuteTemplate(&buf, "html", data); err != nil {
+		return "", "", "", err
+	}
+	return subject, text, buf.String(), nil
+}
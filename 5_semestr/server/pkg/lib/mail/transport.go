@@ -0,0 +1,191 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/config"
+)
+
+// smtpTimeout ограничивает сеанс с SMTP-сервером, если у ctx нет своего
+// срока.
+const smtpTimeout = 30 * time.Second
+
+// Mailer доставляет готовое письмо.
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// NewMailer возвращает транспорт из конфигурации.
+func NewMailer(cfg config.Mail) (Mailer, error) {
+	if cfg.Transport == config.MailMaildir {
+		return NewMaildir(cfg.Dir)
+	}
+	return NewSMTP(cfg), nil
+}
+
+// SMTP отправляет письма через SMTP-сервер, с STARTTLS, если сервер его
+// поддерживает, и с авторизацией, если задан пользователь.
+type SMTP struct {
+	cfg config.Mail
+}
+
+func NewSMTP(cfg config.Mail) *SMTP {
+	return &SMTP{cfg: cfg}
+}
+
+func (t *SMTP) Send(ctx context.Context, m Message) error {
+	body, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	from, err := netmail.ParseAddress(m.From)
+	if err != nil {
+		return err
+	}
+	to, err := netmail.ParseAddress(m.To)
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(t.cfg.Host, t.cfg.Port))
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(smtpTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, t.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: t.cfg.Host}); err != nil {
+			return err
+		}
+	}
+	if t.cfg.User != "" {
+		if err := c.Auth(smtp.PlainAuth("", t.cfg.User, t.cfg.Password, t.cfg.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// Maildir складывает письма файлами в каталог в формате maildir: файл
+// пишется в tmp и переносится в new, так что читатель каталога не видит
+// недописанных писем.
+type Maildir struct {
+	dir  string
+	host string
+	seq  atomic.Int64
+}
+
+func NewMaildir(dir string) (*Maildir, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, fmt.Errorf("maildir %s: %w", dir, err)
+		}
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return &Maildir{dir: dir, host: host}, nil
+}
+
+func (t *Maildir) Send(ctx context.Context, m Message) error {
+	body, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), t.seq.Add(1), t.host)
+	tmp := filepath.Join(t.dir, "tmp", name)
+
+	if err := os.WriteFile(tmp, body, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(t.dir, "new", name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Memory запоминает письма вместо отправки; для тестов. Пока задана
+// ошибка (Fail), Send возвращает ее.
+type Memory struct {
+	mu   sync.Mutex
+	sent []Message
+	err  error
+}
+
+func (t *Memory) Send(ctx context.Context, m Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err != nil {
+		return t.err
+	}
+	if _, err := m.Bytes(); err != nil {
+		return err
+	}
+	t.sent = append(t.sent, m)
+	return nil
+}
+
+// Fail задает ошибку для следующих отправок; nil снова включает доставку.
+func (t *Memory) Fail(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.err = err
+}
+
+// Sent возвращает доставленные письма в порядке отправки.
+func (t *Memory) Sent() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return slices.Clone(t.sent)
+}
@@ -0,0 +1,131 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/config"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+const (
+	// batchSize - сколько писем воркер забирает из очереди за раз.
+	batchSize = 20
+	// claimLease - на сколько письмо откладывается на время отправки. Если
+	// воркер упадет посреди отправки, письмо вернется в очередь позже.
+	claimLease = 5 * time.Minute
+	// maxBackoff ограничивает задержку между попытками.
+	maxBackoff = 6 * time.Hour
+)
+
+// Worker отправляет письма из outbox через Mailer. Неудачная попытка
+// откладывается на cfg.RetryBackoff, с каждой следующей вдвое дольше;
+// после cfg.MaxAttempts попыток письмо помечается failed.
+type Worker struct {
+	outbox repository.OutboxRepository
+	mailer Mailer
+	cfg    config.Mail
+}
+
+func NewWorker(outbox repository.OutboxRepository, mailer Mailer, cfg config.Mail) *Worker {
+	return &Worker{outbox: outbox, mailer: mailer, cfg: cfg}
+}
+
+// Run проверяет очередь каждые cfg.PollInterval, пока не отменен ctx.
+// Неотправленные письма остаются в очереди до следующего запуска сервера.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.PollInterval.Std())
+	defer ticker.Stop()
+
+	for {
+		if _, err := w.Deliver(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("Mail outbox failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Deliver отправляет письма, которые пора отправить на момент now, и
+// возвращает число отправленных.
+func (w *Worker) Deliver(ctx context.Context, now time.Time) (int, error) {
+	sent := 0
+	for {
+		batch, err := w.outbox.Claim(ctx, now, claimLease, batchSize)
+		if err != nil {
+			return sent, err
+		}
+
+		for _, m := range batch {
+			if err := ctx.Err(); err != nil {
+				return sent, err
+			}
+
+			if err := w.mailer.Send(ctx, w.message(m)); err != nil {
+				if err := w.fail(ctx, m, now, err); err != nil {
+					return sent, err
+				}
+				continue
+			}
+
+			if err := w.outbox.MarkSent(ctx, m.ID); err != nil {
+				return sent, err
+			}
+			log.Printf("%s email sent to %s", m.Kind, m.To)
+			sent++
+		}
+
+		if len(batch) < batchSize {
+			return sent, nil
+		}
+	}
+}
+
+func (w *Worker) fail(ctx context.Context, m repository.OutboxMessage, now time.Time, sendErr error) error {
+	if m.Attempts >= w.cfg.MaxAttempts {
+		log.Printf("Failed to send %s email to %s, giving up after %d attempts: %v", m.Kind, m.To, m.Attempts, sendErr)
+		return w.outbox.Fail(ctx, m.ID, sendErr.Error())
+	}
+
+	at := now.Add(w.backoff(m.Attempts))
+	log.Printf("Failed to send %s email to %s (attempt %d), retry at %s: %v",
+		m.Kind, m.To, m.Attempts, at.Format(time.DateTime), sendErr)
+	return w.outbox.Retry(ctx, m.ID, at, sendErr.Error())
+}
+
+// backoff - задержка после attempt-й неудачной попытки.
+func (w *Worker) backoff(attempt int) time.Duration {
+	d := w.cfg.RetryBackoff.Std()
+	for range attempt - 1 {
+		if d *= 2; d >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return d
+}
+
+// message собирает письмо из очереди. Message-ID строится по id письма в
+// очереди, поэтому повторные попытки отправляют то же самое письмо.
+func (w *Worker) message(m repository.OutboxMessage) Message {
+	domain := "localhost"
+	if i := strings.LastIndex(w.cfg.From, "@"); i >= 0 {
+		domain = strings.TrimRight(w.cfg.From[i+1:], "> ")
+	}
+
+	return Message{
+		ID:      fmt.Sprintf("%d.%d@%s", m.ID, m.CreatedAt.Unix(), domain),
+		From:    w.cfg.From,
+		To:      m.To,
+		Subject: m.Subject,
+		Text:    m.Text,
+		HTML:    m.HTML,
+		Date:    time.Now(),
+	}
+}
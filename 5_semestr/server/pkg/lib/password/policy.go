@@ -0,0 +1,79 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"unicode"
+)
+
+const MinLength = 8
+
+var ErrWeakPassword = errors.New("password does not satisfy policy")
+
+// PolicyError перечисляет все нарушенные требования к паролю.
+type PolicyError struct {
+	Violations []string
+}
+
+func (e *PolicyError) Error() string {
+	return "Пароль не соответствует требованиям: " + strings.Join(e.Violations, "; ")
+}
+
+func (e *PolicyError) Unwrap() error {
+	return ErrWeakPassword
+}
+
+// Validate проверяет пароль по политике банка: не короче MinLength,
+// есть буквы в обоих регистрах и цифры, пароль не совпадает с логином.
+func Validate(password string, login string) error {
+	var violations []string
+
+	if len([]rune(password)) < MinLength {
+		violations = append(violations, "не менее 8 символов")
+	}
+
+	var lower, upper, digit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
+	}
+	if !lower || !upper {
+		violations = append(violations, "строчные и заглавные буквы")
+	}
+	if !digit {
+		violations = append(violations, "хотя бы одна цифра")
+	}
+	if login != "" && strings.EqualFold(password, login) {
+		violations = append(violations, "не должен совпадать с логином")
+	}
+
+	if violations != nil {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// GenerateToken возвращает случайный одноразовый токен для ссылки в письме
+// и его SHA-256, который хранится в базе вместо самого токена.
+func GenerateToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
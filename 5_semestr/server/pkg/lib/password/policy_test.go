@@ -0,0 +1,41 @@
+package password
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		password string
+		login    string
+		ok       bool
+	}{
+		{"Secure123", "user1", true},
+		{"ПарольНадежный1", "user1", true},
+		{"short1A", "user1", false},
+		{"alllowercase1", "user1", false},
+		{"NoDigitsHere", "user1", false},
+		{"Manager2024", "manager2024", false},
+	}
+
+	for _, tc := range cases {
+		err := Validate(tc.password, tc.login)
+		if tc.ok && err != nil {
+			t.Errorf("%q: unexpected error %v", tc.password, err)
+		}
+		if !tc.ok && !errors.Is(err, ErrWeakPassword) {
+			t.Errorf("%q: expected ErrWeakPassword, got %v", tc.password, err)
+		}
+	}
+}
+
+func TestGenerateToken(t *testing.T) {
+	token, hash, err := GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(token) != 64 || hash == token || HashToken(token) != hash {
+		t.Error("Token hash must be deterministic and differ from the token")
+	}
+}
@@ -0,0 +1,47 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// Векторы из приложения B RFC 6238 (SHA1, 8 цифр), усеченные до 6 цифр.
+func TestCodeRFC6238Vectors(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tc := range cases {
+		got, err := Code(secret, Step(time.Unix(tc.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("t=%d: expected %s, got %s", tc.unix, tc.want, got)
+		}
+	}
+}
+
+func TestVerifySkew(t *testing.T) {
+	secret, _ := GenerateSecret()
+	now := time.Now()
+
+	prev, _ := Code(secret, Step(now)-1)
+	if _, ok := Verify(secret, prev, now); !ok {
+		t.Error("Code from the previous step should be accepted")
+	}
+
+	old, _ := Code(secret, Step(now)-3)
+	if _, ok := Verify(secret, old, now); ok {
+		t.Error("Code outside the skew window should be rejected")
+	}
+}
@@ -0,0 +1,48 @@
+package translit
+
+import (
+	"strings"
+	"unicode"
+)
+
+var table = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+}
+
+const maxLoginBase = 40
+
+func Latin(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if t, ok := table[r]; ok {
+			b.WriteString(t)
+			continue
+		}
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// LoginBase строит читаемую основу логина вида "ivanov.p" из фамилии и
+// имени. Уникальность (суффикс-номер) обеспечивает база данных.
+func LoginBase(lastName, firstName string) string {
+	base := Latin(lastName)
+	if initial := Latin(firstName); initial != "" {
+		base += "." + initial[:1]
+	}
+
+	base = strings.Trim(base, ".")
+	if base == "" {
+		base = "client"
+	}
+	if len(base) > maxLoginBase {
+		base = base[:maxLoginBase]
+	}
+	return base
+}
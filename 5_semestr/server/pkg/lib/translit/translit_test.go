@@ -0,0 +1,22 @@
+package translit
+
+import "testing"
+
+func TestLoginBase(t *testing.T) {
+	cases := []struct {
+		last, first string
+		want        string
+	}{
+		{"Распутин", "Иван", "rasputin.i"},
+		{"Щукина", "Юлия", "shchukina.y"},
+		{"Smith", "John", "smith.j"},
+		{"Д'Артаньян", "", "dartanyan"},
+		{"---", "???", "client"},
+	}
+
+	for _, tc := range cases {
+		if got := LoginBase(tc.last, tc.first); got != tc.want {
+			t.Errorf("LoginBase(%q, %q) = %q, want %q", tc.last, tc.first, got, tc.want)
+		}
+	}
+}
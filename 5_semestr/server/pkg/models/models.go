@@ -2,12 +2,34 @@ package models
 
 import "time"
 
+// PaymentRequest - оплата строки графика. Без Amount вносится все, что
+// причитается по ней, включая пени.
 type PaymentRequest struct {
-	ScheduleID int64 `json:"scheduleId" binding:"required"`
+	ScheduleID int64  `json:"scheduleId" binding:"required"`
+	Amount     *Money `json:"amount" binding:"omitempty,gt=0"`
 }
 
+// PaymentAllocation - как платеж распределился: сначала пени, затем
+// проценты, затем основной долг.
+type PaymentAllocation struct {
+	Penalty   Money `json:"penalty"`
+	Interest  Money `json:"interest"`
+	Principal Money `json:"principal"`
+}
+
+// Способы пересчета графика при частичном досрочном погашении.
+const (
+	RepayReduceTerm    = "term"
+	RepayReducePayment = "payment"
+)
+
+// EarlyRepaymentRequest - досрочное погашение. Без Amount гасится весь
+// остаток; при частичном погашении Mode выбирает, что уменьшить: срок или
+// платеж.
 type EarlyRepaymentRequest struct {
-	ContractID int64 `json:"contractId" binding:"required"`
+	ContractID int64  `json:"contractId" binding:"required"`
+	Amount     *Money `json:"amount" binding:"omitempty,gt=0"`
+	Mode       string `json:"mode" binding:"required_with=Amount,omitempty,oneof=term payment"`
 }
 
 type CreateClientRequest struct {
@@ -40,6 +62,55 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// UnlockRequest снимает блокировку входа с логина и, если указан IP
+// (его пишет в журнал запись ACCOUNT_LOCKED), с этого адреса.
+type UnlockRequest struct {
+	Login string `json:"login" binding:"required"`
+	IP    string `json:"ip" binding:"omitempty,ip"`
+}
+
+type TwoFactorChallengeRequest struct {
+	Challenge string `json:"challenge" binding:"required"`
+}
+
+type TwoFactorVerifyRequest struct {
+	Challenge    string `json:"challenge" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required,len=6,numeric"`
+}
+
+type RoleTwoFactorRequest struct {
+	Required *bool `json:"required" binding:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
+}
+
+type RequiredPasswordChangeRequest struct {
+	Challenge   string `json:"challenge" binding:"required"`
+	NewPassword string `json:"newPassword" binding:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Login string `json:"login" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"newPassword" binding:"required"`
+}
+
+type ActivateAccountRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"newPassword" binding:"required"`
+}
+
 type LoginResponse struct {
 	Token string `json:"token"`
 	User  struct {
@@ -64,46 +135,287 @@ type Client struct {
 	CreatedAt      time.Time `json:"createdAt"`
 }
 
+// UpdateClientRequest - новая версия карточки клиента, проверяется так же,
+// как при создании.
+type UpdateClientRequest CreateClientRequest
+
+type SetClientActiveRequest struct {
+	Active *bool `json:"active" binding:"required"`
+}
+
+// ClientProfile - карточка клиента. LockedFields - поля, которые нельзя
+// менять, пока есть действующие договоры.
+type ClientProfile struct {
+	Client
+	Login           string   `json:"login"`
+	IsActive        bool     `json:"isActive"`
+	ActiveContracts int      `json:"activeContracts"`
+	LockedFields    []string `json:"lockedFields"`
+}
+
+// ClientChange - изменение одного поля карточки.
+type ClientChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+type ClientHistoryEntry struct {
+	ID      int64          `json:"id"`
+	Action  string         `json:"action"`
+	Date    time.Time      `json:"date"`
+	User    string         `json:"user"`
+	Changes []ClientChange `json:"changes"`
+}
+
+// ClientMatch - клиент в результатах поиска. Rank - релевантность от 0 до 1,
+// MatchedBy - признак совпадения: passport, email, phone или name.
+type ClientMatch struct {
+	Client
+	Rank      float64 `json:"rank"`
+	MatchedBy string  `json:"matchedBy"`
+}
+
+// Типы графика платежей (schedule_type).
+const (
+	ScheduleAnnuity        = "annuity"
+	ScheduleDifferentiated = "differentiated"
+	ScheduleBullet         = "bullet"
+)
+
 type CreditProduct struct {
 	ID            int     `json:"id"`
 	Name          string  `json:"name"`
-	MinAmount     float64 `json:"minAmount"`
-	MaxAmount     float64 `json:"maxAmount"`
+	MinAmount     Money   `json:"minAmount"`
+	MaxAmount     Money   `json:"maxAmount"`
 	MinTermMonths int     `json:"minTerm"`
 	MaxTermMonths int     `json:"maxTerm"`
 	InterestRate  float64 `json:"rate"`
+	ScheduleType  string  `json:"scheduleType"`
+	PenaltyRate   float64 `json:"penaltyRate"`
+	PenaltyCap    float64 `json:"penaltyCap"`
 	IsActive      bool    `json:"isActive"`
+	Version       int     `json:"version"`
+}
+
+// ProductRequest - создание продукта или изменение его условий. PenaltyRate -
+// пени в процентах от просроченной суммы за день, PenaltyCap - их предел в
+// процентах от суммы платежа (0 - без предела). Пропущенные ScheduleType,
+// PenaltyRate и PenaltyCap при создании означают аннуитет без пеней, а при
+// изменении оставляют текущие значения продукта.
+type ProductRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	MinAmount     Money    `json:"minAmount" binding:"gt=0"`
+	MaxAmount     Money    `json:"maxAmount" binding:"gtefield=MinAmount"`
+	MinTermMonths int      `json:"minTerm" binding:"gt=0"`
+	MaxTermMonths int      `json:"maxTerm" binding:"gtefield=MinTermMonths"`
+	InterestRate  float64  `json:"rate" binding:"gt=0,lt=100"`
+	ScheduleType  string   `json:"scheduleType" binding:"omitempty,oneof=annuity differentiated bullet"`
+	PenaltyRate   *float64 `json:"penaltyRate" binding:"omitempty,gte=0,lt=100"`
+	PenaltyCap    *float64 `json:"penaltyCap" binding:"omitempty,gte=0"`
+}
+
+type SetProductActiveRequest SetClientActiveRequest
+
+// ProductVersion - версия условий продукта. Contracts - число договоров,
+// выданных по этой версии.
+type ProductVersion struct {
+	ID            int       `json:"id"`
+	Version       int       `json:"version"`
+	MinAmount     Money     `json:"minAmount"`
+	MaxAmount     Money     `json:"maxAmount"`
+	MinTermMonths int       `json:"minTerm"`
+	MaxTermMonths int       `json:"maxTerm"`
+	InterestRate  float64   `json:"rate"`
+	ScheduleType  string    `json:"scheduleType"`
+	PenaltyRate   float64   `json:"penaltyRate"`
+	PenaltyCap    float64   `json:"penaltyCap"`
+	Date          time.Time `json:"date"`
+	User          string    `json:"user"`
+	Contracts     int64     `json:"contracts"`
 }
 
+// Статусы договора (contract_status): заявка проходит draft -> review ->
+// approved или rejected, одобренная - signed -> active.
+const (
+	LoanDraft     = "draft"
+	LoanReview    = "review"
+	LoanApproved  = "approved"
+	LoanRejected  = "rejected"
+	LoanSigned    = "signed"
+	LoanActive    = "active"
+	LoanClosed    = "closed"
+	LoanDefaulted = "defaulted"
+)
+
 type LoanContract struct {
 	ID             int64     `json:"id"`
 	ContractNumber string    `json:"contractNumber"`
-	ClientID       int64     `json:"clientId"`
-	ClientName     string    `json:"clientName"` 
-	ProductName    string    `json:"productName"` 
-	Amount         float64   `json:"amount"`
+	Amount         Money     `json:"amount"`
+	Status         string    `json:"status"`
+	StartDate      time.Time `json:"startDate"`
+	ClientName     string    `json:"clientName"`
+	ProductName    string    `json:"productName"`
 	InterestRate   float64   `json:"interestRate"`
 	TermMonths     int       `json:"termMonths"`
-	StartDate      time.Time `json:"startDate"`
-	EndDate        time.Time `json:"endDate"`
+	Balance        Money     `json:"balance"`
+}
+
+type ClientLoan struct {
+	ID             int64     `json:"id"`
+	ContractNumber string    `json:"contractNumber"`
+	Amount         Money     `json:"amount"`
 	Status         string    `json:"status"`
+	StartDate      time.Time `json:"startDate"`
+	ProductName    string    `json:"productName"`
+	Balance        Money     `json:"balance"`
+	Progress       string    `json:"progress"`
 }
 
+// RepaymentScheduleItem - платеж графика. PaidAmount - уже внесенная часть
+// платежа, Penalty - начисленные по нему пени, Due - сколько осталось
+// заплатить вместе с пени.
 type RepaymentScheduleItem struct {
 	ID               int64     `json:"id"`
-	ContractID       int64     `json:"contractId"`
 	PaymentDate      time.Time `json:"paymentDate"`
-	PaymentAmount    float64   `json:"paymentAmount"`
-	PrincipalAmount  float64   `json:"principal"`
-	InterestAmount   float64   `json:"interest"`
-	RemainingBalance float64   `json:"remainingBalance"`
+	PaymentAmount    Money     `json:"paymentAmount"`
+	PrincipalAmount  Money     `json:"principal"`
+	InterestAmount   Money     `json:"interest"`
+	RemainingBalance Money     `json:"remainingBalance"`
 	IsPaid           bool      `json:"isPaid"`
+	PaidAmount       Money     `json:"paidAmount"`
+	Penalty          Money     `json:"penalty"`
+	PenaltyPaid      Money     `json:"penaltyPaid"`
+	Due              Money     `json:"due"`
+}
+
+// ScheduleTotal - итоги графика платежей.
+type ScheduleTotal struct {
+	Payment   Money `json:"payment"`
+	Principal Money `json:"principal"`
+	Interest  Money `json:"interest"`
+	Penalty   Money `json:"penalty"`
+}
+
+// RepaymentSchedule - график платежей договора с итогами. Для заявки
+// график пуст.
+type RepaymentSchedule struct {
+	ScheduleType string                  `json:"scheduleType"`
+	Payments     []RepaymentScheduleItem `json:"payments"`
+	Total        ScheduleTotal           `json:"total"`
+}
+
+// NewRepaymentSchedule собирает график, считает остаток к оплате по
+// каждому платежу и итоги.
+func NewRepaymentSchedule(scheduleType string, payments []RepaymentScheduleItem) RepaymentSchedule {
+	s := RepaymentSchedule{ScheduleType: scheduleType, Payments: payments}
+	for i := range payments {
+		p := &payments[i]
+		p.Due = p.PaymentAmount - p.PaidAmount + p.Penalty - p.PenaltyPaid
+
+		s.Total.Payment += p.PaymentAmount
+		s.Total.Principal += p.PrincipalAmount
+		s.Total.Interest += p.InterestAmount
+		s.Total.Penalty += p.Penalty
+	}
+	return s
+}
+
+type LoanTransitionRequest struct {
+	Reason string `json:"reason" binding:"max=500"`
+}
+
+// LoanTransition - смена статуса договора. From пуст для создания заявки.
+type LoanTransition struct {
+	ID     int64     `json:"id"`
+	From   string    `json:"from,omitempty"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason,omitempty"`
+	Date   time.Time `json:"date"`
+	User   string    `json:"user"`
+}
+
+type LoanOperation struct {
+	Type        string    `json:"type"`
+	Amount      Money     `json:"amount"`
+	Date        time.Time `json:"date"`
+	Description string    `json:"desc"`
+}
+
+type Employee struct {
+	ID        int64  `json:"id"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Position  string `json:"position"`
+	Login     string `json:"login"`
+	Role      string `json:"role"`
+}
+
+type AuditLog struct {
+	ID       int64          `json:"id"`
+	Action   string         `json:"action"`
+	Entity   string         `json:"entity"`
+	EntityID int64          `json:"entityId"`
+	Date     time.Time      `json:"date"`
+	Details  map[string]any `json:"details"`
+	User     string         `json:"user"`
+}
+
+// DashboardStats - сводка для панели статистики: выдано и погашено по
+// договорам, плановые проценты по их графикам, число договоров по
+// продуктам и число просроченных договоров по корзинам DelinquencyBuckets.
+type DashboardStats struct {
+	TotalIssued   Money               `json:"totalIssued"`
+	TotalRepaid   Money               `json:"totalRepaid"`
+	TotalInterest Money               `json:"totalInterest"`
+	Distribution  []DistributionValue `json:"distribution"`
+	Delinquency   []DistributionValue `json:"delinquency"`
+}
+
+// DelinquencyBuckets - корзины просрочки по числу дней, в порядке роста.
+var DelinquencyBuckets = []string{"1-30", "31-60", "61-90", "90+"}
+
+// DelinquencyBucket возвращает корзину для daysPastDue дней просрочки или
+// пустую строку, если просрочки нет.
+func DelinquencyBucket(daysPastDue int) string {
+	switch {
+	case daysPastDue <= 0:
+		return ""
+	case daysPastDue <= 30:
+		return "1-30"
+	case daysPastDue <= 60:
+		return "31-60"
+	case daysPastDue <= 90:
+		return "61-90"
+	default:
+		return "90+"
+	}
+}
+
+type DistributionValue struct {
+	Label string `json:"label"`
+	Value int    `json:"value"`
+}
+
+type FinanceReportRow struct {
+	Month  string `json:"month"`
+	Issued Money  `json:"issued"`
+	Repaid Money  `json:"repaid"`
+	Net    Money  `json:"net"`
 }
 
 type IssueLoanRequest struct {
-	ClientID   int64   `json:"clientId"`
-	ProductID  int     `json:"productId"`
-	Amount     float64 `json:"amount"`
-	TermMonths int     `json:"termMonths"`
-	EmployeeID int64   `json:"employeeId"`
+	ClientID   int64 `json:"clientId" binding:"required,gt=0"`
+	ProductID  int   `json:"productId" binding:"required,gt=0"`
+	Amount     Money `json:"amount" binding:"required,gt=0"`
+	TermMonths int   `json:"termMonths" binding:"required,gt=0"`
+}
+
+// LoanTermViolation - нарушение условий продукта в заявке. Limit -
+// нарушенная граница в том виде, в каком она показывается клиенту.
+type LoanTermViolation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Limit   string `json:"limit,omitempty"`
+	Message string `json:"message"`
 }
\ No newline at end of file
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money - денежная сумма в копейках, как в колонках BIGINT. В JSON
+// записывается десятичной строкой "1234.56"; при разборе принимается и
+// строка, и число, но без перевода через float64.
+type Money int64
+
+var ErrInvalidMoney = errors.New("некорректная денежная сумма")
+
+// ParseMoney разбирает сумму в рублях: "1234", "-0.29", "1 000.5".
+// Больше двух знаков после точки - ошибка, а не округление.
+func ParseMoney(str string) (Money, error) {
+	s := strings.ReplaceAll(strings.TrimSpace(str), " ", "")
+
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	rub, kop, hasPoint := strings.Cut(s, ".")
+	if rub == "" || (hasPoint && (kop == "" || len(kop) > 2)) || !digits(rub) || !digits(kop) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMoney, str)
+	}
+
+	r, err := strconv.ParseInt(rub, 10, 64)
+	if err != nil || r > math.MaxInt64/100 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMoney, str)
+	}
+	k, _ := strconv.ParseInt(kop+strings.Repeat("0", 2-len(kop)), 10, 64)
+
+	v := r*100 + k
+	if v < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMoney, str)
+	}
+	if neg {
+		v = -v
+	}
+	return Money(v), nil
+}
+
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String выводит сумму в рублях с двумя знаками: "1234.56".
+func (m Money) String() string {
+	return m.format("")
+}
+
+// Format выводит сумму с разделителем разрядов: "10 000.00", как
+// fn_format_amount.
+func (m Money) Format() string {
+	return m.format(" ")
+}
+
+func (m Money) format(sep string) string {
+	v := uint64(m)
+	sign := ""
+	if m < 0 {
+		sign, v = "-", -v
+	}
+
+	rub := strconv.FormatUint(v/100, 10)
+	var b strings.Builder
+	for i, r := range rub {
+		if i > 0 && (len(rub)-i)%3 == 0 {
+			b.WriteString(sep)
+		}
+		b.WriteRune(r)
+	}
+	return fmt.Sprintf("%s%s.%02d", sign, b.String(), v%100)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + m.String() + `"`), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	s := string(data)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	} else if strings.ContainsAny(s, "eE") {
+		// 1e6 допустимо в JSON, но проще попросить клиента прислать строку.
+		return fmt.Errorf("%w: %s", ErrInvalidMoney, s)
+	}
+
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Money
+	}{
+		{"0", 0},
+		{"0.29", 29},
+		{"0.3", 30},
+		{"1.05", 105},
+		{"-0.29", -29},
+		{"10 000.00", 1_000_000},
+		{"999999999999.99", 99_999_999_999_999},
+		{"92233720368547758.07", math.MaxInt64},
+	}
+
+	for _, tc := range cases {
+		if got, err := ParseMoney(tc.in); err != nil || got != tc.want {
+			t.Errorf("ParseMoney(%q) = %d, %v, want %d", tc.in, got, err, tc.want)
+		}
+	}
+
+	for _, in := range []string{"", "-", "1.", ".5", "1.234", "1,5", "abc", "+1", "92233720368547758.08"} {
+		if _, err := ParseMoney(in); !errors.Is(err, ErrInvalidMoney) {
+			t.Errorf("ParseMoney(%q): expected ErrInvalidMoney, got %v", in, err)
+		}
+	}
+}
+
+func TestMoneyFormat(t *testing.T) {
+	cases := []struct {
+		m            Money
+		str, display string
+	}{
+		{0, "0.00", "0.00"},
+		{29, "0.29", "0.29"},
+		{-29, "-0.29", "-0.29"},
+		{1_000_000, "10000.00", "10 000.00"},
+		{99_999_999_999_999, "999999999999.99", "999 999 999 999.99"},
+		{math.MinInt64, "-92233720368547758.08", "-92 233 720 368 547 758.08"},
+	}
+
+	for _, tc := range cases {
+		if got := tc.m.String(); got != tc.str {
+			t.Errorf("Money(%d).String() = %q, want %q", tc.m, got, tc.str)
+		}
+		if got := tc.m.Format(); got != tc.display {
+			t.Errorf("Money(%d).Format() = %q, want %q", tc.m, got, tc.display)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var req struct {
+		Amount Money `json:"amount"`
+	}
+
+	// 0.29 * 100 во float64 дает 28.999999999999996.
+	for _, body := range []string{`{"amount": 0.29}`, `{"amount": "0.29"}`} {
+		if err := json.Unmarshal([]byte(body), &req); err != nil || req.Amount != 29 {
+			t.Errorf("Unmarshal(%s) = %d, %v, want 29", body, req.Amount, err)
+		}
+	}
+	for _, body := range []string{`{"amount": 1e6}`, `{"amount": "1.001"}`, `{"amount": true}`} {
+		if err := json.Unmarshal([]byte(body), &req); err == nil {
+			t.Errorf("Unmarshal(%s): expected error", body)
+		}
+	}
+
+	req.Amount = 150_000_000_000
+	out, _ := json.Marshal(req)
+	if string(out) != `{"amount":"1500000000.00"}` {
+		t.Errorf("Marshal = %s", out)
+	}
+}
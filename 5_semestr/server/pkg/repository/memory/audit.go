@@ -0,0 +1,201 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+type auditRepo struct {
+	s *Store
+}
+
+func (r auditRepo) Log(ctx context.Context, e repository.AuditEntry) error {
+	defer r.s.lock()()
+
+	d := r.s.d
+	if _, ok := d.users[e.UserID]; e.UserID != 0 && !ok {
+		return constraintError("23503", "audit_logs_user_id_fkey", fmt.Sprintf("user %d does not exist", e.UserID))
+	}
+
+	details := make(map[string]any, len(e.Details))
+	for k, v := range e.Details {
+		details[k] = v
+	}
+
+	d.audit = append(d.audit, auditRow{
+		id:        d.nextID(),
+		userID:    e.UserID,
+		action:    e.Action,
+		entity:    e.Entity,
+		entityID:  e.EntityID,
+		newValues: details,
+		createdAt: r.s.now(),
+	})
+	return nil
+}
+
+// logChange добавляет запись, как триггер fn_audit_log: старое и новое
+// состояние строки целиком.
+func (d *data) logChange(userID int64, action string, entity string, entityID int64, oldValues, newValues map[string]any, at time.Time) {
+	d.audit = append(d.audit, auditRow{
+		id:        d.nextID(),
+		userID:    userID,
+		action:    action,
+		entity:    entity,
+		entityID:  entityID,
+		oldValues: oldValues,
+		newValues: newValues,
+		createdAt: at,
+	})
+}
+
+func (r auditRepo) List(ctx context.Context, f repository.AuditFilter, p repository.PageRequest) (repository.Page[models.AuditLog], error) {
+	var key func(auditRow) sortKey
+	switch p.Sort {
+	case "id":
+		key = func(a auditRow) sortKey { return sortKey{intKey(a.id), a.id} }
+	case "createdAt":
+		key = func(a auditRow) sortKey { return sortKey{timeKey(a.createdAt), a.id} }
+	default:
+		return repository.Page[models.AuditLog]{}, invalidSort(p.Sort)
+	}
+
+	defer r.s.lock()()
+
+	d := r.s.d
+	rows := []auditRow{}
+	for _, a := range d.audit {
+		switch {
+		case f.Action != "" && a.action != f.Action,
+			f.Entity != "" && a.entity != f.Entity,
+			f.UserID != 0 && a.userID != f.UserID,
+			f.From != nil && a.createdAt.Before(*f.From),
+			f.To != nil && a.createdAt.After(*f.To):
+			continue
+		}
+		rows = append(rows, a)
+	}
+
+	page := paginate(rows, key, p)
+	logs := repository.Page[models.AuditLog]{Items: []models.AuditLog{}, Total: page.Total, Next: page.Next}
+	for _, a := range page.Items {
+		details := maps.Clone(a.newValues)
+		if details == nil {
+			details = map[string]any{}
+		}
+
+		logs.Items = append(logs.Items, models.AuditLog{
+			ID:       a.id,
+			Action:   a.action,
+			Entity:   a.entity,
+			EntityID: a.entityID,
+			Date:     a.createdAt,
+			Details:  details,
+			User:     d.auditUserName(a.userID),
+		})
+	}
+	return logs, nil
+}
+
+func (d *data) auditUserName(userID int64) string {
+	u, ok := d.users[userID]
+	if !ok {
+		return "Система/Неизвестный"
+	}
+	if e, ok := d.employees[userID]; ok {
+		return fmt.Sprintf("%s %s (%s)", e.lastName, e.firstName, u.login)
+	}
+	return u.login
+}
+
+type reportRepo struct {
+	s *Store
+}
+
+// DashboardStats считает то же, что mv_dashboard_cache.
+func (r reportRepo) DashboardStats(ctx context.Context) (models.DashboardStats, error) {
+	defer r.s.lock()()
+
+	d := r.s.d
+	stats := models.DashboardStats{Distribution: []models.DistributionValue{}}
+
+	counts := map[string]int{}
+	buckets := map[string]int{}
+	for _, c := range d.contracts {
+		if c.status == models.LoanActive || c.status == models.LoanDefaulted {
+			buckets[c.bucket]++
+		}
+		if slices.Contains([]string{models.LoanActive, models.LoanClosed, models.LoanDefaulted}, c.status) {
+			stats.TotalIssued += c.amount
+			for _, row := range d.schedule {
+				if row.contractID == c.id {
+					stats.TotalInterest += row.interest
+				}
+			}
+		}
+		counts[d.products[c.productID].name]++
+	}
+	for _, name := range slices.Sorted(maps.Keys(counts)) {
+		stats.Distribution = append(stats.Distribution, models.DistributionValue{Label: name, Value: counts[name]})
+	}
+	for _, bucket := range models.DelinquencyBuckets {
+		stats.Delinquency = append(stats.Delinquency, models.DistributionValue{Label: bucket, Value: buckets[bucket]})
+	}
+
+	for _, op := range d.operations {
+		if isRepayment(op.kind) {
+			stats.TotalRepaid += op.amount
+		}
+	}
+
+	return stats, nil
+}
+
+func (r reportRepo) FinanceReport(ctx context.Context) ([]models.FinanceReportRow, error) {
+	defer r.s.lock()()
+
+	type totals struct{ issued, repaid, net models.Money }
+	months := map[string]*totals{}
+
+	for _, op := range r.s.d.operations {
+		key := op.date.Format("2006-01")
+		t, ok := months[key]
+		if !ok {
+			t = &totals{}
+			months[key] = t
+		}
+
+		if op.kind == "issue" {
+			t.issued += op.amount
+		}
+		if isRepayment(op.kind) {
+			t.repaid += op.amount
+			t.net += op.amount
+		} else {
+			t.net -= op.amount
+		}
+	}
+
+	report := []models.FinanceReportRow{}
+	for month, t := range months {
+		report = append(report, models.FinanceReportRow{
+			Month:  month,
+			Issued: t.issued,
+			Repaid: t.repaid,
+			Net:    t.net,
+		})
+	}
+	slices.SortFunc(report, func(a, b models.FinanceReportRow) int { return cmp.Compare(b.Month, a.Month) })
+	return report, nil
+}
+
+func isRepayment(kind string) bool {
+	return kind == "scheduled_payment" || kind == "early_repayment"
+}
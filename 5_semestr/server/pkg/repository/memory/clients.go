@@ -0,0 +1,518 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+type clientRepo struct {
+	s *Store
+}
+
+func (r clientRepo) List(ctx context.Context, f repository.ClientFilter, p repository.PageRequest) (repository.Page[models.Client], error) {
+	var key func(models.Client) sortKey
+	switch p.Sort {
+	case "id":
+		key = func(c models.Client) sortKey { return sortKey{intKey(c.ID), c.ID} }
+	case "lastName":
+		key = func(c models.Client) sortKey { return sortKey{c.LastName, c.ID} }
+	case "createdAt":
+		key = func(c models.Client) sortKey { return sortKey{timeKey(c.CreatedAt), c.ID} }
+	default:
+		return repository.Page[models.Client]{}, invalidSort(p.Sort)
+	}
+
+	defer r.s.lock()()
+
+	clients := []models.Client{}
+	for _, c := range r.s.d.clients {
+		if f.CreatedFrom != nil && c.createdAt.Before(*f.CreatedFrom) {
+			continue
+		}
+		if f.CreatedTo != nil && !c.createdAt.Before(f.CreatedTo.AddDate(0, 0, 1)) {
+			continue
+		}
+		clients = append(clients, c.model())
+	}
+
+	return paginate(clients, key, p), nil
+}
+
+var (
+	passportQuery = regexp.MustCompile(`^\d{4}\s?\d{6}$`)
+	phoneQuery    = regexp.MustCompile(`^[\d\s()+-]+$`)
+	nonDigits     = regexp.MustCompile(`\D`)
+	nonWord       = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+)
+
+// Search повторяет fn_search_clients. Ранг совпадения по ФИО приближенный:
+// сходство триграмм фамилии, как similarity() из pg_trgm, плюс небольшой
+// вес за совпадение слов вместо ts_rank_cd.
+func (r clientRepo) Search(ctx context.Context, query string, limit int) ([]models.ClientMatch, error) {
+	q := strings.TrimSpace(query)
+	name := normalizeName(q)
+	digits := normalizePhone(q)
+	words := nameWords(name)
+
+	defer r.s.lock()()
+
+	best := map[int64]models.ClientMatch{}
+	add := func(c client, rank float64, kind string) {
+		if cur, ok := best[c.id]; !ok || rank > cur.Rank {
+			best[c.id] = models.ClientMatch{Client: c.model(), Rank: rank, MatchedBy: kind}
+		}
+	}
+
+	for _, c := range r.s.d.clients {
+		if passportQuery.MatchString(q) {
+			compact := strings.Join(strings.Fields(q), "")
+			if c.passportSeries == compact[:4] && c.passportNumber == compact[4:] {
+				add(c, 1, "passport")
+			}
+		}
+
+		if strings.Contains(q, "@") && c.email != nil && strings.EqualFold(*c.email, q) {
+			add(c, 1, "email")
+		}
+
+		if phoneQuery.MatchString(q) && len(digits) >= 4 {
+			switch phone := normalizePhone(c.phone); {
+			case phone == digits:
+				add(c, 0.9, "phone")
+			case strings.Contains(phone, digits):
+				add(c, 0.6, "phone")
+			}
+		}
+
+		if strings.IndexFunc(name, unicode.IsLetter) >= 0 {
+			middle := ""
+			if c.middleName != nil {
+				middle = *c.middleName
+			}
+			last := normalizeName(c.lastName)
+			wordsMatch := prefixesMatch(nameWords(normalizeName(c.lastName+" "+c.firstName+" "+middle)), words)
+
+			if wordsMatch || strings.Contains(last, name) {
+				rank := 0.5 * trigramSimilarity(last, name)
+				if wordsMatch {
+					rank += 0.05
+				}
+				add(c, rank, "name")
+			}
+		}
+	}
+
+	matches := make([]models.ClientMatch, 0, len(best))
+	for _, m := range best {
+		matches = append(matches, m)
+	}
+	slices.SortFunc(matches, func(a, b models.ClientMatch) int {
+		return cmp.Or(cmp.Compare(b.Rank, a.Rank), cmp.Compare(b.ID, a.ID))
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+func (r clientRepo) Register(ctx context.Context, reg repository.ClientRegistration) (int64, string, error) {
+	defer r.s.lock()()
+
+	dob, err := time.Parse("2006-01-02", reg.DateOfBirth)
+	if err != nil {
+		return 0, "", dbError("RB005", "Неверный формат даты рождения (YYYY-MM-DD)")
+	}
+	if dob.After(dateOf(r.s.now()).AddDate(-18, 0, 0)) {
+		return 0, "", constraintError("23514", "chk_client_adult", "new row violates check constraint")
+	}
+
+	for _, c := range r.s.d.clients {
+		if c.passportSeries == reg.PassportSeries && c.passportNumber == reg.PassportNumber {
+			return 0, "", constraintError("23505", "uq_clients_passport", "Пользователь с таким логином или паспортом уже существует")
+		}
+	}
+
+	login := r.s.d.nextFreeLogin(reg.LoginBase)
+
+	userID := r.s.d.nextID()
+	r.s.d.users[userID] = user{
+		id:           userID,
+		login:        login,
+		passwordHash: reg.PasswordHash,
+		role:         "client",
+		active:       true,
+	}
+
+	clientID := r.s.d.nextID()
+	r.s.d.clients[clientID] = client{
+		id:             clientID,
+		userID:         userID,
+		firstName:      reg.FirstName,
+		lastName:       reg.LastName,
+		middleName:     &reg.MiddleName,
+		passportSeries: reg.PassportSeries,
+		passportNumber: reg.PassportNumber,
+		passportIssued: &reg.PassportIssued,
+		dateOfBirth:    dob,
+		address:        reg.Address,
+		phone:          reg.Phone,
+		email:          &reg.Email,
+		createdAt:      r.s.now(),
+	}
+
+	r.s.d.logChange(0, "INSERT", "clients", clientID, nil, r.s.d.clients[clientID].row(), r.s.now())
+
+	if err := (userRepo{r.s}).createActivation(userID, reg.ActivationHash, reg.ActivationTTL); err != nil {
+		return 0, "", err
+	}
+
+	return clientID, login, nil
+}
+
+func (r clientRepo) Get(ctx context.Context, clientID int64) (models.ClientProfile, error) {
+	defer r.s.lock()()
+
+	d := r.s.d
+	c, ok := d.clients[clientID]
+	if !ok {
+		return models.ClientProfile{}, repository.ErrNotFound
+	}
+
+	u := d.users[c.userID]
+	return models.ClientProfile{
+		Client:          c.model(),
+		Login:           u.login,
+		IsActive:        u.active,
+		ActiveContracts: d.activeContracts(clientID),
+	}, nil
+}
+
+// Update повторяет sp_update_client вместе с ограничениями таблицы clients.
+func (r clientRepo) Update(ctx context.Context, upd repository.ClientUpdate) error {
+	defer r.s.lock()()
+
+	d := r.s.d
+	dob, err := time.Parse("2006-01-02", upd.DateOfBirth)
+	if err != nil {
+		return dbError("RB005", "Неверный формат даты рождения (YYYY-MM-DD)")
+	}
+
+	old, ok := d.clients[upd.ClientID]
+	if !ok {
+		return dbError("RB012", "Клиент не найден")
+	}
+
+	c := old
+	c.firstName = upd.FirstName
+	c.lastName = upd.LastName
+	c.middleName = &upd.MiddleName
+	c.passportSeries = upd.PassportSeries
+	c.passportNumber = upd.PassportNumber
+	c.passportIssued = &upd.PassportIssued
+	c.dateOfBirth = dob
+	c.address = upd.Address
+	c.phone = upd.Phone
+	c.email = &upd.Email
+
+	if !c.sameIdentity(old) && d.activeContracts(c.id) > 0 {
+		return dbError("RB017", "ФИО, паспорт и дату рождения нельзя менять при действующих договорах")
+	}
+	if dob.After(dateOf(r.s.now()).AddDate(-18, 0, 0)) {
+		return constraintError("23514", "chk_client_adult", "new row violates check constraint")
+	}
+	for _, other := range d.clients {
+		if other.id != c.id && other.passportSeries == c.passportSeries && other.passportNumber == c.passportNumber {
+			return constraintError("23505", "uq_clients_passport", "duplicate key value violates unique constraint")
+		}
+	}
+
+	d.clients[c.id] = c
+	d.logChange(upd.ActorID, "UPDATE", "clients", c.id, old.row(), c.row(), r.s.now())
+	return nil
+}
+
+// SetActive повторяет sp_set_client_active.
+func (r clientRepo) SetActive(ctx context.Context, actorID int64, clientID int64, active bool) (int64, error) {
+	defer r.s.lock()()
+
+	d := r.s.d
+	c, ok := d.clients[clientID]
+	if !ok {
+		return 0, dbError("RB012", "Клиент не найден")
+	}
+	u, ok := d.users[c.userID]
+	if !ok {
+		return 0, dbError("RB012", "Клиент не найден")
+	}
+
+	if !active && d.activeContracts(clientID) > 0 {
+		return 0, dbError("RB018", "У клиента есть действующие договоры")
+	}
+	if u.active == active {
+		return u.id, nil
+	}
+
+	u.active = active
+	d.users[u.id] = u
+	d.logChange(actorID, "UPDATE", "clients", clientID,
+		map[string]any{"is_active": !active}, map[string]any{"is_active": active}, r.s.now())
+	return u.id, nil
+}
+
+// History повторяет fn_get_client_history: по каждой записи аудита
+// перечисляются поля, у которых отличаются старое и новое значения.
+func (r clientRepo) History(ctx context.Context, clientID int64) ([]models.ClientHistoryEntry, error) {
+	defer r.s.lock()()
+
+	d := r.s.d
+	history := []models.ClientHistoryEntry{}
+	for i := len(d.audit) - 1; i >= 0; i-- {
+		a := d.audit[i]
+		if a.entity != "clients" || a.entityID != clientID || !slices.Contains([]string{"INSERT", "UPDATE", "DELETE"}, a.action) {
+			continue
+		}
+
+		values := a.newValues
+		if values == nil {
+			values = a.oldValues
+		}
+
+		changes := []models.ClientChange{}
+		for _, field := range slices.Sorted(maps.Keys(values)) {
+			if field == "id" || field == "user_id" || field == "created_at" {
+				continue
+			}
+			oldValue, newValue := a.oldValues[field], a.newValues[field]
+			if a.oldValues != nil && a.newValues != nil && oldValue == newValue {
+				continue
+			}
+			changes = append(changes, models.ClientChange{Field: field, Old: oldValue, New: newValue})
+		}
+		if len(changes) == 0 {
+			continue
+		}
+
+		history = append(history, models.ClientHistoryEntry{
+			ID:      a.id,
+			Action:  a.action,
+			Date:    a.createdAt,
+			User:    d.auditUserName(a.userID),
+			Changes: changes,
+		})
+	}
+	return history, nil
+}
+
+func (d *data) activeContracts(clientID int64) int {
+	n := 0
+	for _, c := range d.contracts {
+		if c.clientID == clientID && c.status == "active" {
+			n++
+		}
+	}
+	return n
+}
+
+// sameIdentity сравнивает поля, закрепленные в договорах.
+func (c client) sameIdentity(o client) bool {
+	return c.firstName == o.firstName && c.lastName == o.lastName &&
+		deref(c.middleName) == deref(o.middleName) &&
+		c.passportSeries == o.passportSeries && c.passportNumber == o.passportNumber &&
+		deref(c.passportIssued) == deref(o.passportIssued) &&
+		c.dateOfBirth.Equal(o.dateOfBirth)
+}
+
+// row - строка clients в виде row_to_json, как ее сохраняет триггер аудита.
+func (c client) row() map[string]any {
+	return map[string]any{
+		"id":                 c.id,
+		"user_id":            c.userID,
+		"first_name":         c.firstName,
+		"last_name":          c.lastName,
+		"middle_name":        nullableString(c.middleName),
+		"passport_series":    c.passportSeries,
+		"passport_number":    c.passportNumber,
+		"passport_issued_by": nullableString(c.passportIssued),
+		"date_of_birth":      c.dateOfBirth.Format("2006-01-02"),
+		"address":            c.address,
+		"phone":              c.phone,
+		"email":              nullableString(c.email),
+		"created_at":         c.createdAt.Format(time.RFC3339Nano),
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func nullableString(s *string) any {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
+
+func (c client) model() models.Client {
+	return models.Client{
+		ID:             c.id,
+		FirstName:      c.firstName,
+		LastName:       c.lastName,
+		MiddleName:     c.middleName,
+		PassportSeries: c.passportSeries,
+		PassportNumber: c.passportNumber,
+		PassportIssued: c.passportIssued,
+		DateOfBirth:    c.dateOfBirth.Format("2006-01-02"),
+		Address:        c.address,
+		Phone:          c.phone,
+		Email:          c.email,
+		CreatedAt:      c.createdAt,
+	}
+}
+
+func (d *data) loginTaken(login string) bool {
+	for _, u := range d.users {
+		if u.login == login {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *data) nextFreeLogin(base string) string {
+	if !d.loginTaken(base) {
+		return base
+	}
+	for i := 2; ; i++ {
+		if login := base + strconv.Itoa(i); !d.loginTaken(login) {
+			return login
+		}
+	}
+}
+
+type employeeRepo struct {
+	s *Store
+}
+
+func (r employeeRepo) List(ctx context.Context, f repository.EmployeeFilter, p repository.PageRequest) (repository.Page[models.Employee], error) {
+	var key func(models.Employee) sortKey
+	switch p.Sort {
+	case "id":
+		key = func(e models.Employee) sortKey { return sortKey{intKey(e.ID), e.ID} }
+	case "lastName":
+		key = func(e models.Employee) sortKey { return sortKey{e.LastName, e.ID} }
+	default:
+		return repository.Page[models.Employee]{}, invalidSort(p.Sort)
+	}
+
+	defer r.s.lock()()
+
+	employees := []models.Employee{}
+	for _, e := range r.s.d.employees {
+		u := r.s.d.users[e.userID]
+		if f.Role != "" && u.role != f.Role {
+			continue
+		}
+		employees = append(employees, models.Employee{
+			ID:        e.id,
+			FirstName: e.firstName,
+			LastName:  e.lastName,
+			Position:  e.position,
+			Login:     u.login,
+			Role:      u.role,
+		})
+	}
+
+	return paginate(employees, key, p), nil
+}
+
+func (r employeeRepo) Register(ctx context.Context, e repository.EmployeeRegistration) (int64, error) {
+	defer r.s.lock()()
+
+	if r.s.d.loginTaken(e.Login) {
+		return 0, constraintError("23505", "users_login_key", "Пользователь с таким логином уже существует")
+	}
+	return r.s.d.addEmployee("manager", e), nil
+}
+
+func (r employeeRepo) ByUser(ctx context.Context, userID int64) (int64, error) {
+	defer r.s.lock()()
+
+	e, ok := r.s.d.employees[userID]
+	if !ok || !r.s.d.users[userID].active {
+		return 0, repository.ErrNotFound
+	}
+	return e.id, nil
+}
+
+// normalizeName повторяет fn_normalize_name: нижний регистр и ё -> е.
+func normalizeName(s string) string {
+	return strings.ReplaceAll(strings.ToLower(s), "ё", "е")
+}
+
+// normalizePhone повторяет fn_normalize_phone: только цифры, 8 в начале
+// одиннадцатизначного номера заменяется на 7.
+func normalizePhone(s string) string {
+	digits := nonDigits.ReplaceAllString(s, "")
+	if len(digits) == 11 && digits[0] == '8' {
+		digits = "7" + digits[1:]
+	}
+	return digits
+}
+
+func nameWords(s string) []string {
+	return slices.DeleteFunc(nonWord.Split(s, -1), func(w string) bool { return w == "" })
+}
+
+// prefixesMatch сообщает, что каждое слово запроса - префикс какого-то
+// слова имени, как tsquery вида "слово:* & ...".
+func prefixesMatch(words, query []string) bool {
+	if len(query) == 0 {
+		return false
+	}
+	for _, q := range query {
+		if !slices.ContainsFunc(words, func(w string) bool { return strings.HasPrefix(w, q) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// trigramSimilarity считает similarity() из pg_trgm: доля общих триграмм
+// слов, дополненных пробелами.
+func trigramSimilarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
+
+func trigrams(s string) map[string]bool {
+	set := map[string]bool{}
+	for _, w := range nameWords(s) {
+		r := []rune("  " + w + " ")
+		for i := 0; i+3 <= len(r); i++ {
+			set[string(r[i:i+3])] = true
+		}
+	}
+	return set
+}
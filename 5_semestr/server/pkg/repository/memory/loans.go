@@ -0,0 +1,844 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+type loanRepo struct {
+	s *Store
+}
+
+// Issue повторяет sp_issue_loan: заявка в статусе draft без графика и
+// выдачи.
+func (r loanRepo) Issue(ctx context.Context, p repository.IssueLoanParams) (int64, error) {
+	defer r.s.lock()()
+
+	d := r.s.d
+	now := r.s.now()
+	today := dateOf(now)
+
+	prod, ok := d.products[p.ProductID]
+	if !ok {
+		return 0, dbError("RB011", "Кредитный продукт не найден")
+	}
+	terms := d.currentVersion(prod)
+	if _, ok := d.clients[p.ClientID]; !ok {
+		return 0, dbError("RB012", "Клиент не найден")
+	}
+	if p.TermMonths <= 0 {
+		return 0, dbError("RB014", "Неверный срок кредита")
+	}
+	if err := loanTermsError(prod, terms, p); err != nil {
+		return 0, err
+	}
+	if p.Amount <= 0 {
+		return 0, constraintError("23514", "chk_loan_amount_positive", "new row violates check constraint")
+	}
+
+	for _, row := range d.schedule {
+		c := d.contracts[row.contractID]
+		if c.clientID == p.ClientID && !row.isPaid && row.paymentDate.Before(today.AddDate(0, 0, -5)) {
+			return 0, dbError("RB004", "ОТКАЗАНО: У клиента имеется непогашенная просроченная задолженность.")
+		}
+	}
+
+	id := d.nextID()
+	d.contracts[id] = contract{
+		id:         id,
+		number:     fmt.Sprintf("LN-%d-%d", now.Unix(), p.ClientID),
+		clientID:   p.ClientID,
+		productID:  p.ProductID,
+		versionID:  terms.id,
+		createdBy:  p.EmployeeID,
+		amount:     p.Amount,
+		rate:       terms.interestRate,
+		termMonths: p.TermMonths,
+		startDate:  today,
+		endDate:    addMonths(today, p.TermMonths),
+		status:     models.LoanDraft,
+		createdAt:  now,
+	}
+
+	var userID int64
+	for _, e := range d.employees {
+		if e.id == p.EmployeeID {
+			userID = e.userID
+		}
+	}
+	d.transitions = append(d.transitions, loanTransition{
+		id:         d.nextID(),
+		contractID: id,
+		to:         models.LoanDraft,
+		userID:     userID,
+		at:         now,
+	})
+
+	return id, nil
+}
+
+// loanTermsError повторяет проверку условий продукта в sp_issue_loan:
+// RB022 со списком нарушений в DETAIL.
+func loanTermsError(prod product, terms productVersion, p repository.IssueLoanParams) error {
+	var violations []models.LoanTermViolation
+	add := func(field, rule, limit, message string) {
+		violations = append(violations, models.LoanTermViolation{Field: field, Rule: rule, Limit: limit, Message: message})
+	}
+
+	if !prod.isActive {
+		add("productId", "active", "", fmt.Sprintf("Продукт «%s» не принимает новые заявки", prod.name))
+	}
+	if limit := terms.minAmount.Format(); p.Amount < terms.minAmount {
+		add("amount", "min", limit, fmt.Sprintf("Сумма ниже минимальной %s для продукта «%s»", limit, prod.name))
+	}
+	if limit := terms.maxAmount.Format(); p.Amount > terms.maxAmount {
+		add("amount", "max", limit, fmt.Sprintf("Сумма выше максимальной %s для продукта «%s»", limit, prod.name))
+	}
+	if p.TermMonths < terms.minTermMonths {
+		add("termMonths", "min", fmt.Sprint(terms.minTermMonths),
+			fmt.Sprintf("Срок меньше минимального %d мес. для продукта «%s»", terms.minTermMonths, prod.name))
+	}
+	if p.TermMonths > terms.maxTermMonths {
+		add("termMonths", "max", fmt.Sprint(terms.maxTermMonths),
+			fmt.Sprintf("Срок больше максимального %d мес. для продукта «%s»", terms.maxTermMonths, prod.name))
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	detail, _ := json.Marshal(map[string]any{"violations": violations})
+	return &pgconn.PgError{Severity: "ERROR", Code: "RB022", Message: "Условия кредита не соответствуют продукту", Detail: string(detail)}
+}
+
+// loanTransitions - допустимые переходы sp_change_loan_status.
+var loanTransitions = map[string][]string{
+	models.LoanDraft:    {models.LoanReview},
+	models.LoanReview:   {models.LoanApproved, models.LoanRejected},
+	models.LoanApproved: {models.LoanSigned},
+	models.LoanSigned:   {models.LoanActive},
+}
+
+// ChangeStatus повторяет sp_change_loan_status.
+func (r loanRepo) ChangeStatus(ctx context.Context, ch repository.LoanStatusChange) error {
+	defer r.s.lock()()
+
+	d := r.s.d
+	now := r.s.now()
+
+	c, ok := d.contracts[ch.ContractID]
+	if !ok {
+		return dbError("RB013", "Договор не найден")
+	}
+	if !slices.Contains(loanTransitions[c.status], ch.To) {
+		return dbError("RB020", fmt.Sprintf("Договор в статусе %s нельзя перевести в статус %s", c.status, ch.To))
+	}
+	reason := strings.TrimSpace(ch.Reason)
+	if ch.To == models.LoanRejected && reason == "" {
+		return dbError("RB021", "Укажите причину отказа")
+	}
+
+	switch ch.To {
+	case models.LoanApproved:
+		c.employeeID = ch.EmployeeID
+	case models.LoanActive:
+		today := dateOf(now)
+		c.balance = c.amount
+		c.startDate = today
+		c.endDate = addMonths(today, c.termMonths)
+		d.buildSchedule(c)
+		d.operations = append(d.operations, operation{
+			contractID:  c.id,
+			kind:        "issue",
+			amount:      c.amount,
+			date:        now,
+			description: "Выдача",
+		})
+	}
+
+	d.transitions = append(d.transitions, loanTransition{
+		id:         d.nextID(),
+		contractID: c.id,
+		from:       c.status,
+		to:         ch.To,
+		reason:     reason,
+		userID:     ch.ActorID,
+		at:         now,
+	})
+	c.status = ch.To
+	d.contracts[c.id] = c
+	return nil
+}
+
+// buildSchedule повторяет sp_build_schedule: график от даты начала
+// договора по типу его версии условий.
+func (d *data) buildSchedule(c contract) {
+	scheduleType := d.versionOf(c).scheduleType
+	monthlyRate := c.rate / 12 / 100
+	annuity := annuityPayment(c.amount, monthlyRate, c.termMonths)
+	balance := c.amount
+	date := c.startDate
+
+	for i := 1; i <= c.termMonths; i++ {
+		date = addMonths(date, 1)
+
+		interest := models.Money(math.Round(float64(balance) * monthlyRate))
+		var principal models.Money
+		switch scheduleType {
+		case models.ScheduleDifferentiated:
+			principal = models.Money(math.Round(float64(c.amount) / float64(c.termMonths)))
+		case models.ScheduleBullet:
+			principal = 0
+		default:
+			principal = annuity - interest
+		}
+		if i == c.termMonths || principal > balance {
+			principal = balance
+		}
+		balance -= principal
+
+		rowID := d.nextID()
+		d.schedule[rowID] = scheduleRow{
+			id:          rowID,
+			contractID:  c.id,
+			paymentDate: date,
+			payment:     principal + interest,
+			principal:   principal,
+			interest:    interest,
+			remaining:   balance,
+		}
+	}
+}
+
+func (r loanRepo) Transitions(ctx context.Context, contractID int64) ([]models.LoanTransition, error) {
+	defer r.s.lock()()
+
+	transitions := []models.LoanTransition{}
+	for _, t := range r.s.d.transitions {
+		if t.contractID != contractID {
+			continue
+		}
+		transitions = append(transitions, models.LoanTransition{
+			ID:     t.id,
+			From:   t.from,
+			To:     t.to,
+			Reason: t.reason,
+			Date:   t.at,
+			User:   r.s.d.auditUserName(t.userID),
+		})
+	}
+	return transitions, nil
+}
+
+func (r loanRepo) List(ctx context.Context, f repository.LoanFilter, p repository.PageRequest) (repository.Page[models.LoanContract], error) {
+	var key func(contract) sortKey
+	switch p.Sort {
+	case "id":
+		key = func(c contract) sortKey { return sortKey{intKey(c.id), c.id} }
+	case "createdAt":
+		key = func(c contract) sortKey { return sortKey{timeKey(c.createdAt), c.id} }
+	case "startDate":
+		key = func(c contract) sortKey { return sortKey{timeKey(c.startDate), c.id} }
+	case "amount":
+		key = func(c contract) sortKey { return sortKey{intKey(int64(c.amount)), c.id} }
+	case "balance":
+		key = func(c contract) sortKey { return sortKey{intKey(int64(c.balance)), c.id} }
+	default:
+		return repository.Page[models.LoanContract]{}, invalidSort(p.Sort)
+	}
+
+	defer r.s.lock()()
+
+	d := r.s.d
+	contracts := []contract{}
+	for _, c := range d.contracts {
+		switch {
+		case f.Status != "" && c.status != f.Status,
+			f.ProductID != 0 && c.productID != f.ProductID,
+			f.ClientID != 0 && c.clientID != f.ClientID,
+			f.EmployeeID != 0 && c.employeeID != f.EmployeeID,
+			f.From != nil && c.startDate.Before(dateOf(*f.From)),
+			f.To != nil && c.startDate.After(dateOf(*f.To)):
+			continue
+		}
+		contracts = append(contracts, c)
+	}
+
+	page := paginate(contracts, key, p)
+	loans := repository.Page[models.LoanContract]{Items: []models.LoanContract{}, Total: page.Total, Next: page.Next}
+	for _, c := range page.Items {
+		cl := d.clients[c.clientID]
+		middle := ""
+		if cl.middleName != nil {
+			middle = *cl.middleName
+		}
+
+		loans.Items = append(loans.Items, models.LoanContract{
+			ID:             c.id,
+			ContractNumber: c.number,
+			Amount:         c.amount,
+			Status:         c.status,
+			StartDate:      c.startDate,
+			ClientName:     strings.Join([]string{cl.lastName, cl.firstName, middle}, " "),
+			ProductName:    d.products[c.productID].name,
+			InterestRate:   c.rate,
+			TermMonths:     c.termMonths,
+			Balance:        c.balance,
+		})
+	}
+	return loans, nil
+}
+
+func (r loanRepo) ListForUser(ctx context.Context, userID int64) ([]models.ClientLoan, error) {
+	defer r.s.lock()()
+
+	d := r.s.d
+	loans := []models.ClientLoan{}
+	for _, c := range d.sortedContracts() {
+		if d.clients[c.clientID].userID != userID {
+			continue
+		}
+
+		var paid, total int
+		for _, row := range d.schedule {
+			if row.contractID == c.id {
+				total++
+				if row.isPaid {
+					paid++
+				}
+			}
+		}
+
+		loans = append(loans, models.ClientLoan{
+			ID:             c.id,
+			ContractNumber: c.number,
+			Amount:         c.amount,
+			Status:         c.status,
+			StartDate:      c.startDate,
+			ProductName:    d.products[c.productID].name,
+			Balance:        c.balance,
+			Progress:       fmt.Sprintf("%d/%d", paid, total),
+		})
+	}
+	return loans, nil
+}
+
+func (r loanRepo) OwnedBy(ctx context.Context, contractID int64, userID int64) error {
+	defer r.s.lock()()
+
+	d := r.s.d
+	c, ok := d.contracts[contractID]
+	if !ok || d.clients[c.clientID].userID != userID {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+// EarlyRepay повторяет sp_early_repayment: полное погашение закрывает
+// договор, частичное пересчитывает неоплаченную часть графика.
+func (r loanRepo) EarlyRepay(ctx context.Context, p repository.EarlyRepayParams) (models.Money, error) {
+	defer r.s.lock()()
+
+	d := r.s.d
+	c, ok := d.contracts[p.ContractID]
+	if !ok || d.clients[c.clientID].userID != p.UserID {
+		return 0, dbError("RB013", "Договор не найден")
+	}
+	if c.status != models.LoanActive {
+		return 0, dbError("RB020", fmt.Sprintf("Договор в статусе %s нельзя погасить досрочно", c.status))
+	}
+	if c.balance <= 0 {
+		return 0, dbError("RB003", "Нет долга")
+	}
+	today := dateOf(r.s.now())
+	for _, row := range d.schedule {
+		if row.contractID == c.id && !row.isPaid && (row.paymentDate.Before(today) || row.penalty > row.penaltyPaid) {
+			return 0, dbError("RB026", fmt.Sprintf("По договору %d есть просроченные платежи", c.id))
+		}
+	}
+
+	if p.Amount == 0 || p.Amount == c.balance {
+		paid := c.balance
+		c.balance = 0
+		c.status = "closed"
+		d.contracts[c.id] = c
+
+		for id, row := range d.schedule {
+			if row.contractID == c.id && !row.isPaid {
+				delete(d.schedule, id)
+			}
+		}
+
+		d.operations = append(d.operations, operation{
+			contractID:  c.id,
+			kind:        "early_repayment",
+			amount:      paid,
+			date:        r.s.now(),
+			description: "Полное погашение",
+		})
+		return paid, nil
+	}
+
+	if p.Amount < 0 || p.Amount > c.balance {
+		return 0, dbError("RB023", "Сумма досрочного погашения должна быть от 0.01 до "+c.balance.Format())
+	}
+	scheduleType := d.versionOf(c).scheduleType
+	if (p.Mode != models.RepayReduceTerm && p.Mode != models.RepayReducePayment) ||
+		(p.Mode == models.RepayReduceTerm && scheduleType == models.ScheduleBullet) {
+		return 0, dbError("RB024", fmt.Sprintf("Способ пересчета графика %s недоступен", p.Mode))
+	}
+
+	unpaid := d.unpaidRows(c.id)
+	if len(unpaid) == 0 {
+		return 0, dbError("RB003", "Нет неоплаченных платежей")
+	}
+
+	balance := c.balance - p.Amount
+	months := len(unpaid)
+
+	// Сокращение срока: наименьшее число платежей, при котором платеж не
+	// больше прежнего (для дифференцированного - прежняя доля долга).
+	if p.Mode == models.RepayReduceTerm {
+		if scheduleType == models.ScheduleDifferentiated {
+			step := max(unpaid[0].principal, 1)
+			months = min(len(unpaid), int(math.Ceil(float64(balance)/float64(step))))
+		} else {
+			monthlyRate := c.rate / 12 / 100
+			months = 1
+			for months < len(unpaid) && annuityPayment(balance, monthlyRate, months) > unpaid[0].payment {
+				months++
+			}
+		}
+	}
+
+	d.rebuildSchedule(c, balance, months)
+
+	c.balance = balance
+	c.termMonths -= len(unpaid) - months
+	c.endDate = d.unpaidRows(c.id)[months-1].paymentDate
+	d.contracts[c.id] = c
+
+	description := "Частичное погашение, уменьшение платежа"
+	if p.Mode == models.RepayReduceTerm {
+		description = "Частичное погашение, сокращение срока"
+	}
+	d.operations = append(d.operations, operation{
+		contractID:  c.id,
+		kind:        "early_repayment",
+		amount:      p.Amount,
+		date:        r.s.now(),
+		description: description,
+	})
+
+	return p.Amount, nil
+}
+
+// unpaidRows возвращает неоплаченные платежи договора по дате.
+func (d *data) unpaidRows(contractID int64) []scheduleRow {
+	var rows []scheduleRow
+	for _, row := range d.schedule {
+		if row.contractID == contractID && !row.isPaid {
+			rows = append(rows, row)
+		}
+	}
+	slices.SortFunc(rows, func(a, b scheduleRow) int { return a.paymentDate.Compare(b.paymentDate) })
+	return rows
+}
+
+// rebuildSchedule повторяет sp_rebuild_schedule: months платежей от
+// остатка balance в даты прежних неоплаченных платежей.
+func (d *data) rebuildSchedule(c contract, balance models.Money, months int) {
+	unpaid := d.unpaidRows(c.id)
+	for _, row := range unpaid {
+		delete(d.schedule, row.id)
+	}
+
+	scheduleType := d.versionOf(c).scheduleType
+	monthlyRate := c.rate / 12 / 100
+	annuity := annuityPayment(balance, monthlyRate, months)
+	step := models.Money(math.Round(float64(balance) / float64(months)))
+
+	for i := 1; i <= months; i++ {
+		interest := models.Money(math.Round(float64(balance) * monthlyRate))
+		var principal models.Money
+		switch scheduleType {
+		case models.ScheduleDifferentiated:
+			principal = step
+		case models.ScheduleBullet:
+			principal = 0
+		default:
+			principal = annuity - interest
+		}
+		if i == months || principal > balance {
+			principal = balance
+		}
+		balance -= principal
+
+		rowID := d.nextID()
+		d.schedule[rowID] = scheduleRow{
+			id:          rowID,
+			contractID:  c.id,
+			paymentDate: unpaid[i-1].paymentDate,
+			payment:     principal + interest,
+			principal:   principal,
+			interest:    interest,
+			remaining:   balance,
+		}
+	}
+}
+
+// ProcessDelinquency повторяет fn_process_delinquency.
+func (r loanRepo) ProcessDelinquency(ctx context.Context, on time.Time, defaultAfterDays int) ([]repository.DelinquencyChange, error) {
+	defer r.s.lock()()
+
+	if defaultAfterDays <= 0 {
+		return nil, dbError("P0001", "Порог перевода в defaulted должен быть положительным")
+	}
+
+	d := r.s.d
+	on = dateOf(on)
+
+	var changes []repository.DelinquencyChange
+	for _, c := range d.sortedContracts() {
+		if c.status != models.LoanActive && c.status != models.LoanDefaulted {
+			continue
+		}
+
+		days := 0
+		if unpaid := d.unpaidRows(c.id); len(unpaid) > 0 && unpaid[0].paymentDate.Before(on) {
+			days = int(on.Sub(unpaid[0].paymentDate).Hours() / 24)
+		}
+
+		bucket := models.DelinquencyBucket(days)
+		status := c.status
+		switch {
+		case days >= defaultAfterDays:
+			status = models.LoanDefaulted
+		case days == 0:
+			status = models.LoanActive
+		}
+
+		if c.daysPastDue == days && c.bucket == bucket && c.status == status {
+			continue
+		}
+
+		if c.status != status {
+			reason := "Просрочка погашена"
+			if status == models.LoanDefaulted {
+				reason = fmt.Sprintf("Просрочка %d дн.", days)
+			}
+			d.transitions = append(d.transitions, loanTransition{
+				id:         d.nextID(),
+				contractID: c.id,
+				from:       c.status,
+				to:         status,
+				reason:     reason,
+				at:         r.s.now(),
+			})
+		}
+
+		if c.bucket != bucket || c.status != status {
+			changes = append(changes, repository.DelinquencyChange{
+				ContractID:     c.id,
+				ContractNumber: c.number,
+				DaysPastDue:    days,
+				FromBucket:     c.bucket,
+				ToBucket:       bucket,
+				FromStatus:     c.status,
+				ToStatus:       status,
+			})
+		}
+
+		c.daysPastDue = days
+		c.bucket = bucket
+		c.status = status
+		d.contracts[c.id] = c
+	}
+	slices.SortFunc(changes, func(a, b repository.DelinquencyChange) int { return cmp.Compare(a.ContractID, b.ContractID) })
+	return changes, nil
+}
+
+func (r loanRepo) Operations(ctx context.Context, contractID int64) ([]models.LoanOperation, error) {
+	defer r.s.lock()()
+
+	ops := []models.LoanOperation{}
+	for i := len(r.s.d.operations) - 1; i >= 0; i-- {
+		op := r.s.d.operations[i]
+		if op.contractID != contractID {
+			continue
+		}
+		ops = append(ops, models.LoanOperation{
+			Type:        op.kind,
+			Amount:      op.amount,
+			Date:        op.date,
+			Description: op.description,
+		})
+	}
+	return ops, nil
+}
+
+type scheduleRepo struct {
+	s *Store
+}
+
+func (r scheduleRepo) ForContract(ctx context.Context, contractID int64) (models.RepaymentSchedule, error) {
+	defer r.s.lock()()
+
+	c, ok := r.s.d.contracts[contractID]
+	if !ok {
+		return models.RepaymentSchedule{}, repository.ErrNotFound
+	}
+
+	var rows []scheduleRow
+	for _, row := range r.s.d.schedule {
+		if row.contractID == contractID {
+			rows = append(rows, row)
+		}
+	}
+	slices.SortFunc(rows, func(a, b scheduleRow) int { return a.paymentDate.Compare(b.paymentDate) })
+
+	schedule := []models.RepaymentScheduleItem{}
+	for _, row := range rows {
+		schedule = append(schedule, models.RepaymentScheduleItem{
+			ID:               row.id,
+			PaymentDate:      row.paymentDate,
+			PaymentAmount:    row.payment,
+			PrincipalAmount:  row.principal,
+			InterestAmount:   row.interest,
+			RemainingBalance: row.remaining,
+			IsPaid:           row.isPaid,
+			PaidAmount:       row.paid,
+			Penalty:          row.penalty,
+			PenaltyPaid:      row.penaltyPaid,
+		})
+	}
+	return models.NewRepaymentSchedule(r.s.d.versionOf(c).scheduleType, schedule), nil
+}
+
+func (r scheduleRepo) GetForUser(ctx context.Context, scheduleID int64, userID int64) (repository.ScheduledPayment, error) {
+	defer r.s.lock()()
+
+	d := r.s.d
+	row, ok := d.schedule[scheduleID]
+	if !ok || d.clients[d.contracts[row.contractID].clientID].userID != userID {
+		return repository.ScheduledPayment{}, repository.ErrNotFound
+	}
+
+	return repository.ScheduledPayment{ID: row.id, ContractID: row.contractID, Amount: row.payment}, nil
+}
+
+// Pay повторяет sp_make_payment: сумма гасит пени, затем проценты, затем
+// основной долг; договор закрывается, когда остаток становится нулевым.
+func (r scheduleRepo) Pay(ctx context.Context, scheduleID int64, amount models.Money) (models.PaymentAllocation, error) {
+	defer r.s.lock()()
+
+	d := r.s.d
+	row, ok := d.schedule[scheduleID]
+	if !ok {
+		return models.PaymentAllocation{}, dbError("RB001", "Платеж не найден")
+	}
+	if row.isPaid {
+		return models.PaymentAllocation{}, dbError("RB002", "Этот платеж уже оплачен")
+	}
+
+	duePenalty := row.penalty - row.penaltyPaid
+	dueInterest := max(row.interest-row.paid, 0)
+	due := duePenalty + row.payment - row.paid
+	if amount == 0 {
+		amount = due
+	}
+	if amount <= 0 || amount > due {
+		return models.PaymentAllocation{}, dbError("RB025", "Сумма платежа должна быть от 0.01 до "+due.Format())
+	}
+
+	var a models.PaymentAllocation
+	a.Penalty = min(amount, duePenalty)
+	a.Interest = min(amount-a.Penalty, dueInterest)
+	a.Principal = amount - a.Penalty - a.Interest
+
+	row.penaltyPaid += a.Penalty
+	row.paid += a.Interest + a.Principal
+	row.isPaid = row.paid == row.payment
+	d.schedule[scheduleID] = row
+
+	c := d.contracts[row.contractID]
+	c.balance -= a.Principal
+	if c.balance <= 0 {
+		c.balance = 0
+		c.status = "closed"
+	}
+	d.contracts[c.id] = c
+
+	now := r.s.now()
+	if a.Penalty > 0 {
+		d.operations = append(d.operations, operation{
+			contractID:  c.id,
+			kind:        "penalty_payment",
+			amount:      a.Penalty,
+			date:        now,
+			description: "Оплата пени",
+		})
+	}
+	if paid := a.Interest + a.Principal; paid > 0 {
+		description := "Частичный платеж по графику"
+		if row.isPaid {
+			description = "Платеж по графику"
+		}
+		d.operations = append(d.operations, operation{
+			contractID:  c.id,
+			kind:        "scheduled_payment",
+			amount:      paid,
+			date:        now,
+			description: description,
+		})
+	}
+	return a, nil
+}
+
+// AccruePenalties повторяет sp_accrue_penalties.
+func (r scheduleRepo) AccruePenalties(ctx context.Context, on time.Time) (repository.PenaltyAccrual, error) {
+	defer r.s.lock()()
+
+	d := r.s.d
+	on = dateOf(on)
+
+	var rows []scheduleRow
+	for _, row := range d.schedule {
+		c := d.contracts[row.contractID]
+		switch {
+		case row.isPaid, !row.paymentDate.Before(on),
+			!row.penaltyOn.IsZero() && !row.penaltyOn.Before(on),
+			c.status != models.LoanActive && c.status != models.LoanDefaulted,
+			d.versionOf(c).penaltyRate <= 0:
+			continue
+		}
+		rows = append(rows, row)
+	}
+	slices.SortFunc(rows, func(a, b scheduleRow) int {
+		return cmp.Or(cmp.Compare(a.contractID, b.contractID), a.paymentDate.Compare(b.paymentDate))
+	})
+
+	var res repository.PenaltyAccrual
+	for _, row := range rows {
+		terms := d.versionOf(d.contracts[row.contractID])
+
+		from := row.paymentDate
+		if row.penaltyOn.After(from) {
+			from = row.penaltyOn
+		}
+		days := int(on.Sub(from).Hours() / 24)
+
+		charge := models.Money(math.Round(float64(row.payment-row.paid)*terms.penaltyRate/100)) * models.Money(days)
+		if terms.penaltyCap > 0 {
+			limit := models.Money(math.Round(float64(row.payment) * terms.penaltyCap / 100))
+			charge = min(charge, max(limit-row.penalty, 0))
+		}
+
+		row.penalty += charge
+		row.penaltyOn = on
+		d.schedule[row.id] = row
+
+		if charge > 0 {
+			d.operations = append(d.operations, operation{
+				contractID: row.contractID,
+				kind:       "penalty",
+				amount:     charge,
+				date:       r.s.now(),
+				description: fmt.Sprintf("Пени за %d дн. просрочки платежа от %s",
+					days, row.paymentDate.Format("02.01.2006")),
+			})
+			res.Installments++
+			res.Amount += charge
+		}
+	}
+	return res, nil
+}
+
+// reminderOverdueWindow - p_overdue_window по умолчанию.
+const reminderOverdueWindow = 7
+
+// ClaimReminders повторяет fn_claim_payment_reminders с окном просрочки
+// reminderOverdueWindow дней.
+func (r scheduleRepo) ClaimReminders(ctx context.Context, on time.Time, daysBefore []int) ([]repository.PaymentReminder, error) {
+	defer r.s.lock()()
+
+	d := r.s.d
+	on = dateOf(on)
+
+	var reminders []repository.PaymentReminder
+	for _, row := range d.schedule {
+		c := d.contracts[row.contractID]
+		cl := d.clients[c.clientID]
+		if row.isPaid || (c.status != models.LoanActive && c.status != models.LoanDefaulted) ||
+			cl.email == nil || strings.TrimSpace(*cl.email) == "" ||
+			row.paymentDate.Before(on.AddDate(0, 0, -reminderOverdueWindow)) {
+			continue
+		}
+
+		key := reminderKey{scheduleID: row.id, kind: repository.ReminderOverdue}
+		if !row.paymentDate.Before(on) {
+			ahead := int(row.paymentDate.Sub(on).Hours() / 24)
+			days := -1
+			for _, n := range daysBefore {
+				if n >= ahead && (days < 0 || n < days) {
+					days = n
+				}
+			}
+			if days < 0 {
+				continue
+			}
+			key = reminderKey{scheduleID: row.id, kind: repository.ReminderUpcoming, daysBefore: days}
+		}
+
+		if _, sent := d.reminders[key]; sent {
+			continue
+		}
+		d.reminders[key] = r.s.now()
+
+		reminders = append(reminders, repository.PaymentReminder{
+			ScheduleID:     row.id,
+			Kind:           key.kind,
+			DaysBefore:     key.daysBefore,
+			PaymentDate:    row.paymentDate,
+			Amount:         row.payment - row.paid + row.penalty - row.penaltyPaid,
+			ContractNumber: c.number,
+			Email:          *cl.email,
+			ClientName:     cl.firstName + " " + cl.lastName,
+		})
+	}
+	slices.SortFunc(reminders, func(a, b repository.PaymentReminder) int {
+		return cmp.Or(a.PaymentDate.Compare(b.PaymentDate), cmp.Compare(a.ScheduleID, b.ScheduleID))
+	})
+	return reminders, nil
+}
+
+func (d *data) sortedContracts() []contract {
+	contracts := make([]contract, 0, len(d.contracts))
+	for _, c := range d.contracts {
+		contracts = append(contracts, c)
+	}
+	slices.SortFunc(contracts, func(a, b contract) int {
+		if n := b.createdAt.Compare(a.createdAt); n != 0 {
+			return n
+		}
+		return cmp.Compare(b.id, a.id)
+	})
+	return contracts
+}
+
+func annuityPayment(amount models.Money, monthlyRate float64, months int) models.Money {
+	if monthlyRate == 0 {
+		return models.Money(math.Round(float64(amount) / float64(months)))
+	}
+	factor := math.Pow(1+monthlyRate, float64(months))
+	return models.Money(math.Round(float64(amount) * monthlyRate * factor / (factor - 1)))
+}
@@ -0,0 +1,105 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+// Статусы письма в mail_outbox.
+const (
+	mailPending = "pending"
+	mailSent    = "sent"
+	mailFailed  = "failed"
+)
+
+type outboxRepo struct {
+	s *Store
+}
+
+func (r outboxRepo) Enqueue(ctx context.Context, m repository.OutboxMessage) (int64, error) {
+	defer r.s.lock()()
+
+	now := r.s.now()
+	m.ID = r.s.d.nextID()
+	m.Attempts = 0
+	m.CreatedAt = now
+	r.s.d.outbox[m.ID] = outboxRow{msg: m, status: mailPending, nextAttempt: now}
+	return m.ID, nil
+}
+
+// Claim повторяет fn_claim_mail.
+func (r outboxRepo) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]repository.OutboxMessage, error) {
+	defer r.s.lock()()
+
+	d := r.s.d
+	var due []outboxRow
+	for _, row := range d.outbox {
+		if row.status == mailPending && !row.nextAttempt.After(now) {
+			due = append(due, row)
+		}
+	}
+	slices.SortFunc(due, func(a, b outboxRow) int {
+		return cmp.Or(a.nextAttempt.Compare(b.nextAttempt), cmp.Compare(a.msg.ID, b.msg.ID))
+	})
+
+	var messages []repository.OutboxMessage
+	for _, row := range due[:min(limit, len(due))] {
+		row.msg.Attempts++
+		row.nextAttempt = now.Add(lease)
+		d.outbox[row.msg.ID] = row
+		messages = append(messages, row.msg)
+	}
+	slices.SortFunc(messages, func(a, b repository.OutboxMessage) int { return cmp.Compare(a.ID, b.ID) })
+	return messages, nil
+}
+
+func (r outboxRepo) MarkSent(ctx context.Context, id int64) error {
+	return r.update(id, func(row *outboxRow) {
+		row.status = mailSent
+		row.sentAt = r.s.now()
+		row.lastError = ""
+	})
+}
+
+func (r outboxRepo) Retry(ctx context.Context, id int64, at time.Time, reason string) error {
+	return r.update(id, func(row *outboxRow) {
+		row.nextAttempt = at
+		row.lastError = reason
+	})
+}
+
+func (r outboxRepo) Fail(ctx context.Context, id int64, reason string) error {
+	return r.update(id, func(row *outboxRow) {
+		row.status = mailFailed
+		row.lastError = reason
+	})
+}
+
+func (r outboxRepo) update(id int64, fn func(row *outboxRow)) error {
+	defer r.s.lock()()
+
+	if row, ok := r.s.d.outbox[id]; ok {
+		fn(&row)
+		r.s.d.outbox[id] = row
+	}
+	return nil
+}
+
+// OutboxMessages возвращает письма очереди в статусе status (pending, sent
+// или failed) в порядке постановки. Нужен для проверок в тестах.
+func (s *Store) OutboxMessages(status string) []repository.OutboxMessage {
+	defer s.lock()()
+
+	var messages []repository.OutboxMessage
+	for _, row := range s.d.outbox {
+		if row.status == status {
+			messages = append(messages, row.msg)
+		}
+	}
+	slices.SortFunc(messages, func(a, b repository.OutboxMessage) int { return cmp.Compare(a.ID, b.ID) })
+	return messages
+}
@@ -0,0 +1,282 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+type productRepo struct {
+	s *Store
+}
+
+func (r productRepo) ListActive(ctx context.Context) ([]models.CreditProduct, error) {
+	return r.list(false), nil
+}
+
+func (r productRepo) List(ctx context.Context) ([]models.CreditProduct, error) {
+	return r.list(true), nil
+}
+
+func (r productRepo) list(includeInactive bool) []models.CreditProduct {
+	defer r.s.lock()()
+
+	products := []models.CreditProduct{}
+	for _, p := range r.s.d.products {
+		if p.isActive || includeInactive {
+			products = append(products, p.model())
+		}
+	}
+
+	slices.SortFunc(products, func(a, b models.CreditProduct) int { return cmp.Compare(a.ID, b.ID) })
+	return products
+}
+
+func (r productRepo) Get(ctx context.Context, productID int) (models.CreditProduct, error) {
+	defer r.s.lock()()
+
+	p, ok := r.s.d.products[productID]
+	if !ok {
+		return models.CreditProduct{}, repository.ErrNotFound
+	}
+	return p.model(), nil
+}
+
+// Create повторяет sp_create_product.
+func (r productRepo) Create(ctx context.Context, p repository.ProductParams) (int, error) {
+	defer r.s.lock()()
+
+	d := r.s.d
+	if err := d.checkProduct(0, p); err != nil {
+		return 0, err
+	}
+
+	now := r.s.now()
+	id := int(d.nextID())
+	prod := product{
+		id:            id,
+		name:          p.Name,
+		minAmount:     p.MinAmount,
+		maxAmount:     p.MaxAmount,
+		minTermMonths: p.MinTermMonths,
+		maxTermMonths: p.MaxTermMonths,
+		interestRate:  roundRate(p.InterestRate),
+		scheduleType:  p.ScheduleType,
+		penaltyRate:   roundPenaltyRate(p.PenaltyRate),
+		penaltyCap:    roundRate(p.PenaltyCap),
+		isActive:      true,
+		version:       1,
+	}
+	d.products[id] = prod
+	d.logChange(p.ActorID, "INSERT", "credit_products", int64(id), nil, prod.row(), now)
+	d.addVersion(prod, p.ActorID, now)
+	return id, nil
+}
+
+// Update повторяет sp_update_product: версия растет только при изменении
+// условий.
+func (r productRepo) Update(ctx context.Context, productID int, p repository.ProductParams) (int, error) {
+	defer r.s.lock()()
+
+	d := r.s.d
+	old, ok := d.products[productID]
+	if !ok {
+		return 0, dbError("RB011", "Кредитный продукт не найден")
+	}
+	if err := d.checkProduct(productID, p); err != nil {
+		return 0, err
+	}
+
+	now := r.s.now()
+	upd := old
+	upd.name = p.Name
+	upd.minAmount, upd.maxAmount = p.MinAmount, p.MaxAmount
+	upd.minTermMonths, upd.maxTermMonths = p.MinTermMonths, p.MaxTermMonths
+	upd.interestRate = roundRate(p.InterestRate)
+	upd.scheduleType = p.ScheduleType
+	upd.penaltyRate, upd.penaltyCap = roundPenaltyRate(p.PenaltyRate), roundRate(p.PenaltyCap)
+
+	if !upd.sameTerms(old) {
+		upd.version++
+		d.addVersion(upd, p.ActorID, now)
+	}
+	if upd != old {
+		d.products[productID] = upd
+		d.logChange(p.ActorID, "UPDATE", "credit_products", int64(productID), old.row(), upd.row(), now)
+	}
+	return upd.version, nil
+}
+
+// SetActive повторяет sp_set_product_active.
+func (r productRepo) SetActive(ctx context.Context, actorID int64, productID int, active bool) error {
+	defer r.s.lock()()
+
+	d := r.s.d
+	old, ok := d.products[productID]
+	if !ok {
+		return dbError("RB011", "Кредитный продукт не найден")
+	}
+	if old.isActive == active {
+		return nil
+	}
+
+	upd := old
+	upd.isActive = active
+	d.products[productID] = upd
+	d.logChange(actorID, "UPDATE", "credit_products", int64(productID), old.row(), upd.row(), r.s.now())
+	return nil
+}
+
+func (r productRepo) Versions(ctx context.Context, productID int) ([]models.ProductVersion, error) {
+	defer r.s.lock()()
+
+	d := r.s.d
+	versions := []models.ProductVersion{}
+	for _, v := range d.versions {
+		if v.productID != productID {
+			continue
+		}
+
+		var contracts int64
+		for _, c := range d.contracts {
+			if c.versionID == v.id {
+				contracts++
+			}
+		}
+
+		versions = append(versions, models.ProductVersion{
+			ID:            v.id,
+			Version:       v.version,
+			MinAmount:     v.minAmount,
+			MaxAmount:     v.maxAmount,
+			MinTermMonths: v.minTermMonths,
+			MaxTermMonths: v.maxTermMonths,
+			InterestRate:  v.interestRate,
+			ScheduleType:  v.scheduleType,
+			PenaltyRate:   v.penaltyRate,
+			PenaltyCap:    v.penaltyCap,
+			Date:          v.createdAt,
+			User:          d.auditUserName(v.createdBy),
+			Contracts:     contracts,
+		})
+	}
+
+	slices.SortFunc(versions, func(a, b models.ProductVersion) int { return cmp.Compare(b.Version, a.Version) })
+	return versions, nil
+}
+
+// checkProduct повторяет ограничения credit_products.
+func (d *data) checkProduct(productID int, p repository.ProductParams) error {
+	for _, other := range d.products {
+		if other.id != productID && other.name == p.Name {
+			return constraintError("23505", "uq_products_name", "duplicate key value violates unique constraint")
+		}
+	}
+	switch {
+	case p.MinAmount <= 0 || p.MaxAmount < p.MinAmount:
+		return constraintError("23514", "chk_product_amounts", "new row violates check constraint")
+	case p.MinTermMonths <= 0 || p.MaxTermMonths < p.MinTermMonths:
+		return constraintError("23514", "chk_product_terms", "new row violates check constraint")
+	case p.InterestRate <= 0 || roundRate(p.InterestRate) >= 100:
+		return constraintError("23514", "chk_product_rate", "new row violates check constraint")
+	case p.PenaltyRate < 0 || roundPenaltyRate(p.PenaltyRate) >= 100 || p.PenaltyCap < 0:
+		return constraintError("23514", "chk_product_penalty", "new row violates check constraint")
+	}
+	return nil
+}
+
+func (d *data) addVersion(p product, actorID int64, at time.Time) {
+	d.versions = append(d.versions, productVersion{
+		id:            int(d.nextID()),
+		productID:     p.id,
+		version:       p.version,
+		minAmount:     p.minAmount,
+		maxAmount:     p.maxAmount,
+		minTermMonths: p.minTermMonths,
+		maxTermMonths: p.maxTermMonths,
+		interestRate:  p.interestRate,
+		scheduleType:  p.scheduleType,
+		penaltyRate:   p.penaltyRate,
+		penaltyCap:    p.penaltyCap,
+		createdBy:     actorID,
+		createdAt:     at,
+	})
+}
+
+// currentVersion возвращает версию условий, по которой сейчас выдается
+// продукт.
+func (d *data) currentVersion(p product) productVersion {
+	for _, v := range d.versions {
+		if v.productID == p.id && v.version == p.version {
+			return v
+		}
+	}
+	return productVersion{}
+}
+
+// versionOf возвращает версию условий, по которой выдан договор.
+func (d *data) versionOf(c contract) productVersion {
+	for _, v := range d.versions {
+		if v.id == c.versionID {
+			return v
+		}
+	}
+	return productVersion{scheduleType: models.ScheduleAnnuity}
+}
+
+func (p product) sameTerms(o product) bool {
+	return p.minAmount == o.minAmount && p.maxAmount == o.maxAmount &&
+		p.minTermMonths == o.minTermMonths && p.maxTermMonths == o.maxTermMonths &&
+		p.interestRate == o.interestRate && p.scheduleType == o.scheduleType &&
+		p.penaltyRate == o.penaltyRate && p.penaltyCap == o.penaltyCap
+}
+
+// row повторяет row_to_json(credit_products) для аудита.
+func (p product) row() map[string]any {
+	return map[string]any{
+		"id":              p.id,
+		"name":            p.name,
+		"min_amount":      p.minAmount,
+		"max_amount":      p.maxAmount,
+		"min_term_months": p.minTermMonths,
+		"max_term_months": p.maxTermMonths,
+		"interest_rate":   p.interestRate,
+		"schedule_type":   p.scheduleType,
+		"penalty_rate":    p.penaltyRate,
+		"penalty_cap":     p.penaltyCap,
+		"is_active":       p.isActive,
+		"current_version": p.version,
+	}
+}
+
+func (p product) model() models.CreditProduct {
+	return models.CreditProduct{
+		ID:            p.id,
+		Name:          p.name,
+		MinAmount:     p.minAmount,
+		MaxAmount:     p.maxAmount,
+		MinTermMonths: p.minTermMonths,
+		MaxTermMonths: p.maxTermMonths,
+		InterestRate:  p.interestRate,
+		ScheduleType:  p.scheduleType,
+		PenaltyRate:   p.penaltyRate,
+		PenaltyCap:    p.penaltyCap,
+		IsActive:      p.isActive,
+		Version:       p.version,
+	}
+}
+
+// roundRate повторяет NUMERIC(5, 2).
+func roundRate(rate float64) float64 {
+	return math.Round(rate*100) / 100
+}
+
+// roundPenaltyRate повторяет NUMERIC(6, 3).
+func roundPenaltyRate(rate float64) float64 {
+	return math.Round(rate*1000) / 1000
+}
@@ -0,0 +1,455 @@
+// Package memory - реализация репозиториев в памяти процесса. Повторяет
+// поведение хранимых процедур настолько, насколько это нужно для тестов
+// HTTP API без PostgreSQL.
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+type user struct {
+	id                int64
+	login             string
+	passwordHash      string
+	role              string
+	active            bool
+	activated         bool
+	mustChange        bool
+	passwordChangedAt time.Time
+}
+
+type employee struct {
+	id        int64
+	userID    int64
+	firstName string
+	lastName  string
+	position  string
+	email     string
+}
+
+type client struct {
+	id             int64
+	userID         int64
+	firstName      string
+	lastName       string
+	middleName     *string
+	passportSeries string
+	passportNumber string
+	passportIssued *string
+	dateOfBirth    time.Time
+	address        string
+	phone          string
+	email          *string
+	createdAt      time.Time
+}
+
+type product struct {
+	id            int
+	name          string
+	minAmount     models.Money
+	maxAmount     models.Money
+	minTermMonths int
+	maxTermMonths int
+	interestRate  float64
+	scheduleType  string
+	penaltyRate   float64
+	penaltyCap    float64
+	isActive      bool
+	version       int
+}
+
+type productVersion struct {
+	id            int
+	productID     int
+	version       int
+	minAmount     models.Money
+	maxAmount     models.Money
+	minTermMonths int
+	maxTermMonths int
+	interestRate  float64
+	scheduleType  string
+	penaltyRate   float64
+	penaltyCap    float64
+	createdBy     int64
+	createdAt     time.Time
+}
+
+type contract struct {
+	id         int64
+	number     string
+	clientID   int64
+	productID  int
+	versionID  int
+	createdBy  int64
+	employeeID int64
+	amount     models.Money
+	balance    models.Money
+	rate       float64
+	termMonths int
+	startDate  time.Time
+	endDate    time.Time
+	status     string
+	createdAt  time.Time
+	// Учет просрочки (fn_process_delinquency).
+	daysPastDue int
+	bucket      string
+}
+
+type loanTransition struct {
+	id         int64
+	contractID int64
+	from       string
+	to         string
+	reason     string
+	userID     int64
+	at         time.Time
+}
+
+type scheduleRow struct {
+	id          int64
+	contractID  int64
+	paymentDate time.Time
+	payment     models.Money
+	principal   models.Money
+	interest    models.Money
+	remaining   models.Money
+	isPaid      bool
+	paid        models.Money
+	penalty     models.Money
+	penaltyPaid models.Money
+	penaltyOn   time.Time
+}
+
+type operation struct {
+	contractID  int64
+	kind        string
+	amount      models.Money
+	date        time.Time
+	description string
+}
+
+type auditRow struct {
+	id        int64
+	userID    int64
+	action    string
+	entity    string
+	entityID  int64
+	oldValues map[string]any
+	newValues map[string]any
+	createdAt time.Time
+}
+
+// outboxRow - письмо в mail_outbox.
+type outboxRow struct {
+	msg         repository.OutboxMessage
+	status      string
+	nextAttempt time.Time
+	lastError   string
+	sentAt      time.Time
+}
+
+type token struct {
+	userID    int64
+	expiresAt time.Time
+	used      bool
+}
+
+type totpState struct {
+	secret    string
+	confirmed bool
+	lastStep  int64
+	hasStep   bool
+}
+
+type recoveryKey struct {
+	userID int64
+	hash   string
+}
+
+// reminderKey - отправленное напоминание (uq_payment_reminder).
+type reminderKey struct {
+	scheduleID int64
+	kind       string
+	daysBefore int
+}
+
+type data struct {
+	seq int64
+
+	roles2fa    map[string]bool
+	users       map[int64]user
+	employees   map[int64]employee
+	clients     map[int64]client
+	products    map[int]product
+	versions    []productVersion
+	contracts   map[int64]contract
+	transitions []loanTransition
+	schedule    map[int64]scheduleRow
+	operations  []operation
+	reminders   map[reminderKey]time.Time
+	audit       []auditRow
+	outbox      map[int64]outboxRow
+
+	resetTokens      map[string]token
+	activationTokens map[string]token
+	totp             map[int64]totpState
+	recovery         map[recoveryKey]bool
+}
+
+func (d *data) clone() *data {
+	return &data{
+		seq:              d.seq,
+		roles2fa:         maps.Clone(d.roles2fa),
+		users:            maps.Clone(d.users),
+		employees:        maps.Clone(d.employees),
+		clients:          maps.Clone(d.clients),
+		products:         maps.Clone(d.products),
+		versions:         slices.Clone(d.versions),
+		contracts:        maps.Clone(d.contracts),
+		transitions:      slices.Clone(d.transitions),
+		schedule:         maps.Clone(d.schedule),
+		operations:       slices.Clone(d.operations),
+		reminders:        maps.Clone(d.reminders),
+		audit:            slices.Clone(d.audit),
+		outbox:           maps.Clone(d.outbox),
+		resetTokens:      maps.Clone(d.resetTokens),
+		activationTokens: maps.Clone(d.activationTokens),
+		totp:             maps.Clone(d.totp),
+		recovery:         maps.Clone(d.recovery),
+	}
+}
+
+// nextID выдает идентификаторы из общей последовательности.
+func (d *data) nextID() int64 {
+	d.seq++
+	return d.seq
+}
+
+// Store хранит все данные под одним мьютексом. Транзакция держит его
+// целиком и при ошибке восстанавливает снимок.
+type Store struct {
+	mu   *sync.Mutex
+	d    *data
+	inTx bool
+	now  func() time.Time
+}
+
+func NewStore() *Store {
+	return &Store{
+		mu: &sync.Mutex{},
+		d: &data{
+			roles2fa:         map[string]bool{"admin": false, "manager": false, "client": false},
+			users:            make(map[int64]user),
+			employees:        make(map[int64]employee),
+			clients:          make(map[int64]client),
+			products:         make(map[int]product),
+			contracts:        make(map[int64]contract),
+			schedule:         make(map[int64]scheduleRow),
+			reminders:        make(map[reminderKey]time.Time),
+			outbox:           make(map[int64]outboxRow),
+			resetTokens:      make(map[string]token),
+			activationTokens: make(map[string]token),
+			totp:             make(map[int64]totpState),
+			recovery:         make(map[recoveryKey]bool),
+		},
+		now: time.Now,
+	}
+}
+
+func (s *Store) Users() repository.UserRepository          { return userRepo{s} }
+func (s *Store) TwoFactor() repository.TwoFactorRepository { return twoFactorRepo{s} }
+func (s *Store) Employees() repository.EmployeeRepository  { return employeeRepo{s} }
+func (s *Store) Clients() repository.ClientRepository      { return clientRepo{s} }
+func (s *Store) Products() repository.ProductRepository    { return productRepo{s} }
+func (s *Store) Loans() repository.LoanRepository          { return loanRepo{s} }
+func (s *Store) Schedules() repository.ScheduleRepository  { return scheduleRepo{s} }
+func (s *Store) Audit() repository.AuditRepository         { return auditRepo{s} }
+func (s *Store) Reports() repository.ReportRepository      { return reportRepo{s} }
+func (s *Store) Outbox() repository.OutboxRepository       { return outboxRepo{s} }
+
+func (s *Store) InTx(ctx context.Context, fn func(tx repository.Store) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := s.d.clone()
+	if err := fn(&Store{mu: s.mu, d: s.d, inTx: true, now: s.now}); err != nil {
+		*s.d = *snapshot
+		return err
+	}
+	return nil
+}
+
+// lock берет мьютекс вне транзакции; внутри InTx он уже захвачен.
+func (s *Store) lock() func() {
+	if s.inTx {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+// AddEmployee заводит сотрудника с заданной ролью и возвращает id
+// пользователя. Нужен для начального наполнения в тестах.
+func (s *Store) AddEmployee(role string, e repository.EmployeeRegistration) int64 {
+	defer s.lock()()
+
+	return s.d.addEmployee(role, e)
+}
+
+func (d *data) addEmployee(role string, e repository.EmployeeRegistration) int64 {
+	userID := d.nextID()
+	d.users[userID] = user{
+		id:           userID,
+		login:        e.Login,
+		passwordHash: e.PasswordHash,
+		role:         role,
+		active:       true,
+		activated:    true,
+	}
+	d.employees[userID] = employee{
+		id:        d.nextID(),
+		userID:    userID,
+		firstName: e.FirstName,
+		lastName:  e.LastName,
+		position:  e.Position,
+		email:     e.Email,
+	}
+	return userID
+}
+
+// AddProduct добавляет активный кредитный продукт с аннуитетным графиком,
+// суммы в копейках.
+func (s *Store) AddProduct(name string, minAmount, maxAmount models.Money, minTerm, maxTerm int, rate float64) int {
+	defer s.lock()()
+
+	id := int(s.d.nextID())
+	s.d.products[id] = product{
+		id:            id,
+		name:          name,
+		minAmount:     minAmount,
+		maxAmount:     maxAmount,
+		minTermMonths: minTerm,
+		maxTermMonths: maxTerm,
+		interestRate:  rate,
+		scheduleType:  models.ScheduleAnnuity,
+		isActive:      true,
+		version:       1,
+	}
+	s.d.addVersion(s.d.products[id], 0, s.now())
+	return id
+}
+
+// profile собирает имя и email пользователя, как v_user_complete_profile.
+func (d *data) profile(u user) repository.User {
+	p := repository.User{
+		ID:                 u.id,
+		Login:              u.login,
+		PasswordHash:       u.passwordHash,
+		Role:               u.role,
+		MustChangePassword: u.mustChange,
+	}
+
+	for _, e := range d.employees {
+		if e.userID == u.id {
+			p.FirstName, p.LastName, p.Email = &e.firstName, &e.lastName, &e.email
+			return p
+		}
+	}
+	for _, c := range d.clients {
+		if c.userID == u.id {
+			p.FirstName, p.LastName, p.Email = &c.firstName, &c.lastName, c.email
+			return p
+		}
+	}
+	return p
+}
+
+// dbError повторяет ошибку, которую в PostgreSQL поднимает процедура
+// (SQLSTATE из миграции 000009_error_codes), чтобы API классифицировало
+// ее так же.
+func dbError(code string, message string) error {
+	return &pgconn.PgError{Severity: "ERROR", Code: code, Message: message}
+}
+
+// constraintError повторяет нарушение ограничения схемы.
+func constraintError(code string, constraint string, message string) error {
+	return &pgconn.PgError{Severity: "ERROR", Code: code, Message: message, ConstraintName: constraint}
+}
+
+func dateOf(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// addMonths прибавляет месяцы, как интервал PostgreSQL: 31 января + 1 месяц
+// дает последний день февраля, а не 3 марта.
+func addMonths(t time.Time, months int) time.Time {
+	y, m, d := t.Date()
+	first := time.Date(y, m+time.Month(months), 1, 0, 0, 0, 0, t.Location())
+	if last := first.AddDate(0, 1, -1).Day(); d > last {
+		d = last
+	}
+	return time.Date(first.Year(), first.Month(), d, 0, 0, 0, 0, t.Location())
+}
+
+// sortKey - положение записи в списке. Значения сравниваются как строки,
+// поэтому числа и время приводятся к виду, сохраняющему порядок.
+type sortKey struct {
+	value string
+	id    int64
+}
+
+func intKey(v int64) string {
+	return fmt.Sprintf("%020d", v)
+}
+
+func timeKey(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000")
+}
+
+func invalidSort(sort string) error {
+	return dbError("RB016", fmt.Sprintf("Недопустимое поле сортировки: %s", sort))
+}
+
+// paginate повторяет fn_get_*: сортировка по (поле, id), отсечение по
+// курсору и лимит. Total - число записей до отсечения.
+func paginate[T any](items []T, key func(T) sortKey, p repository.PageRequest) repository.Page[T] {
+	compare := func(a, b sortKey) int {
+		c := cmp.Or(cmp.Compare(a.value, b.value), cmp.Compare(a.id, b.id))
+		if p.Desc {
+			return -c
+		}
+		return c
+	}
+
+	slices.SortFunc(items, func(a, b T) int { return compare(key(a), key(b)) })
+
+	page := repository.Page[T]{Items: []T{}, Total: int64(len(items))}
+	for _, item := range items {
+		k := key(item)
+		if p.After != nil && compare(k, sortKey{p.After.Value, p.After.ID}) <= 0 {
+			continue
+		}
+		if len(page.Items) == p.Limit {
+			last := key(page.Items[len(page.Items)-1])
+			page.Next = &repository.Cursor{Value: last.value, ID: last.id}
+			break
+		}
+		page.Items = append(page.Items, item)
+	}
+	return page
+}
@@ -0,0 +1,38 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+func TestInTxRollsBackOnError(t *testing.T) {
+	s := NewStore()
+	ctx := context.Background()
+	boom := errors.New("boom")
+
+	err := s.InTx(ctx, func(tx repository.Store) error {
+		if _, err := tx.Employees().Register(ctx, repository.EmployeeRegistration{Login: "manager"}); err != nil {
+			return err
+		}
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected fn error, got %v", err)
+	}
+
+	if _, err := s.Users().GetByLogin(ctx, "manager"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("user created in a failed transaction should not exist, got %v", err)
+	}
+}
+
+func TestAddMonthsClampsToMonthEnd(t *testing.T) {
+	jan31 := time.Date(2025, time.January, 31, 0, 0, 0, 0, time.UTC)
+
+	if got := addMonths(jan31, 1); got.Day() != 28 || got.Month() != time.February {
+		t.Errorf("expected 2025-02-28, got %s", got.Format("2006-01-02"))
+	}
+}
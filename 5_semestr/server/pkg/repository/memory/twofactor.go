@@ -0,0 +1,111 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+type twoFactorRepo struct {
+	s *Store
+}
+
+func (r twoFactorRepo) Get(ctx context.Context, userID int64) (repository.TwoFactor, error) {
+	defer r.s.lock()()
+
+	u, ok := r.s.d.users[userID]
+	if !ok {
+		return repository.TwoFactor{}, repository.ErrNotFound
+	}
+
+	st := repository.TwoFactor{Required: r.s.d.roles2fa[u.role]}
+	if t, ok := r.s.d.totp[userID]; ok {
+		secret := t.secret
+		st.Secret = &secret
+		st.Confirmed = t.confirmed
+	}
+	return st, nil
+}
+
+func (r twoFactorRepo) BeginEnrollment(ctx context.Context, userID int64, secret string) error {
+	defer r.s.lock()()
+
+	if r.s.d.totp[userID].confirmed {
+		return dbError("RB007", "Двухфакторная аутентификация уже подключена")
+	}
+
+	r.s.d.totp[userID] = totpState{secret: secret}
+	return nil
+}
+
+func (r twoFactorRepo) Confirm(ctx context.Context, userID int64, step int64, codeHashes []string) error {
+	defer r.s.lock()()
+
+	t, ok := r.s.d.totp[userID]
+	if !ok || t.confirmed {
+		return dbError("RB008", "Нет ожидающей подтверждения настройки 2FA")
+	}
+
+	t.confirmed = true
+	t.lastStep, t.hasStep = step, true
+	r.s.d.totp[userID] = t
+
+	r.deleteRecoveryCodes(userID)
+	for _, h := range codeHashes {
+		r.s.d.recovery[recoveryKey{userID, h}] = false
+	}
+	return nil
+}
+
+func (r twoFactorRepo) UseStep(ctx context.Context, userID int64, step int64) (bool, error) {
+	defer r.s.lock()()
+
+	t, ok := r.s.d.totp[userID]
+	if !ok || !t.confirmed || (t.hasStep && t.lastStep >= step) {
+		return false, nil
+	}
+
+	t.lastStep, t.hasStep = step, true
+	r.s.d.totp[userID] = t
+	return true, nil
+}
+
+func (r twoFactorRepo) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) (bool, error) {
+	defer r.s.lock()()
+
+	key := recoveryKey{userID, codeHash}
+	used, ok := r.s.d.recovery[key]
+	if !ok || used {
+		return false, nil
+	}
+
+	r.s.d.recovery[key] = true
+	return true, nil
+}
+
+func (r twoFactorRepo) Disable(ctx context.Context, userID int64) error {
+	defer r.s.lock()()
+
+	r.deleteRecoveryCodes(userID)
+	delete(r.s.d.totp, userID)
+	return nil
+}
+
+func (r twoFactorRepo) SetRoleRequired(ctx context.Context, role string, required bool) error {
+	defer r.s.lock()()
+
+	if _, ok := r.s.d.roles2fa[role]; !ok {
+		return dbError("RB015", "Роль "+role+" не найдена")
+	}
+
+	r.s.d.roles2fa[role] = required
+	return nil
+}
+
+func (r twoFactorRepo) deleteRecoveryCodes(userID int64) {
+	for k := range r.s.d.recovery {
+		if k.userID == userID {
+			delete(r.s.d.recovery, k)
+		}
+	}
+}
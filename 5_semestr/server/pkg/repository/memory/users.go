@@ -0,0 +1,210 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+type userRepo struct {
+	s *Store
+}
+
+func (r userRepo) GetByLogin(ctx context.Context, login string) (repository.User, error) {
+	defer r.s.lock()()
+
+	for _, u := range r.s.d.users {
+		if u.login == login && u.active {
+			return r.s.d.profile(u), nil
+		}
+	}
+	return repository.User{}, repository.ErrNotFound
+}
+
+func (r userRepo) GetByID(ctx context.Context, userID int64) (repository.User, error) {
+	defer r.s.lock()()
+
+	u, ok := r.s.d.users[userID]
+	if !ok || !u.active {
+		return repository.User{}, repository.ErrNotFound
+	}
+	return r.s.d.profile(u), nil
+}
+
+func (r userRepo) SetPassword(ctx context.Context, userID int64, passwordHash string) error {
+	defer r.s.lock()()
+
+	u, ok := r.s.d.users[userID]
+	if !ok {
+		return dbError("RB010", "Пользователь не найден")
+	}
+
+	u.passwordHash = passwordHash
+	u.passwordChangedAt = r.s.now()
+	u.mustChange = false
+	r.s.d.users[userID] = u
+
+	for hash, t := range r.s.d.resetTokens {
+		if t.userID == userID {
+			t.used = true
+			r.s.d.resetTokens[hash] = t
+		}
+	}
+	return nil
+}
+
+// bcryptHash совпадает с хешами bcrypt, как условие в
+// fn_get_plaintext_credentials.
+var bcryptHash = regexp.MustCompile(`^\$2[aby]\$`)
+
+func (r userRepo) PlaintextCredentials(ctx context.Context) ([]repository.PlaintextCredential, error) {
+	defer r.s.lock()()
+
+	var creds []repository.PlaintextCredential
+	for _, u := range r.s.d.users {
+		if !bcryptHash.MatchString(u.passwordHash) {
+			creds = append(creds, repository.PlaintextCredential{UserID: u.id, Login: u.login, Value: u.passwordHash})
+		}
+	}
+	slices.SortFunc(creds, func(a, b repository.PlaintextCredential) int { return cmp.Compare(a.UserID, b.UserID) })
+	return creds, nil
+}
+
+func (r userRepo) ReplaceCredential(ctx context.Context, userID int64, passwordHash string) error {
+	defer r.s.lock()()
+
+	u, ok := r.s.d.users[userID]
+	if !ok {
+		return nil
+	}
+	u.passwordHash = passwordHash
+	u.mustChange = true
+	r.s.d.users[userID] = u
+	return nil
+}
+
+func (r userRepo) SessionValid(ctx context.Context, userID int64, issuedAt time.Time) (bool, error) {
+	defer r.s.lock()()
+
+	u, ok := r.s.d.users[userID]
+	if !ok || !u.active {
+		return false, nil
+	}
+	return u.passwordChangedAt.IsZero() || !issuedAt.Before(u.passwordChangedAt.Truncate(time.Second)), nil
+}
+
+func (r userRepo) FindForReset(ctx context.Context, loginOrEmail string) (repository.User, error) {
+	defer r.s.lock()()
+
+	var found *repository.User
+	for _, u := range r.s.d.users {
+		if !u.active {
+			continue
+		}
+		p := r.s.d.profile(u)
+		if p.Email == nil || *p.Email == "" {
+			continue
+		}
+		if u.login == loginOrEmail {
+			return p, nil
+		}
+		if found == nil && strings.EqualFold(*p.Email, loginOrEmail) {
+			found = &p
+		}
+	}
+
+	if found == nil {
+		return repository.User{}, repository.ErrNotFound
+	}
+	return *found, nil
+}
+
+func (r userRepo) CreatePasswordReset(ctx context.Context, userID int64, tokenHash string, ttl time.Duration) error {
+	defer r.s.lock()()
+
+	createToken(r.s.d.resetTokens, userID, tokenHash, r.s.now().Add(ttl))
+	return nil
+}
+
+func (r userRepo) ConsumePasswordReset(ctx context.Context, tokenHash string) (int64, error) {
+	defer r.s.lock()()
+
+	return consumeToken(r.s.d.resetTokens, tokenHash, r.s.now())
+}
+
+func (r userRepo) CreateActivation(ctx context.Context, userID int64, tokenHash string, ttl time.Duration) error {
+	defer r.s.lock()()
+
+	return r.createActivation(userID, tokenHash, ttl)
+}
+
+func (r userRepo) createActivation(userID int64, tokenHash string, ttl time.Duration) error {
+	if r.s.d.users[userID].activated {
+		return dbError("RB009", "Учетная запись уже активирована")
+	}
+
+	createToken(r.s.d.activationTokens, userID, tokenHash, r.s.now().Add(ttl))
+	return nil
+}
+
+func (r userRepo) ConsumeActivation(ctx context.Context, tokenHash string) (int64, error) {
+	defer r.s.lock()()
+
+	userID, err := consumeToken(r.s.d.activationTokens, tokenHash, r.s.now())
+	if err != nil {
+		return 0, err
+	}
+
+	u := r.s.d.users[userID]
+	u.activated = true
+	r.s.d.users[userID] = u
+
+	return userID, nil
+}
+
+func (r userRepo) GetClientActivation(ctx context.Context, clientID int64) (repository.ActivationTarget, error) {
+	defer r.s.lock()()
+
+	c, ok := r.s.d.clients[clientID]
+	if !ok {
+		return repository.ActivationTarget{}, repository.ErrNotFound
+	}
+	u := r.s.d.users[c.userID]
+
+	return repository.ActivationTarget{
+		UserID:    u.id,
+		Login:     u.login,
+		FirstName: c.firstName,
+		LastName:  c.lastName,
+		Email:     c.email,
+		Activated: u.activated,
+	}, nil
+}
+
+// createToken гасит прежние неиспользованные токены пользователя и
+// сохраняет новый.
+func createToken(tokens map[string]token, userID int64, hash string, expiresAt time.Time) {
+	for h, t := range tokens {
+		if t.userID == userID && !t.used {
+			t.used = true
+			tokens[h] = t
+		}
+	}
+	tokens[hash] = token{userID: userID, expiresAt: expiresAt}
+}
+
+func consumeToken(tokens map[string]token, hash string, now time.Time) (int64, error) {
+	t, ok := tokens[hash]
+	if !ok || t.used || !t.expiresAt.After(now) {
+		return 0, repository.ErrNotFound
+	}
+
+	t.used = true
+	tokens[hash] = t
+	return t.userID, nil
+}
@@ -0,0 +1,129 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+type auditRepo struct {
+	q querier
+}
+
+func (r auditRepo) Log(ctx context.Context, e repository.AuditEntry) error {
+	var actor any
+	if e.UserID != 0 {
+		actor = e.UserID
+	}
+
+	_, err := r.q.Exec(ctx, "CALL sp_audit_log($1, $2, $3, $4, $5)",
+		actor, e.Action, e.Entity, e.EntityID, e.Details)
+	return err
+}
+
+func (r auditRepo) List(ctx context.Context, f repository.AuditFilter, p repository.PageRequest) (repository.Page[models.AuditLog], error) {
+	filter := []any{nullable(f.Action), nullable(f.Entity), nullable(f.UserID), f.From, f.To}
+
+	var total int64
+	if err := r.q.QueryRow(ctx, "SELECT fn_count_audit_logs($1, $2, $3, $4, $5)", filter...).Scan(&total); err != nil {
+		return repository.Page[models.AuditLog]{}, err
+	}
+
+	args := append(filter, pageArgs(p)...)
+	rows, err := r.q.Query(ctx, "SELECT * FROM fn_get_audit_logs($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)", args...)
+	if err != nil {
+		return repository.Page[models.AuditLog]{}, err
+	}
+	defer rows.Close()
+
+	logs := []models.AuditLog{}
+	var cursors []repository.Cursor
+	for rows.Next() {
+		var l models.AuditLog
+		var login, fn, ln *string
+		var sortValue string
+
+		err := rows.Scan(&l.ID, &l.Action, &l.Entity, &l.EntityID, &l.Date, &l.Details, &login, &fn, &ln, &sortValue)
+		if err != nil {
+			return repository.Page[models.AuditLog]{}, err
+		}
+
+		l.User = auditUserName(login, fn, ln)
+		logs = append(logs, l)
+		cursors = append(cursors, repository.Cursor{Value: sortValue, ID: l.ID})
+	}
+	if err := rows.Err(); err != nil {
+		return repository.Page[models.AuditLog]{}, err
+	}
+
+	return buildPage(logs, cursors, p.Limit, total), nil
+}
+
+func auditUserName(login, firstName, lastName *string) string {
+	if login == nil {
+		return "Система/Неизвестный"
+	}
+	if firstName != nil && lastName != nil {
+		return fmt.Sprintf("%s %s (%s)", *lastName, *firstName, *login)
+	}
+	return *login
+}
+
+type reportRepo struct {
+	q querier
+}
+
+// DashboardStats читает JSON из mv_dashboard_cache: суммы в нем - числа в
+// копейках, а не строки Money.
+func (r reportRepo) DashboardStats(ctx context.Context) (models.DashboardStats, error) {
+	var raw []byte
+	if err := r.q.QueryRow(ctx, "SELECT fn_get_dashboard_stats()").Scan(&raw); err != nil {
+		return models.DashboardStats{}, err
+	}
+
+	var cache struct {
+		TotalIssued   int64                      `json:"totalIssued"`
+		TotalRepaid   int64                      `json:"totalRepaid"`
+		TotalInterest int64                      `json:"totalInterest"`
+		Distribution  []models.DistributionValue `json:"distribution"`
+		Delinquency   []models.DistributionValue `json:"delinquency"`
+	}
+	if err := json.Unmarshal(raw, &cache); err != nil {
+		return models.DashboardStats{}, err
+	}
+
+	stats := models.DashboardStats{
+		TotalIssued:   models.Money(cache.TotalIssued),
+		TotalRepaid:   models.Money(cache.TotalRepaid),
+		TotalInterest: models.Money(cache.TotalInterest),
+		Distribution:  cache.Distribution,
+		Delinquency:   cache.Delinquency,
+	}
+	if stats.Distribution == nil {
+		stats.Distribution = []models.DistributionValue{}
+	}
+	return stats, nil
+}
+
+func (r reportRepo) FinanceReport(ctx context.Context) ([]models.FinanceReportRow, error) {
+	rows, err := r.q.Query(ctx, "SELECT * FROM fn_get_financial_report()")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := []models.FinanceReportRow{}
+	for rows.Next() {
+		var row models.FinanceReportRow
+		if err := rows.Scan(&row.Month, &row.Issued, &row.Repaid, &row.Net); err != nil {
+			return nil, err
+		}
+
+		report = append(report, row)
+	}
+
+	return report, rows.Err()
+}
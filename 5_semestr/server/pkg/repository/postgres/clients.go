@@ -0,0 +1,213 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+type clientRepo struct {
+	q querier
+}
+
+func (r clientRepo) List(ctx context.Context, f repository.ClientFilter, p repository.PageRequest) (repository.Page[models.Client], error) {
+	var total int64
+	err := r.q.QueryRow(ctx, "SELECT fn_count_clients($1, $2)", f.CreatedFrom, f.CreatedTo).Scan(&total)
+	if err != nil {
+		return repository.Page[models.Client]{}, err
+	}
+
+	args := append([]any{f.CreatedFrom, f.CreatedTo}, pageArgs(p)...)
+	rows, err := r.q.Query(ctx, "SELECT * FROM fn_get_clients($1, $2, $3, $4, $5, $6, $7)", args...)
+	if err != nil {
+		return repository.Page[models.Client]{}, err
+	}
+	defer rows.Close()
+
+	clients := []models.Client{}
+	var cursors []repository.Cursor
+	for rows.Next() {
+		var cl models.Client
+		var dob time.Time
+		var sortValue string
+
+		err := rows.Scan(&cl.ID, &cl.FirstName, &cl.LastName, &cl.MiddleName,
+			&cl.PassportSeries, &cl.PassportNumber, &cl.PassportIssued,
+			&dob, &cl.Address, &cl.Phone, &cl.Email, &cl.CreatedAt, &sortValue)
+		if err != nil {
+			return repository.Page[models.Client]{}, err
+		}
+
+		cl.DateOfBirth = dob.Format("2006-01-02")
+		clients = append(clients, cl)
+		cursors = append(cursors, repository.Cursor{Value: sortValue, ID: cl.ID})
+	}
+	if err := rows.Err(); err != nil {
+		return repository.Page[models.Client]{}, err
+	}
+
+	return buildPage(clients, cursors, p.Limit, total), nil
+}
+
+func (r clientRepo) Search(ctx context.Context, query string, limit int) ([]models.ClientMatch, error) {
+	rows, err := r.q.Query(ctx, "SELECT * FROM fn_search_clients($1, $2)", query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []models.ClientMatch{}
+	for rows.Next() {
+		var m models.ClientMatch
+		var dob time.Time
+		var rank float32
+
+		err := rows.Scan(&m.ID, &m.FirstName, &m.LastName, &m.MiddleName,
+			&m.PassportSeries, &m.PassportNumber, &m.PassportIssued,
+			&dob, &m.Address, &m.Phone, &m.Email, &m.CreatedAt, &rank, &m.MatchedBy)
+		if err != nil {
+			return nil, err
+		}
+
+		m.DateOfBirth = dob.Format("2006-01-02")
+		m.Rank = float64(rank)
+		matches = append(matches, m)
+	}
+
+	return matches, rows.Err()
+}
+
+func (r clientRepo) Register(ctx context.Context, reg repository.ClientRegistration) (int64, string, error) {
+	var clientID int64
+	var login string
+
+	err := r.q.QueryRow(ctx, `CALL sp_register_client($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, NULL, NULL)`,
+		reg.LoginBase, reg.PasswordHash,
+		reg.FirstName, reg.LastName, reg.MiddleName,
+		reg.PassportSeries, reg.PassportNumber, reg.PassportIssued,
+		reg.DateOfBirth, reg.Address, reg.Phone, reg.Email,
+		reg.ActivationHash, int(reg.ActivationTTL.Seconds()),
+	).Scan(&clientID, &login)
+
+	return clientID, login, err
+}
+
+func (r clientRepo) Get(ctx context.Context, clientID int64) (models.ClientProfile, error) {
+	var p models.ClientProfile
+	var dob time.Time
+	var login *string
+
+	err := r.q.QueryRow(ctx, "SELECT * FROM fn_get_client($1)", clientID).Scan(
+		&p.ID, &p.FirstName, &p.LastName, &p.MiddleName,
+		&p.PassportSeries, &p.PassportNumber, &p.PassportIssued,
+		&dob, &p.Address, &p.Phone, &p.Email, &p.CreatedAt,
+		&login, &p.IsActive, &p.ActiveContracts)
+	if err != nil {
+		return models.ClientProfile{}, notFound(err)
+	}
+
+	p.DateOfBirth = dob.Format("2006-01-02")
+	if login != nil {
+		p.Login = *login
+	}
+	return p, nil
+}
+
+func (r clientRepo) Update(ctx context.Context, u repository.ClientUpdate) error {
+	_, err := r.q.Exec(ctx, "CALL sp_update_client($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)",
+		u.ActorID, u.ClientID,
+		u.FirstName, u.LastName, u.MiddleName,
+		u.PassportSeries, u.PassportNumber, u.PassportIssued,
+		u.DateOfBirth, u.Address, u.Phone, u.Email,
+	)
+	return err
+}
+
+func (r clientRepo) SetActive(ctx context.Context, actorID int64, clientID int64, active bool) (int64, error) {
+	var userID int64
+	err := r.q.QueryRow(ctx, "CALL sp_set_client_active($1, $2, $3, NULL)", actorID, clientID, active).Scan(&userID)
+	return userID, err
+}
+
+func (r clientRepo) History(ctx context.Context, clientID int64) ([]models.ClientHistoryEntry, error) {
+	rows, err := r.q.Query(ctx, "SELECT * FROM fn_get_client_history($1)", clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []models.ClientHistoryEntry{}
+	for rows.Next() {
+		var h models.ClientHistoryEntry
+		var login, fn, ln *string
+
+		if err := rows.Scan(&h.ID, &h.Action, &h.Date, &h.Changes, &login, &fn, &ln); err != nil {
+			return nil, err
+		}
+
+		h.User = auditUserName(login, fn, ln)
+		history = append(history, h)
+	}
+
+	return history, rows.Err()
+}
+
+type employeeRepo struct {
+	q querier
+}
+
+func (r employeeRepo) List(ctx context.Context, f repository.EmployeeFilter, p repository.PageRequest) (repository.Page[models.Employee], error) {
+	role := nullable(f.Role)
+
+	var total int64
+	if err := r.q.QueryRow(ctx, "SELECT fn_count_employees($1)", role).Scan(&total); err != nil {
+		return repository.Page[models.Employee]{}, err
+	}
+
+	args := append([]any{role}, pageArgs(p)...)
+	rows, err := r.q.Query(ctx, "SELECT * FROM fn_get_employees($1, $2, $3, $4, $5, $6)", args...)
+	if err != nil {
+		return repository.Page[models.Employee]{}, err
+	}
+	defer rows.Close()
+
+	employees := []models.Employee{}
+	var cursors []repository.Cursor
+	for rows.Next() {
+		var e models.Employee
+		var sortValue string
+		if err := rows.Scan(&e.ID, &e.FirstName, &e.LastName, &e.Position, &e.Login, &e.Role, &sortValue); err != nil {
+			return repository.Page[models.Employee]{}, err
+		}
+		employees = append(employees, e)
+		cursors = append(cursors, repository.Cursor{Value: sortValue, ID: e.ID})
+	}
+	if err := rows.Err(); err != nil {
+		return repository.Page[models.Employee]{}, err
+	}
+
+	return buildPage(employees, cursors, p.Limit, total), nil
+}
+
+func (r employeeRepo) Register(ctx context.Context, e repository.EmployeeRegistration) (int64, error) {
+	var userID int64
+
+	err := r.q.QueryRow(ctx, "CALL sp_register_employee($1, $2, $3, $4, $5, $6, NULL)",
+		e.Login, e.PasswordHash, e.FirstName, e.LastName, e.Position, e.Email,
+	).Scan(&userID)
+
+	return userID, err
+}
+
+func (r employeeRepo) ByUser(ctx context.Context, userID int64) (int64, error) {
+	var employeeID *int64
+	if err := r.q.QueryRow(ctx, "SELECT fn_get_session_employee($1)", userID).Scan(&employeeID); err != nil {
+		return 0, err
+	}
+	if employeeID == nil {
+		return 0, repository.ErrNotFound
+	}
+	return *employeeID, nil
+}
@@ -0,0 +1,281 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+type loanRepo struct {
+	q querier
+}
+
+func (r loanRepo) Issue(ctx context.Context, p repository.IssueLoanParams) (int64, error) {
+	var contractID int64
+
+	err := r.q.QueryRow(ctx, "CALL sp_issue_loan($1, $2, $3, $4, $5, NULL)",
+		p.ClientID, p.ProductID, p.Amount, p.TermMonths, p.EmployeeID,
+	).Scan(&contractID)
+
+	return contractID, err
+}
+
+func (r loanRepo) ChangeStatus(ctx context.Context, c repository.LoanStatusChange) error {
+	_, err := r.q.Exec(ctx, "CALL sp_change_loan_status($1, $2, $3, $4, $5)", c.ActorID, c.EmployeeID, c.ContractID, c.To, c.Reason)
+	return err
+}
+
+func (r loanRepo) Transitions(ctx context.Context, contractID int64) ([]models.LoanTransition, error) {
+	rows, err := r.q.Query(ctx, "SELECT * FROM fn_get_loan_transitions($1)", contractID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transitions := []models.LoanTransition{}
+	for rows.Next() {
+		var t models.LoanTransition
+		var from, reason, login, fn, ln *string
+
+		if err := rows.Scan(&t.ID, &from, &t.To, &reason, &t.Date, &login, &fn, &ln); err != nil {
+			return nil, err
+		}
+
+		if from != nil {
+			t.From = *from
+		}
+		if reason != nil {
+			t.Reason = *reason
+		}
+		t.User = auditUserName(login, fn, ln)
+		transitions = append(transitions, t)
+	}
+
+	return transitions, rows.Err()
+}
+
+func (r loanRepo) List(ctx context.Context, f repository.LoanFilter, p repository.PageRequest) (repository.Page[models.LoanContract], error) {
+	filter := []any{nullable(f.Status), nullable(f.ProductID), nullable(f.ClientID), nullable(f.EmployeeID), f.From, f.To}
+
+	var total int64
+	if err := r.q.QueryRow(ctx, "SELECT fn_count_loans($1, $2, $3, $4, $5, $6)", filter...).Scan(&total); err != nil {
+		return repository.Page[models.LoanContract]{}, err
+	}
+
+	args := append(filter, pageArgs(p)...)
+	rows, err := r.q.Query(ctx, "SELECT * FROM fn_get_loans($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)", args...)
+	if err != nil {
+		return repository.Page[models.LoanContract]{}, err
+	}
+	defer rows.Close()
+
+	loans := []models.LoanContract{}
+	var cursors []repository.Cursor
+	for rows.Next() {
+		var l models.LoanContract
+		var sortValue string
+
+		err := rows.Scan(&l.ID, &l.ContractNumber, &l.Amount, &l.Status, &l.StartDate,
+			&l.InterestRate, &l.TermMonths, &l.Balance, &l.ClientName, &l.ProductName, &sortValue)
+		if err != nil {
+			return repository.Page[models.LoanContract]{}, err
+		}
+
+		loans = append(loans, l)
+		cursors = append(cursors, repository.Cursor{Value: sortValue, ID: l.ID})
+	}
+	if err := rows.Err(); err != nil {
+		return repository.Page[models.LoanContract]{}, err
+	}
+
+	return buildPage(loans, cursors, p.Limit, total), nil
+}
+
+func (r loanRepo) ListForUser(ctx context.Context, userID int64) ([]models.ClientLoan, error) {
+	rows, err := r.q.Query(ctx, "SELECT * FROM fn_get_client_loans($1)", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loans := []models.ClientLoan{}
+	for rows.Next() {
+		var l models.ClientLoan
+		var paid, total int64
+
+		err := rows.Scan(&l.ID, &l.ContractNumber, &l.Amount, &l.Status, &l.StartDate,
+			&l.Balance, &l.ProductName, &paid, &total)
+		if err != nil {
+			return nil, err
+		}
+
+		l.Progress = fmt.Sprintf("%d/%d", paid, total)
+		loans = append(loans, l)
+	}
+
+	return loans, rows.Err()
+}
+
+func (r loanRepo) OwnedBy(ctx context.Context, contractID int64, userID int64) error {
+	var found bool
+
+	err := r.q.QueryRow(ctx, `
+		SELECT TRUE
+		FROM loan_contracts lc
+		JOIN clients cl ON lc.client_id = cl.id
+		WHERE lc.id = $1 AND cl.user_id = $2
+	`, contractID, userID).Scan(&found)
+
+	return notFound(err)
+}
+
+func (r loanRepo) EarlyRepay(ctx context.Context, p repository.EarlyRepayParams) (models.Money, error) {
+	var paid models.Money
+
+	err := r.q.QueryRow(ctx, "CALL sp_early_repayment($1, $2, $3, $4, NULL)",
+		p.ContractID, p.UserID, nullable(p.Amount), nullable(p.Mode)).Scan(&paid)
+
+	return paid, err
+}
+
+func (r loanRepo) ProcessDelinquency(ctx context.Context, on time.Time, defaultAfterDays int) ([]repository.DelinquencyChange, error) {
+	rows, err := r.q.Query(ctx, "SELECT * FROM fn_process_delinquency($1, $2)", on, defaultAfterDays)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []repository.DelinquencyChange
+	for rows.Next() {
+		var c repository.DelinquencyChange
+		var from, to *string
+		err := rows.Scan(&c.ContractID, &c.ContractNumber, &c.DaysPastDue, &from, &to, &c.FromStatus, &c.ToStatus)
+		if err != nil {
+			return nil, err
+		}
+		if from != nil {
+			c.FromBucket = *from
+		}
+		if to != nil {
+			c.ToBucket = *to
+		}
+
+		changes = append(changes, c)
+	}
+
+	return changes, rows.Err()
+}
+
+func (r loanRepo) Operations(ctx context.Context, contractID int64) ([]models.LoanOperation, error) {
+	rows, err := r.q.Query(ctx, "SELECT * FROM fn_get_loan_operations($1)", contractID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ops := []models.LoanOperation{}
+	for rows.Next() {
+		var op models.LoanOperation
+		if err := rows.Scan(&op.Type, &op.Amount, &op.Date, &op.Description); err != nil {
+			return nil, err
+		}
+
+		ops = append(ops, op)
+	}
+
+	return ops, rows.Err()
+}
+
+type scheduleRepo struct {
+	q querier
+}
+
+func (r scheduleRepo) ForContract(ctx context.Context, contractID int64) (models.RepaymentSchedule, error) {
+	var scheduleType *string
+	if err := r.q.QueryRow(ctx, "SELECT fn_get_contract_schedule_type($1)", contractID).Scan(&scheduleType); err != nil {
+		return models.RepaymentSchedule{}, err
+	}
+	if scheduleType == nil {
+		return models.RepaymentSchedule{}, repository.ErrNotFound
+	}
+
+	rows, err := r.q.Query(ctx, "SELECT * FROM fn_get_repayment_schedule($1)", contractID)
+	if err != nil {
+		return models.RepaymentSchedule{}, err
+	}
+	defer rows.Close()
+
+	schedule := []models.RepaymentScheduleItem{}
+	for rows.Next() {
+		var it models.RepaymentScheduleItem
+
+		err := rows.Scan(&it.ID, &it.PaymentDate, &it.PaymentAmount, &it.PrincipalAmount,
+			&it.InterestAmount, &it.RemainingBalance, &it.IsPaid, &it.PaidAmount, &it.Penalty, &it.PenaltyPaid)
+		if err != nil {
+			return models.RepaymentSchedule{}, err
+		}
+
+		schedule = append(schedule, it)
+	}
+
+	return models.NewRepaymentSchedule(*scheduleType, schedule), rows.Err()
+}
+
+func (r scheduleRepo) GetForUser(ctx context.Context, scheduleID int64, userID int64) (repository.ScheduledPayment, error) {
+	p := repository.ScheduledPayment{ID: scheduleID}
+
+	err := r.q.QueryRow(ctx, `
+		SELECT rs.contract_id, rs.payment_amount
+		FROM repayment_schedule rs
+		JOIN loan_contracts lc ON rs.contract_id = lc.id
+		JOIN clients cl ON lc.client_id = cl.id
+		WHERE rs.id = $1 AND cl.user_id = $2
+	`, scheduleID, userID).Scan(&p.ContractID, &p.Amount)
+
+	return p, notFound(err)
+}
+
+func (r scheduleRepo) Pay(ctx context.Context, scheduleID int64, amount models.Money) (models.PaymentAllocation, error) {
+	var a models.PaymentAllocation
+
+	err := r.q.QueryRow(ctx, "CALL sp_make_payment($1, $2, NULL, NULL, NULL)", scheduleID, nullable(amount)).
+		Scan(&a.Penalty, &a.Interest, &a.Principal)
+
+	return a, err
+}
+
+func (r scheduleRepo) AccruePenalties(ctx context.Context, on time.Time) (repository.PenaltyAccrual, error) {
+	var res repository.PenaltyAccrual
+
+	err := r.q.QueryRow(ctx, "CALL sp_accrue_penalties($1, NULL, NULL)", on).Scan(&res.Installments, &res.Amount)
+
+	return res, err
+}
+
+func (r scheduleRepo) ClaimReminders(ctx context.Context, on time.Time, daysBefore []int) ([]repository.PaymentReminder, error) {
+	rows, err := r.q.Query(ctx, "SELECT * FROM fn_claim_payment_reminders($1, $2)", on, daysBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reminders []repository.PaymentReminder
+	for rows.Next() {
+		var p repository.PaymentReminder
+		var firstName, lastName string
+
+		err := rows.Scan(&p.ScheduleID, &p.Kind, &p.DaysBefore, &p.PaymentDate, &p.Amount,
+			&p.ContractNumber, &p.Email, &firstName, &lastName)
+		if err != nil {
+			return nil, err
+		}
+
+		p.ClientName = firstName + " " + lastName
+		reminders = append(reminders, p)
+	}
+
+	return reminders, rows.Err()
+}
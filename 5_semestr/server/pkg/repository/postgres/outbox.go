@@ -0,0 +1,57 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+type outboxRepo struct {
+	q querier
+}
+
+func (r outboxRepo) Enqueue(ctx context.Context, m repository.OutboxMessage) (int64, error) {
+	var id int64
+
+	err := r.q.QueryRow(ctx, "CALL sp_enqueue_mail($1, $2, $3, $4, $5, NULL)",
+		m.Kind, m.To, m.Subject, m.Text, m.HTML).Scan(&id)
+
+	return id, err
+}
+
+func (r outboxRepo) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]repository.OutboxMessage, error) {
+	rows, err := r.q.Query(ctx, "SELECT * FROM fn_claim_mail($1, $2, $3)", now, lease, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []repository.OutboxMessage
+	for rows.Next() {
+		var m repository.OutboxMessage
+		err := rows.Scan(&m.ID, &m.Kind, &m.To, &m.Subject, &m.Text, &m.HTML, &m.Attempts, &m.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, m)
+	}
+
+	return messages, rows.Err()
+}
+
+func (r outboxRepo) MarkSent(ctx context.Context, id int64) error {
+	_, err := r.q.Exec(ctx, "CALL sp_mail_sent($1)", id)
+	return err
+}
+
+func (r outboxRepo) Retry(ctx context.Context, id int64, at time.Time, reason string) error {
+	_, err := r.q.Exec(ctx, "CALL sp_mail_retry($1, $2, $3)", id, at, reason)
+	return err
+}
+
+func (r outboxRepo) Fail(ctx context.Context, id int64, reason string) error {
+	_, err := r.q.Exec(ctx, "CALL sp_mail_failed($1, $2)", id, reason)
+	return err
+}
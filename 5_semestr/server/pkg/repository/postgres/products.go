@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+type productRepo struct {
+	q querier
+}
+
+func (r productRepo) ListActive(ctx context.Context) ([]models.CreditProduct, error) {
+	return r.list(ctx, nil, false)
+}
+
+func (r productRepo) List(ctx context.Context) ([]models.CreditProduct, error) {
+	return r.list(ctx, nil, true)
+}
+
+func (r productRepo) Get(ctx context.Context, productID int) (models.CreditProduct, error) {
+	products, err := r.list(ctx, productID, true)
+	if err != nil {
+		return models.CreditProduct{}, err
+	}
+	if len(products) == 0 {
+		return models.CreditProduct{}, repository.ErrNotFound
+	}
+	return products[0], nil
+}
+
+func (r productRepo) list(ctx context.Context, productID any, includeInactive bool) ([]models.CreditProduct, error) {
+	rows, err := r.q.Query(ctx, "SELECT * FROM fn_get_products($1, $2)", productID, includeInactive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []models.CreditProduct{}
+	for rows.Next() {
+		var p models.CreditProduct
+
+		err := rows.Scan(&p.ID, &p.Name, &p.MinAmount, &p.MaxAmount, &p.MinTermMonths, &p.MaxTermMonths, &p.InterestRate,
+			&p.IsActive, &p.Version, &p.ScheduleType, &p.PenaltyRate, &p.PenaltyCap)
+		if err != nil {
+			return nil, err
+		}
+
+		products = append(products, p)
+	}
+
+	return products, rows.Err()
+}
+
+func (r productRepo) Create(ctx context.Context, p repository.ProductParams) (int, error) {
+	var productID int
+
+	err := r.q.QueryRow(ctx, "CALL sp_create_product($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULL)",
+		p.ActorID, p.Name, p.MinAmount, p.MaxAmount, p.MinTermMonths, p.MaxTermMonths, p.InterestRate, p.ScheduleType,
+		p.PenaltyRate, p.PenaltyCap,
+	).Scan(&productID)
+
+	return productID, err
+}
+
+func (r productRepo) Update(ctx context.Context, productID int, p repository.ProductParams) (int, error) {
+	var version int
+
+	err := r.q.QueryRow(ctx, "CALL sp_update_product($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NULL)",
+		p.ActorID, productID, p.Name, p.MinAmount, p.MaxAmount, p.MinTermMonths, p.MaxTermMonths, p.InterestRate,
+		p.ScheduleType, p.PenaltyRate, p.PenaltyCap,
+	).Scan(&version)
+
+	return version, err
+}
+
+func (r productRepo) SetActive(ctx context.Context, actorID int64, productID int, active bool) error {
+	_, err := r.q.Exec(ctx, "CALL sp_set_product_active($1, $2, $3)", actorID, productID, active)
+	return err
+}
+
+func (r productRepo) Versions(ctx context.Context, productID int) ([]models.ProductVersion, error) {
+	rows, err := r.q.Query(ctx, "SELECT * FROM fn_get_product_versions($1)", productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []models.ProductVersion{}
+	for rows.Next() {
+		var v models.ProductVersion
+		var login, fn, ln *string
+
+		err := rows.Scan(&v.ID, &v.Version, &v.MinAmount, &v.MaxAmount, &v.MinTermMonths, &v.MaxTermMonths,
+			&v.InterestRate, &v.ScheduleType, &v.PenaltyRate, &v.PenaltyCap, &v.Date, &login, &fn, &ln, &v.Contracts)
+		if err != nil {
+			return nil, err
+		}
+
+		v.User = auditUserName(login, fn, ln)
+		versions = append(versions, v)
+	}
+
+	return versions, rows.Err()
+}
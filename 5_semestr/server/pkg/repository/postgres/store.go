@@ -0,0 +1,99 @@
+// Package postgres реализует репозитории поверх хранимых функций и
+// процедур PostgreSQL (fn_* / sp_*).
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+// querier - общее у пула и транзакции.
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type Store struct {
+	pool *pgxpool.Pool
+	q    querier
+	inTx bool
+}
+
+func NewStore(pool *pgxpool.Pool) *Store {
+	return &Store{
+		pool: pool,
+		q:    pool,
+	}
+}
+
+func (s *Store) Users() repository.UserRepository          { return userRepo{s.q} }
+func (s *Store) TwoFactor() repository.TwoFactorRepository { return twoFactorRepo{s.q} }
+func (s *Store) Employees() repository.EmployeeRepository  { return employeeRepo{s.q} }
+func (s *Store) Clients() repository.ClientRepository      { return clientRepo{s.q} }
+func (s *Store) Products() repository.ProductRepository    { return productRepo{s.q} }
+func (s *Store) Loans() repository.LoanRepository          { return loanRepo{s.q} }
+func (s *Store) Schedules() repository.ScheduleRepository  { return scheduleRepo{s.q} }
+func (s *Store) Audit() repository.AuditRepository         { return auditRepo{s.q} }
+func (s *Store) Reports() repository.ReportRepository      { return reportRepo{s.q} }
+func (s *Store) Outbox() repository.OutboxRepository       { return outboxRepo{s.q} }
+
+// InTx открывает транзакцию; вложенный вызов переиспользует текущую.
+func (s *Store) InTx(ctx context.Context, fn func(tx repository.Store) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(&Store{pool: s.pool, q: tx, inTx: true}); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func notFound(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return repository.ErrNotFound
+	}
+	return err
+}
+
+// nullable превращает нулевое значение фильтра в NULL.
+func nullable[T comparable](v T) any {
+	var zero T
+	if v == zero {
+		return nil
+	}
+	return v
+}
+
+// pageArgs - параметры сортировки, курсора и размера страницы для fn_get_*.
+// Запрашивается на одну запись больше, чтобы узнать о следующей странице.
+func pageArgs(p repository.PageRequest) []any {
+	var value, id any
+	if p.After != nil {
+		value, id = p.After.Value, p.After.ID
+	}
+	return []any{p.Sort, p.Desc, value, id, p.Limit + 1}
+}
+
+// buildPage отрезает лишнюю запись и ставит курсор на последнюю отданную.
+func buildPage[T any](items []T, cursors []repository.Cursor, limit int, total int64) repository.Page[T] {
+	page := repository.Page[T]{Items: items, Total: total}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		next := cursors[limit-1]
+		page.Next = &next
+	}
+	return page
+}
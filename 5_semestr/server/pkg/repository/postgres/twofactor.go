@@ -0,0 +1,52 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+type twoFactorRepo struct {
+	q querier
+}
+
+func (r twoFactorRepo) Get(ctx context.Context, userID int64) (repository.TwoFactor, error) {
+	var st repository.TwoFactor
+
+	err := r.q.QueryRow(ctx, "SELECT * FROM fn_get_user_2fa($1)", userID).
+		Scan(&st.Secret, &st.Confirmed, &st.Required)
+
+	return st, notFound(err)
+}
+
+func (r twoFactorRepo) BeginEnrollment(ctx context.Context, userID int64, secret string) error {
+	_, err := r.q.Exec(ctx, "CALL sp_begin_totp_enrollment($1, $2)", userID, secret)
+	return err
+}
+
+func (r twoFactorRepo) Confirm(ctx context.Context, userID int64, step int64, codeHashes []string) error {
+	_, err := r.q.Exec(ctx, "CALL sp_confirm_totp($1, $2, $3)", userID, step, codeHashes)
+	return err
+}
+
+func (r twoFactorRepo) UseStep(ctx context.Context, userID int64, step int64) (bool, error) {
+	var fresh bool
+	err := r.q.QueryRow(ctx, "SELECT fn_use_totp_step($1, $2)", userID, step).Scan(&fresh)
+	return fresh, err
+}
+
+func (r twoFactorRepo) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) (bool, error) {
+	var used bool
+	err := r.q.QueryRow(ctx, "SELECT fn_use_recovery_code($1, $2)", userID, codeHash).Scan(&used)
+	return used, err
+}
+
+func (r twoFactorRepo) Disable(ctx context.Context, userID int64) error {
+	_, err := r.q.Exec(ctx, "CALL sp_disable_totp($1)", userID)
+	return err
+}
+
+func (r twoFactorRepo) SetRoleRequired(ctx context.Context, role string, required bool) error {
+	_, err := r.q.Exec(ctx, "CALL sp_set_role_2fa($1, $2)", role, required)
+	return err
+}
@@ -0,0 +1,119 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/repository"
+)
+
+type userRepo struct {
+	q querier
+}
+
+func (r userRepo) GetByLogin(ctx context.Context, login string) (repository.User, error) {
+	u := repository.User{Login: login}
+
+	err := r.q.QueryRow(ctx, "SELECT * FROM fn_get_user_by_login($1)", login).
+		Scan(&u.ID, &u.PasswordHash, &u.Role, &u.FirstName, &u.LastName, &u.Email, &u.MustChangePassword)
+
+	return u, notFound(err)
+}
+
+func (r userRepo) GetByID(ctx context.Context, userID int64) (repository.User, error) {
+	u := repository.User{ID: userID}
+
+	err := r.q.QueryRow(ctx, "SELECT * FROM fn_get_user_profile($1)", userID).
+		Scan(&u.Login, &u.Role, &u.FirstName, &u.LastName, &u.Email, &u.MustChangePassword)
+	if err != nil {
+		return u, notFound(err)
+	}
+
+	var login string
+	err = r.q.QueryRow(ctx, "SELECT * FROM fn_get_user_password($1)", userID).Scan(&login, &u.PasswordHash)
+
+	return u, notFound(err)
+}
+
+func (r userRepo) SetPassword(ctx context.Context, userID int64, passwordHash string) error {
+	_, err := r.q.Exec(ctx, "CALL sp_set_password($1, $2)", userID, passwordHash)
+	return err
+}
+
+func (r userRepo) PlaintextCredentials(ctx context.Context) ([]repository.PlaintextCredential, error) {
+	rows, err := r.q.Query(ctx, "SELECT * FROM fn_get_plaintext_credentials()")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var creds []repository.PlaintextCredential
+	for rows.Next() {
+		var cr repository.PlaintextCredential
+		if err := rows.Scan(&cr.UserID, &cr.Login, &cr.Value); err != nil {
+			return nil, err
+		}
+		creds = append(creds, cr)
+	}
+	return creds, rows.Err()
+}
+
+func (r userRepo) ReplaceCredential(ctx context.Context, userID int64, passwordHash string) error {
+	_, err := r.q.Exec(ctx, "CALL sp_replace_credential($1, $2)", userID, passwordHash)
+	return err
+}
+
+func (r userRepo) SessionValid(ctx context.Context, userID int64, issuedAt time.Time) (bool, error) {
+	var valid bool
+	err := r.q.QueryRow(ctx, "SELECT fn_session_valid($1, $2)", userID, issuedAt).Scan(&valid)
+	return valid, err
+}
+
+func (r userRepo) FindForReset(ctx context.Context, loginOrEmail string) (repository.User, error) {
+	var u repository.User
+
+	err := r.q.QueryRow(ctx, "SELECT * FROM fn_find_user_for_reset($1)", loginOrEmail).
+		Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email)
+
+	return u, notFound(err)
+}
+
+func (r userRepo) CreatePasswordReset(ctx context.Context, userID int64, tokenHash string, ttl time.Duration) error {
+	_, err := r.q.Exec(ctx, "CALL sp_create_password_reset($1, $2, $3)",
+		userID, tokenHash, int(ttl.Seconds()))
+	return err
+}
+
+func (r userRepo) ConsumePasswordReset(ctx context.Context, tokenHash string) (int64, error) {
+	return r.consume(ctx, "SELECT fn_consume_password_reset($1)", tokenHash)
+}
+
+func (r userRepo) CreateActivation(ctx context.Context, userID int64, tokenHash string, ttl time.Duration) error {
+	_, err := r.q.Exec(ctx, "CALL sp_create_activation_token($1, $2, $3)",
+		userID, tokenHash, int(ttl.Seconds()))
+	return err
+}
+
+func (r userRepo) ConsumeActivation(ctx context.Context, tokenHash string) (int64, error) {
+	return r.consume(ctx, "SELECT fn_consume_activation_token($1)", tokenHash)
+}
+
+func (r userRepo) consume(ctx context.Context, sql string, tokenHash string) (int64, error) {
+	var userID *int64
+	if err := r.q.QueryRow(ctx, sql, tokenHash).Scan(&userID); err != nil {
+		return 0, err
+	}
+	if userID == nil {
+		return 0, repository.ErrNotFound
+	}
+	return *userID, nil
+}
+
+func (r userRepo) GetClientActivation(ctx context.Context, clientID int64) (repository.ActivationTarget, error) {
+	var t repository.ActivationTarget
+
+	err := r.q.QueryRow(ctx, "SELECT * FROM fn_get_client_activation($1)", clientID).
+		Scan(&t.UserID, &t.Login, &t.FirstName, &t.LastName, &t.Email, &t.Activated)
+
+	return t, notFound(err)
+}
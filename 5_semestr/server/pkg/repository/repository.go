@@ -0,0 +1,422 @@
+// Package repository описывает доступ к данным банка. Обработчики HTTP
+// работают только с этими интерфейсами; реализация на pgx живет в
+// repository/postgres, реализация в памяти для тестов - в repository/memory.
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/stepan41k/Kursach/5_semestr/pkg/models"
+)
+
+// ErrNotFound возвращается, когда запрошенная запись не существует
+// (или недоступна вызывающему пользователю).
+var ErrNotFound = errors.New("not found")
+
+// DefaultPageLimit и MaxPageLimit ограничивают размер страницы списков.
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 200
+)
+
+// Cursor - позиция в списке: значение поля сортировки и id последней
+// отданной записи. Формат Value определяется реализацией репозитория.
+type Cursor struct {
+	Value string
+	ID    int64
+}
+
+// PageRequest - параметры постраничной выборки с keyset-пагинацией.
+// Sort - имя поля из списка, допустимого для конкретного списка.
+type PageRequest struct {
+	Limit int
+	Sort  string
+	Desc  bool
+	After *Cursor
+}
+
+// Page - страница списка. Total - число записей, подходящих под фильтр,
+// без учета курсора; Next == nil, если страница последняя.
+type Page[T any] struct {
+	Items []T
+	Total int64
+	Next  *Cursor
+}
+
+// Store объединяет репозитории. InTx выполняет fn в одной транзакции:
+// если fn вернула ошибку, все изменения откатываются.
+type Store interface {
+	Users() UserRepository
+	TwoFactor() TwoFactorRepository
+	Employees() EmployeeRepository
+	Clients() ClientRepository
+	Products() ProductRepository
+	Loans() LoanRepository
+	Schedules() ScheduleRepository
+	Audit() AuditRepository
+	Reports() ReportRepository
+	Outbox() OutboxRepository
+
+	InTx(ctx context.Context, fn func(tx Store) error) error
+}
+
+// User - учетная запись вместе с данными сотрудника или клиента.
+// PasswordHash заполняется только методами, которым он нужен.
+type User struct {
+	ID                 int64
+	Login              string
+	PasswordHash       string
+	Role               string
+	FirstName          *string
+	LastName           *string
+	Email              *string
+	MustChangePassword bool
+}
+
+// ActivationTarget - учетная запись клиента, ожидающая активации.
+type ActivationTarget struct {
+	UserID    int64
+	Login     string
+	FirstName string
+	LastName  string
+	Email     *string
+	Activated bool
+}
+
+// PlaintextCredential - учетная запись с открытым паролем Value вместо хеша.
+type PlaintextCredential struct {
+	UserID int64
+	Login  string
+	Value  string
+}
+
+type UserRepository interface {
+	// GetByLogin ищет активного пользователя по логину, с хешем пароля.
+	GetByLogin(ctx context.Context, login string) (User, error)
+	// GetByID возвращает профиль активного пользователя, с хешем пароля.
+	GetByID(ctx context.Context, userID int64) (User, error)
+	// SetPassword сохраняет новый хеш и отзывает все сессии пользователя.
+	SetPassword(ctx context.Context, userID int64, passwordHash string) error
+	// SessionValid сообщает, выдан ли токен после последней смены пароля.
+	SessionValid(ctx context.Context, userID int64, issuedAt time.Time) (bool, error)
+	// PlaintextCredentials возвращает учетные записи, у которых вместо
+	// bcrypt-хеша хранится открытый пароль.
+	PlaintextCredentials(ctx context.Context) ([]PlaintextCredential, error)
+	// ReplaceCredential сохраняет новый хеш, требует сменить пароль при
+	// следующем входе и отзывает сессии.
+	ReplaceCredential(ctx context.Context, userID int64, passwordHash string) error
+
+	// FindForReset ищет пользователя по логину или email.
+	FindForReset(ctx context.Context, loginOrEmail string) (User, error)
+	CreatePasswordReset(ctx context.Context, userID int64, tokenHash string, ttl time.Duration) error
+	// ConsumePasswordReset гасит токен и возвращает владельца или ErrNotFound.
+	ConsumePasswordReset(ctx context.Context, tokenHash string) (int64, error)
+
+	CreateActivation(ctx context.Context, userID int64, tokenHash string, ttl time.Duration) error
+	ConsumeActivation(ctx context.Context, tokenHash string) (int64, error)
+	GetClientActivation(ctx context.Context, clientID int64) (ActivationTarget, error)
+}
+
+// TwoFactor - настройки TOTP пользователя и требование его роли.
+type TwoFactor struct {
+	Secret    *string
+	Confirmed bool
+	Required  bool
+}
+
+type TwoFactorRepository interface {
+	Get(ctx context.Context, userID int64) (TwoFactor, error)
+	BeginEnrollment(ctx context.Context, userID int64, secret string) error
+	Confirm(ctx context.Context, userID int64, step int64, codeHashes []string) error
+	// UseStep отмечает шаг TOTP использованным; false - шаг уже был.
+	UseStep(ctx context.Context, userID int64, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID int64, codeHash string) (bool, error)
+	Disable(ctx context.Context, userID int64) error
+	SetRoleRequired(ctx context.Context, role string, required bool) error
+}
+
+type EmployeeRegistration struct {
+	Login        string
+	PasswordHash string
+	FirstName    string
+	LastName     string
+	Position     string
+	Email        string
+}
+
+// Поля сортировки списков. Первое в каждом наборе - сортировка по умолчанию.
+var (
+	ClientSorts   = []string{"id", "lastName", "createdAt"}
+	EmployeeSorts = []string{"id", "lastName"}
+	LoanSorts     = []string{"createdAt", "id", "startDate", "amount", "balance"}
+	AuditSorts    = []string{"createdAt", "id"}
+)
+
+// EmployeeFilter - фильтр списка сотрудников; пустые поля не фильтруют.
+type EmployeeFilter struct {
+	Role string
+}
+
+type EmployeeRepository interface {
+	List(ctx context.Context, f EmployeeFilter, p PageRequest) (Page[models.Employee], error)
+	// Register создает учетную запись менеджера и возвращает id пользователя.
+	Register(ctx context.Context, e EmployeeRegistration) (int64, error)
+	// ByUser возвращает id сотрудника активной учетной записи или ErrNotFound.
+	ByUser(ctx context.Context, userID int64) (int64, error)
+}
+
+// ClientRegistration - анкета клиента. Логин подбирается по LoginBase,
+// вместе с учетной записью создается токен активации.
+type ClientRegistration struct {
+	models.CreateClientRequest
+	LoginBase      string
+	PasswordHash   string
+	ActivationHash string
+	ActivationTTL  time.Duration
+}
+
+// ClientFilter - фильтр списка клиентов по дате регистрации.
+type ClientFilter struct {
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+}
+
+// ClientUpdate - новая версия карточки клиента. ActorID попадает в
+// историю изменений как автор.
+type ClientUpdate struct {
+	models.UpdateClientRequest
+	ClientID int64
+	ActorID  int64
+}
+
+type ClientRepository interface {
+	List(ctx context.Context, f ClientFilter, p PageRequest) (Page[models.Client], error)
+	// Search ищет клиентов по части ФИО, паспорту, телефону или email и
+	// возвращает не больше limit записей, самые релевантные первыми.
+	Search(ctx context.Context, query string, limit int) ([]models.ClientMatch, error)
+	Register(ctx context.Context, r ClientRegistration) (clientID int64, login string, err error)
+	// Get возвращает карточку клиента или ErrNotFound.
+	Get(ctx context.Context, clientID int64) (models.ClientProfile, error)
+	Update(ctx context.Context, u ClientUpdate) error
+	// SetActive включает или блокирует вход клиента и возвращает id его
+	// учетной записи.
+	SetActive(ctx context.Context, actorID int64, clientID int64, active bool) (userID int64, err error)
+	// History возвращает изменения карточки, новые первыми. Имена полей -
+	// колонки таблицы clients.
+	History(ctx context.Context, clientID int64) ([]models.ClientHistoryEntry, error)
+}
+
+// ProductParams - название и условия продукта. ActorID - пользователь, от
+// имени которого пишется аудит.
+type ProductParams struct {
+	Name          string
+	MinAmount     models.Money
+	MaxAmount     models.Money
+	MinTermMonths int
+	MaxTermMonths int
+	InterestRate  float64
+	ScheduleType  string
+	PenaltyRate   float64
+	PenaltyCap    float64
+	ActorID       int64
+}
+
+type ProductRepository interface {
+	ListActive(ctx context.Context) ([]models.CreditProduct, error)
+	// List возвращает все продукты, включая неактивные.
+	List(ctx context.Context) ([]models.CreditProduct, error)
+	Get(ctx context.Context, productID int) (models.CreditProduct, error)
+	Create(ctx context.Context, p ProductParams) (int, error)
+	// Update меняет продукт и возвращает номер текущей версии условий.
+	// Новая версия создается, только если условия изменились.
+	Update(ctx context.Context, productID int, p ProductParams) (version int, err error)
+	SetActive(ctx context.Context, actorID int64, productID int, active bool) error
+	// Versions возвращает версии условий, новые первыми.
+	Versions(ctx context.Context, productID int) ([]models.ProductVersion, error)
+}
+
+// IssueLoanParams - параметры выдачи кредита. EmployeeID - сотрудник
+// текущей сессии, а не значение из запроса.
+type IssueLoanParams struct {
+	ClientID   int64
+	ProductID  int
+	Amount     models.Money
+	TermMonths int
+	EmployeeID int64
+}
+
+// LoanFilter - фильтр списка договоров. From и To ограничивают дату
+// начала договора включительно.
+type LoanFilter struct {
+	Status     string
+	ProductID  int
+	ClientID   int64
+	EmployeeID int64
+	From       *time.Time
+	To         *time.Time
+}
+
+// LoanStatusChange - перевод договора в статус To от имени пользователя
+// ActorID (users.id). EmployeeID - его сотрудник: при одобрении он
+// записывается как одобривший, при активации - как выдавший.
+type LoanStatusChange struct {
+	ContractID int64
+	ActorID    int64
+	EmployeeID int64
+	To         string
+	Reason     string
+}
+
+// EarlyRepayParams - досрочное погашение договора клиентом UserID.
+// Amount == 0 гасит весь остаток; при частичном погашении неоплаченная
+// часть графика пересчитывается по Mode (models.RepayReduceTerm или
+// models.RepayReducePayment).
+type EarlyRepayParams struct {
+	ContractID int64
+	UserID     int64
+	Amount     models.Money
+	Mode       string
+}
+
+// DelinquencyChange - договор, у которого при учете просрочки сменилась
+// корзина (models.DelinquencyBucket) или статус. Пустая корзина - просрочки
+// нет.
+type DelinquencyChange struct {
+	ContractID     int64
+	ContractNumber string
+	DaysPastDue    int
+	FromBucket     string
+	ToBucket       string
+	FromStatus     string
+	ToStatus       string
+}
+
+type LoanRepository interface {
+	// Issue регистрирует заявку в статусе draft. Выдача и график платежей
+	// появляются при переходе в active.
+	Issue(ctx context.Context, p IssueLoanParams) (int64, error)
+	ChangeStatus(ctx context.Context, c LoanStatusChange) error
+	// Transitions возвращает историю статусов договора, старые первыми.
+	Transitions(ctx context.Context, contractID int64) ([]models.LoanTransition, error)
+	List(ctx context.Context, f LoanFilter, p PageRequest) (Page[models.LoanContract], error)
+	ListForUser(ctx context.Context, userID int64) ([]models.ClientLoan, error)
+	// OwnedBy возвращает ErrNotFound, если договора нет или он не
+	// принадлежит клиенту userID.
+	OwnedBy(ctx context.Context, contractID int64, userID int64) error
+	// EarlyRepay вносит досрочный платеж и возвращает уплаченную сумму.
+	EarlyRepay(ctx context.Context, p EarlyRepayParams) (models.Money, error)
+	// ProcessDelinquency пересчитывает дни просрочки действующих договоров на
+	// дату on. Договор с просрочкой от defaultAfterDays дней переходит в
+	// defaulted, без просроченных платежей - возвращается в active.
+	// Возвращает только договоры, у которых что-то поменялось.
+	ProcessDelinquency(ctx context.Context, on time.Time, defaultAfterDays int) ([]DelinquencyChange, error)
+	Operations(ctx context.Context, contractID int64) ([]models.LoanOperation, error)
+}
+
+// ScheduledPayment - платеж графика.
+type ScheduledPayment struct {
+	ID         int64
+	ContractID int64
+	Amount     models.Money
+}
+
+// PenaltyAccrual - итог начисления пени: сколько платежей затронуто и
+// на какую сумму.
+type PenaltyAccrual struct {
+	Installments int
+	Amount       models.Money
+}
+
+// Виды напоминаний о платеже.
+const (
+	ReminderUpcoming = "upcoming"
+	ReminderOverdue  = "overdue"
+)
+
+// PaymentReminder - напоминание клиенту о платеже графика. DaysBefore -
+// за сколько дней до платежа оно отправляется (для просрочки 0), Amount -
+// сумма к оплате с учетом пени.
+type PaymentReminder struct {
+	ScheduleID     int64
+	Kind           string
+	DaysBefore     int
+	PaymentDate    time.Time
+	Amount         models.Money
+	ContractNumber string
+	Email          string
+	ClientName     string
+}
+
+type ScheduleRepository interface {
+	// ForContract возвращает график договора или ErrNotFound.
+	ForContract(ctx context.Context, contractID int64) (models.RepaymentSchedule, error)
+	// GetForUser возвращает платеж, только если договор принадлежит клиенту userID.
+	GetForUser(ctx context.Context, scheduleID int64, userID int64) (ScheduledPayment, error)
+	// Pay вносит amount по строке графика (0 - все, что причитается) и
+	// возвращает, как он распределился между пени, процентами и долгом.
+	Pay(ctx context.Context, scheduleID int64, amount models.Money) (models.PaymentAllocation, error)
+	// AccruePenalties начисляет пени по просроченным платежам на дату on.
+	// Повторный вызов за ту же дату ничего не добавляет.
+	AccruePenalties(ctx context.Context, on time.Time) (PenaltyAccrual, error)
+	// ClaimReminders отмечает отправленными и возвращает напоминания, которые
+	// пора отправить на дату on: о платежах через daysBefore дней и о только
+	// что просроченных. Одно напоминание возвращается только один раз.
+	ClaimReminders(ctx context.Context, on time.Time, daysBefore []int) ([]PaymentReminder, error)
+}
+
+// AuditEntry - запись журнала аудита. UserID == 0 означает систему.
+type AuditEntry struct {
+	UserID   int64
+	Action   string
+	Entity   string
+	EntityID int64
+	Details  map[string]string
+}
+
+// AuditFilter - фильтр журнала аудита; From и To - границы времени записи
+// включительно.
+type AuditFilter struct {
+	Action string
+	Entity string
+	UserID int64
+	From   *time.Time
+	To     *time.Time
+}
+
+type AuditRepository interface {
+	Log(ctx context.Context, e AuditEntry) error
+	List(ctx context.Context, f AuditFilter, p PageRequest) (Page[models.AuditLog], error)
+}
+
+// OutboxMessage - письмо в очереди исходящих. Attempts - число уже
+// начатых попыток отправки, включая текущую.
+type OutboxMessage struct {
+	ID        int64
+	Kind      string
+	To        string
+	Subject   string
+	Text      string
+	HTML      string
+	Attempts  int
+	CreatedAt time.Time
+}
+
+type OutboxRepository interface {
+	// Enqueue ставит письмо в очередь и возвращает его id.
+	Enqueue(ctx context.Context, m OutboxMessage) (int64, error)
+	// Claim забирает до limit писем, которые пора отправить на момент now,
+	// и откладывает их на lease, чтобы их не взял другой воркер.
+	Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]OutboxMessage, error)
+	MarkSent(ctx context.Context, id int64) error
+	// Retry назначает следующую попытку на at.
+	Retry(ctx context.Context, id int64, at time.Time, reason string) error
+	// Fail прекращает попытки отправить письмо.
+	Fail(ctx context.Context, id int64, reason string) error
+}
+
+type ReportRepository interface {
+	DashboardStats(ctx context.Context) (models.DashboardStats, error)
+	FinanceReport(ctx context.Context) ([]models.FinanceReportRow, error)
+}